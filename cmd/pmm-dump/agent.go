@@ -0,0 +1,211 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/valyala/fasthttp"
+	"gopkg.in/yaml.v2"
+
+	"pmm-dump/pkg/dump"
+	"pmm-dump/pkg/grafana/client"
+	"pmm-dump/pkg/transferer"
+)
+
+// addServiceMethods are the inventory API methods, which register services of the type.
+var addServiceMethods = map[string]string{
+	"mysql":      "AddMySQL",
+	"mongodb":    "AddMongoDB",
+	"postgresql": "AddPostgreSQL",
+	"proxysql":   "AddProxySQL",
+	"haproxy":    "AddHAProxyService",
+	"external":   "AddExternalService",
+}
+
+// getPMMAgentStatus returns the response of `/v1/management/AgentStatus` as YAML, which is stored in the dump as is.
+// It fails if the PMM server has no agent status API.
+func getPMMAgentStatus(pmmURL string, c *client.Client) ([]byte, error) {
+	statusCode, body, err := c.Post(pmmURL + "/v1/management/AgentStatus")
+	if err != nil {
+		return nil, err
+	}
+	if statusCode != fasthttp.StatusOK {
+		return nil, fmt.Errorf("non-ok status: %d", statusCode)
+	}
+	// JSON is valid YAML, so the response is converted keeping the order of its fields
+	var status yaml.MapSlice
+	if err := yaml.Unmarshal(body, &status); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	return yaml.Marshal(status)
+}
+
+func readAgentStatus(dumpPath string, piped bool) (*dump.AgentStatus, error) {
+	files, err := transferer.ReadFilesFromDump(dumpPath, piped, dump.AgentConfigFilename)
+	if err != nil {
+		return nil, err
+	}
+	content, ok := files[dump.AgentConfigFilename]
+	if !ok {
+		return nil, errors.Errorf("%s is not found in dump", dump.AgentConfigFilename)
+	}
+
+	var status dump.AgentStatus
+	if err := yaml.Unmarshal(content, &status); err != nil {
+		return nil, errors.Wrap(err, "failed to parse pmm-agent status")
+	}
+	return &status, nil
+}
+
+func printAgentConfig(dumpPath string, piped bool) {
+	if piped {
+		fmt.Printf("pmm-agents: can't be shown in a pipeline\n")
+		return
+	}
+	if isS3Path(dumpPath) {
+		fmt.Printf("pmm-agents: can't be shown for the dump in S3 without downloading it\n")
+		return
+	}
+
+	status, err := readAgentStatus(dumpPath, piped)
+	if err != nil {
+		log.Fatal().Msgf("Can't show pmm-agent configuration: %v", err)
+	}
+
+	fmt.Printf("pmm-agents:\n")
+	for _, a := range status.Agents {
+		fmt.Printf("\t- Agent ID: %s\n", a.AgentID)
+		fmt.Printf("\t  Node Name: %s\n", a.NodeName)
+		fmt.Printf("\t  Connected: %v\n", a.Connected)
+		if len(a.Services) > 0 {
+			fmt.Printf("\t  Services:\n")
+		}
+		for _, s := range a.Services {
+			fmt.Printf("\t\t- %s (%s)\n", s.ServiceName, s.ServiceType)
+		}
+	}
+}
+
+func registerDumpAgentServices(c *client.Client, pmmURL, dumpPath string, piped bool) {
+	if piped {
+		log.Warn().Msg("pmm-agent services can't be registered in a pipeline, skipping them")
+		return
+	}
+	if isS3Path(dumpPath) {
+		log.Warn().Msg("pmm-agent services can't be registered from the dump in S3, skipping them")
+		return
+	}
+
+	status, err := readAgentStatus(dumpPath, piped)
+	if err != nil {
+		log.Warn().Msgf("pmm-agent configuration is unavailable, skipping it: %v", err)
+		return
+	}
+	count, err := registerAgentServices(c, pmmURL, status)
+	if err != nil {
+		log.Fatal().Msgf("Failed to register pmm-agent services: %v", err)
+	}
+	log.Info().Msgf("Registered %d pmm-agent services", count)
+}
+
+// registerAgentServices registers the services of pmm-agents with the inventory API of the target PMM. Nodes are
+// registered by pmm-agents themselves, so services are added to the nodes with the same names. Services already
+// registered on the target PMM, services of missing nodes and of unknown types are skipped.
+func registerAgentServices(c *client.Client, pmmURL string, status *dump.AgentStatus) (int, error) {
+	var nodes map[string][]struct {
+		ID   string `json:"node_id"`
+		Name string `json:"node_name"`
+	}
+	if err := postInventory(c, pmmURL+"/v1/inventory/Nodes/List", &nodes); err != nil {
+		return 0, errors.Wrap(err, "failed to list nodes")
+	}
+	var services map[string][]struct {
+		Name string `json:"service_name"`
+	}
+	if err := postInventory(c, pmmURL+"/v1/inventory/Services/List", &services); err != nil {
+		return 0, errors.Wrap(err, "failed to list services")
+	}
+
+	nodeIDs := make(map[string]string)
+	for _, list := range nodes {
+		for _, n := range list {
+			nodeIDs[n.Name] = n.ID
+		}
+	}
+	registered := make(map[string]bool)
+	for _, list := range services {
+		for _, s := range list {
+			registered[s.Name] = true
+		}
+	}
+
+	count := 0
+	for _, a := range status.Agents {
+		nodeID, ok := nodeIDs[a.NodeName]
+		if !ok {
+			if len(a.Services) > 0 {
+				log.Warn().Msgf("Node %s is not registered on the target PMM, skipping its services", a.NodeName)
+			}
+			continue
+		}
+		for _, s := range a.Services {
+			if registered[s.ServiceName] {
+				log.Info().Msgf("Service %s is already registered, skipping it", s.ServiceName)
+				continue
+			}
+			method, ok := addServiceMethods[s.ServiceType]
+			if !ok {
+				log.Warn().Msgf("Service %s has unsupported type %q, skipping it", s.ServiceName, s.ServiceType)
+				continue
+			}
+			req := struct {
+				NodeID      string `json:"node_id"`
+				ServiceName string `json:"service_name"`
+				Address     string `json:"address,omitempty"`
+				Port        int    `json:"port,omitempty"`
+				Socket      string `json:"socket,omitempty"`
+			}{nodeID, s.ServiceName, s.Address, s.Port, s.Socket}
+			statusCode, body, err := c.PostJSON(pmmURL+"/v1/inventory/Services/"+method, req)
+			if err != nil {
+				return count, errors.Wrapf(err, "failed to register service %s", s.ServiceName)
+			}
+			if statusCode != fasthttp.StatusOK {
+				return count, errors.Errorf("failed to register service %s: non-ok status %d: %s", s.ServiceName, statusCode, body)
+			}
+			log.Info().Msgf("Registered service %s on node %s", s.ServiceName, a.NodeName)
+			registered[s.ServiceName] = true
+			count++
+		}
+	}
+	return count, nil
+}
+
+func postInventory(c *client.Client, url string, resp interface{}) error {
+	statusCode, body, err := c.PostJSON(url, struct{}{})
+	if err != nil {
+		return err
+	}
+	if statusCode != fasthttp.StatusOK {
+		return fmt.Errorf("non-ok status: %d", statusCode)
+	}
+	if err := json.Unmarshal(body, resp); err != nil {
+		return fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+	"gopkg.in/yaml.v2"
+
+	"pmm-dump/pkg/dump"
+	"pmm-dump/pkg/grafana/client"
+)
+
+const agentStatusResponse = `{
+	"agents": [
+		{
+			"agent_id": "pmm-agent-1",
+			"runs_on_node_id": "node-1",
+			"node_name": "db1",
+			"connected": true,
+			"server_address": "pmm:443",
+			"services": [
+				{"service_id": "s1", "service_name": "mysql-db1", "service_type": "mysql", "address": "127.0.0.1", "port": 3306},
+				{"service_id": "s2", "service_name": "mongo-db1", "service_type": "mongodb", "socket": "/tmp/mongodb.sock"},
+				{"service_id": "s3", "service_name": "redis-db1", "service_type": "redis"}
+			]
+		},
+		{
+			"agent_id": "pmm-agent-2",
+			"node_name": "db2",
+			"services": [
+				{"service_id": "s4", "service_name": "pg-db2", "service_type": "postgresql", "address": "db2", "port": 5432}
+			]
+		}
+	]
+}`
+
+// fakeInventory is the PMM server with the agent status and inventory APIs.
+type fakeInventory struct {
+	nodes    string
+	services string
+	// added are the requests to register services by the inventory method
+	added map[string][]map[string]interface{}
+}
+
+func (s *fakeInventory) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/v1/management/AgentStatus":
+		fmt.Fprint(w, agentStatusResponse)
+	case "/v1/inventory/Nodes/List":
+		fmt.Fprint(w, s.nodes)
+	case "/v1/inventory/Services/List":
+		fmt.Fprint(w, s.services)
+	default:
+		body, _ := io.ReadAll(r.Body)
+		var req map[string]interface{}
+		if err := json.Unmarshal(body, &req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.added[r.URL.Path] = append(s.added[r.URL.Path], req)
+		fmt.Fprint(w, "{}")
+	}
+}
+
+func newFakeInventoryClient(t *testing.T, s *fakeInventory) (*client.Client, string) {
+	t.Helper()
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	c, err := client.NewClient(&fasthttp.Client{}, client.AuthParams{User: "admin", Password: "admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, srv.URL
+}
+
+func TestGetPMMAgentStatus(t *testing.T) {
+	c, pmmURL := newFakeInventoryClient(t, &fakeInventory{})
+	content, err := getPMMAgentStatus(pmmURL, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The whole response is stored, including the fields which are not read
+	var stored map[string][]map[string]interface{}
+	if err := yaml.Unmarshal(content, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if got := stored["agents"][0]["server_address"]; got != "pmm:443" {
+		t.Fatalf("want server address of the response, got %v", got)
+	}
+	var status dump.AgentStatus
+	if err := yaml.Unmarshal(content, &status); err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Agents) != 2 || len(status.Agents[0].Services) != 3 || status.Agents[0].Services[0].Port != 3306 {
+		t.Fatalf("unexpected agent status: %+v", status)
+	}
+}
+
+func TestRegisterAgentServices(t *testing.T) {
+	var status dump.AgentStatus
+	if err := yaml.Unmarshal([]byte(agentStatusResponse), &status); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		nodes    string
+		services string
+		want     map[string][]map[string]interface{}
+	}{
+		{
+			name:     "all nodes",
+			nodes:    `{"generic": [{"node_id": "target-1", "node_name": "db1"}], "remote": [{"node_id": "target-2", "node_name": "db2"}]}`,
+			services: `{}`,
+			want: map[string][]map[string]interface{}{
+				"/v1/inventory/Services/AddMySQL":      {{"node_id": "target-1", "service_name": "mysql-db1", "address": "127.0.0.1", "port": float64(3306)}},
+				"/v1/inventory/Services/AddMongoDB":    {{"node_id": "target-1", "service_name": "mongo-db1", "socket": "/tmp/mongodb.sock"}},
+				"/v1/inventory/Services/AddPostgreSQL": {{"node_id": "target-2", "service_name": "pg-db2", "address": "db2", "port": float64(5432)}},
+			},
+		},
+		{
+			name:     "missing node and registered service",
+			nodes:    `{"generic": [{"node_id": "target-1", "node_name": "db1"}]}`,
+			services: `{"mysql": [{"service_id": "target-s1", "service_name": "mysql-db1"}]}`,
+			want: map[string][]map[string]interface{}{
+				"/v1/inventory/Services/AddMongoDB": {{"node_id": "target-1", "service_name": "mongo-db1", "socket": "/tmp/mongodb.sock"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &fakeInventory{nodes: tt.nodes, services: tt.services, added: make(map[string][]map[string]interface{})}
+			c, pmmURL := newFakeInventoryClient(t, s)
+			count, err := registerAgentServices(c, pmmURL, &status)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(s.added, tt.want) {
+				t.Fatalf("want %v, got %v", tt.want, s.added)
+			}
+			if count != len(tt.want) {
+				t.Fatalf("want %d registered services, got %d", len(tt.want), count)
+			}
+		})
+	}
+}
@@ -0,0 +1,228 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"pmm-dump/pkg/clickhouse/tsv"
+	"pmm-dump/pkg/dump"
+	"pmm-dump/pkg/victoriametrics"
+)
+
+// dumpData is core metrics series and QAN rows of all chunks of a dump.
+type dumpData struct {
+	series *victoriametrics.SeriesSet
+	// rows are QAN rows by their hashes, rowCounts are the numbers of equal rows
+	rows      map[string][]string
+	rowCounts map[string]int
+	totalRows int
+}
+
+// readDumpData reads all chunks of the dump. Core metrics chunks should be in JSON format, they are parsed by the workers.
+func readDumpData(r io.Reader, workers int) (*dumpData, error) {
+	dr, err := dump.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer dr.Close() //nolint:errcheck
+
+	data := &dumpData{
+		series:    victoriametrics.NewSeriesSet(),
+		rows:      make(map[string][]string),
+		rowCounts: make(map[string]int),
+	}
+	parser := victoriametrics.NewChunkParser(workers, func(_ string, metrics []victoriametrics.Metric) error {
+		for _, m := range metrics {
+			data.series.Add(m)
+		}
+		return nil
+	})
+	defer parser.Wait() //nolint:errcheck
+	for {
+		header, err := dr.Next()
+		if errors.Is(err, io.EOF) {
+			if err := parser.Wait(); err != nil {
+				return nil, errors.Wrap(err, "only JSON format of core metrics is supported")
+			}
+			return data, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		dir, filename := path.Split(header.Name)
+		st := dump.ParseSourceType(path.Clean(dir))
+		if dir == "" || st == dump.UndefinedSource || filename == dump.ChunkStatsFilename ||
+			header.Name == dump.VMMetadataFilename || header.Name == dump.VMMetricMetadataFilename {
+			continue
+		}
+
+		content, err := io.ReadAll(dr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read chunk %s", header.Name)
+		}
+		if len(content) == 0 {
+			continue
+		}
+
+		switch st {
+		case dump.VictoriaMetrics:
+			parser.Add(header.Name, content)
+		case dump.ClickHouse:
+			records, err := tsv.NewReader(bytes.NewReader(content), nil).ReadAll()
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to parse chunk %s", header.Name)
+			}
+			for _, record := range records {
+				hash := tsv.RecordHash(record)
+				data.rows[hash] = record
+				data.rowCounts[hash]++
+				data.totalRows++
+			}
+		}
+	}
+}
+
+// rowsDiff is a QAN row present in one of the dumps only, count is the number of its missing copies.
+type rowsDiff struct {
+	record []string
+	count  int
+}
+
+// dumpDiff is the difference between the first dump (x) and the second one (y).
+type dumpDiff struct {
+	xSeries, ySeries int
+	series           victoriametrics.SeriesDiff
+
+	xRows, yRows         int
+	onlyXRows, onlyYRows []rowsDiff
+	onlyXRowsCount       int
+	onlyYRowsCount       int
+}
+
+// diffDumps compares series and QAN rows of the dumps. Chunks are not compared one by one,
+// so dumps exported with different chunk settings are compared by their content only.
+func diffDumps(x, y io.Reader, workers int) (*dumpDiff, error) {
+	xData, err := readDumpData(x, workers)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read the first dump")
+	}
+	yData, err := readDumpData(y, workers)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read the second dump")
+	}
+
+	d := &dumpDiff{
+		xSeries: xData.series.Len(),
+		ySeries: yData.series.Len(),
+		series:  victoriametrics.DiffSeries(xData.series, yData.series),
+		xRows:   xData.totalRows,
+		yRows:   yData.totalRows,
+	}
+	d.onlyXRows, d.onlyXRowsCount = missingRows(xData, yData)
+	d.onlyYRows, d.onlyYRowsCount = missingRows(yData, xData)
+	return d, nil
+}
+
+// missingRows returns rows of a which are missing in b, and the total number of missing rows.
+func missingRows(a, b *dumpData) ([]rowsDiff, int) {
+	var diffs []rowsDiff
+	total := 0
+	for hash, count := range a.rowCounts {
+		if missing := count - b.rowCounts[hash]; missing > 0 {
+			diffs = append(diffs, rowsDiff{record: a.rows[hash], count: missing})
+			total += missing
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool {
+		return strings.Join(diffs[i].record, "\t") < strings.Join(diffs[j].record, "\t")
+	})
+	return diffs, total
+}
+
+func (d *dumpDiff) empty() bool {
+	return d.series.Empty() && d.onlyXRowsCount == 0 && d.onlyYRowsCount == 0
+}
+
+// print writes the summary of differences to w. With details it lists the different series, samples and rows.
+func (d *dumpDiff) print(w io.Writer, details bool) error {
+	var sb strings.Builder
+	differentSamples := 0
+	for _, s := range d.series.Different {
+		differentSamples += len(s.Samples)
+	}
+
+	fmt.Fprintf(&sb, "Core metrics: %d series in the first dump, %d in the second\n", d.xSeries, d.ySeries)
+	fmt.Fprintf(&sb, "\tSeries only in the first dump: %d\n", len(d.series.OnlyX))
+	if details {
+		for _, m := range d.series.OnlyX {
+			fmt.Fprintf(&sb, "\t\t%s\n", victoriametrics.FormatLabels(m.Metric))
+		}
+	}
+	fmt.Fprintf(&sb, "\tSeries only in the second dump: %d\n", len(d.series.OnlyY))
+	if details {
+		for _, m := range d.series.OnlyY {
+			fmt.Fprintf(&sb, "\t\t%s\n", victoriametrics.FormatLabels(m.Metric))
+		}
+	}
+	fmt.Fprintf(&sb, "\tSeries with different samples: %d, samples: %d\n", len(d.series.Different), differentSamples)
+	if details {
+		for _, s := range d.series.Different {
+			fmt.Fprintf(&sb, "\t\t%s\n", victoriametrics.FormatLabels(s.Metric))
+			for _, sample := range s.Samples {
+				fmt.Fprintf(&sb, "\t\t\t%d: %s != %s\n", sample.Timestamp, formatSampleValue(sample.X), formatSampleValue(sample.Y))
+			}
+		}
+	}
+
+	fmt.Fprintf(&sb, "QAN: %d rows in the first dump, %d in the second\n", d.xRows, d.yRows)
+	fmt.Fprintf(&sb, "\tRows only in the first dump: %d\n", d.onlyXRowsCount)
+	if details {
+		writeRowsDiff(&sb, d.onlyXRows)
+	}
+	fmt.Fprintf(&sb, "\tRows only in the second dump: %d\n", d.onlyYRowsCount)
+	if details {
+		writeRowsDiff(&sb, d.onlyYRows)
+	}
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func writeRowsDiff(sb *strings.Builder, diffs []rowsDiff) {
+	for _, r := range diffs {
+		row := strings.Join(r.record, "\t")
+		if r.count > 1 {
+			fmt.Fprintf(sb, "\t\t%s (x%d)\n", row, r.count)
+		} else {
+			fmt.Fprintf(sb, "\t\t%s\n", row)
+		}
+	}
+}
+
+func formatSampleValue(v *float64) string {
+	if v == nil {
+		return "missing"
+	}
+	return fmt.Sprint(*v)
+}
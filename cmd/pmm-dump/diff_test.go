@@ -0,0 +1,129 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+
+	"pmm-dump/pkg/dump"
+)
+
+func TestDiffDumps(t *testing.T) {
+	newDump := func(files ...dump.File) *bytes.Reader {
+		var buf bytes.Buffer
+		w, err := dump.NewWriter(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range append(files, dump.File{Name: dump.MetaFilename, Content: []byte(`{}`)}) {
+			if err := w.AddFile(f.Name, f.Content); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return bytes.NewReader(buf.Bytes())
+	}
+
+	x := newDump(
+		dump.File{Name: "vm/1-2.bin", Content: gzipped(t, `{"metric":{"__name__":"up"},"values":[1],"timestamps":[1000]}`+
+			`{"metric":{"__name__":"mysql_up"},"values":[1],"timestamps":[1000]}`)},
+		dump.File{Name: "ch/1-2-0.tsv", Content: []byte("q1\t1\nq2\t2\nq2\t2\n")},
+	)
+	// The same series and rows in other chunks with one missing series and one missing row
+	y := newDump(
+		dump.File{Name: "vm/1-3.bin", Content: gzipped(t, `{"metric":{"__name__":"up"},"values":[1],"timestamps":[1000]}`)},
+		dump.File{Name: "ch/1-3-0.tsv", Content: []byte("q2\t2\n")},
+		dump.File{Name: "ch/1-3-1.tsv", Content: []byte("q1\t1\n")},
+	)
+
+	d, err := diffDumps(x, y, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.empty() {
+		t.Fatal("diff shouldn't be empty")
+	}
+	var buf bytes.Buffer
+	if err := d.print(&buf, true); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"Core metrics: 2 series in the first dump, 1 in the second",
+		"Series only in the first dump: 1\n\t\t{__name__=\"mysql_up\"}",
+		"Series only in the second dump: 0",
+		"QAN: 3 rows in the first dump, 2 in the second",
+		"Rows only in the first dump: 1\n\t\tq2\t2\n",
+		"Rows only in the second dump: 0",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Fatalf("want %q in diff:\n%s", want, buf.String())
+		}
+	}
+
+	same := dump.File{Name: "ch/1-2-0.tsv", Content: []byte("q1\t1\n")}
+	d, err = diffDumps(newDump(same), newDump(same), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !d.empty() {
+		t.Fatal("diff of equal dumps should be empty")
+	}
+}
+
+func BenchmarkReadDumpData(b *testing.B) {
+	var buf bytes.Buffer
+	w, err := dump.NewWriter(&buf)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for chunk := 0; chunk < 200; chunk++ {
+		var content bytes.Buffer
+		gw := gzip.NewWriter(&content)
+		for series := 0; series < 100; series++ {
+			fmt.Fprintf(gw, `{"metric":{"__name__":"up","instance":"node%d"},"values":[1,2,3],"timestamps":[%d,%d,%d]}`+"\n",
+				series, chunk*3000, chunk*3000+1000, chunk*3000+2000)
+		}
+		if err := gw.Close(); err != nil {
+			b.Fatal(err)
+		}
+		if err := w.AddFile(fmt.Sprintf("vm/%d-%d.bin", chunk, chunk+1), content.Bytes()); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		b.Fatal(err)
+	}
+
+	for _, workers := range []int{1, 4, runtime.NumCPU()} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				data, err := readDumpData(bytes.NewReader(buf.Bytes()), workers)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if data.series.Len() != 100 {
+					b.Fatalf("want 100 series, got %d", data.series.Len())
+				}
+			}
+		})
+	}
+}
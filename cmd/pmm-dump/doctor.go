@@ -0,0 +1,296 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/valyala/fasthttp"
+
+	"pmm-dump/pkg/clickhouse"
+	"pmm-dump/pkg/dump"
+	"pmm-dump/pkg/grafana/client"
+	"pmm-dump/pkg/util"
+	"pmm-dump/pkg/victoriametrics"
+)
+
+// doctorSeverity orders issues found by the doctor command: errors are printed before warnings.
+type doctorSeverity int
+
+const (
+	severityWarning doctorSeverity = iota
+	severityError
+)
+
+func (s doctorSeverity) String() string {
+	if s == severityError {
+		return "error"
+	}
+	return "warning"
+}
+
+// doctorIssue is a problem found by the doctor command with the suggested fix.
+type doctorIssue struct {
+	severity doctorSeverity
+	problem  string
+	fix      string
+}
+
+type doctorReport struct {
+	issues []doctorIssue
+}
+
+func (r *doctorReport) add(severity doctorSeverity, problem, fix string) {
+	r.issues = append(r.issues, doctorIssue{severity: severity, problem: problem, fix: fix})
+}
+
+func (r *doctorReport) hasErrors() bool {
+	for _, issue := range r.issues {
+		if issue.severity == severityError {
+			return true
+		}
+	}
+	return false
+}
+
+// print writes the issues to w, the most severe first. Issues of the same severity keep the order of checks.
+func (r *doctorReport) print(w io.Writer) error {
+	if len(r.issues) == 0 {
+		_, err := fmt.Fprintln(w, "No issues found")
+		return err
+	}
+	issues := make([]doctorIssue, len(r.issues))
+	copy(issues, r.issues)
+	sort.SliceStable(issues, func(i, j int) bool {
+		return issues[i].severity > issues[j].severity
+	})
+	for i, issue := range issues {
+		if _, err := fmt.Fprintf(w, "%d. [%s] %s\n\tFix: %s\n", i+1, issue.severity, issue.problem, issue.fix); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// vmMaxInsertRequestSize is the default limit of VictoriaMetrics import request size (`-maxInsertRequestSize` flag).
+const vmMaxInsertRequestSize = 32 * 1024 * 1024
+
+// diagnoseDump reads the whole dump and reports if it's truncated, has unknown files or chunks too large to import.
+func diagnoseDump(r io.Reader, report *doctorReport) {
+	dr, err := dump.NewReader(r)
+	if err != nil {
+		report.add(severityError, fmt.Sprintf("Dump is not a gzip archive: %v", err), "Check `--dump-path`, the file may be not a dump or truncated")
+		return
+	}
+	defer dr.Close() //nolint:errcheck
+
+	corrupted := func(err error) {
+		report.add(severityError, fmt.Sprintf("Dump is corrupted: %v", err), "The dump is probably truncated: copy or export it again")
+	}
+
+	var meta *dump.Meta
+	var chunks, emptyChunks int
+	var maxVMChunkSize int64
+	// Metric names are collected before the meta is read, as it's the last file of the dump
+	vmNames := make(map[string]bool)
+	var vmNamesUnknown bool
+	for {
+		header, err := dr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			corrupted(err)
+			return
+		}
+		content, err := io.ReadAll(dr)
+		if err != nil {
+			corrupted(err)
+			return
+		}
+
+		dir, filename := path.Split(header.Name)
+		st := dump.ParseSourceType(path.Clean(dir))
+		switch {
+		case header.Name == dump.MetaFilename:
+			meta = new(dump.Meta)
+			if err := json.Unmarshal(content, meta); err != nil {
+				report.add(severityError, fmt.Sprintf("Meta file is invalid: %v", err), "Export the dump again")
+			}
+		case filename == dump.LogFilename || filename == dump.ChunkStatsFilename ||
+			header.Name == dump.AgentConfigFilename || header.Name == dump.AnnotationsFilename || header.Name == dump.VMMetadataFilename ||
+			header.Name == dump.FiltersFilename || header.Name == dump.VMMetricMetadataFilename:
+		case dir == "" || st == dump.UndefinedSource:
+			report.add(severityError, fmt.Sprintf("Dump has unknown file %s, import would fail", header.Name), "Export the dump again")
+		default:
+			chunks++
+			if len(content) == 0 {
+				emptyChunks++
+			}
+			if st == dump.VictoriaMetrics && int64(len(content)) > maxVMChunkSize {
+				maxVMChunkSize = int64(len(content))
+			}
+			if st == dump.VictoriaMetrics && len(content) > 0 && !vmNamesUnknown {
+				if err := addMetricNames(vmNames, content); err != nil {
+					vmNamesUnknown = true
+				}
+			}
+		}
+	}
+
+	if meta == nil {
+		report.add(severityWarning, "Dump has no meta file, so import can't check versions and VM data format",
+			"Export the dump with a recent pmm-dump version")
+	}
+	if chunks == 0 {
+		report.add(severityWarning, "Dump has no chunks, there is nothing to import", "Check the time range and filters of the export")
+	}
+	if emptyChunks > 0 {
+		report.add(severityWarning, fmt.Sprintf("Dump has %d empty chunks", emptyChunks), "Nothing, they are skipped on import")
+	}
+	if maxVMChunkSize > vmMaxInsertRequestSize {
+		fix := "Export the dump again with a smaller `--chunk-time-range`"
+		if meta != nil && meta.VMDataFormat == victoriametrics.FormatJSON {
+			fix = fmt.Sprintf("Use `--vm-content-limit=%d` on import", vmMaxInsertRequestSize)
+		}
+		report.add(severityWarning, fmt.Sprintf("Largest core metrics chunk is %s, VictoriaMetrics may reject it with 413 error on import",
+			ByteCountBinary(maxVMChunkSize)), fix)
+	}
+	if meta != nil && meta.VMNameRegex != "" {
+		diagnoseNameScope(meta.VMNameRegex, vmNames, vmNamesUnknown, report)
+	}
+}
+
+// addMetricNames adds metric names of the gzipped JSON chunk to names. Chunks in other formats can't be parsed.
+func addMetricNames(names map[string]bool, content []byte) error {
+	gzr, err := gzip.NewReader(bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	defer gzr.Close() //nolint:errcheck
+	metrics, err := victoriametrics.ParseMetrics(gzr)
+	if err != nil {
+		return err
+	}
+	for _, m := range metrics {
+		names[m.Metric["__name__"]] = true
+	}
+	return nil
+}
+
+// diagnoseNameScope reports core metrics of the dump with names not matching the name regexp the dump was exported with.
+func diagnoseNameScope(nameRegex string, names map[string]bool, namesUnknown bool, report *doctorReport) {
+	if namesUnknown {
+		report.add(severityWarning, fmt.Sprintf("Can't check that core metrics names match %q of the dump: chunks are not in JSON format", nameRegex),
+			"Nothing, only JSON dumps can be checked")
+		return
+	}
+	m, err := victoriametrics.NewSeriesMatcher(victoriametrics.NameRegexSelector(nameRegex))
+	if err != nil {
+		report.add(severityError, fmt.Sprintf("Name regexp of the dump is invalid: %v", err), "Export the dump again")
+		return
+	}
+	var leaked []string
+	for name := range names {
+		if !m.Match(map[string]string{"__name__": name}) {
+			leaked = append(leaked, name)
+		}
+	}
+	if len(leaked) > 0 {
+		sort.Strings(leaked)
+		report.add(severityError, fmt.Sprintf("Dump has core metrics with names not matching %q: %s", nameRegex, strings.Join(leaked, ", ")),
+			"Export the dump again with `--name-regex`, or don't rely on its name scope")
+	}
+}
+
+// diagnosePMM checks PMM connection, credentials, version and clock, and VictoriaMetrics and ClickHouse endpoints.
+func diagnosePMM(ctx context.Context, c, vmC *client.Client, pmmURL string, pmmConfig util.PMMConfig, report *doctorReport) {
+	status, _, err := c.Get(pmmURL + "/v1/version")
+	if err != nil {
+		report.add(severityError, fmt.Sprintf("Can't connect to PMM: %v", err),
+			"Check `--pmm-url` and network access to PMM. Use `--allow-insecure-certs` for self-signed certificates")
+		return
+	}
+	switch status {
+	case fasthttp.StatusOK:
+	case fasthttp.StatusUnauthorized, fasthttp.StatusForbidden:
+		report.add(severityError, fmt.Sprintf("PMM rejected the credentials: %d", status),
+			"Check `--pmm-user` and `--pmm-pass`, or the API token may be expired: create a new one for `--pmm-token`")
+		return
+	default:
+		report.add(severityError, fmt.Sprintf("PMM version request failed: %d", status), "Check that `--pmm-url` points to PMM server")
+		return
+	}
+
+	pmmVer, _, err := getPMMVersion(pmmURL, c)
+	switch {
+	case err != nil:
+		report.add(severityError, fmt.Sprintf("Failed to get PMM version: %v", err), "Check that `--pmm-url` points to PMM server")
+	case pmmVer < minPMMServerVersion:
+		report.add(severityError, fmt.Sprintf("PMM server version %s is not supported", pmmVer),
+			fmt.Sprintf("Upgrade PMM server to %s or later", minPMMServerVersion))
+	}
+
+	skew, err := pmmClockSkew(pmmURL, c)
+	switch {
+	case err != nil:
+		report.add(severityWarning, fmt.Sprintf("Failed to check clock skew: %v", err), "Specify `--start-ts` and `--end-ts` on export")
+	case skew > maxClockSkew:
+		report.add(severityWarning, fmt.Sprintf("Clock skew detected: local clock differs from PMM server clock by %v", skew),
+			"Sync the clocks or specify `--start-ts` and `--end-ts` on export")
+	}
+
+	if err := victoriametrics.ExportTestRequest(vmC, pmmConfig.VictoriaMetricsURL); err != nil {
+		if errors.Is(err, victoriametrics.ErrNotFound) {
+			report.add(severityError, "VictoriaMetrics export endpoint is not found", "Check `--victoria-metrics-url`, PMM server version may be unsupported")
+		} else {
+			report.add(severityError, fmt.Sprintf("Can't connect to VictoriaMetrics: %v", err), "Check `--victoria-metrics-url`")
+		}
+	}
+
+	// Empty import request writes nothing, but checks the endpoint and the permissions
+	status, _, err = vmC.Post(pmmConfig.VictoriaMetricsURL + "/api/v1/import")
+	switch {
+	case err != nil:
+		report.add(severityError, fmt.Sprintf("Can't connect to VictoriaMetrics: %v", err), "Check `--victoria-metrics-url`")
+	case status == fasthttp.StatusNotFound:
+		report.add(severityError, "VictoriaMetrics import endpoint is not found", "Check `--victoria-metrics-url`, PMM server version may be unsupported")
+	case status == fasthttp.StatusUnauthorized || status == fasthttp.StatusForbidden:
+		report.add(severityWarning, fmt.Sprintf("Credentials are not allowed to import core metrics: %d", status), "Use PMM admin credentials for import")
+	case status >= fasthttp.StatusBadRequest:
+		report.add(severityWarning, fmt.Sprintf("VictoriaMetrics import endpoint check failed: %d", status), "Check `--victoria-metrics-url`")
+	}
+
+	_, err = clickhouse.NewSource(ctx, clickhouse.Config{
+		ConnectionURL: pmmConfig.ClickHouseURL,
+		InitRetries:   1,
+	})
+	switch {
+	case errors.Is(err, clickhouse.ErrMetricsTableNotFound):
+		report.add(severityError, err.Error(), "Enable Query Analytics on PMM or don't use `--dump-qan`")
+	case err != nil:
+		report.add(severityError, fmt.Sprintf("Can't connect to ClickHouse: %v", err),
+			"Check `--click-house-url` and that ClickHouse port is reachable, or don't use `--dump-qan`")
+	}
+}
@@ -0,0 +1,184 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"strings"
+	"testing"
+
+	"pmm-dump/pkg/dump"
+)
+
+func TestDoctorReportPrint(t *testing.T) {
+	report := new(doctorReport)
+	report.add(severityWarning, "first warning", "fix 1")
+	report.add(severityError, "first error", "fix 2")
+	report.add(severityWarning, "second warning", "fix 3")
+	report.add(severityError, "second error", "fix 4")
+
+	var buf bytes.Buffer
+	if err := report.print(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var problems []string
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if strings.HasPrefix(line, "\tFix: ") || line == "" {
+			continue
+		}
+		problems = append(problems, line)
+	}
+	want := []string{
+		"1. [error] first error",
+		"2. [error] second error",
+		"3. [warning] first warning",
+		"4. [warning] second warning",
+	}
+	if strings.Join(problems, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("want %v, got %v", want, problems)
+	}
+	if !report.hasErrors() {
+		t.Fatal("report should have errors")
+	}
+
+	buf.Reset()
+	if err := new(doctorReport).print(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "No issues found\n" {
+		t.Fatalf("unexpected report of no issues: %s", buf.String())
+	}
+}
+
+func TestDiagnoseDump(t *testing.T) {
+	tests := []struct {
+		name         string
+		files        []dump.File
+		truncate     bool
+		wantProblems []string
+	}{
+		{
+			name: "valid",
+			files: []dump.File{
+				{Name: "vm/1-2.bin", Content: []byte("content")},
+				{Name: dump.MetaFilename, Content: []byte(`{"vm-data-format":"json"}`)},
+			},
+		},
+		{
+			name: "no meta and empty chunk",
+			files: []dump.File{
+				{Name: "vm/1-2.bin", Content: []byte("content")},
+				{Name: "ch/0-1-2.tsv"},
+			},
+			wantProblems: []string{"no meta file", "1 empty chunks"},
+		},
+		{
+			name: "unknown file",
+			files: []dump.File{
+				{Name: "xx/1-2.bin", Content: []byte("content")},
+				{Name: dump.MetaFilename, Content: []byte(`{}`)},
+			},
+			wantProblems: []string{"unknown file xx/1-2.bin", "no chunks"},
+		},
+		{
+			name: "large chunk",
+			files: []dump.File{
+				{Name: "vm/1-2.bin", Content: make([]byte, vmMaxInsertRequestSize+1)},
+				{Name: dump.MetaFilename, Content: []byte(`{"vm-data-format":"json"}`)},
+			},
+			wantProblems: []string{"413"},
+		},
+		{
+			name: "truncated",
+			files: []dump.File{
+				{Name: "vm/1-2.bin", Content: bytes.Repeat([]byte("content"), 1000)},
+				{Name: dump.MetaFilename, Content: []byte(`{}`)},
+			},
+			truncate:     true,
+			wantProblems: []string{"corrupted"},
+		},
+		{
+			name: "metrics out of name scope",
+			files: []dump.File{
+				{Name: "vm/1-2.bin", Content: gzipped(t, `{"metric":{"__name__":"mysql_up"},"values":[1],"timestamps":[1000]}`+
+					`{"metric":{"__name__":"node_load1"},"values":[1],"timestamps":[1000]}`)},
+				{Name: dump.MetaFilename, Content: []byte(`{"vm-data-format":"json","vm-name-regex":"mysql_.*"}`)},
+			},
+			wantProblems: []string{`not matching "mysql_.*": node_load1`},
+		},
+		{
+			name: "metrics in name scope",
+			files: []dump.File{
+				{Name: "vm/1-2.bin", Content: gzipped(t, `{"metric":{"__name__":"mysql_up"},"values":[1],"timestamps":[1000]}`)},
+				{Name: dump.MetaFilename, Content: []byte(`{"vm-data-format":"json","vm-name-regex":"mysql_.*"}`)},
+			},
+		},
+		{
+			name: "name scope of native dump",
+			files: []dump.File{
+				{Name: "vm/1-2.bin", Content: []byte("content")},
+				{Name: dump.MetaFilename, Content: []byte(`{"vm-data-format":"native","vm-name-regex":"mysql_.*"}`)},
+			},
+			wantProblems: []string{"Can't check"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := dump.NewWriter(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, f := range tt.files {
+				if err := w.AddFile(f.Name, f.Content); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			content := buf.Bytes()
+			if tt.truncate {
+				content = content[:len(content)/2]
+			}
+
+			report := new(doctorReport)
+			diagnoseDump(bytes.NewReader(content), report)
+			if len(report.issues) != len(tt.wantProblems) {
+				t.Fatalf("want %d issues, got %v", len(tt.wantProblems), report.issues)
+			}
+			for i, want := range tt.wantProblems {
+				if !strings.Contains(report.issues[i].problem, want) {
+					t.Fatalf("want issue about %q, got %q", want, report.issues[i].problem)
+				}
+			}
+		})
+	}
+}
+
+func gzipped(t *testing.T, s string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	if _, err := gw.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
@@ -16,16 +16,26 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"net/url"
 	"os"
+	"os/signal"
+	"path"
+	"sort"
+	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/alecthomas/kingpin/v2"
+	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 
+	"pmm-dump/pkg/clickhouse"
 	"pmm-dump/pkg/dump"
 	grafana "pmm-dump/pkg/grafana"
 	"pmm-dump/pkg/grafana/client"
@@ -40,6 +50,7 @@ var (
 	GitBranch  string
 	GitCommit  string
 	GitVersion string
+	BuildDate  string
 )
 
 func main() { //nolint:gocyclo,maintidx
@@ -47,7 +58,7 @@ func main() { //nolint:gocyclo,maintidx
 		cli = kingpin.New("pmm-dump", "Percona PMM Dump")
 
 		// general options
-		pmmURL = cli.Flag("pmm-url", "PMM connection string").Envar("PMM_URL").String()
+		pmmURLs = cli.Flag("pmm-url", "PMM connection string. Export accepts several of them to export every PMM server into its own dump").Envar("PMM_URL").Strings()
 
 		pmmHost     = cli.Flag("pmm-host", "PMM server host(with scheme)").Envar("PMM_HOST").String()
 		pmmPort     = cli.Flag("pmm-port", "PMM server port").Envar("PMM_PORT").String()
@@ -56,21 +67,45 @@ func main() { //nolint:gocyclo,maintidx
 		pmmCookie   = cli.Flag("pmm-cookie", "PMM Auth cookie").Envar("PMM_COOKIE").String()
 		pmmPassword = cli.Flag("pmm-pass", "PMM credentials password").Envar("PMM_PASS").String()
 
+		_ = cli.Flag(envFileFlag, "Path to a dotenv file with environment variables, ex. PMM_URL. "+
+			"Flags and environment variables take precedence over it").ExistingFile()
+		_ = cli.Flag(credentialsFileFlag, "Path to a dotenv file with PMM secrets only: PMM_PASS, PMM_TOKEN or PMM_COOKIE. "+
+			"It should be accessible by the owner only. Flags and environment variables take precedence over it").ExistingFile()
+
 		victoriaMetricsURL = cli.Flag("victoria-metrics-url", "VictoriaMetrics connection string").String()
 		clickHouseURL      = cli.Flag("click-house-url", "ClickHouse connection string").String()
+		vmHeaders          = cli.Flag("vm-header", "Header name=value to send with every VictoriaMetrics request, ex. 'X-Scope-OrgID=tenant1'. Use multiple times to send multiple headers").StringMap()
 
 		dumpCore = cli.Flag("dump-core", "Specify to export/import core metrics").Default("true").Bool()
 		dumpQAN  = cli.Flag("dump-qan", "Specify to export/import QAN metrics").Bool()
 
 		enableVerboseMode  = cli.Flag("verbose", "Enable verbose mode").Short('v').Bool()
+		enableTrace        = cli.Flag("trace", "Log URL, status, body sizes and duration of every PMM and VictoriaMetrics HTTP request. Enables debug logs").Bool()
+		logFormat          = cli.Flag("log-format", "Log format: console or json").Default("console").Enum("console", "json")
+		noProgress         = cli.Flag("no-progress", "Don't log export and import progress with ETA every 5 seconds").Bool()
 		allowInsecureCerts = cli.Flag("allow-insecure-certs",
 			"Accept any certificate presented by the server and any host name in that certificate").Bool()
 
 		dumpPath = cli.Flag("dump-path", "Path to dump file").Short('d').String()
 
+		s3Endpoint = cli.Flag("s3-endpoint", "Endpoint of S3-compatible storage for `s3://bucket/key` dump paths. AWS S3 of the configured region is used by default").Envar("AWS_ENDPOINT_URL_S3").String()
+		dumpS3URL  = cli.Flag("dump-s3-url", "URL of the dump in S3 instead of `--dump-path`. On export it's the prefix the auto-named dump is uploaded under, unless it ends with the dump extension").String()
+
 		workersCount = cli.Flag("workers", "Set the number of reading workers").Int()
 
+		_ = cli.Flag("version-json", "Show tool version of the binary in JSON format").PreAction(func(*kingpin.ParseContext) error {
+			data, err := versionJSON()
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(data))
+			os.Exit(0)
+			return nil
+		}).Bool()
+
 		vmNativeData = cli.Flag("vm-native-data", "Use VictoriaMetrics' native export format. Reduces dump size, but can be incompatible between PMM versions").Bool()
+		vmFormat     = cli.Flag("vm-format", "VictoriaMetrics data format: json, native or openmetrics (Prometheus text exposition format)").
+				Enum(victoriametrics.FormatJSON, victoriametrics.FormatNative, victoriametrics.FormatOpenMetrics)
 		// export command options
 		exportCmd = cli.Command("export", "Export PMM Server metrics to dump file."+
 			"By default only the 4 last hours are exported, but it can be configured via start-ts/end-ts options")
@@ -81,14 +116,41 @@ func main() { //nolint:gocyclo,maintidx
 			"End date-time to filter exported metrics, ex. "+time.RFC3339).String()
 
 		tsSelector = exportCmd.Flag("ts-selector", "Time series selector to pass to VM").String()
-		where      = exportCmd.Flag("where", "ClickHouse only. WHERE statement").Short('w').String()
+		where      = exportCmd.Flag("where", "ClickHouse only. WHERE statement, {{start}} and {{end}} are replaced with the export time range in unix seconds").Short('w').String()
+		whereFile  = exportCmd.Flag("ch-where-file", "ClickHouse only. Path to a file with WHERE statement").ExistingFile()
 
 		instances  = exportCmd.Flag("instance", "Name to filter instances by service names, node names, or instance names. Use multiple times to filter by multiple names").Strings()
 		dashboards = exportCmd.Flag("dashboard", "Dashboard name to filter. Use multiple times to filter by multiple dashboards").Strings()
 
+		metricNames       = exportCmd.Flag("metric", "Metric name to export. Use multiple times to export multiple metrics").Strings()
+		nameRegex         = exportCmd.Flag("name-regex", "Export only core metrics with names matching the regexp, ex. 'mysql_.*'. It's stored in the meta, so `doctor` can check the dump has no other metrics").String()
+		vmGlobalSelector  = exportCmd.Flag("vm-global-selector", "Label filters added to every core metrics selector: user, dashboard and instance ones, ex. 'cluster=\"prod\"'").String()
+		includeVMInternal = exportCmd.Flag("include-vm-internal", "Export VictoriaMetrics internal metrics (vm_*) in addition to the filtered ones").Bool()
+		labelValuesLimit  = exportCmd.Flag("label-values-limit", "Max number of values of a dashboard variable fetched from VictoriaMetrics to resolve `--dashboard` selectors. 0 means no limit").Default("0").Int()
+
 		chunkTimeRange = exportCmd.Flag("chunk-time-range", "Time range to be fit into a single chunk (core metrics). "+
 			"5 minutes by default, example '45s', '5m', '1h'").Default("5m").Duration()
-		chunkRows = exportCmd.Flag("chunk-rows", "Amount of rows to fit into a single chunk (qan metrics)").Default("100000").Int()
+		chunkRows    = exportCmd.Flag("chunk-rows", "Amount of rows to fit into a single chunk (qan metrics)").Default("100000").Int()
+		chMaxRows    = exportCmd.Flag("ch-max-rows", "Max amount of rows to export in total (qan metrics). 0 means no limit").Default("0").Int()
+		chSinceDump  = exportCmd.Flag("since-dump", "Path to a prior dump. Export only QAN rows with period_start after the max one of the prior dump").ExistingFile()
+		chCheckpoint = exportCmd.Flag("ch-checkpoint", "Path to a file with QAN export progress. A stopped export with the same file and time range writes only QAN rows not exported yet").String()
+		chFinal      = exportCmd.Flag("ch-final", "Read QAN metrics with FINAL modifier, so rows not merged yet by ReplacingMergeTree engine are deduplicated. It's slower").Bool()
+		chSpoolDir   = exportCmd.Flag("ch-spool-dir", "Directory to write QAN chunks to row by row while they are read, so large chunks are not held in memory. By default chunks are read into memory").ExistingDir()
+
+		vmMaxChunkSize = exportCmd.Flag("vm-max-chunk-size", "Split core metrics chunks larger than this size (in bytes). JSON format only. 0 means no limit").Default("0").Uint64()
+		vmSplitByName  = exportCmd.Flag("vm-split-by-name", "Write every metric name into its own core metrics chunk. JSON format only").Bool()
+		vmExportParams = exportCmd.Flag("vm-export-param", "Query param key=value of core metrics export requests: reduce_mem_usage or max_rows_per_line, ex. 'reduce_mem_usage=1'. Use multiple times to pass multiple params").StringMap()
+		vmDedup        = exportCmd.Flag("vm-dedup", "Merge duplicate series of core metrics chunks and drop samples with the same timestamp, keeping the last value. JSON and OpenMetrics formats only").Bool()
+
+		alignChunks = exportCmd.Flag("align-chunks", "Align core metrics chunk boundaries to multiples of this step, ex. the scrape interval '1m'. "+
+			"Chunk time range should be a multiple of it. Disabled by default").Default("0s").Duration()
+
+		maxInFlightBytes = exportCmd.Flag("max-inflight-bytes", "Max total size of chunks read from PMM, but not written to the dump yet (in bytes). 0 means no limit").Default("0").Int64()
+		writeBufferSize  = exportCmd.Flag("write-buffer-size", "Size of the buffer the dump file is written through (in bytes), larger writes are faster on network file systems. 0 disables buffering").Default(strconv.Itoa(dump.DefaultWriteBufferSize)).Int()
+		compression      = exportCmd.Flag("compression", "Compression of the dump file: gzip:1..9 (level), zstd or none. Import detects it automatically").Default(dump.DefaultCompression.String()).String()
+		chunkDeadline    = exportCmd.Flag("chunk-deadline", "Skip chunks which take longer than this duration to read, ex. '2m'. Skipped chunks are listed in the meta. Disabled by default").Default("0s").Duration()
+
+		chunkCompressionLevel = exportCmd.Flag("chunk-compression-level", "Gzip level (1-9) of core metrics chunks compressed by pmm-dump: split or converted to OpenMetrics. 0 means the default level").Default("0").Int()
 
 		ignoreLoad = exportCmd.Flag("ignore-load", "Disable checking for load threshold values").Bool()
 		maxLoad    = exportCmd.Flag("max-load", "Max load threshold values. For the CPU value is overall regardless cores count: 0-100%").
@@ -96,17 +158,70 @@ func main() { //nolint:gocyclo,maintidx
 		criticalLoad = exportCmd.Flag("critical-load", "Critical load threshold values. For the CPU value is overall regardless cores count: 0-100%").
 				Default(fmt.Sprintf("%v=90,%v=90,%v=30", transferer.ThresholdCPU, transferer.ThresholdRAM, transferer.ThresholdMYRAM)).String()
 
-		stdout = exportCmd.Flag("stdout", "Redirect output to STDOUT").Bool()
+		stdout       = exportCmd.Flag("stdout", "Redirect output to STDOUT").Bool()
+		stdoutFormat = exportCmd.Flag("stdout-format", "Format of the dump written to STDOUT: tar (gzipped tar archive) or raw (length-prefixed stream of dump files without tar)").Default("tar").Enum("tar", "raw")
 
 		exportServicesInfo = exportCmd.Flag("export-services-info", "Export overview info about all the services, that are being monitored").Bool()
+		exportAgentConfig  = exportCmd.Flag("export-pmm-agent-config", "Export the pmm-agents status of the `/v1/management/AgentStatus` API with the services registered on them").Bool()
+		exportChunkStats   = exportCmd.Flag("export-chunk-stats", "Export per-chunk statistics: size, read duration and metrics count").Bool()
+		exportAnnotations  = exportCmd.Flag("export-annotations", "Export Grafana annotations within the export time range").Bool()
+		exportVMMetadata   = exportCmd.Flag("export-vm-metadata", "Export VictoriaMetrics metadata: retention period and TSDB status").Bool()
+		includeVMMetadata  = exportCmd.Flag("include-vm-metadata", "Export metric metadata of core metrics: type, help and unit. It's skipped if VictoriaMetrics doesn't provide it").Bool()
+		printLoadInterval  = exportCmd.Flag("print-load-interval", "Log current load values at this interval, ex. '10s'. Disabled by default").Default("0s").Duration()
+		keepPartial        = exportCmd.Flag("keep-partial", "Keep the partially written dump file if export fails. By default it's removed").Bool()
+		resume             = exportCmd.Flag("resume", "Resume the failed export into the partial dump at `--dump-path`: its complete chunks are kept and only missing ones are exported").Bool()
+		checkpointFile     = exportCmd.Flag("checkpoint-file", "Path to a file with export progress. A failed export with the same file and time range appends the chunks not exported yet to the partial dump").String()
+		expectedSize       = exportCmd.Flag("expected-size", "Expected size of the dump file (in bytes). Export fails before writing anything if there is less free disk space. 0 disables the check").Default("0").Uint64()
+		skipDiskCheck      = exportCmd.Flag("skip-disk-check", "Don't check free disk space against `--expected-size`").Bool()
+		chunkChecksums     = exportCmd.Flag("verify-checksums", "Store SHA-256 checksums of chunks in the dump meta, so import with `--verify-checksums` detects corrupted chunks").Bool()
+		dryRun             = exportCmd.Flag("dry-run", "Print the chunks count and the estimated size by source without writing the dump. The first chunk of every source with matching data is read to estimate the size").Bool()
+
+		watch         = exportCmd.Flag("watch", "Export the latest chunk-time-range window every interval into new dump files, until interrupted").Bool()
+		watchInterval = exportCmd.Flag("interval", "Interval between watch exports").Default("15m").Duration()
+		watchCount    = exportCmd.Flag("watch-count", "Number of watch exports. 0 means no limit").Default("0").Int()
 		// import command options
 		importCmd = cli.Command("import", "Import PMM Server metrics from dump file")
 
 		vmContentLimit = importCmd.Flag("vm-content-limit", "Limit the chunk content size for VictoriaMetrics (in bytes). Doesn't work with native format").Default("0").Uint64()
+		chAsyncInsert  = importCmd.Flag("ch-async-insert", "Use ClickHouse async inserts for QAN metrics, if supported by the server").Bool()
+		chDedup        = importCmd.Flag("ch-dedup", "Skip QAN rows already present in ClickHouse, so the dump can be imported again. Rows are staged in a temporary table first").Bool()
+		summaryOnly    = importCmd.Flag("summary-only", "Report what the dump contains and would be imported, without writing anything").Bool()
+		chunkGlob      = importCmd.Flag("chunk-glob", "Import only the chunks whose path in the dump matches the glob pattern, ex. 'vm/1717*-*.bin'").String()
+		importMatch    = importCmd.Flag("import-match", "Import only core metrics series matching the time series selector, ex. '{service_name=\"mongo\"}'. JSON format only").String()
+		importAddLabel = importCmd.Flag("import-add-label", "Label name=value to add to every imported core metrics series, ex. 'source_pmm=serverA'. Use multiple times to add multiple labels. JSON format only").StringMap()
+
+		assumeYes         = importCmd.Flag("yes", "Don't ask for confirmation if the target PMM already has data in the dump time range").Short('y').Bool()
+		importAnnotations = importCmd.Flag("import-annotations", "Import Grafana annotations, if the dump has them").Bool()
+		registerPMMAgent  = importCmd.Flag("register-pmm-agent", "Register services of pmm-agents stored with `--export-pmm-agent-config` on the target PMM, on the nodes with the same names").Bool()
+		onlyMetaCompare   = importCmd.Flag("only-meta-compare", "Compare the dump meta with the target PMM: versions, timezone and VM data format. Print the compatibility verdict and exit without importing").Bool()
+		verifyChecksums   = importCmd.Flag("verify-checksums", "Verify chunks against the checksums of the dump meta and fail on a corrupted chunk. The dump should be exported with `--verify-checksums`, piped dumps are not supported. Core metrics of chunks imported before the corrupted one are not rolled back").Bool()
+		waitForReady      = importCmd.Flag("wait-for-ready", "Wait up to this timeout for VictoriaMetrics to be ready before import, ex. '2m'. Useful right after a PMM restart. Disabled by default").Default("0s").Duration()
 
 		// show meta command options
-		showMetaCmd  = cli.Command("show-meta", "Shows metadata from the specified dump file")
-		prettifyMeta = showMetaCmd.Flag("prettify", "Print meta in human readable format").Default("true").Bool()
+		showMetaCmd   = cli.Command("show-meta", "Shows metadata from the specified dump file")
+		prettifyMeta  = showMetaCmd.Flag("prettify", "Print meta in human readable format").Default("true").Bool()
+		showTopChunks = showMetaCmd.Flag("show-top-chunks", "Show N largest chunks, if the dump has chunk stats").Default("0").Int()
+		showFilters   = showMetaCmd.Flag("filters", "Show selectors, WHERE statement and time range used for export in JSON format").Bool()
+
+		// cardinality command options
+		cardinalityCmd = cli.Command("cardinality", "Shows label cardinality of core metrics from the specified dump file")
+		topLabels      = cardinalityCmd.Flag("top", "Amount of top label names and values to show").Default("10").Int()
+
+		// scrub command options
+		scrubCmd     = cli.Command("scrub", "Redacts core metrics labels of the specified dump file and writes a new dump")
+		scrubOutput  = scrubCmd.Flag("output", "Path to the scrubbed dump file").Short('o').Required().String()
+		redactLabels = scrubCmd.Flag("redact-label", "Label name to redact values of. Use multiple times to redact multiple labels").Strings()
+		redactRegex  = scrubCmd.Flag("redact-regex", "Regex of label values to redact in any label").String()
+		scrubKeepQAN = scrubCmd.Flag("keep-qan", "Keep QAN chunks unredacted. By default they are dropped, as their columns are unknown offline").Bool()
+
+		// diff command options
+		diffCmd     = cli.Command("diff", "Compares core metrics series and QAN rows of two dump files. Core metrics should be in JSON format")
+		diffFirst   = diffCmd.Arg("first", "Path to the first dump file").Required().ExistingFile()
+		diffSecond  = diffCmd.Arg("second", "Path to the second dump file").Required().ExistingFile()
+		diffDetails = diffCmd.Flag("details", "List the different series, samples and rows").Bool()
+
+		// doctor command options
+		doctorCmd = cli.Command("doctor", "Diagnoses common problems of the specified dump file and of the connection to PMM")
 
 		// version command options
 		versionCmd = cli.Command("version", "Shows tool version of the binary")
@@ -122,12 +237,35 @@ func main() { //nolint:gocyclo,maintidx
 
 	log.Logger = log.Output(logConsoleWriter)
 
-	cmd, err := cli.DefaultEnvars().Parse(os.Args[1:])
+	cli.DefaultEnvars()
+	// Every later parse of the arguments, ex. for the dump meta, should accept `--dump-core=false` too
+	os.Args = append(os.Args[:1], expandBoolFlagValues(cli, os.Args[1:])...)
+	if err := loadCredentialsFile(cli, os.Args[1:]); err != nil {
+		log.Fatal().Msgf("Failed to load credentials file: %v", err)
+	}
+	if err := loadEnvFile(cli, os.Args[1:]); err != nil {
+		log.Fatal().Msgf("Failed to load env file: %v", err)
+	}
+
+	cmd, err := cli.Parse(os.Args[1:])
 	if err != nil {
 		log.Fatal().Msgf("Error parsing parameters: %s", err.Error())
 	}
+	pmmURL := new(string)
+	if len(*pmmURLs) > 0 {
+		*pmmURL = (*pmmURLs)[0]
+	}
+	if len(*pmmURLs) > 1 && cmd != exportCmd.FullCommand() {
+		log.Fatal().Msgf("Several `--pmm-url` are supported only by export, %s accepts one", cmd)
+	}
 
-	if *enableVerboseMode {
+	var logWriter io.Writer = logConsoleWriter
+	if *logFormat == "json" {
+		logWriter = os.Stderr
+		log.Logger = log.Output(logWriter)
+	}
+
+	if *enableVerboseMode || *enableTrace {
 		log.Logger = log.Logger.
 			With().Caller().Logger().
 			Hook(goroutineLoggingHook{}).
@@ -137,8 +275,44 @@ func main() { //nolint:gocyclo,maintidx
 			Level(zerolog.InfoLevel)
 	}
 
+	vmDataFormat, err := resolveVMDataFormat(*vmFormat, *vmNativeData)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid VictoriaMetrics data format")
+	}
+	// Content limits can't split chunks of other formats, so the conflict fails before any setup work
+	if vmDataFormat != victoriametrics.FormatJSON && *vmContentLimit > 0 {
+		log.Fatal().Msgf("`--vm-content-limit` is not supported with %s data format", vmDataFormat)
+	}
+	if vmDataFormat != victoriametrics.FormatJSON && *vmMaxChunkSize > 0 {
+		log.Fatal().Msgf("`--vm-max-chunk-size` is not supported with %s data format", vmDataFormat)
+	}
+
+	s3Opts := s3Options{endpoint: *s3Endpoint, insecureSkipVerify: *allowInsecureCerts}
+	if *dumpS3URL != "" {
+		if *dumpPath != "" {
+			log.Fatal().Msg("`--dump-s3-url` can't be used with `--dump-path`")
+		}
+		*dumpPath, err = dumpS3URLPath(*dumpS3URL, cmd == exportCmd.FullCommand())
+		if err != nil {
+			log.Fatal().Msgf("Invalid `--dump-s3-url`: %v", err)
+		}
+	}
+
 	switch cmd {
 	case exportCmd.FullCommand():
+		if *watch {
+			switch {
+			case *stdout:
+				log.Fatal().Msg("`--watch` can't be used with `--stdout`")
+			case *start != "" || *end != "":
+				log.Fatal().Msg("`--watch` can't be used with `--start-ts` or `--end-ts`, the latest `--chunk-time-range` window is exported")
+			case *watchInterval <= 0:
+				log.Fatal().Msg("`--interval` should be positive")
+			case *watchCount < 0:
+				log.Fatal().Msg("`--watch-count` can't be negative")
+			}
+		}
+
 		var startTime, endTime time.Time
 
 		if *end != "" {
@@ -155,6 +329,8 @@ func main() { //nolint:gocyclo,maintidx
 			if err != nil {
 				log.Fatal().Msgf("Error parsing start date-time: %v", err)
 			}
+		} else if *watch {
+			startTime = endTime.Add(-1 * *chunkTimeRange)
 		} else {
 			startTime = endTime.Add(-1 * defaultTimeframe)
 		}
@@ -163,6 +339,134 @@ func main() { //nolint:gocyclo,maintidx
 			log.Fatal().Msg("Invalid time range: start > end")
 		}
 
+		dumpCompression, err := dump.ParseCompression(*compression)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Invalid `--compression`")
+		}
+
+		if len(*pmmURLs) > 1 {
+			switch {
+			case *stdout:
+				log.Fatal().Msg("Several `--pmm-url` can't be used with `--stdout`")
+			case *watch:
+				log.Fatal().Msg("Several `--pmm-url` can't be used with `--watch`")
+			case *chCheckpoint != "":
+				log.Fatal().Msg("Several `--pmm-url` can't be used with `--ch-checkpoint`: the progress of every server would be written to the same file")
+			case *checkpointFile != "":
+				log.Fatal().Msg("Several `--pmm-url` can't be used with `--checkpoint-file`: the progress of every server would be written to the same file")
+			case *resume:
+				log.Fatal().Msg("Several `--pmm-url` can't be used with `--resume`")
+			case *dryRun:
+				log.Fatal().Msg("Several `--pmm-url` can't be used with `--dry-run`")
+			}
+			exports, err := planServerExports(*pmmURLs, *dumpPath, endTime, dumpCompression)
+			if err != nil {
+				log.Fatal().Err(err).Msg("Failed to plan exports of PMM servers")
+			}
+			// All servers are exported at the same time range
+			os.Exit(exportServers(exports, startTime, endTime))
+		}
+
+		if vmDataFormat != victoriametrics.FormatJSON && *vmSplitByName {
+			log.Fatal().Msgf("`--vm-split-by-name` is not supported with %s data format", vmDataFormat)
+		}
+		if vmDataFormat == victoriametrics.FormatNative && *vmDedup {
+			log.Fatal().Msgf("`--vm-dedup` is not supported with %s data format", vmDataFormat)
+		}
+		if *chunkCompressionLevel < 0 || *chunkCompressionLevel > gzip.BestCompression {
+			log.Fatal().Msgf("`--chunk-compression-level` should be between 0 and %d", gzip.BestCompression)
+		}
+		if *writeBufferSize < 0 {
+			log.Fatal().Msg("`--write-buffer-size` can't be negative")
+		}
+		if *labelValuesLimit < 0 {
+			log.Fatal().Msg("`--label-values-limit` can't be negative")
+		}
+		if *chMaxRows < 0 {
+			log.Fatal().Msg("`--ch-max-rows` can't be negative")
+		}
+		if *stdoutFormat == "raw" && !*stdout {
+			log.Fatal().Msg("`--stdout-format=raw` requires `--stdout`")
+		}
+		if *stdoutFormat == "raw" && dumpCompression != dump.DefaultCompression {
+			log.Fatal().Msg("`--compression` can't be used with `--stdout-format=raw`: the raw stream is not compressed")
+		}
+		if *expectedSize > 0 && *stdout {
+			log.Fatal().Msg("`--expected-size` can't be used with `--stdout`: free disk space is checked only for dump files")
+		}
+		if err := victoriametrics.ValidateExportParams(*vmExportParams); err != nil {
+			log.Fatal().Msgf("Invalid `--vm-export-param`: %v", err)
+		}
+		if isS3Path(*dumpPath) {
+			switch {
+			case *stdout:
+				log.Fatal().Msg("`--stdout` can't be used with the dump path in S3")
+			case *keepPartial:
+				log.Fatal().Msg("`--keep-partial` can't be used with the dump path in S3: the upload of the failed export is aborted")
+			case *chCheckpoint != "":
+				log.Fatal().Msg("`--ch-checkpoint` can't be used with the dump path in S3: the partial dump should be a file")
+			}
+		}
+
+		var exportCheckpoint *transferer.ExportCheckpoint
+		if *checkpointFile != "" {
+			switch {
+			case *stdout:
+				log.Fatal().Msg("`--checkpoint-file` can't be used with `--stdout`: the partial dump should be a file")
+			case *watch:
+				log.Fatal().Msg("`--checkpoint-file` can't be used with `--watch`")
+			case *chCheckpoint != "":
+				log.Fatal().Msg("`--checkpoint-file` can't be used with `--ch-checkpoint`")
+			case *exportChunkStats:
+				log.Fatal().Msg("`--checkpoint-file` can't be used with `--export-chunk-stats`: stats of chunks exported before resume are lost")
+			case *end == "":
+				log.Fatal().Msg("`--checkpoint-file` requires `--end-ts`, so the resumed export has the same time range")
+			case isS3Path(*dumpPath):
+				log.Fatal().Msg("`--checkpoint-file` can't be used with the dump path in S3: the partial dump should be a file")
+			}
+			exportCheckpoint, err = prepareExportCheckpoint(*checkpointFile, *dumpPath, startTime, endTime, dumpCompression)
+			if err != nil {
+				log.Fatal().Err(err).Msg("Failed to prepare export checkpoint")
+			}
+		}
+
+		if *dryRun {
+			switch {
+			case *stdout:
+				log.Fatal().Msg("`--dry-run` can't be used with `--stdout`: the estimate is printed to STDOUT")
+			case *watch:
+				log.Fatal().Msg("`--dry-run` can't be used with `--watch`")
+			case *checkpointFile != "" || *resume || *chCheckpoint != "":
+				log.Fatal().Msg("`--dry-run` can't be used with `--checkpoint-file`, `--resume` or `--ch-checkpoint`")
+			}
+		}
+
+		var partialDump *transferer.PartialDump
+		if *resume {
+			switch {
+			case *stdout:
+				log.Fatal().Msg("`--resume` can't be used with `--stdout`: the partial dump should be a file")
+			case *watch:
+				log.Fatal().Msg("`--resume` can't be used with `--watch`")
+			case *checkpointFile != "":
+				log.Fatal().Msg("`--resume` can't be used with `--checkpoint-file`: the export with the checkpoint is resumed without it")
+			case *chCheckpoint != "":
+				log.Fatal().Msg("`--resume` can't be used with `--ch-checkpoint`")
+			case *exportChunkStats:
+				log.Fatal().Msg("`--resume` can't be used with `--export-chunk-stats`: stats of chunks exported before resume are lost")
+			case *end == "":
+				log.Fatal().Msg("`--resume` requires `--end-ts`, so the resumed export has the same time range")
+			case *dumpPath == "":
+				log.Fatal().Msg("`--resume` requires `--dump-path` of the partial dump")
+			case isS3Path(*dumpPath):
+				log.Fatal().Msg("`--resume` can't be used with the dump path in S3: the partial dump should be a file")
+			}
+			partialDump, err = transferer.ReadPartialDump(*dumpPath)
+			if err != nil {
+				log.Fatal().Err(err).Msg("Failed to read the partial dump")
+			}
+		}
+
 		httpC := newClientHTTP(*allowInsecureCerts)
 
 		parseURL(pmmURL, pmmHost, pmmPort, pmmUser, pmmPassword)
@@ -177,19 +481,33 @@ func main() { //nolint:gocyclo,maintidx
 		if err != nil {
 			log.Fatal().Msgf("Failed to create HTTP client: %v", err)
 		}
+		grafanaC = grafanaC.WithTrace(*enableTrace)
+		vmC := grafanaC.WithHeaders(*vmHeaders)
 
 		var dumpLog bytes.Buffer
 
 		hasLevel := log.Logger.GetLevel()
 
 		log.Logger = log.Logger.Level(zerolog.DebugLevel).Output(zerolog.MultiLevelWriter(LevelWriter{
-			Writer: logConsoleWriter,
+			Writer: logWriter,
 			Level:  hasLevel,
 		}, &dumpLog))
 
 		if !(*dumpQAN || *dumpCore) {
+			log.Error().Msgf("No data source is enabled: %s", describeSources(*dumpCore, *dumpQAN))
 			log.Fatal().Msg("Please, specify at least one data source")
 		}
+		log.Debug().Msgf("Data sources: %s", describeSources(*dumpCore, *dumpQAN))
+
+		if *whereFile != "" {
+			if *where != "" {
+				log.Fatal().Msg("Please, specify either --where or --ch-where-file")
+			}
+			*where, err = readWhereFile(*whereFile)
+			if err != nil {
+				log.Fatal().Err(err).Msg("Failed to read WHERE statement")
+			}
+		}
 
 		if *dumpQAN && *dumpCore && len(*instances) == 0 {
 			if *where == "" && (*tsSelector != "" || len(*dashboards) > 0) {
@@ -206,87 +524,412 @@ func main() { //nolint:gocyclo,maintidx
 			log.Fatal().Err(err).Msg("Failed to get PMM config")
 		}
 
-		checkVersionSupport(grafanaC, *pmmURL, pmmConfig.VictoriaMetricsURL)
+		checkVersionSupport(vmC, *pmmURL, pmmConfig.VictoriaMetricsURL)
+		checkClockSkew(*pmmURL, grafanaC)
 
-		selectors, err := grafana.GetSelectorsFromDashboards(grafanaC, *pmmURL, *dashboards, *instances, startTime, endTime)
+		selectors, err := grafana.GetSelectorsFromDashboards(vmC, *pmmURL, *dashboards, *instances, startTime, endTime, *labelValuesLimit)
 		if err != nil {
 			log.Fatal().Msgf("Error retrieving dashboard selectors: %v", err)
 		}
+		if err := victoriametrics.ValidateMetricNames(*metricNames); err != nil {
+			log.Fatal().Msgf("Invalid `--metric` value: %v", err)
+		}
+		metricsSelected := false
 		if *tsSelector != "" {
 			selectors = append(selectors, *tsSelector)
 		} else if len(selectors) == 0 && len(*instances) > 0 {
 			for _, serviceName := range *instances {
-				selectors = append(selectors, fmt.Sprintf(`{service_name="%s" or node_name="%s" or instance="%s"}`, serviceName, serviceName, serviceName))
+				if len(*metricNames) > 0 {
+					selectors = append(selectors, victoriametrics.MetricNamesSelector(*metricNames, serviceName))
+					metricsSelected = true
+				} else {
+					selectors = append(selectors, victoriametrics.InstanceSelector(serviceName))
+				}
 			}
 		}
-		vmSource, ok := prepareVictoriaMetricsSource(grafanaC, *dumpCore, pmmConfig.VictoriaMetricsURL, selectors, *vmNativeData, *vmContentLimit)
+		if len(*metricNames) > 0 && !metricsSelected {
+			selectors = append(selectors, victoriametrics.MetricNamesSelector(*metricNames, ""))
+		}
+		if *vmGlobalSelector != "" {
+			selectors, err = victoriametrics.GlobalSelector(selectors, *vmGlobalSelector)
+			if err != nil {
+				log.Fatal().Msgf("Invalid `--vm-global-selector` value: %v", err)
+			}
+		}
+		// Without selectors all metrics are exported, internal ones included
+		if *includeVMInternal && len(selectors) > 0 {
+			selectors = append(selectors, victoriametrics.InternalMetricsSelector)
+		}
+		if *nameRegex != "" {
+			selectors, err = victoriametrics.ScopeSelectors(selectors, *nameRegex)
+			if err != nil {
+				log.Fatal().Msgf("Invalid `--name-regex` value: %v", err)
+			}
+		}
+		vmConfig := newVictoriaMetricsConfig(pmmConfig.VictoriaMetricsURL, vmDataFormat, *vmMaxChunkSize)
+		vmConfig.TimeSeriesSelectors = selectors
+		vmConfig.ExclusiveEnd = *alignChunks != 0
+		vmConfig.SplitByName = *vmSplitByName
+		vmConfig.ChunkCompressionLevel = *chunkCompressionLevel
+		vmConfig.Dedup = *vmDedup
+		vmConfig.ExportParams = *vmExportParams
+		vmSource, ok := prepareVictoriaMetricsSource(vmC, *dumpCore, vmConfig)
 		if ok {
 			sources = append(sources, vmSource)
 		}
 
-		if *where == "" && len(*instances) > 0 {
-			for i, serviceName := range *instances {
-				if i != 0 {
-					*where += " OR "
-				}
-				*where += fmt.Sprintf("service_name='%s'", serviceName)
+		*where = whereWithInstances(*where, *instances)
+
+		var checkpoint *clickhouse.Checkpoint
+		if *chCheckpoint != "" {
+			switch {
+			case !*dumpQAN:
+				log.Fatal().Msg("`--ch-checkpoint` requires `--dump-qan`")
+			case *watch:
+				log.Fatal().Msg("`--ch-checkpoint` can't be used with `--watch`")
+			case *end == "":
+				log.Fatal().Msg("`--ch-checkpoint` requires `--end-ts`, so the resumed export has the same time range")
+			case *chMaxRows > 0:
+				log.Fatal().Msg("`--ch-checkpoint` can't be used with `--ch-max-rows`")
+			}
+			checkpoint, err = prepareCheckpoint(*chCheckpoint, startTime, endTime)
+			if err != nil {
+				log.Fatal().Err(err).Msg("Failed to prepare QAN export checkpoint")
+			}
+			if checkpoint.Complete() && !*dumpCore {
+				log.Info().Msgf("QAN export is already complete according to checkpoint %s", *chCheckpoint)
+				break
 			}
 		}
 
-		chSource, ok := prepareClickHouseSource(ctx, *dumpQAN, pmmConfig.ClickHouseURL, *where)
+		var since *time.Time
+		if *chSinceDump != "" {
+			if !*dumpQAN {
+				log.Fatal().Msg("`--since-dump` requires `--dump-qan`")
+			}
+			since, err = readSinceDump(*chSinceDump, s3Opts)
+			if err != nil {
+				log.Fatal().Err(err).Msg("Failed to read prior dump")
+			}
+			log.Info().Msgf("Exporting QAN rows with period_start after %s of the prior dump", since.Format(time.RFC3339))
+		}
+
+		chSource, ok := prepareClickHouseSource(ctx, *dumpQAN, clickhouse.Config{
+			ConnectionURL: pmmConfig.ClickHouseURL,
+			Where:         *where,
+			MaxRows:       *chMaxRows,
+			Final:         *chFinal,
+			Checkpoint:    checkpoint,
+			Since:         since,
+			SpoolDir:      *chSpoolDir,
+		})
 		if ok {
+			if *whereFile != "" {
+				if _, err := chSource.Count(*where, &startTime, &endTime); err != nil {
+					log.Fatal().Err(err).Msgf("Invalid WHERE statement in %s", *whereFile)
+				}
+			} else if clickhouse.HasWherePlaceholders(*where) {
+				if _, err := chSource.Count(*where, &startTime, &endTime); err != nil {
+					log.Fatal().Err(err).Msg("Invalid WHERE statement")
+				}
+			}
 			sources = append(sources, chSource)
 		}
 
-		file, err := createFile(*dumpPath, *stdout)
-		if err != nil {
-			log.Fatal().Msgf("Failed to create file: %v", err)
+		var thresholds []transferer.Threshold
+		if !*ignoreLoad {
+			thresholds, err = transferer.ParseThresholdList(*maxLoad, *criticalLoad)
+			if err != nil {
+				log.Fatal().Err(err).Msgf("Failed to parse max/critical load args")
+			}
 		}
-		defer file.Close() //nolint:errcheck
 
-		t, err := transferer.New(file, sources, *workersCount)
-		if err != nil {
-			log.Fatal().Msgf("Failed to setup export: %v", err) //nolint:gocritic //TODO: potential problem here, see muted linter warning
-		}
+		// exportData exports the from-to time range to the dump file at dumpPath.
+		exportData := func(from, to time.Time, dumpPath string) error {
+			exportCtx, cancel := context.WithCancel(ctx)
+			defer cancel()
 
-		var chunks []dump.ChunkMeta
+			lc := transferer.NewLoadChecker(exportCtx, vmC, pmmConfig.VictoriaMetricsURL, thresholds)
+			if status, _ := lc.GetLatestStatus(); *watch && status == transferer.LoadStatusTerminate {
+				log.Warn().Msg("PMM load exceeds critical thresholds, skipping the watch cycle")
+				return nil
+			}
 
-		if *dumpCore {
-			chunks = append(chunks, victoriametrics.SplitTimeRangeIntoChunks(startTime, endTime, *chunkTimeRange)...)
-		}
+			var chunks []dump.ChunkMeta
+			var qanRowsCapped bool
+
+			// The resumed export has the same chunks, as QAN chunks depend on the rows count
+			if exportCheckpoint != nil {
+				chunks = exportCheckpoint.Chunks
+				qanRowsCapped = exportCheckpoint.QANRowsCapped
+			}
+
+			if *dumpCore && exportCheckpoint == nil {
+				vmChunks, err := victoriametrics.SplitTimeRangeIntoChunks(from, to, *chunkTimeRange, *alignChunks)
+				if err != nil {
+					log.Fatal().Msgf("Failed to create victoria metrics chunks: %s", err.Error())
+				}
+				chunks = append(chunks, vmChunks...)
+			}
+
+			var progress *clickhouse.Progress
+			if *dumpQAN && exportCheckpoint == nil {
+				chChunks, capped, err := chSource.SplitIntoChunks(from, to, *chunkRows)
+				if err != nil {
+					log.Fatal().Msgf("Failed to create clickhouse chunks: %s", err.Error())
+				}
+				if len(chChunks) == 0 && !*dumpCore {
+					log.Fatal().Msg("QAN doesn't have any data")
+				}
+				chunks = append(chunks, chChunks...)
+				qanRowsCapped = capped
+				if checkpoint != nil {
+					progress = clickhouse.NewProgress(*checkpoint, chChunks)
+				}
+			}
+
+			if *dryRun {
+				estimates, err := transferer.EstimateExport(exportCtx, sources, chunks)
+				if err != nil {
+					return err
+				}
+				printExportEstimate(os.Stdout, estimates)
+				return nil
+			}
 
-		if *dumpQAN {
-			chChunks, err := chSource.SplitIntoChunks(startTime, endTime, *chunkRows)
+			var minFreeSpace uint64
+			if !*skipDiskCheck {
+				minFreeSpace = *expectedSize
+			}
+			var file io.ReadWriteCloser
+			var err error
+			switch {
+			case exportCheckpoint != nil:
+				file, err = openPartialDump(*exportCheckpoint)
+			case partialDump != nil:
+				// The partial dump is replaced by the resumed one after its chunks are copied
+				file, err = createFile(partialDump.Path+resumedDumpSuffix, false, minFreeSpace, dumpCompression, s3Opts)
+			default:
+				file, err = createFile(dumpPath, *stdout, minFreeSpace, dumpCompression, s3Opts)
+			}
 			if err != nil {
-				log.Fatal().Msgf("Failed to create clickhouse chunks: %s", err.Error())
+				log.Fatal().Msgf("Failed to create file: %v", err)
 			}
-			if len(chChunks) == 0 && !*dumpCore {
-				log.Fatal().Msg("QAN doesn't have any data")
+			defer file.Close() //nolint:errcheck
+
+			t, err := transferer.New(file, sources, *workersCount)
+			if err != nil {
+				log.Fatal().Msgf("Failed to setup export: %v", err) //nolint:gocritic //TODO: potential problem here, see muted linter warning
 			}
-			chunks = append(chunks, chChunks...)
-		}
 
-		meta, err := composeMeta(*pmmURL, grafanaC, *exportServicesInfo, cli, *vmNativeData)
-		if err != nil {
-			log.Fatal().Err(err).Msg("Failed to compose meta")
-		}
+			meta, err := composeMeta(*pmmURL, grafanaC, *exportServicesInfo, cli, vmDataFormat)
+			if err != nil {
+				log.Fatal().Err(err).Msg("Failed to compose meta")
+			}
 
-		pool, err := dump.NewChunkPool(chunks)
-		if err != nil {
-			log.Fatal().Msgf("Failed to generate chunk pool: %v", err)
-		}
+			meta.QANRowsCapped = qanRowsCapped
+			// Rows after the max period_start of a capped dump may be not exported, so it can't be the start of the next one
+			if *dumpQAN && !meta.QANRowsCapped {
+				maxPeriod, err := chSource.MaxPeriodStart(from, to)
+				if err != nil {
+					log.Fatal().Err(err).Msg("Failed to get max period_start of QAN rows")
+				}
+				if maxPeriod == nil {
+					maxPeriod = since
+				}
+				meta.QANMaxPeriodStart = maxPeriod
+			}
+			meta.VMMaxChunkSize = *vmMaxChunkSize
+			if *dumpCore {
+				meta.VMNameRegex = *nameRegex
+			}
 
-		var thresholds []transferer.Threshold
-		if !*ignoreLoad {
-			thresholds, err = transferer.ParseThresholdList(*maxLoad, *criticalLoad)
+			exportOpts := transferer.ExportOptions{
+				ChunkStats:        *exportChunkStats,
+				PrintLoadInterval: *printLoadInterval,
+				ProgressInterval:  progressInterval(*noProgress),
+				MaxInFlightBytes:  *maxInFlightBytes,
+				ChunkDeadline:     *chunkDeadline,
+				WriteBufferSize:   *writeBufferSize,
+				RawStream:         *stdoutFormat == "raw",
+				Compression:       dumpCompression,
+				ChunkChecksums:    *chunkChecksums,
+			}
+			if progress != nil {
+				exportOpts.OnChunkWritten = func(m dump.ChunkMeta) error {
+					if m.Source != dump.ClickHouse {
+						return nil
+					}
+					return clickhouse.WriteCheckpoint(*chCheckpoint, progress.Written(m))
+				}
+			}
+
+			if *exportAgentConfig {
+				content, err := getPMMAgentStatus(*pmmURL, grafanaC)
+				if err != nil {
+					log.Warn().Err(err).Msg("pmm-agent status is unavailable, skipping it")
+				} else {
+					exportOpts.Files = append(exportOpts.Files, dump.File{Name: dump.AgentConfigFilename, Content: content})
+					meta.AgentConfigExported = true
+				}
+			}
+
+			filters := dump.Filters{
+				Start:      from,
+				End:        to,
+				Instances:  *instances,
+				Dashboards: *dashboards,
+				Metrics:    *metricNames,
+			}
+			if vmSource != nil {
+				filters.VMSelectors = vmSource.TimeSeriesSelectors()
+			}
+			if chSource != nil {
+				filters.CHWhere = clickhouse.ExpandWhereTemplate(*where, &from, &to)
+			}
+			filtersContent, err := json.Marshal(filters)
 			if err != nil {
-				log.Fatal().Err(err).Msgf("Failed to parse max/critical load args")
+				log.Fatal().Err(err).Msg("Failed to marshal export filters")
+			}
+			exportOpts.HeadFiles = append(exportOpts.HeadFiles, dump.File{Name: dump.FiltersFilename, Content: filtersContent})
+			if partialDump != nil && !bytes.Equal(partialDump.Filters, filtersContent) {
+				_ = replacePartialDump(file, partialDump)
+				log.Fatal().Msgf("Partial dump %s is exported with other filters or time range, or by an older pmm-dump version: "+
+					"it can't be resumed, export it again without `--resume`", partialDump.Path)
+			}
+
+			if *exportAnnotations {
+				annotations, err := grafana.GetAnnotations(grafanaC, *pmmURL, from, to)
+				if err != nil {
+					log.Warn().Err(err).Msg("Grafana annotations are unavailable, skipping them")
+				} else {
+					content, err := json.Marshal(annotations)
+					if err != nil {
+						log.Fatal().Err(err).Msg("Failed to marshal Grafana annotations")
+					}
+					exportOpts.Files = append(exportOpts.Files, dump.File{Name: dump.AnnotationsFilename, Content: content})
+					meta.AnnotationsExported = true
+				}
+			}
+
+			if *exportVMMetadata {
+				vmMetadata, err := victoriametrics.GetMetadata(vmC, pmmConfig.VictoriaMetricsURL)
+				if err != nil {
+					log.Warn().Err(err).Msg("VictoriaMetrics metadata is unavailable, skipping it")
+				} else {
+					content, err := json.Marshal(vmMetadata)
+					if err != nil {
+						log.Fatal().Err(err).Msg("Failed to marshal VictoriaMetrics metadata")
+					}
+					exportOpts.Files = append(exportOpts.Files, dump.File{Name: dump.VMMetadataFilename, Content: content})
+					meta.VMMetadataExported = true
+					if vmMetadata.RetentionPeriod != "" {
+						meta.VMRetentionDays, err = victoriametrics.RetentionDays(vmMetadata.RetentionPeriod)
+						if err != nil {
+							log.Warn().Err(err).Msg("Failed to parse VictoriaMetrics retention period")
+						}
+					}
+				}
+			}
+
+			if *includeVMMetadata {
+				metricMetadata, err := victoriametrics.GetMetricMetadata(vmC, pmmConfig.VictoriaMetricsURL)
+				switch {
+				case errors.Is(err, victoriametrics.ErrNotFound):
+					log.Warn().Msg("VictoriaMetrics doesn't provide metric metadata, skipping it")
+				case err != nil:
+					log.Warn().Err(err).Msg("Metric metadata is unavailable, skipping it")
+				case len(metricMetadata) == 0:
+					log.Info().Msg("VictoriaMetrics has no metric metadata, skipping it")
+				default:
+					content, err := json.Marshal(metricMetadata)
+					if err != nil {
+						log.Fatal().Err(err).Msg("Failed to marshal metric metadata")
+					}
+					exportOpts.Files = append(exportOpts.Files, dump.File{Name: dump.VMMetricMetadataFilename, Content: content})
+					meta.VMMetricMetadataExported = true
+				}
+			}
+
+			pool, err := dump.NewChunkPool(chunks)
+			if err != nil {
+				log.Fatal().Msgf("Failed to generate chunk pool: %v", err)
+			}
+
+			if *checkpointFile != "" {
+				if exportCheckpoint == nil {
+					exportCheckpoint, err = newExportCheckpoint(*checkpointFile, file, from, to, dumpCompression, chunks, qanRowsCapped)
+					if err != nil {
+						log.Fatal().Err(err).Msg("Failed to create export checkpoint")
+					}
+				} else {
+					skipped := pool.Skip(exportCheckpoint.IsCompleted)
+					log.Info().Msgf("Resuming export from checkpoint %s: %d of %d chunks are already exported to %s",
+						*checkpointFile, skipped, len(chunks), exportCheckpoint.DumpPath)
+				}
+				exportOpts.Checkpoint = exportCheckpoint
+				exportOpts.CheckpointFile = *checkpointFile
+			}
+
+			if partialDump != nil {
+				// QAN chunks are exported again, as rows may be split into chunks differently since the partial dump
+				kept := pool.Skip(func(m dump.ChunkMeta) bool {
+					return m.Source == dump.VictoriaMetrics && partialDump.Keep(m)
+				})
+				log.Info().Msgf("Resuming export into the partial dump %s: %d of %d chunks are already exported", partialDump.Path, kept, len(chunks))
+				exportOpts.Partial = partialDump
+			}
+
+			if err := t.Export(exportCtx, lc, *meta, pool, &dumpLog, exportOpts); err != nil {
+				if partialDump != nil {
+					if err := replacePartialDump(file, partialDump); err != nil {
+						log.Error().Err(err).Msg("Failed to keep the resumed dump")
+					}
+					log.Warn().Msgf("Partial dump is kept: %s", partialDump.Path)
+					return err
+				}
+				if !*stdout {
+					// Rows or chunks of the partial dump are already in the checkpoint, so it's kept
+					handlePartialDump(file, *keepPartial || *chCheckpoint != "" || *checkpointFile != "")
+				}
+				return err
 			}
+			if partialDump != nil {
+				if err := replacePartialDump(file, partialDump); err != nil {
+					return err
+				}
+			}
+			if *checkpointFile != "" {
+				if err := os.Remove(*checkpointFile); err != nil {
+					log.Warn().Err(err).Msgf("Failed to remove checkpoint %s of the complete export", *checkpointFile)
+				}
+			}
+			return nil
 		}
 
-		lc := transferer.NewLoadChecker(ctx, grafanaC, pmmConfig.VictoriaMetricsURL, thresholds)
+		if !*watch {
+			if err := exportData(startTime, endTime, *dumpPath); err != nil {
+				log.Fatal().Msgf("Failed to export: %v", err)
+			}
+			break
+		}
 
-		if err = t.Export(ctx, lc, *meta, pool, &dumpLog); err != nil {
+		stopC := make(chan os.Signal, 1)
+		signal.Notify(stopC, os.Interrupt, syscall.SIGTERM)
+		opts := watchOptions{
+			interval: *watchInterval,
+			window:   *chunkTimeRange,
+			count:    *watchCount,
+		}
+		err = watchExport(stopC, opts, time.Now, func(from, to time.Time) error {
+			dumpLog.Reset()
+			cyclePath, err := watchDumpPath(*dumpPath, to, dumpCompression)
+			if err != nil {
+				return err
+			}
+			return exportData(from, to, cyclePath)
+		})
+		if err != nil {
 			log.Fatal().Msgf("Failed to export: %v", err)
 		}
 	case importCmd.FullCommand():
@@ -303,9 +946,13 @@ func main() { //nolint:gocyclo,maintidx
 		if err != nil {
 			log.Fatal().Msgf("Failed to create HTTP client: %v", err)
 		}
+		grafanaC = grafanaC.WithTrace(*enableTrace)
+		vmC := grafanaC.WithHeaders(*vmHeaders)
 		if !(*dumpQAN || *dumpCore) {
+			log.Error().Msgf("No data source is enabled: %s", describeSources(*dumpCore, *dumpQAN))
 			log.Fatal().Msg("Please, specify at least one data source")
 		}
+		log.Debug().Msgf("Data sources: %s", describeSources(*dumpCore, *dumpQAN))
 
 		var sources []dump.Source
 
@@ -314,7 +961,13 @@ func main() { //nolint:gocyclo,maintidx
 			log.Fatal().Err(err).Msg("Failed to get PMM config")
 		}
 
-		checkVersionSupport(grafanaC, *pmmURL, pmmConfig.VictoriaMetricsURL)
+		if *waitForReady > 0 {
+			if err := victoriametrics.WaitReady(vmC, pmmConfig.VictoriaMetricsURL, *waitForReady); err != nil {
+				log.Fatal().Err(err).Msg("Failed to wait for VictoriaMetrics")
+			}
+		}
+
+		checkVersionSupport(vmC, *pmmURL, pmmConfig.VictoriaMetricsURL)
 
 		piped, err := checkPiped()
 		if err != nil {
@@ -322,42 +975,92 @@ func main() { //nolint:gocyclo,maintidx
 		}
 
 		if piped { //nolint:nestif
-			if *vmNativeData {
+			switch vmDataFormat {
+			case victoriametrics.FormatNative:
 				log.Warn().Msgf("Cannot read meta file during import in a pipeline. Using VictoriaMetrics' native export format because `--vm-native-data` was provided")
-			} else {
+			case victoriametrics.FormatOpenMetrics:
+				log.Warn().Msgf("Cannot read meta file during import in a pipeline. Using OpenMetrics format because `--vm-format` was provided")
+			default:
 				log.Warn().Msgf("Cannot read meta file during import in a pipeline. Using VictoriaMetrics' JSON export format")
 			}
 		} else {
-			dumpMeta, err := transferer.ReadMetaFromDump(*dumpPath, false)
+			dumpMeta, err := readDumpMeta(*dumpPath, false, s3Opts)
 			if err != nil {
 				log.Warn().Msgf("Can't show meta: %v", err)
-				*vmNativeData = true
+				vmDataFormat = victoriametrics.FormatNative
 			} else {
 				switch dumpMeta.VMDataFormat {
 				case "":
 					log.Warn().Msgf("Meta file doesn't contain `vm-data-format`. Using VictoriaMetrics' native export format")
-					*vmNativeData = true
-				case "native":
-					*vmNativeData = true
-				case "json":
-					*vmNativeData = false
+					vmDataFormat = victoriametrics.FormatNative
+				case victoriametrics.FormatNative, victoriametrics.FormatJSON, victoriametrics.FormatOpenMetrics:
+					vmDataFormat = dumpMeta.VMDataFormat
 				default:
-					*vmNativeData = false
+					vmDataFormat = victoriametrics.FormatJSON
 					log.Warn().Msgf("Meta file contains invalid `vm-data-format`. Using VictoriaMetrics' JSON export format")
 				}
+				if dumpMeta.VMNameRegex != "" {
+					log.Info().Msgf("Dump has only core metrics with names matching %q", dumpMeta.VMNameRegex)
+				}
 			}
 		}
 
-		if *vmNativeData && *vmContentLimit > 0 {
-			log.Fatal().Msgf("`--vm-content-limit` is not supported with native data format")
+		if *onlyMetaCompare {
+			if piped {
+				log.Fatal().Msg("`--only-meta-compare` is not supported in a pipeline: meta of the dump can't be read before the import")
+			}
+			compatible, err := compareDumpMeta(*dumpPath, s3Opts, *pmmURL, grafanaC, cli, vmDataFormat)
+			if err != nil {
+				log.Fatal().Err(err).Msg("Failed to compare dump meta")
+			}
+			if !compatible {
+				os.Exit(1)
+			}
+			break
+		}
+
+		// The format of the dump is known only after reading its meta
+		if vmDataFormat != victoriametrics.FormatJSON && *vmContentLimit > 0 {
+			log.Fatal().Msgf("`--vm-content-limit` is not supported with %s data format of the dump", vmDataFormat)
 		}
 
-		vmSource, ok := prepareVictoriaMetricsSource(grafanaC, *dumpCore, pmmConfig.VictoriaMetricsURL, nil, *vmNativeData, *vmContentLimit)
+		if *chunkGlob != "" {
+			if _, err := path.Match(*chunkGlob, ""); err != nil {
+				log.Fatal().Msgf("Invalid `--chunk-glob` pattern: %v", err)
+			}
+		}
+
+		vmConfig := newVictoriaMetricsConfig(pmmConfig.VictoriaMetricsURL, vmDataFormat, *vmContentLimit)
+		if *importMatch != "" {
+			if vmDataFormat != victoriametrics.FormatJSON {
+				log.Fatal().Msgf("`--import-match` is not supported with %s data format: only JSON chunks can be decoded to filter series", vmDataFormat)
+			}
+			vmConfig.ImportMatcher, err = victoriametrics.NewSeriesMatcher(*importMatch)
+			if err != nil {
+				log.Fatal().Msgf("Invalid `--import-match` selector: %v", err)
+			}
+		}
+		if len(*importAddLabel) > 0 {
+			if vmDataFormat != victoriametrics.FormatJSON {
+				log.Fatal().Msgf("`--import-add-label` is not supported with %s data format: only JSON chunks can be decoded to add labels", vmDataFormat)
+			}
+			if err := victoriametrics.ValidateExtraLabels(*importAddLabel); err != nil {
+				log.Fatal().Msgf("Invalid `--import-add-label`: %v", err)
+			}
+			vmConfig.ImportLabels = *importAddLabel
+			log.Info().Interface("labels", *importAddLabel).Msg("Adding labels to imported core metrics")
+		}
+		vmSource, ok := prepareVictoriaMetricsSource(vmC, *dumpCore, vmConfig)
 		if ok {
 			sources = append(sources, vmSource)
 		}
 
-		chSource, ok := prepareClickHouseSource(ctx, *dumpQAN, pmmConfig.ClickHouseURL, *where)
+		chSource, ok := prepareClickHouseSource(ctx, *dumpQAN, clickhouse.Config{
+			ConnectionURL: pmmConfig.ClickHouseURL,
+			Where:         *where,
+			AsyncInsert:   *chAsyncInsert,
+			Dedup:         *chDedup,
+		})
 		if ok {
 			sources = append(sources, chSource)
 		}
@@ -366,7 +1069,23 @@ func main() { //nolint:gocyclo,maintidx
 			log.Fatal().Msg("Please, specify path to dump file")
 		}
 
-		file, err := getFile(*dumpPath, piped)
+		// Checksums should be known before chunks are written, but the meta of the piped dump is at its end
+		var dumpChecksums map[string]string
+		if *verifyChecksums && !*summaryOnly {
+			if piped {
+				log.Fatal().Msg("`--verify-checksums` can't be used with piped dumps: their meta with checksums is read after chunks are imported")
+			}
+			dumpMeta, err := readDumpMeta(*dumpPath, false, s3Opts)
+			if err != nil {
+				log.Fatal().Msgf("Failed to read dump meta to verify checksums: %v", err)
+			}
+			if len(dumpMeta.ChunkChecksums) == 0 {
+				log.Fatal().Msg("Dump has no chunk checksums: it should be exported with `--verify-checksums`")
+			}
+			dumpChecksums = dumpMeta.ChunkChecksums
+		}
+
+		file, err := getFile(*dumpPath, piped, s3Opts)
 		if err != nil {
 			log.Fatal().Msgf("Failed to get file: %v", err)
 		}
@@ -377,12 +1096,29 @@ func main() { //nolint:gocyclo,maintidx
 			log.Fatal().Msgf("Failed to setup import: %v", err)
 		}
 
-		meta, err := composeMeta(*pmmURL, grafanaC, *exportServicesInfo, cli, *vmNativeData)
+		meta, err := composeMeta(*pmmURL, grafanaC, *exportServicesInfo, cli, vmDataFormat)
 		if err != nil {
 			log.Fatal().Err(err).Msg("Failed to compose meta")
 		}
 
-		if err = t.Import(ctx, *meta); err != nil {
+		if *summaryOnly {
+			if u, err := url.Parse(*pmmURL); err == nil {
+				log.Info().Msgf("Target PMM: %s", u.Redacted())
+			}
+		}
+
+		if !*summaryOnly && !*assumeYes && !piped {
+			confirmImport(*dumpPath, sources)
+		}
+
+		importOpts := transferer.ImportOptions{
+			ChunkGlob:        *chunkGlob,
+			SummaryOnly:      *summaryOnly,
+			ProgressInterval: progressInterval(*noProgress),
+			VerifyChecksums:  *verifyChecksums,
+			ChunkChecksums:   dumpChecksums,
+		}
+		if err = t.Import(ctx, *meta, importOpts); err != nil {
 			var additionalInfo string
 			if victoriametrics.ErrIsRequestEntityTooLarge(err) {
 				additionalInfo = ". Consider to use \"vm-content-limit\" option. Also, you can decrease \"chunk-time-range\" or \"chunk-rows\" values. " +
@@ -391,6 +1127,13 @@ func main() { //nolint:gocyclo,maintidx
 			}
 			log.Fatal().Msgf("Failed to import: %v%s", err, additionalInfo)
 		}
+
+		if *importAnnotations && !*summaryOnly {
+			importDumpAnnotations(grafanaC, *pmmURL, *dumpPath, piped)
+		}
+		if *registerPMMAgent && !*summaryOnly {
+			registerDumpAgentServices(grafanaC, *pmmURL, *dumpPath, piped)
+		}
 	case showMetaCmd.FullCommand():
 		piped, err := checkPiped()
 		if err != nil {
@@ -400,7 +1143,12 @@ func main() { //nolint:gocyclo,maintidx
 			log.Fatal().Msg("Please, specify path to dump file")
 		}
 
-		meta, err := transferer.ReadMetaFromDump(*dumpPath, piped)
+		if *showFilters {
+			printFilters(*dumpPath, piped)
+			break
+		}
+
+		meta, err := readDumpMeta(*dumpPath, piped, s3Opts)
 		if err != nil {
 			log.Fatal().Msgf("Can't show meta: %v", err)
 		}
@@ -409,10 +1157,45 @@ func main() { //nolint:gocyclo,maintidx
 			fmt.Printf("Build: %v\n", meta.Version.GitCommit)
 			fmt.Printf("PMM Version: %v\n", meta.PMMServerVersion)
 			fmt.Printf("Max Chunk Size: %v (%v)\n", ByteCountDecimal(meta.MaxChunkSize), ByteCountBinary(meta.MaxChunkSize))
+			// Dumps exported before the compression was configurable are gzipped
+			compression := dump.CompressionGzip
+			if meta.Compression != "" {
+				compression = meta.Compression
+			}
+			fmt.Printf("Compression: %s\n", compression)
 			if meta.PMMTimezone != nil {
 				fmt.Printf("PMM Timezone: %s\n", *meta.PMMTimezone)
 			}
 			fmt.Printf("Arguments: %s\n", meta.Arguments)
+			if meta.QANRowsCapped {
+				fmt.Printf("QAN Rows Capped: %v\n", meta.QANRowsCapped)
+			}
+			if meta.VMNameRegex != "" {
+				fmt.Printf("VM Name Regex: %s\n", meta.VMNameRegex)
+			}
+			if meta.QANMaxPeriodStart != nil {
+				fmt.Printf("QAN Max Period Start: %s\n", meta.QANMaxPeriodStart.Format(time.RFC3339))
+			}
+			if len(meta.SkippedChunks) > 0 {
+				fmt.Printf("Skipped Chunks:\n")
+				for _, c := range meta.SkippedChunks {
+					fmt.Printf("\t- %s\n", c)
+				}
+			}
+			if len(meta.ChunkChecksums) > 0 {
+				fmt.Printf("Chunk Checksums: %d\n", len(meta.ChunkChecksums))
+			}
+			if len(meta.ChunkContentTypes) > 0 {
+				fmt.Printf("Chunk Content Types:\n")
+				sources := make([]string, 0, len(meta.ChunkContentTypes))
+				for source := range meta.ChunkContentTypes {
+					sources = append(sources, source)
+				}
+				sort.Strings(sources)
+				for _, source := range sources {
+					fmt.Printf("\t- %s: %s\n", source, meta.ChunkContentTypes[source])
+				}
+			}
 			if len(meta.PMMServerServices) > 0 {
 				fmt.Printf("Services:\n")
 				for _, s := range meta.PMMServerServices {
@@ -422,6 +1205,27 @@ func main() { //nolint:gocyclo,maintidx
 					fmt.Printf("\t  Agents ID: %v\n", s.AgentsIDs)
 				}
 			}
+			if meta.AgentConfigExported {
+				printAgentConfig(*dumpPath, piped)
+			}
+			if meta.AnnotationsExported {
+				printAnnotationsCount(*dumpPath, piped)
+			}
+			if meta.VMMaxChunkSize > 0 {
+				fmt.Printf("VM Max Chunk Size Limit: %v\n", ByteCountDecimal(int64(meta.VMMaxChunkSize)))
+			}
+			if meta.VMRetentionDays > 0 {
+				fmt.Printf("VM Retention: %d days\n", meta.VMRetentionDays)
+			}
+			if meta.VMMetadataExported {
+				printVMTotalSeries(*dumpPath, piped)
+			}
+			if meta.VMMetricMetadataExported {
+				printVMMetricMetadataCount(*dumpPath, piped)
+			}
+			if *showTopChunks > 0 {
+				printTopChunks(*dumpPath, piped, *showTopChunks)
+			}
 		} else {
 			jsonMeta, err := json.MarshalIndent(meta, "", "\t")
 			if err != nil {
@@ -430,6 +1234,152 @@ func main() { //nolint:gocyclo,maintidx
 
 			fmt.Printf("%v\n", string(jsonMeta))
 		}
+	case cardinalityCmd.FullCommand():
+		piped, err := checkPiped()
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to check if a program is piped")
+		}
+		if *dumpPath == "" && !piped {
+			log.Fatal().Msg("Please, specify path to dump file")
+		}
+
+		if !piped {
+			meta, err := readDumpMeta(*dumpPath, false, s3Opts)
+			if err != nil {
+				log.Warn().Msgf("Can't read meta: %v", err)
+			} else if meta.VMDataFormat != victoriametrics.FormatJSON {
+				log.Fatal().Msg("Cardinality is supported only for dumps with VictoriaMetrics' JSON export format")
+			}
+		}
+
+		file, err := getFile(*dumpPath, piped, s3Opts)
+		if err != nil {
+			log.Fatal().Msgf("Failed to get file: %v", err)
+		}
+		defer file.Close() //nolint:errcheck
+
+		c, err := readCardinality(file)
+		if err != nil {
+			log.Fatal().Msgf("Failed to calculate cardinality: %v", err)
+		}
+
+		fmt.Printf("Total series: %d\n", c.Series())
+		fmt.Printf("Top label names by series count:\n")
+		for _, l := range c.TopLabelNames(*topLabels) {
+			fmt.Printf("\t%s: %d\n", l.Label, l.Series)
+		}
+		fmt.Printf("Top label values by series count:\n")
+		for _, l := range c.TopLabelValues(*topLabels) {
+			fmt.Printf("\t%s: %d\n", l.Label, l.Series)
+		}
+	case scrubCmd.FullCommand():
+		piped, err := checkPiped()
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to check if a program is piped")
+		}
+		if *dumpPath == "" && !piped {
+			log.Fatal().Msg("Please, specify path to dump file")
+		}
+		if len(*redactLabels) == 0 && *redactRegex == "" {
+			log.Fatal().Msg("Please, specify `--redact-label` or `--redact-regex`")
+		}
+
+		redactor, err := victoriametrics.NewRedactor(*redactLabels, *redactRegex)
+		if err != nil {
+			log.Fatal().Msgf("Invalid redaction rules: %v", err)
+		}
+
+		if !piped {
+			meta, err := readDumpMeta(*dumpPath, false, s3Opts)
+			if err != nil {
+				log.Warn().Msgf("Can't read meta: %v", err)
+			} else if meta.VMDataFormat != victoriametrics.FormatJSON {
+				log.Fatal().Msg("Scrub is supported only for dumps with VictoriaMetrics' JSON export format")
+			}
+		}
+
+		file, err := getFile(*dumpPath, piped, s3Opts)
+		if err != nil {
+			log.Fatal().Msgf("Failed to get file: %v", err)
+		}
+		defer file.Close() //nolint:errcheck
+
+		out, err := os.Create(*scrubOutput)
+		if err != nil {
+			log.Fatal().Msgf("Failed to create %s: %v", *scrubOutput, err)
+		}
+		defer out.Close() //nolint:errcheck
+
+		if err := scrubDump(file, out, redactor, *scrubKeepQAN); err != nil {
+			_ = out.Close()
+			_ = os.Remove(*scrubOutput)
+			log.Fatal().Msgf("Failed to scrub dump: %v", err)
+		}
+		log.Info().Msgf("Scrubbed dump is written to %s", *scrubOutput)
+	case diffCmd.FullCommand():
+		x, err := os.Open(*diffFirst)
+		if err != nil {
+			log.Fatal().Msgf("Failed to open %s: %v", *diffFirst, err)
+		}
+		y, err := os.Open(*diffSecond)
+		if err != nil {
+			log.Fatal().Msgf("Failed to open %s: %v", *diffSecond, err)
+		}
+		d, err := diffDumps(x, y, *workersCount)
+		_ = x.Close()
+		_ = y.Close()
+		if err != nil {
+			log.Fatal().Msgf("Failed to compare dumps: %v", err)
+		}
+		if err := d.print(os.Stdout, *diffDetails); err != nil {
+			log.Fatal().Err(err).Msg("Failed to print dumps difference")
+		}
+		if !d.empty() {
+			os.Exit(1)
+		}
+	case doctorCmd.FullCommand():
+		if *dumpPath == "" && *pmmURL == "" && *pmmHost == "" {
+			log.Fatal().Msg("Please, specify `--dump-path` or `--pmm-url` to diagnose")
+		}
+
+		report := new(doctorReport)
+		if *dumpPath != "" {
+			file, err := os.Open(*dumpPath)
+			if err != nil {
+				report.add(severityError, fmt.Sprintf("Can't open dump: %v", err), "Check `--dump-path`")
+			} else {
+				diagnoseDump(file, report)
+				_ = file.Close()
+			}
+		}
+
+		if *pmmURL != "" || *pmmHost != "" {
+			parseURL(pmmURL, pmmHost, pmmPort, pmmUser, pmmPassword)
+			grafanaC, err := client.NewClient(newClientHTTP(*allowInsecureCerts), client.AuthParams{
+				User:       *pmmUser,
+				Password:   *pmmPassword,
+				APIToken:   *pmmToken,
+				AuthCookie: *pmmCookie,
+			})
+			if err != nil {
+				log.Fatal().Msgf("Failed to create HTTP client: %v", err)
+			}
+			grafanaC = grafanaC.WithTrace(*enableTrace)
+			pmmConfig, err := util.GetPMMConfig(*pmmURL, *victoriaMetricsURL, *clickHouseURL)
+			if err != nil {
+				log.Fatal().Err(err).Msg("Failed to get PMM config")
+			}
+			diagnosePMM(ctx, grafanaC, grafanaC.WithHeaders(*vmHeaders), *pmmURL, pmmConfig, report)
+		} else {
+			log.Info().Msg("PMM URL is not specified, skipping connection checks")
+		}
+
+		if err := report.print(os.Stdout); err != nil {
+			log.Fatal().Err(err).Msg("Failed to print doctor report")
+		}
+		if report.hasErrors() {
+			os.Exit(1)
+		}
 	case versionCmd.FullCommand():
 		fmt.Printf("Version: %v, Build: %v\n", GitVersion, GitCommit)
 	default:
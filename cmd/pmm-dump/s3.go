@@ -0,0 +1,155 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+
+	"pmm-dump/pkg/dump"
+	"pmm-dump/pkg/storage"
+	"pmm-dump/pkg/transferer"
+)
+
+// s3MetaTailSizes are the sizes of the dump end read to find the meta, before the whole dump is downloaded.
+var s3MetaTailSizes = []int64{1 << 20, 16 << 20}
+
+// s3Options configure access to dumps in S3, which are passed as `s3://bucket/key` dump paths.
+type s3Options struct {
+	endpoint           string
+	insecureSkipVerify bool
+}
+
+func isS3Path(dumpPath string) bool {
+	_, _, ok := storage.ParseS3URL(dumpPath)
+	return ok
+}
+
+func (o s3Options) client(ctx context.Context, dumpPath string) (*storage.S3Client, string, string, error) {
+	bucket, key, _ := storage.ParseS3URL(dumpPath)
+	if bucket == "" || key == "" {
+		return nil, "", "", errors.Errorf("invalid S3 dump path %q: should be s3://bucket/key", dumpPath)
+	}
+	c, err := storage.NewS3Client(ctx, storage.S3Config{Endpoint: o.endpoint, InsecureSkipVerify: o.insecureSkipVerify})
+	if err != nil {
+		return nil, "", "", err
+	}
+	return c, bucket, key, nil
+}
+
+// dumpS3URLPath returns the dump path of `--dump-s3-url`. On export the URL is the prefix, which the dump
+// is uploaded under with the auto filename, unless it ends with the dump extension.
+func dumpS3URLPath(url string, export bool) (string, error) {
+	bucket, key, ok := storage.ParseS3URL(url)
+	if !ok || bucket == "" {
+		return "", errors.Errorf("%q should be s3://bucket/prefix", url)
+	}
+	if !export || key == "" || strings.HasSuffix(key, "/") {
+		return url, nil
+	}
+	for _, c := range []string{dump.CompressionGzip, dump.CompressionZstd, dump.CompressionNone} {
+		if dumpExtension(key) == (dump.Compression{Algorithm: c}).Extension() {
+			return url, nil
+		}
+	}
+	return url + "/", nil
+}
+
+// s3DumpWriter is the dump uploaded to S3 while it's written. The transferer aborts the upload if export fails.
+type s3DumpWriter struct {
+	*storage.S3Writer
+}
+
+func (w s3DumpWriter) Read([]byte) (int, error) {
+	return 0, errors.New("dump in S3 is write-only")
+}
+
+// s3DumpReader is the dump downloaded from S3 while it's read.
+type s3DumpReader struct {
+	io.ReadCloser
+}
+
+func (r s3DumpReader) Write([]byte) (int, error) {
+	return 0, errors.New("dump in S3 is read-only")
+}
+
+// createS3Dump starts the upload of the dump to S3.
+func createS3Dump(ctx context.Context, dumpPath string, o s3Options) (io.ReadWriteCloser, error) {
+	c, bucket, key, err := o.client(ctx, dumpPath)
+	if err != nil {
+		return nil, err
+	}
+	log.Info().Msgf("Uploading dump to %s...", dumpPath)
+	w, err := c.Create(ctx, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	return s3DumpWriter{S3Writer: w}, nil
+}
+
+// openS3Dump starts the download of the dump from S3.
+func openS3Dump(ctx context.Context, dumpPath string, o s3Options) (io.ReadWriteCloser, error) {
+	c, bucket, key, err := o.client(ctx, dumpPath)
+	if err != nil {
+		return nil, err
+	}
+	log.Info().Msgf("Downloading dump from %s...", dumpPath)
+	r, err := c.Open(ctx, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	return s3DumpReader{ReadCloser: r}, nil
+}
+
+// readS3DumpMeta reads the meta from the end of the dump in S3. The whole dump is downloaded only if the meta
+// is not found in the end, ex. in dumps of older versions.
+func readS3DumpMeta(ctx context.Context, dumpPath string, o s3Options) (*dump.Meta, error) {
+	c, bucket, key, err := o.client(ctx, dumpPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, tailSize := range s3MetaTailSizes {
+		tail, size, err := c.ReadTail(ctx, bucket, key, tailSize)
+		if err != nil {
+			return nil, err
+		}
+		if meta, err := transferer.ReadMetaFromTail(tail); err == nil {
+			return meta, nil
+		}
+		if int64(len(tail)) == size {
+			return nil, errors.New("no meta file found in dump")
+		}
+	}
+
+	log.Info().Msgf("Meta is not found in the end of %s, downloading the dump...", dumpPath)
+	r, err := c.Open(ctx, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close() //nolint:errcheck
+	return transferer.ReadMetaFromReader(r)
+}
+
+// readDumpMeta reads the meta of the dump file or of the dump in S3.
+func readDumpMeta(dumpPath string, piped bool, o s3Options) (*dump.Meta, error) {
+	if !piped && isS3Path(dumpPath) {
+		return readS3DumpMeta(context.Background(), dumpPath, o)
+	}
+	return transferer.ReadMetaFromDump(dumpPath, piped)
+}
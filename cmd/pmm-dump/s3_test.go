@@ -0,0 +1,55 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import "testing"
+
+func TestDumpS3URLPath(t *testing.T) {
+	tests := []struct {
+		name      string
+		url       string
+		export    bool
+		want      string
+		shouldErr bool
+	}{
+		{name: "export prefix", url: "s3://bucket/dumps", export: true, want: "s3://bucket/dumps/"},
+		{name: "export directory", url: "s3://bucket/dumps/", export: true, want: "s3://bucket/dumps/"},
+		{name: "export bucket", url: "s3://bucket", export: true, want: "s3://bucket"},
+		{name: "export gzip dump", url: "s3://bucket/dumps/pmm.tar.gz", export: true, want: "s3://bucket/dumps/pmm.tar.gz"},
+		{name: "export zstd dump", url: "s3://bucket/pmm.tar.zst", export: true, want: "s3://bucket/pmm.tar.zst"},
+		{name: "export tar dump", url: "s3://bucket/pmm.tar", export: true, want: "s3://bucket/pmm.tar"},
+		{name: "import dump", url: "s3://bucket/dumps/pmm.tar.gz", want: "s3://bucket/dumps/pmm.tar.gz"},
+		{name: "import key without extension", url: "s3://bucket/dumps/pmm", want: "s3://bucket/dumps/pmm"},
+		{name: "not s3", url: "dumps/pmm.tar.gz", shouldErr: true},
+		{name: "no bucket", url: "s3:///pmm.tar.gz", export: true, shouldErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := dumpS3URLPath(tt.url, tt.export)
+			if tt.shouldErr {
+				if err == nil {
+					t.Fatal("should be error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("want %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+
+	"pmm-dump/pkg/dump"
+)
+
+// serverExport is the export of one of several PMM servers.
+type serverExport struct {
+	name     string
+	pmmURL   string
+	dumpPath string
+}
+
+// planServerExports returns the exports of the PMM servers, every server gets its own dump named after it.
+func planServerExports(pmmURLs []string, customPath string, ts time.Time, c dump.Compression) ([]serverExport, error) {
+	exports := make([]serverExport, 0, len(pmmURLs))
+	paths := make(map[string]string, len(pmmURLs))
+	for i, pmmURL := range pmmURLs {
+		name, err := serverName(pmmURL)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid PMM URL #%d", i+1)
+		}
+		dumpPath, err := serverDumpPath(customPath, name, ts, c)
+		if err != nil {
+			return nil, err
+		}
+		if other, ok := paths[dumpPath]; ok {
+			return nil, errors.Errorf("PMM servers %s and %s have the same dump path %s", other, name, dumpPath)
+		}
+		paths[dumpPath] = name
+		exports = append(exports, serverExport{name: name, pmmURL: pmmURL, dumpPath: dumpPath})
+	}
+	return exports, nil
+}
+
+// serverName returns the host and port of the PMM URL, without credentials. URLs without a scheme are accepted as by parseURL.
+func serverName(pmmURL string) (string, error) {
+	if !strings.Contains(pmmURL, "://") {
+		pmmURL = "http://" + pmmURL
+	}
+	u, err := url.Parse(pmmURL)
+	if err != nil {
+		return "", errors.New("failed to parse URL")
+	}
+	if u.Host == "" {
+		return "", errors.New("no host in URL")
+	}
+	return u.Host, nil
+}
+
+var unsafeFilenameChars = regexp.MustCompile(`[^A-Za-z0-9.-]+`)
+
+// serverDumpPath inserts the server name before the extension of the dump path, ex. `pmm-dump-1700000000-pmm.example.com_443.tar.gz`.
+func serverDumpPath(customPath string, name string, ts time.Time, c dump.Compression) (string, error) {
+	filepath, err := getDumpFilepath(customPath, ts, c)
+	if err != nil {
+		return "", err
+	}
+	ext := dumpExtension(filepath)
+	name = unsafeFilenameChars.ReplaceAllString(name, "_")
+	return fmt.Sprintf("%s-%s%s", strings.TrimSuffix(filepath, ext), name, ext), nil
+}
+
+// serverExportArgs returns the arguments of export for one PMM server: the shared flags with the server URL, its dump path and the time range.
+func serverExportArgs(args []string, e serverExport, start, end time.Time) []string {
+	replaced := map[string]bool{"pmm-url": true, "dump-path": true, "start-ts": true, "end-ts": true}
+	result := make([]string, 0, len(args)+4)
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			result = append(result, args[i:]...)
+			break
+		}
+		switch {
+		case strings.HasPrefix(arg, "--"):
+			name, _, hasValue := strings.Cut(strings.TrimPrefix(arg, "--"), "=")
+			if replaced[name] {
+				if !hasValue {
+					i++
+				}
+				continue
+			}
+		case strings.HasPrefix(arg, "-d"):
+			if arg == "-d" {
+				i++
+			}
+			continue
+		}
+		result = append(result, arg)
+	}
+	return append(result,
+		"--pmm-url="+e.pmmURL,
+		"--dump-path="+e.dumpPath,
+		"--start-ts="+start.Format(time.RFC3339),
+		"--end-ts="+end.Format(time.RFC3339),
+	)
+}
+
+// exportServers exports every PMM server into its own dump with the rest of the flags shared.
+// Failed exports are fatal, so every server is exported by a child process and a failed server doesn't stop the others.
+// It returns the exit code, which is 1 if any export failed.
+func exportServers(exports []serverExport, start, end time.Time) int {
+	executable, err := os.Executable()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get the path of pmm-dump executable")
+		return 1
+	}
+
+	failed := make(map[string]bool)
+	for _, e := range exports {
+		log.Info().Msgf("Exporting PMM server %s to %s", e.name, e.dumpPath)
+		cmd := exec.Command(executable, serverExportArgs(os.Args[1:], e, start, end)...) //nolint:gosec
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			log.Error().Err(err).Msgf("Failed to export PMM server %s", e.name)
+			failed[e.name] = true
+		}
+	}
+
+	log.Info().Msgf("Exported %d of %d PMM servers", len(exports)-len(failed), len(exports))
+	for _, e := range exports {
+		status := "exported to " + e.dumpPath
+		if failed[e.name] {
+			status = "failed"
+		}
+		log.Info().Msgf("  %s: %s", e.name, status)
+	}
+	if len(failed) > 0 {
+		return 1
+	}
+	return 0
+}
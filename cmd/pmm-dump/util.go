@@ -15,21 +15,28 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
 	"math"
+	"net/http"
 	"net/url"
 	"os"
 	"path"
+	"path/filepath"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"syscall"
+	"text/tabwriter"
 	"time"
 
 	"github.com/alecthomas/kingpin/v2"
+	"github.com/compose-spec/compose-go/dotenv"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
@@ -37,7 +44,10 @@ import (
 
 	"pmm-dump/pkg/clickhouse"
 	"pmm-dump/pkg/dump"
+	"pmm-dump/pkg/grafana"
 	"pmm-dump/pkg/grafana/client"
+	"pmm-dump/pkg/storage"
+	"pmm-dump/pkg/transferer"
 	"pmm-dump/pkg/victoriametrics"
 )
 
@@ -223,7 +233,7 @@ func getPMMTimezone(pmmURL string, c *client.Client) (string, error) {
 	return resp.Timezone, nil
 }
 
-func composeMeta(pmmURL string, c *client.Client, exportServices bool, cli *kingpin.Application, vmNativeData bool) (*dump.Meta, error) {
+func composeMeta(pmmURL string, c *client.Client, exportServices bool, cli *kingpin.Application, vmDataFormat string) (*dump.Meta, error) {
 	_, pmmVer, err := getPMMVersion(pmmURL, c)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get PMM version")
@@ -277,14 +287,36 @@ func composeMeta(pmmURL string, c *client.Client, exportServices bool, cli *king
 		PMMTimezone:       pmmTz,
 		Arguments:         strings.Join(args, " "),
 		PMMServerServices: pmmServices,
-		VMDataFormat:      "json",
+		VMDataFormat:      vmDataFormat,
 	}
 
-	if vmNativeData {
-		meta.VMDataFormat = "native"
+	return meta, nil
+}
+
+// compareDumpMeta prints differences of the dump meta from the target PMM and the compatibility verdict.
+// It returns false if the dump is incompatible.
+func compareDumpMeta(dumpPath string, s3 s3Options, pmmURL string, c *client.Client, cli *kingpin.Application, vmDataFormat string) (bool, error) {
+	if dumpPath == "" {
+		return false, errors.New("please, specify path to dump file")
+	}
+	dumpMeta, err := readDumpMeta(dumpPath, false, s3)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to read dump meta")
+	}
+	runtimeMeta, err := composeMeta(pmmURL, c, false, cli, vmDataFormat)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to compose meta")
 	}
 
-	return meta, nil
+	comparison := transferer.CompareMeta(*dumpMeta, *runtimeMeta)
+	for _, p := range comparison.Problems {
+		fmt.Printf("Problem: %s\n", p)
+	}
+	for _, w := range comparison.Warnings {
+		fmt.Printf("Warning: %s\n", w)
+	}
+	fmt.Printf("Verdict: %s\n", comparison.Verdict())
+	return len(comparison.Problems) == 0, nil
 }
 
 func ByteCountDecimal(b int64) string {
@@ -313,6 +345,475 @@ func ByteCountBinary(b int64) string {
 	return fmt.Sprintf("%.1f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
 }
 
+func readAnnotations(dumpPath string) ([]dump.Annotation, error) {
+	files, err := transferer.ReadFilesFromDump(dumpPath, false, dump.AnnotationsFilename)
+	if err != nil {
+		return nil, err
+	}
+	content, ok := files[dump.AnnotationsFilename]
+	if !ok {
+		return nil, errors.Errorf("%s is not found in dump", dump.AnnotationsFilename)
+	}
+
+	var annotations []dump.Annotation
+	if err := json.Unmarshal(content, &annotations); err != nil {
+		return nil, errors.Wrap(err, "failed to parse annotations")
+	}
+	return annotations, nil
+}
+
+func printAnnotationsCount(dumpPath string, piped bool) {
+	if piped {
+		fmt.Printf("Annotations: can't be shown in a pipeline\n")
+		return
+	}
+	if isS3Path(dumpPath) {
+		fmt.Printf("Annotations: can't be shown for the dump in S3 without downloading it\n")
+		return
+	}
+
+	annotations, err := readAnnotations(dumpPath)
+	if err != nil {
+		log.Fatal().Msgf("Can't show Grafana annotations: %v", err)
+	}
+	fmt.Printf("Annotations: %d\n", len(annotations))
+}
+
+func printVMTotalSeries(dumpPath string, piped bool) {
+	if piped {
+		fmt.Printf("VM Total Series: can't be shown in a pipeline\n")
+		return
+	}
+	if isS3Path(dumpPath) {
+		fmt.Printf("VM Total Series: can't be shown for the dump in S3 without downloading it\n")
+		return
+	}
+
+	files, err := transferer.ReadFilesFromDump(dumpPath, false, dump.VMMetadataFilename)
+	if err != nil {
+		log.Fatal().Msgf("Can't show VictoriaMetrics metadata: %v", err)
+	}
+	content, ok := files[dump.VMMetadataFilename]
+	if !ok {
+		log.Fatal().Msgf("Can't show VictoriaMetrics metadata: %s is not found in dump", dump.VMMetadataFilename)
+	}
+
+	var metadata dump.VMMetadata
+	if err := json.Unmarshal(content, &metadata); err != nil {
+		log.Fatal().Msgf("Failed to parse VictoriaMetrics metadata: %v", err)
+	}
+	fmt.Printf("VM Total Series: %d\n", metadata.TotalSeries)
+}
+
+func printVMMetricMetadataCount(dumpPath string, piped bool) {
+	if piped {
+		fmt.Printf("VM Metric Metadata: can't be shown in a pipeline\n")
+		return
+	}
+	if isS3Path(dumpPath) {
+		fmt.Printf("VM Metric Metadata: can't be shown for the dump in S3 without downloading it\n")
+		return
+	}
+
+	files, err := transferer.ReadFilesFromDump(dumpPath, false, dump.VMMetricMetadataFilename)
+	if err != nil {
+		log.Fatal().Msgf("Can't show metric metadata: %v", err)
+	}
+	content, ok := files[dump.VMMetricMetadataFilename]
+	if !ok {
+		log.Fatal().Msgf("Can't show metric metadata: %s is not found in dump", dump.VMMetricMetadataFilename)
+	}
+
+	var metadata map[string][]dump.MetricMetadata
+	if err := json.Unmarshal(content, &metadata); err != nil {
+		log.Fatal().Msgf("Failed to parse metric metadata: %v", err)
+	}
+	fmt.Printf("VM Metric Metadata: %d metrics\n", len(metadata))
+}
+
+func printFilters(dumpPath string, piped bool) {
+	if !piped && isS3Path(dumpPath) {
+		log.Fatal().Msg("Can't show export filters of the dump in S3 without downloading it")
+	}
+	files, err := transferer.ReadFilesFromDump(dumpPath, piped, dump.FiltersFilename)
+	if err != nil {
+		log.Fatal().Msgf("Can't show export filters: %v", err)
+	}
+	content, ok := files[dump.FiltersFilename]
+	if !ok {
+		log.Fatal().Msgf("Can't show export filters: %s is not found in dump", dump.FiltersFilename)
+	}
+
+	var filters dump.Filters
+	if err := json.Unmarshal(content, &filters); err != nil {
+		log.Fatal().Msgf("Failed to parse export filters: %v", err)
+	}
+	jsonFilters, err := json.MarshalIndent(filters, "", "\t")
+	if err != nil {
+		log.Fatal().Msgf("Failed to format export filters as json: %v", err)
+	}
+	fmt.Printf("%v\n", string(jsonFilters))
+}
+
+func importDumpAnnotations(c *client.Client, pmmURL, dumpPath string, piped bool) {
+	if piped {
+		log.Warn().Msg("Grafana annotations can't be imported in a pipeline, skipping them")
+		return
+	}
+	if isS3Path(dumpPath) {
+		log.Warn().Msg("Grafana annotations can't be imported from the dump in S3, skipping them")
+		return
+	}
+
+	annotations, err := readAnnotations(dumpPath)
+	if err != nil {
+		log.Warn().Msgf("Grafana annotations are unavailable, skipping them: %v", err)
+		return
+	}
+	if err := grafana.ImportAnnotations(c, pmmURL, annotations); err != nil {
+		log.Fatal().Msgf("Failed to import Grafana annotations: %v", err)
+	}
+	log.Info().Msgf("Imported %d Grafana annotations", len(annotations))
+}
+
+type versionInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	Branch    string `json:"branch"`
+	GoVersion string `json:"go_version"`
+	BuildDate string `json:"build_date"`
+}
+
+func versionJSON() ([]byte, error) {
+	return json.Marshal(versionInfo{
+		Version:   GitVersion,
+		Commit:    GitCommit,
+		Branch:    GitBranch,
+		GoVersion: runtime.Version(),
+		BuildDate: BuildDate,
+	})
+}
+
+func printTopChunks(dumpPath string, piped bool, n int) {
+	if piped {
+		fmt.Printf("Top chunks: can't be shown in a pipeline\n")
+		return
+	}
+	if isS3Path(dumpPath) {
+		fmt.Printf("Top chunks: can't be shown for the dump in S3 without downloading it\n")
+		return
+	}
+
+	var statsFiles []string
+	for _, st := range []dump.SourceType{dump.VictoriaMetrics, dump.ClickHouse} {
+		statsFiles = append(statsFiles, path.Join(st.String(), dump.ChunkStatsFilename))
+	}
+	files, err := transferer.ReadFilesFromDump(dumpPath, piped, statsFiles...)
+	if err != nil {
+		log.Fatal().Msgf("Can't show top chunks: %v", err)
+	}
+	if len(files) == 0 {
+		fmt.Printf("Top chunks: dump has no chunk stats, use --export-chunk-stats on export\n")
+		return
+	}
+
+	var stats []dump.ChunkStats
+	for _, name := range statsFiles {
+		content, ok := files[name]
+		if !ok {
+			continue
+		}
+		var sourceStats []dump.ChunkStats
+		if err := json.Unmarshal(content, &sourceStats); err != nil {
+			log.Fatal().Msgf("Failed to parse %s: %v", name, err)
+		}
+		for _, s := range sourceStats {
+			s.Filename = path.Join(path.Dir(name), s.Filename)
+			stats = append(stats, s)
+		}
+	}
+	sort.SliceStable(stats, func(i, j int) bool {
+		return stats[i].Bytes > stats[j].Bytes
+	})
+	if len(stats) > n {
+		stats = stats[:n]
+	}
+
+	fmt.Printf("Top chunks:\n")
+	for _, s := range stats {
+		fmt.Printf("\t- %s: %v, read in %v, metrics: %d\n", s.Filename, ByteCountDecimal(s.Bytes), time.Duration(s.DurationMs)*time.Millisecond, s.MetricsCount)
+	}
+}
+
+// printExportEstimate prints the chunks count and the estimated size of every source and the total of the dry-run export.
+func printExportEstimate(w io.Writer, estimates []transferer.ExportEstimate) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0) //nolint:mnd
+	fmt.Fprintf(tw, "SOURCE\tCHUNKS\tSAMPLE SIZE\tESTIMATED SIZE\tUNCOMPRESSED SIZE\n")
+	var chunks int
+	var size, uncompressedSize int64
+	for _, e := range estimates {
+		sample := ByteCountDecimal(e.SampleSize)
+		switch {
+		case e.Chunks == 0:
+			sample = "-"
+		case e.NoData:
+			sample = "no data"
+		case e.SampleEmpty:
+			sample = "empty"
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\n", e.Source, e.Chunks, sample,
+			ByteCountDecimal(e.Size()), ByteCountDecimal(e.UncompressedSize()))
+		chunks += e.Chunks
+		size += e.Size()
+		uncompressedSize += e.UncompressedSize()
+	}
+	fmt.Fprintf(tw, "total\t%d\t\t%s\t%s\n", chunks, ByteCountDecimal(size), ByteCountDecimal(uncompressedSize))
+	_ = tw.Flush()
+	for _, e := range estimates {
+		switch {
+		case e.NoData:
+			fmt.Fprintf(w, "No %s data matches the filters in the export time range\n", e.Source)
+		case e.SampleEmpty:
+			fmt.Fprintf(w, "The first %s chunk has no data, the estimate of %s may be too low\n", e.Source, e.Source)
+		}
+	}
+}
+
+func readCardinality(r io.Reader) (*victoriametrics.Cardinality, error) {
+	dr, err := dump.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer dr.Close() //nolint:errcheck
+
+	c := victoriametrics.NewCardinality()
+	for {
+		header, err := dr.Next()
+		if errors.Is(err, io.EOF) {
+			return c, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		dir, filename := path.Split(header.Name)
+		if dump.ParseSourceType(path.Clean(dir)) != dump.VictoriaMetrics || filename == dump.ChunkStatsFilename ||
+			header.Name == dump.VMMetadataFilename || header.Name == dump.VMMetricMetadataFilename {
+			continue
+		}
+
+		content, err := io.ReadAll(dr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read chunk %s", header.Name)
+		}
+		if len(content) == 0 {
+			continue
+		}
+		if err := c.AddChunk(content); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse chunk %s", header.Name)
+		}
+	}
+}
+
+// scrubDump writes the dump from r to w with redacted core metrics labels. Files which can't be redacted are dropped:
+// QAN chunks unless keepQAN is set (their columns are unknown offline), pmm-agent configuration and VictoriaMetrics metadata.
+func scrubDump(r io.Reader, w io.Writer, redactor *victoriametrics.Redactor, keepQAN bool) error {
+	dr, err := dump.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer dr.Close() //nolint:errcheck
+
+	dw, err := dump.NewWriter(w)
+	if err != nil {
+		return errors.Wrap(err, "failed to create dump writer")
+	}
+
+	// Chunks are redacted, so their checksums are computed again for the meta, which is after chunks
+	checksums := make(map[string]string)
+	for {
+		header, err := dr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		content, err := io.ReadAll(dr)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read %s", header.Name)
+		}
+
+		dir, filename := path.Split(header.Name)
+		st := dump.ParseSourceType(path.Clean(dir))
+		switch {
+		case header.Name == dump.MetaFilename:
+			content, err = scrubMeta(content, checksums)
+		case header.Name == dump.AgentConfigFilename || header.Name == dump.VMMetadataFilename || header.Name == dump.VMMetricMetadataFilename ||
+			header.Name == dump.FiltersFilename:
+			log.Info().Msgf("Dropping %s", header.Name)
+			continue
+		case st == dump.ClickHouse && !keepQAN:
+			log.Debug().Msgf("Dropping QAN chunk %s", header.Name)
+			continue
+		case st == dump.VictoriaMetrics && filename != dump.ChunkStatsFilename && len(content) > 0:
+			content, err = redactor.RedactChunk(content)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed to scrub %s", header.Name)
+		}
+
+		if err := dw.AddFile(header.Name, content); err != nil {
+			return err
+		}
+		// Only chunks have checksums, ex. metric metadata in the vm directory is not a chunk
+		if st != dump.UndefinedSource && filename != dump.ChunkStatsFilename && header.Name != dump.VMMetricMetadataFilename {
+			h := dump.NewChecksum()
+			_, _ = h.Write(content)
+			checksums[header.Name] = dump.ChecksumString(h)
+		}
+	}
+
+	return dw.Close()
+}
+
+// scrubMeta removes service names from the meta, marks dropped files as not exported and sets the compression of the scrubbed dump.
+// Chunk checksums, if the meta has them, are replaced with the checksums of scrubbed chunks.
+func scrubMeta(content []byte, checksums map[string]string) ([]byte, error) {
+	var meta dump.Meta
+	if err := json.Unmarshal(content, &meta); err != nil {
+		return nil, errors.Wrap(err, "failed to parse meta")
+	}
+	if len(meta.ChunkChecksums) > 0 {
+		meta.ChunkChecksums = checksums
+	}
+	meta.PMMServerServices = nil
+	meta.AgentConfigExported = false
+	meta.VMMetadataExported = false
+	meta.VMMetricMetadataExported = false
+	meta.Compression = dump.DefaultCompression.String()
+	return json.Marshal(meta)
+}
+
+// envFileFlag is the name of the flag with the path to a dotenv file.
+const envFileFlag = "env-file"
+
+// loadEnvFile sets environment variables from the dotenv file of the env file flag, so they are used by the flags on parse.
+// It's done before the parse, as kingpin resolves flag envars on it. Variables already set in the environment are kept.
+func loadEnvFile(cli *kingpin.Application, args []string) error {
+	filename, ok := flagArg(cli, args, envFileFlag)
+	if !ok {
+		return nil
+	}
+	return dotenv.Load(filename)
+}
+
+// credentialsFileFlag is the name of the flag with the path to a dotenv file with PMM secrets.
+const credentialsFileFlag = "credentials-file"
+
+// credentialsEnvars are the only variables allowed in the credentials file.
+var credentialsEnvars = []string{"PMM_PASS", "PMM_TOKEN", "PMM_COOKIE"}
+
+// loadCredentialsFile sets environment variables from the credentials file the same way as loadEnvFile.
+// The file should be accessible by its owner only and have secrets only, see credentialsEnvars.
+func loadCredentialsFile(cli *kingpin.Application, args []string) error {
+	filename, ok := flagArg(cli, args, credentialsFileFlag)
+	if !ok {
+		return nil
+	}
+	stat, err := os.Stat(filename)
+	if err != nil {
+		return errors.Wrap(err, "failed to stat credentials file")
+	}
+	if perm := stat.Mode().Perm(); perm&0o077 != 0 {
+		return errors.Errorf("credentials file %s is accessible by other users (%04o), restrict it with `chmod 600`", filename, perm)
+	}
+	vars, err := dotenv.Read(filename)
+	if err != nil {
+		return errors.Wrap(err, "failed to read credentials file")
+	}
+	allowed := make(map[string]bool, len(credentialsEnvars))
+	for _, name := range credentialsEnvars {
+		allowed[name] = true
+	}
+	for name, value := range vars {
+		if !allowed[name] {
+			return errors.Errorf("credentials file has unsupported variable %s, allowed ones are %s", name, strings.Join(credentialsEnvars, ", "))
+		}
+		if _, ok := os.LookupEnv(name); ok {
+			continue
+		}
+		if err := os.Setenv(name, value); err != nil {
+			return errors.Wrapf(err, "failed to set %s", name)
+		}
+	}
+	return nil
+}
+
+// flagArg returns the value of the flag from args without parsing them, so it can be used before the parse.
+func flagArg(cli *kingpin.Application, args []string, name string) (string, bool) {
+	context, _ := cli.ParseContext(args) // parse errors are reported by the parse itself
+	if context == nil {
+		return "", false
+	}
+	for _, e := range context.Elements {
+		flag, ok := e.Clause.(*kingpin.FlagClause)
+		if !ok || e.Value == nil || flag.Model().Name != name {
+			continue
+		}
+		return *e.Value, true
+	}
+	return "", false
+}
+
+// expandBoolFlagValues rewrites `--flag=true` and `--flag=false` of boolean flags to `--flag` and `--no-flag`,
+// as kingpin doesn't accept values of boolean flags.
+func expandBoolFlagValues(cli *kingpin.Application, args []string) []string {
+	boolFlags := make(map[string]bool)
+	addFlags := func(flags []*kingpin.FlagModel) {
+		for _, f := range flags {
+			if f.IsBoolFlag() {
+				boolFlags[f.Name] = true
+			}
+		}
+	}
+	model := cli.Model()
+	addFlags(model.Flags)
+	for _, cmd := range model.FlattenedCommands() {
+		addFlags(cmd.Flags)
+	}
+
+	expanded := make([]string, 0, len(args))
+	for i, arg := range args {
+		if arg == "--" {
+			return append(expanded, args[i:]...)
+		}
+		name, value, ok := strings.Cut(strings.TrimPrefix(arg, "--"), "=")
+		if ok && strings.HasPrefix(arg, "--") && boolFlags[name] {
+			if enabled, err := strconv.ParseBool(value); err == nil {
+				arg = "--no-" + name
+				if enabled {
+					arg = "--" + name
+				}
+			}
+		}
+		expanded = append(expanded, arg)
+	}
+	return expanded
+}
+
+// describeSources lists the data sources, whether they are enabled and the flags enabling them.
+func describeSources(dumpCore, dumpQAN bool) string {
+	describe := func(name string, enabled bool, flag string) string {
+		if enabled {
+			return name + " are enabled"
+		}
+		return fmt.Sprintf("%s are disabled, enable them with `%s`", name, flag)
+	}
+	return describe("core metrics (VictoriaMetrics)", dumpCore, "--dump-core") + "; " + describe("QAN metrics (ClickHouse)", dumpQAN, "--dump-qan")
+}
+
 func checkPiped() (bool, error) {
 	stat, err := os.Stdin.Stat()
 	if err != nil {
@@ -362,38 +863,159 @@ func checkVersionSupport(c *client.Client, pmmURL, victoriaMetricsURL string) {
 	}
 }
 
-func prepareVictoriaMetricsSource(grafanaC *client.Client, dumpCore bool, url string, selectors []string, nativeData bool, contentLimit uint64) (*victoriametrics.Source, bool) {
-	if !dumpCore {
-		return nil, false
+// metricsChecker is implemented by sources which can check for existing data within a time range.
+type metricsChecker interface {
+	HasMetrics(start, end time.Time) (bool, error)
+}
+
+// confirmImport asks for confirmation if the target PMM already has data in the dump time range.
+func confirmImport(dumpPath string, sources []dump.Source) {
+	// The time range is read from chunk names, so the dump in S3 would be downloaded twice
+	if isS3Path(dumpPath) {
+		log.Warn().Msg("Existing data in the dump time range is not checked for the dump in S3")
+		return
+	}
+	start, end, ok, err := transferer.ReadTimeRangeFromDump(dumpPath)
+	if err != nil {
+		log.Fatal().Msgf("Failed to read dump time range: %v", err)
+	}
+	if !ok {
+		log.Debug().Msg("Dump time range is unknown, skipping the check for existing data")
+		return
+	}
+
+	var nonEmpty []string
+	for _, s := range sources {
+		c, ok := s.(metricsChecker)
+		if !ok {
+			continue
+		}
+		hasMetrics, err := c.HasMetrics(start, end)
+		if err != nil {
+			log.Fatal().Msgf("Failed to check existing %s data: %v", s.Type(), err)
+		}
+		if hasMetrics {
+			nonEmpty = append(nonEmpty, s.Type().String())
+		}
+	}
+	if len(nonEmpty) == 0 {
+		return
 	}
 
+	question := fmt.Sprintf("Target PMM already has %s data between %s and %s. Continue import? [y/N]: ",
+		strings.Join(nonEmpty, " and "), start.Format(time.RFC3339), end.Format(time.RFC3339))
+	confirmed, err := askConfirmation(os.Stdin, os.Stderr, question)
+	if err != nil {
+		log.Fatal().Msgf("Failed to read confirmation: %v", err)
+	}
+	if !confirmed {
+		log.Fatal().Msg("Import is canceled. Use `--yes` to skip the confirmation")
+	}
+}
+
+// askConfirmation writes the question to w and reads y/N answer from r. Anything except "y" or "yes" is no.
+func askConfirmation(r io.Reader, w io.Writer, question string) (bool, error) {
+	if _, err := fmt.Fprint(w, question); err != nil {
+		return false, err
+	}
+	answer, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return false, err
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes", nil
+}
+
+// maxClockSkew is the max difference between the local and PMM server clocks which doesn't produce a warning.
+const maxClockSkew = time.Minute
+
+// checkClockSkew warns if the local clock differs from the PMM server one, as the default export time range is based on the local time.
+func checkClockSkew(pmmURL string, c *client.Client) {
+	skew, err := pmmClockSkew(pmmURL, c)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to check clock skew with PMM server")
+		return
+	}
+
+	if skew > maxClockSkew {
+		log.Warn().Msgf("Local clock differs from PMM server clock by %v. "+
+			"Default export time range may select the wrong data, consider to specify `--start-ts` and `--end-ts`", skew)
+	}
+}
+
+// pmmClockSkew returns the difference between the local and PMM server clocks.
+// PMM server time is taken from the Date header of the version endpoint, as its `server.timestamp` is the build time.
+func pmmClockSkew(pmmURL string, c *client.Client) (time.Duration, error) {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	req.SetRequestURI(pmmURL + "/v1/version")
+	req.Header.SetMethod(fasthttp.MethodGet)
+
+	resp, err := c.Do(req)
+	defer fasthttp.ReleaseResponse(resp)
+	if err != nil {
+		return 0, err
+	}
+	serverTime, err := http.ParseTime(string(resp.Header.Peek(fasthttp.HeaderDate)))
+	if err != nil {
+		return 0, errors.Wrap(err, "invalid Date header")
+	}
+	return clockSkew(time.Now(), serverTime), nil
+}
+
+// clockSkew returns the absolute difference between local and server time.
+func clockSkew(local, server time.Time) time.Duration {
+	skew := local.Sub(server)
+	if skew < 0 {
+		return -skew
+	}
+	return skew
+}
+
+// newVictoriaMetricsConfig returns VictoriaMetrics source config with the data format and the chunk content limit.
+func newVictoriaMetricsConfig(url, dataFormat string, contentLimit uint64) victoriametrics.Config {
 	if contentLimit > math.MaxInt {
-		log.Fatal().Msgf("`--vm-content-limit` can't have a value greater than %d", math.MaxInt)
+		log.Fatal().Msgf("VictoriaMetrics chunk content limit can't have a value greater than %d", math.MaxInt)
 	}
 
-	c := &victoriametrics.Config{
-		ConnectionURL:       url,
-		TimeSeriesSelectors: selectors,
-		NativeData:          nativeData,
-		ContentLimit:        int(contentLimit),
+	return victoriametrics.Config{
+		ConnectionURL: url,
+		NativeData:    dataFormat == victoriametrics.FormatNative,
+		OpenMetrics:   dataFormat == victoriametrics.FormatOpenMetrics,
+		ContentLimit:  int(contentLimit),
+	}
+}
+
+func prepareVictoriaMetricsSource(grafanaC *client.Client, dumpCore bool, c victoriametrics.Config) (*victoriametrics.Source, bool) {
+	if !dumpCore {
+		return nil, false
 	}
 
 	log.Debug().Msgf("Got Victoria Metrics URL: %s", c.ConnectionURL)
 
-	return victoriametrics.NewSource(grafanaC, *c), true
+	return victoriametrics.NewSource(grafanaC, c), true
 }
 
-func prepareClickHouseSource(ctx context.Context, dumpQAN bool, url, where string) (*clickhouse.Source, bool) {
-	if !dumpQAN {
-		return nil, false
+// resolveVMDataFormat returns VictoriaMetrics data format from `--vm-format` and `--vm-native-data` flags, JSON by default.
+func resolveVMDataFormat(format string, nativeData bool) (string, error) {
+	if !nativeData {
+		if format == "" {
+			return victoriametrics.FormatJSON, nil
+		}
+		return format, nil
 	}
+	if format != "" && format != victoriametrics.FormatNative {
+		return "", errors.Errorf("`--vm-native-data` conflicts with `--vm-format=%s`", format)
+	}
+	return victoriametrics.FormatNative, nil
+}
 
-	c := &clickhouse.Config{
-		ConnectionURL: url,
-		Where:         where,
+func prepareClickHouseSource(ctx context.Context, dumpQAN bool, c clickhouse.Config) (*clickhouse.Source, bool) {
+	if !dumpQAN {
+		return nil, false
 	}
 
-	clickhouseSource, err := clickhouse.NewSource(ctx, *c)
+	clickhouseSource, err := clickhouse.NewSource(ctx, c)
 	if err != nil {
 		log.Fatal().Msgf("Failed to create ClickHouse source: %s", err.Error())
 	}
@@ -403,7 +1025,157 @@ func prepareClickHouseSource(ctx context.Context, dumpQAN bool, url, where strin
 	return clickhouseSource, true
 }
 
+// readSinceDump returns the max period_start of QAN rows of the prior dump, the start of the incremental export.
+func readSinceDump(dumpPath string, s3 s3Options) (*time.Time, error) {
+	meta, err := readDumpMeta(dumpPath, false, s3)
+	if err != nil {
+		return nil, err
+	}
+	if meta.QANMaxPeriodStart == nil {
+		return nil, errors.Errorf("dump %s has no `qan-max-period-start` in meta: it has no QAN rows, its QAN rows are capped "+
+			"or it's exported by an older pmm-dump version", dumpPath)
+	}
+	return meta.QANMaxPeriodStart, nil
+}
+
+// prepareCheckpoint reads the QAN export checkpoint or creates it if the file doesn't exist.
+// The checkpoint should be created for the same time range, as its watermark is meaningless for another one.
+func prepareCheckpoint(filename string, start, end time.Time) (*clickhouse.Checkpoint, error) {
+	cp, err := clickhouse.ReadCheckpoint(filename)
+	if err != nil {
+		return nil, err
+	}
+	if cp == nil {
+		cp = &clickhouse.Checkpoint{Start: start, End: end}
+		if err := clickhouse.WriteCheckpoint(filename, *cp); err != nil {
+			return nil, err
+		}
+		return cp, nil
+	}
+	if !cp.Start.Equal(start) || !cp.End.Equal(end) {
+		return nil, errors.Errorf("checkpoint %s is for time range %s - %s: use the same `--start-ts` and `--end-ts` or remove the file",
+			filename, cp.Start.Format(time.RFC3339), cp.End.Format(time.RFC3339))
+	}
+	if cp.Watermark != nil {
+		log.Info().Msgf("Resuming QAN export from checkpoint %s: rows with period_start before %s are already exported",
+			filename, cp.Watermark.Format(time.RFC3339))
+	}
+	return cp, nil
+}
+
+// prepareExportCheckpoint reads the export checkpoint to resume the export from. It returns nil if the file doesn't exist.
+// The resumed export should have the same time range and compression, and write to the same dump.
+func prepareExportCheckpoint(filename, dumpPath string, start, end time.Time, c dump.Compression) (*transferer.ExportCheckpoint, error) {
+	cp, err := transferer.ReadExportCheckpoint(filename)
+	if err != nil || cp == nil {
+		return nil, err
+	}
+	if !cp.Start.Equal(start) || !cp.End.Equal(end) {
+		return nil, errors.Errorf("checkpoint %s is for time range %s - %s: use the same `--start-ts` and `--end-ts` or remove the file",
+			filename, cp.Start.Format(time.RFC3339), cp.End.Format(time.RFC3339))
+	}
+	if cp.Compression != c.String() {
+		return nil, errors.Errorf("checkpoint %s is for %s compression: use the same `--compression` or remove the file", filename, cp.Compression)
+	}
+	if dumpPath != "" {
+		// The dump directory is allowed too, as the dump name is generated from the time of the first export
+		info, err := os.Stat(dumpPath)
+		isDir := (err == nil && info.IsDir()) || os.IsPathSeparator(dumpPath[len(dumpPath)-1])
+		absPath, err := filepath.Abs(dumpPath)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to get absolute path of %s", dumpPath)
+		}
+		if absPath != cp.DumpPath && !(isDir && absPath == filepath.Dir(cp.DumpPath)) {
+			return nil, errors.Errorf("checkpoint %s is for dump %s: use the same `--dump-path` or remove the file", filename, cp.DumpPath)
+		}
+	}
+	return cp, nil
+}
+
+// newExportCheckpoint writes the checkpoint of the export to the created dump file, which has no chunks yet.
+func newExportCheckpoint(filename string, file io.ReadWriteCloser, start, end time.Time, c dump.Compression, chunks []dump.ChunkMeta, qanRowsCapped bool) (*transferer.ExportCheckpoint, error) {
+	f, ok := file.(*os.File)
+	if !ok {
+		return nil, errors.New("export checkpoint requires the dump file")
+	}
+	dumpPath, err := filepath.Abs(f.Name())
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get absolute path of %s", f.Name())
+	}
+	cp := &transferer.ExportCheckpoint{
+		DumpPath:      dumpPath,
+		Start:         start,
+		End:           end,
+		Compression:   c.String(),
+		Chunks:        chunks,
+		QANRowsCapped: qanRowsCapped,
+	}
+	if err := transferer.WriteExportCheckpoint(filename, *cp); err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+// openPartialDump opens the partial dump of the resumed export for appending, cutting everything written after the checkpoint.
+func openPartialDump(cp transferer.ExportCheckpoint) (io.ReadWriteCloser, error) {
+	file, err := os.OpenFile(cp.DumpPath, os.O_RDWR, 0) //nolint:gosec
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open partial dump %s", cp.DumpPath)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, errors.Wrapf(err, "failed to get partial dump %s info", cp.DumpPath)
+	}
+	if info.Size() < cp.Size {
+		_ = file.Close()
+		return nil, errors.Errorf("partial dump %s is %d bytes, but the checkpoint has %d bytes exported", cp.DumpPath, info.Size(), cp.Size)
+	}
+	if err := file.Truncate(cp.Size); err != nil {
+		_ = file.Close()
+		return nil, errors.Wrapf(err, "failed to truncate partial dump %s", cp.DumpPath)
+	}
+	if _, err := file.Seek(0, io.SeekEnd); err != nil {
+		_ = file.Close()
+		return nil, errors.Wrapf(err, "failed to seek partial dump %s", cp.DumpPath)
+	}
+	return file, nil
+}
+
+func readWhereFile(filepath string) (string, error) {
+	content, err := os.ReadFile(filepath) //nolint:gosec
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to read file %s", filepath)
+	}
+	where := strings.TrimSpace(string(content))
+	if where == "" {
+		return "", errors.Errorf("file %s is empty", filepath)
+	}
+	return where, nil
+}
+
+// whereWithInstances adds the filter by service names of instances to the WHERE statement of `--where` or `--ch-where-file`,
+// so QAN rows should match both of them.
+func whereWithInstances(where string, instances []string) string {
+	if len(instances) == 0 {
+		return where
+	}
+	conditions := make([]string, 0, len(instances))
+	for _, serviceName := range instances {
+		conditions = append(conditions, "service_name="+clickhouse.QuoteString(serviceName))
+	}
+	instancesWhere := strings.Join(conditions, " OR ")
+	if where == "" {
+		return instancesWhere
+	}
+	return fmt.Sprintf("(%s) AND (%s)", where, instancesWhere)
+}
+
 func parseURL(pmmURL, pmmHost, pmmPort, pmmUser, pmmPassword *string) {
+	if *pmmURL == "" && *pmmHost == "" {
+		log.Fatal().Msg("Please, specify `--pmm-url` or `--pmm-host`: the command requires connection to PMM")
+	}
+
 	parsedURL, err := url.Parse(*pmmURL)
 	if err != nil {
 		log.Fatal().Err(err).Msg("Cannot parse pmm url")
@@ -451,10 +1223,12 @@ func parseURL(pmmURL, pmmHost, pmmPort, pmmUser, pmmPassword *string) {
 	*pmmURL = parsedURL.String()
 }
 
-func getFile(dumpPath string, piped bool) (io.ReadWriteCloser, error) {
+func getFile(dumpPath string, piped bool, s3 s3Options) (io.ReadWriteCloser, error) {
 	var file io.ReadWriteCloser
 	if piped {
 		file = os.Stdin
+	} else if isS3Path(dumpPath) {
+		return openS3Dump(context.Background(), dumpPath, s3)
 	} else {
 		var err error
 		log.Info().
@@ -471,22 +1245,32 @@ func getFile(dumpPath string, piped bool) (io.ReadWriteCloser, error) {
 
 const dirPermission = 0o777
 
-func createFile(dumpPath string, piped bool) (io.ReadWriteCloser, error) {
+// createFile creates the dump file, or returns STDOUT if piped, or starts the upload if the dump path is in S3.
+// The file isn't created if its directory has less than minFreeSpace bytes of free disk space. 0 disables the check.
+func createFile(dumpPath string, piped bool, minFreeSpace uint64, c dump.Compression, s3 s3Options) (io.ReadWriteCloser, error) {
 	var file *os.File
 	if piped {
 		file = os.Stdout
 	} else {
 		exportTS := time.Now().UTC()
 		log.Debug().Msgf("Trying to determine filepath")
-		filepath, err := getDumpFilepath(dumpPath, exportTS)
+		filepath, err := getDumpFilepath(dumpPath, exportTS, c)
 		if err != nil {
 			return nil, err
 		}
+		if isS3Path(filepath) {
+			return createS3Dump(context.Background(), filepath, s3)
+		}
 
 		log.Debug().Msgf("Preparing dump file: %s", filepath)
 		if err := os.MkdirAll(path.Dir(filepath), dirPermission); err != nil {
 			return nil, errors.Wrap(err, "failed to create folders for the dump file")
 		}
+		if minFreeSpace > 0 {
+			if err := checkFreeSpace(path.Dir(filepath), minFreeSpace); err != nil {
+				return nil, err
+			}
+		}
 		file, err = os.Create(filepath) //nolint:gosec
 		if err != nil {
 			return nil, errors.Wrapf(err, "failed to create %s", filepath)
@@ -495,11 +1279,132 @@ func createFile(dumpPath string, piped bool) (io.ReadWriteCloser, error) {
 	return file, nil
 }
 
-func getDumpFilepath(customPath string, ts time.Time) (string, error) {
-	autoFilename := fmt.Sprintf("pmm-dump-%v.tar.gz", ts.Unix())
+// checkFreeSpace checks that the file system of dir has at least minFreeSpace bytes available to the user.
+func checkFreeSpace(dir string, minFreeSpace uint64) error {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return errors.Wrapf(err, "failed to get free disk space of %s", dir)
+	}
+	free := uint64(stat.Bavail) * uint64(stat.Bsize) //nolint:unconvert
+	log.Debug().Msgf("Free disk space of %s: %s", dir, ByteCountBinary(int64(free)))
+	if free < minFreeSpace {
+		return errors.Errorf("not enough free disk space in %s: %s available, %s expected. Use `--skip-disk-check` to export anyway",
+			dir, ByteCountBinary(int64(free)), ByteCountBinary(int64(minFreeSpace)))
+	}
+	return nil
+}
+
+// handlePartialDump closes the dump file of a failed export and removes it, unless keep is set.
+func handlePartialDump(file io.ReadWriteCloser, keep bool) {
+	_ = file.Close()
+	f, ok := file.(*os.File)
+	if !ok {
+		return
+	}
+	if keep {
+		log.Warn().Msgf("Partial dump is kept: %s", f.Name())
+		return
+	}
+	if err := os.Remove(f.Name()); err != nil {
+		log.Error().Err(err).Msgf("Failed to remove partial dump %s", f.Name())
+		return
+	}
+	log.Info().Msgf("Partial dump is removed: %s. Use `--keep-partial` to keep it", f.Name())
+}
+
+// resumedDumpSuffix is the suffix of the dump the export is resumed into, until it replaces the partial dump.
+const resumedDumpSuffix = ".resume"
+
+// replacePartialDump replaces the partial dump with the resumed one, if the chunks of the partial dump are copied to it.
+// Otherwise the resumed dump is removed, so the partial dump can be resumed again.
+func replacePartialDump(file io.ReadWriteCloser, partial *transferer.PartialDump) error {
+	_ = file.Close()
+	f, ok := file.(*os.File)
+	if !ok {
+		return nil
+	}
+	if !partial.Copied {
+		if err := os.Remove(f.Name()); err != nil {
+			return errors.Wrapf(err, "failed to remove resumed dump %s", f.Name())
+		}
+		return nil
+	}
+	if err := os.Rename(f.Name(), partial.Path); err != nil {
+		return errors.Wrapf(err, "failed to replace partial dump %s with %s", partial.Path, f.Name())
+	}
+	return nil
+}
+
+type watchOptions struct {
+	interval time.Duration
+	window   time.Duration
+	count    int
+}
+
+// watchExport exports the latest window every interval, until count exports are done or stopC receives a signal.
+// The current export is never interrupted, so every written dump is complete. Windows don't overlap.
+func watchExport(stopC <-chan os.Signal, opts watchOptions, now func() time.Time, export func(from, to time.Time) error) error {
+	var prevEnd time.Time
+	for i := 0; opts.count == 0 || i < opts.count; i++ {
+		cycleStart := now()
+		to := cycleStart.UTC()
+		from := to.Add(-opts.window)
+		if from.Before(prevEnd) {
+			from = prevEnd
+		}
+
+		log.Info().Msgf("Watch export %d: %s - %s", i+1, from.Format(time.RFC3339), to.Format(time.RFC3339))
+		if err := export(from, to); err != nil {
+			return err
+		}
+		prevEnd = to
+
+		if opts.count != 0 && i == opts.count-1 {
+			break
+		}
+
+		wait := opts.interval - now().Sub(cycleStart)
+		if wait < 0 {
+			log.Warn().Msgf("Export took longer than the watch interval %v", opts.interval)
+			wait = 0
+		}
+		select {
+		case sig := <-stopC:
+			log.Info().Msgf("Got %v, stopping watch", sig)
+			return nil
+		case <-time.After(wait):
+		}
+	}
+	return nil
+}
+
+// watchDumpPath returns the dump path of the watch export with the timestamp suffix, so every export writes a new file.
+func watchDumpPath(customPath string, ts time.Time, c dump.Compression) (string, error) {
+	filepath, err := getDumpFilepath(customPath, ts, c)
+	if err != nil {
+		return "", err
+	}
+	if filepath != customPath {
+		// auto filename already has the timestamp
+		return filepath, nil
+	}
+
+	ext := dumpExtension(filepath)
+	return fmt.Sprintf("%s-%d%s", strings.TrimSuffix(filepath, ext), ts.Unix(), ext), nil
+}
+
+func getDumpFilepath(customPath string, ts time.Time, c dump.Compression) (string, error) {
+	autoFilename := autoDumpFilename(ts, c)
 	if customPath == "" {
 		return autoFilename, nil
 	}
+	if _, key, ok := storage.ParseS3URL(customPath); ok {
+		// The key ending with `/` is a directory, as in local dump paths
+		if key == "" || strings.HasSuffix(key, "/") {
+			return strings.TrimSuffix(customPath, "/") + "/" + autoFilename, nil
+		}
+		return customPath, nil
+	}
 
 	customPathInfo, err := os.Stat(customPath)
 	if err != nil && !os.IsNotExist(err) {
@@ -513,3 +1418,27 @@ func getDumpFilepath(customPath string, ts time.Time) (string, error) {
 
 	return customPath, nil
 }
+
+// autoDumpFilename is the filename of the dump if the dump path is a directory or isn't specified.
+// The extension is of the compression, ex. `.tar.zst` for zstd.
+func autoDumpFilename(ts time.Time, c dump.Compression) string {
+	return fmt.Sprintf("pmm-dump-%v%s", ts.Unix(), c.Extension())
+}
+
+// dumpExtension returns the extension of the dump path, including the compression extension after `.tar`.
+func dumpExtension(dumpPath string) string {
+	for _, c := range []string{dump.CompressionGzip, dump.CompressionZstd} {
+		if ext := (dump.Compression{Algorithm: c}).Extension(); strings.HasSuffix(dumpPath, ext) {
+			return ext
+		}
+	}
+	return path.Ext(dumpPath)
+}
+
+// progressInterval returns the interval of export and import progress logs, 0 disables them.
+func progressInterval(noProgress bool) time.Duration {
+	if noProgress {
+		return 0
+	}
+	return transferer.DefaultProgressInterval
+}
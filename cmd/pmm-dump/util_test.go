@@ -0,0 +1,627 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/pkg/errors"
+
+	"pmm-dump/pkg/dump"
+	"pmm-dump/pkg/transferer"
+	"pmm-dump/pkg/victoriametrics"
+)
+
+func TestVersionJSON(t *testing.T) {
+	GitVersion, GitCommit, GitBranch, BuildDate = "v0.7.0", "abc123", "main", "2024-01-01"
+	defer func() {
+		GitVersion, GitCommit, GitBranch, BuildDate = "", "", "", ""
+	}()
+
+	data, err := versionJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fields map[string]string
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatalf("invalid json %s: %v", data, err)
+	}
+	for _, name := range []string{"version", "commit", "branch", "go_version", "build_date"} {
+		if fields[name] == "" {
+			t.Fatalf("field %s is empty in %s", name, data)
+		}
+	}
+}
+
+func TestResolveVMDataFormat(t *testing.T) {
+	tests := []struct {
+		name       string
+		format     string
+		nativeData bool
+		want       string
+		shouldErr  bool
+	}{
+		{name: "default", want: "json"},
+		{name: "native data", nativeData: true, want: "native"},
+		{name: "openmetrics", format: "openmetrics", want: "openmetrics"},
+		{name: "native format and native data", format: "native", nativeData: true, want: "native"},
+		{name: "conflict", format: "openmetrics", nativeData: true, shouldErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveVMDataFormat(tt.format, tt.nativeData)
+			if err != nil {
+				if !tt.shouldErr {
+					t.Fatal(err)
+				}
+				return
+			}
+			if tt.shouldErr {
+				t.Fatal("should be error")
+			}
+			if got != tt.want {
+				t.Fatalf("want %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestHandlePartialDump(t *testing.T) {
+	tests := []struct {
+		name string
+		keep bool
+	}{
+		{name: "remove"},
+		{name: "keep", keep: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file, err := os.Create(filepath.Join(t.TempDir(), "dump.tar.gz"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			handlePartialDump(file, tt.keep)
+
+			_, err = os.Stat(file.Name())
+			if tt.keep && err != nil {
+				t.Fatalf("partial dump should be kept: %v", err)
+			}
+			if !tt.keep && !os.IsNotExist(err) {
+				t.Fatalf("partial dump should be removed, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestClockSkew(t *testing.T) {
+	server := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		local time.Time
+		want  time.Duration
+	}{
+		{name: "same", local: server},
+		{name: "local ahead", local: server.Add(2 * time.Minute), want: 2 * time.Minute},
+		{name: "local behind", local: server.Add(-time.Hour), want: time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := clockSkew(tt.local, server); got != tt.want {
+				t.Fatalf("want %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestAskConfirmation(t *testing.T) {
+	tests := []struct {
+		answer string
+		want   bool
+	}{
+		{answer: "y\n", want: true},
+		{answer: "Yes\n", want: true},
+		{answer: "n\n"},
+		{answer: "\n"},
+		{answer: ""},
+	}
+	for _, tt := range tests {
+		t.Run(strings.TrimSpace(tt.answer), func(t *testing.T) {
+			var out bytes.Buffer
+			got, err := askConfirmation(strings.NewReader(tt.answer), &out, "Continue? [y/N]: ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("want %v, got %v", tt.want, got)
+			}
+			if out.String() != "Continue? [y/N]: " {
+				t.Fatalf("unexpected question: %q", out.String())
+			}
+		})
+	}
+}
+
+func TestWatchExport(t *testing.T) {
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+
+	type window struct{ from, to time.Time }
+	var windows []window
+	opts := watchOptions{
+		interval: time.Millisecond,
+		window:   time.Hour,
+		count:    3,
+	}
+	err := watchExport(make(chan os.Signal), opts, clock, func(from, to time.Time) error {
+		windows = append(windows, window{from, to})
+		now = now.Add(10 * time.Minute)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(windows) != opts.count {
+		t.Fatalf("want %d exports, got %d", opts.count, len(windows))
+	}
+	if !windows[0].from.Equal(windows[0].to.Add(-opts.window)) {
+		t.Fatalf("first export should cover the whole window, got %v", windows[0])
+	}
+	for i := 1; i < len(windows); i++ {
+		if windows[i].from.Before(windows[i-1].to) {
+			t.Fatalf("exports %d and %d overlap: %v, %v", i-1, i, windows[i-1], windows[i])
+		}
+	}
+
+	stopC := make(chan os.Signal, 1)
+	stopC <- os.Interrupt
+	count := 0
+	opts.count = 0
+	err = watchExport(stopC, opts, clock, func(time.Time, time.Time) error {
+		count++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("current export should be finished before stopping, got %d exports", count)
+	}
+}
+
+func TestWatchDumpPath(t *testing.T) {
+	ts := time.Unix(1700000000, 0)
+	dir := t.TempDir()
+	zstd := dump.Compression{Algorithm: dump.CompressionZstd}
+	tests := []struct {
+		path        string
+		compression dump.Compression
+		want        string
+	}{
+		{path: "", want: "pmm-dump-1700000000.tar.gz"},
+		{path: dir, want: filepath.Join(dir, "pmm-dump-1700000000.tar.gz")},
+		{path: "backup.tar.gz", want: "backup-1700000000.tar.gz"},
+		{path: "backup", want: "backup-1700000000"},
+		{path: "", compression: zstd, want: "pmm-dump-1700000000.tar.zst"},
+		{path: "backup.tar.zst", compression: zstd, want: "backup-1700000000.tar.zst"},
+		{path: "", compression: dump.Compression{Algorithm: dump.CompressionNone}, want: "pmm-dump-1700000000.tar"},
+		{path: "s3://bucket/dumps/", want: "s3://bucket/dumps/pmm-dump-1700000000.tar.gz"},
+		{path: "s3://bucket", want: "s3://bucket/pmm-dump-1700000000.tar.gz"},
+		{path: "s3://bucket/backup.tar.gz", want: "s3://bucket/backup-1700000000.tar.gz"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path+" "+tt.compression.String(), func(t *testing.T) {
+			got, err := watchDumpPath(tt.path, ts, tt.compression)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("want %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestLoadEnvFile(t *testing.T) {
+	envFile := filepath.Join(t.TempDir(), "pmm.env")
+	content := "PMM_DUMP_TEST_URL=http://file\nPMM_DUMP_TEST_USER=file-user\nPMM_DUMP_TEST_PASS=file-pass\n"
+	if err := os.WriteFile(envFile, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PMM_DUMP_TEST_USER", "env-user")
+
+	cli := kingpin.New("test", "")
+	_ = cli.Flag(envFileFlag, "").ExistingFile()
+	url := cli.Flag("url", "").Envar("PMM_DUMP_TEST_URL").String()
+	user := cli.Flag("user", "").Envar("PMM_DUMP_TEST_USER").String()
+	pass := cli.Flag("pass", "").Envar("PMM_DUMP_TEST_PASS").String()
+
+	args := []string{"--env-file", envFile, "--pass", "flag-pass"}
+	if err := loadEnvFile(cli, args); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Unsetenv("PMM_DUMP_TEST_URL")  //nolint:errcheck
+	defer os.Unsetenv("PMM_DUMP_TEST_PASS") //nolint:errcheck
+	if _, err := cli.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+
+	if *url != "http://file" {
+		t.Fatalf("want url from env file, got %s", *url)
+	}
+	if *user != "env-user" {
+		t.Fatalf("want user from environment, got %s", *user)
+	}
+	if *pass != "flag-pass" {
+		t.Fatalf("want password from flag, got %s", *pass)
+	}
+
+	if err := loadEnvFile(cli, []string{"--env-file", filepath.Join(t.TempDir(), "missing.env")}); err == nil {
+		t.Fatal("should be error")
+	}
+}
+
+func TestLoadCredentialsFile(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		perm      os.FileMode
+		want      string
+		shouldErr bool
+	}{
+		{
+			name:    "owner only",
+			content: "PMM_PASS=file-pass\n",
+			perm:    0o600,
+			want:    "file-pass",
+		},
+		{
+			name:      "world readable",
+			content:   "PMM_PASS=file-pass\n",
+			perm:      0o644,
+			shouldErr: true,
+		},
+		{
+			name:      "not a secret",
+			content:   "PMM_PASS=file-pass\nPMM_URL=http://file\n",
+			perm:      0o600,
+			shouldErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			credentialsFile := filepath.Join(t.TempDir(), "credentials.env")
+			if err := os.WriteFile(credentialsFile, []byte(tt.content), tt.perm); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chmod(credentialsFile, tt.perm); err != nil { // umask may restrict the permissions on write
+				t.Fatal(err)
+			}
+
+			cli := kingpin.New("test", "")
+			_ = cli.Flag(credentialsFileFlag, "").ExistingFile()
+			pass := cli.Flag("pass", "").Envar("PMM_PASS").String()
+
+			args := []string{"--credentials-file", credentialsFile}
+			err := loadCredentialsFile(cli, args)
+			defer os.Unsetenv("PMM_PASS") //nolint:errcheck
+			if err != nil {
+				if !tt.shouldErr {
+					t.Fatal(err)
+				}
+				return
+			}
+			if tt.shouldErr {
+				t.Fatal("should be error")
+			}
+			if _, err := cli.Parse(args); err != nil {
+				t.Fatal(err)
+			}
+			if *pass != tt.want {
+				t.Fatalf("want %s, got %s", tt.want, *pass)
+			}
+		})
+	}
+}
+
+func TestPrepareCheckpoint(t *testing.T) {
+	checkpointFile := filepath.Join(t.TempDir(), "checkpoint.json")
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+
+	cp, err := prepareCheckpoint(checkpointFile, start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp.Watermark != nil || cp.Complete() {
+		t.Fatalf("new checkpoint shouldn't have progress: %+v", cp)
+	}
+	if _, err := os.Stat(checkpointFile); err != nil {
+		t.Fatalf("checkpoint file should be created: %v", err)
+	}
+	if _, err := prepareCheckpoint(checkpointFile, start, end); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := prepareCheckpoint(checkpointFile, start, end.Add(time.Minute)); err == nil {
+		t.Fatal("should be error for another time range")
+	}
+}
+
+func TestPrepareExportCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	checkpointFile := filepath.Join(dir, "checkpoint.json")
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+
+	cp, err := prepareExportCheckpoint(checkpointFile, "", start, end, dump.DefaultCompression)
+	if err != nil || cp != nil {
+		t.Fatalf("want no checkpoint before export, got %+v, %v", cp, err)
+	}
+
+	file, err := createFile(filepath.Join(dir, "dump.tar.gz"), false, 0, dump.DefaultCompression, s3Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close() //nolint:errcheck
+	if _, err := newExportCheckpoint(checkpointFile, file, start, end, dump.DefaultCompression, nil, false); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		dumpPath    string
+		end         time.Time
+		compression dump.Compression
+		shouldErr   bool
+	}{
+		{name: "same export", end: end, compression: dump.DefaultCompression},
+		{name: "same dump path", dumpPath: filepath.Join(dir, "dump.tar.gz"), end: end, compression: dump.DefaultCompression},
+		{name: "dump directory", dumpPath: dir, end: end, compression: dump.DefaultCompression},
+		{name: "another dump path", dumpPath: filepath.Join(dir, "other.tar.gz"), end: end, compression: dump.DefaultCompression, shouldErr: true},
+		{name: "another time range", end: end.Add(time.Minute), compression: dump.DefaultCompression, shouldErr: true},
+		{name: "another compression", end: end, compression: dump.Compression{Algorithm: dump.CompressionZstd}, shouldErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cp, err := prepareExportCheckpoint(checkpointFile, tt.dumpPath, start, tt.end, tt.compression)
+			if tt.shouldErr {
+				if err == nil {
+					t.Fatal("should be error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cp == nil || cp.DumpPath != filepath.Join(dir, "dump.tar.gz") {
+				t.Fatalf("want checkpoint of the dump, got %+v", cp)
+			}
+		})
+	}
+}
+
+func TestCreateFileFreeSpace(t *testing.T) {
+	dumpPath := filepath.Join(t.TempDir(), "dump.tar.gz")
+	if _, err := createFile(dumpPath, false, 1<<62, dump.DefaultCompression, s3Options{}); err == nil {
+		t.Fatal("should be error for not enough free disk space")
+	}
+	if _, err := os.Stat(dumpPath); !os.IsNotExist(err) {
+		t.Fatalf("dump file shouldn't be created: %v", err)
+	}
+
+	file, err := createFile(dumpPath, false, 1, dump.DefaultCompression, s3Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExpandBoolFlagValues(t *testing.T) {
+	cli := kingpin.New("test", "")
+	_ = cli.Flag("dump-core", "").Default("true").Bool()
+	_ = cli.Flag("where", "").String()
+	cmd := cli.Command("export", "")
+	_ = cmd.Flag("dump-qan", "").Bool()
+
+	args := []string{"export", "--dump-core=false", "--dump-qan=true", "--where=a=false", "--dump-core=maybe", "--", "--dump-qan=false"}
+	want := []string{"export", "--no-dump-core", "--dump-qan", "--where=a=false", "--dump-core=maybe", "--", "--dump-qan=false"}
+	if got := expandBoolFlagValues(cli, args); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+}
+
+func TestPrintExportEstimate(t *testing.T) {
+	tests := []struct {
+		name      string
+		estimates []transferer.ExportEstimate
+		want      string
+	}{
+		{
+			name: "empty sample",
+			estimates: []transferer.ExportEstimate{
+				{Source: dump.VictoriaMetrics, Chunks: 1440, SampleSize: 2000, SampleUncompressedSize: 20000},
+				{Source: dump.ClickHouse, Chunks: 3, SampleEmpty: true},
+			},
+			want: "SOURCE  CHUNKS  SAMPLE SIZE  ESTIMATED SIZE  UNCOMPRESSED SIZE\n" +
+				"vm      1440    2.0 kB       2.9 MB          28.8 MB\n" +
+				"ch      3       empty        0 B             0 B\n" +
+				"total   1443                 2.9 MB          28.8 MB\n" +
+				"The first ch chunk has no data, the estimate of ch may be too low\n",
+		},
+		{
+			name: "no data",
+			estimates: []transferer.ExportEstimate{
+				{Source: dump.VictoriaMetrics, Chunks: 24, NoData: true},
+				{Source: dump.ClickHouse},
+			},
+			want: "SOURCE  CHUNKS  SAMPLE SIZE  ESTIMATED SIZE  UNCOMPRESSED SIZE\n" +
+				"vm      24      no data      0 B             0 B\n" +
+				"ch      0       -            0 B             0 B\n" +
+				"total   24                   0 B             0 B\n" +
+				"No vm data matches the filters in the export time range\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			printExportEstimate(&buf, tt.estimates)
+			if buf.String() != tt.want {
+				t.Fatalf("want:\n%s\ngot:\n%s", tt.want, buf.String())
+			}
+		})
+	}
+}
+
+func TestWhereWithInstances(t *testing.T) {
+	tests := []struct {
+		name      string
+		where     string
+		whereFile string
+		instances []string
+		want      string
+	}{
+		{
+			name: "no filters",
+		},
+		{
+			name:  "where",
+			where: "node_name='node'",
+			want:  "node_name='node'",
+		},
+		{
+			name:      "where file",
+			whereFile: "node_name='node'\n",
+			want:      "node_name='node'",
+		},
+		{
+			name:      "instances",
+			instances: []string{"mongo", "mysql"},
+			want:      "service_name='mongo' OR service_name='mysql'",
+		},
+		{
+			name:      "where and instances",
+			where:     "node_name='node'",
+			instances: []string{"mongo", "mysql"},
+			want:      "(node_name='node') AND (service_name='mongo' OR service_name='mysql')",
+		},
+		{
+			name:      "where file and instances",
+			whereFile: "node_name='node'\n",
+			instances: []string{"mongo"},
+			want:      "(node_name='node') AND (service_name='mongo')",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			where := tt.where
+			if tt.whereFile != "" {
+				path := filepath.Join(t.TempDir(), "where.sql")
+				if err := os.WriteFile(path, []byte(tt.whereFile), 0o600); err != nil {
+					t.Fatal(err)
+				}
+				var err error
+				where, err = readWhereFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := whereWithInstances(where, tt.instances); got != tt.want {
+				t.Fatalf("want %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestScrubDump(t *testing.T) {
+	vmChunk := gzipped(t, `{"metric":{"__name__":"up","node_name":"db-1"},"values":[1],"timestamps":[1000]}`)
+	meta, err := json.Marshal(dump.Meta{
+		VMMetricMetadataExported: true,
+		ChunkChecksums:           map[string]string{"vm/1-2.bin": "checksum", "ch/1-2-0.tsv": "checksum"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	w, err := dump.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []dump.File{
+		{Name: "vm/1-2.bin", Content: vmChunk},
+		{Name: "ch/1-2-0.tsv", Content: []byte("q1\t1\n")},
+		{Name: dump.VMMetricMetadataFilename, Content: []byte(`[{"metric":"up","type":"gauge","help":"Up"}]`)},
+		{Name: dump.MetaFilename, Content: meta},
+	} {
+		if err := w.AddFile(f.Name, f.Content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	redactor, err := victoriametrics.NewRedactor([]string{"node_name"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := scrubDump(&buf, &out, redactor, false); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := dump.NewReader(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close() //nolint:errcheck
+	var names []string
+	var scrubbedMeta dump.Meta
+	for {
+		header, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, header.Name)
+		if header.Name == dump.MetaFilename {
+			if err := json.NewDecoder(r).Decode(&scrubbedMeta); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if want := []string{"vm/1-2.bin", dump.MetaFilename}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("want files %v, got %v", want, names)
+	}
+	if scrubbedMeta.VMMetricMetadataExported {
+		t.Fatal("metric metadata should be marked as not exported")
+	}
+	if _, ok := scrubbedMeta.ChunkChecksums["vm/1-2.bin"]; !ok || len(scrubbedMeta.ChunkChecksums) != 1 {
+		t.Fatalf("want checksum of the core metrics chunk only, got %v", scrubbedMeta.ChunkChecksums)
+	}
+}
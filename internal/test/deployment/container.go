@@ -53,6 +53,9 @@ const (
 const (
 	execTimeout = time.Second * 180
 	getTimeout  = time.Second * 120
+
+	retryInitialDelay = time.Second
+	retryMaxDelay     = time.Second * 16
 )
 
 func (pmm *PMM) CreatePMMServer(ctx context.Context, dockerCli *client.Client, networkID string) error {
@@ -115,7 +118,7 @@ func (pmm *PMM) CreatePMMServer(ctx context.Context, dockerCli *client.Client, n
 	}
 	tCtx, cancel = context.WithTimeout(ctx, execTimeout)
 	defer cancel()
-	if err := util.RetryOnError(tCtx, func() error {
+	if err := util.RetryOnErrorWithBackoff(tCtx, retryInitialDelay, retryMaxDelay, func() error {
 		return victoriametrics.ExportTestRequest(gc, pmmConfig.VictoriaMetricsURL)
 	}); err != nil {
 		return errors.Wrap(err, "failed to check victoriametrics")
@@ -338,7 +338,7 @@ func (pmm *PMM) deploy(ctx context.Context) error {
 
 	tCtx, cancel = context.WithTimeout(ctx, execTimeout)
 	defer cancel()
-	if err := util.RetryOnError(tCtx, func() error {
+	if err := util.RetryOnErrorWithBackoff(tCtx, retryInitialDelay, retryMaxDelay, func() error {
 		return pmm.PingClickhouse(ctx)
 	}); err != nil {
 		return errors.Wrap(err, "failed to ping clickhouse")
@@ -18,13 +18,16 @@ package e2e
 
 import (
 	"context"
+	"encoding/json"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"golang.org/x/sync/errgroup"
 
 	"pmm-dump/internal/test/deployment"
 	"pmm-dump/internal/test/util"
+	"pmm-dump/pkg/dump"
 )
 
 func TestExportImport(t *testing.T) {
@@ -56,6 +59,18 @@ func TestExportImport(t *testing.T) {
 	}
 	checkDumpFiltering(t, filepath.Join(testDir, "filter-dump.tar.gz"), "pmm-client")
 
+	stdout, stderr, err = b.Run("show-meta", "-d", filepath.Join(testDir, "filter-dump.tar.gz"), "--filters")
+	if err != nil {
+		t.Fatal("failed to show filters", err, stdout, stderr)
+	}
+	var filters dump.Filters
+	if err := json.Unmarshal([]byte(stdout), &filters); err != nil {
+		t.Fatal("invalid filters", err, stdout)
+	}
+	if len(filters.VMSelectors) == 0 || !strings.Contains(filters.CHWhere, "pmm-client") || filters.Start.IsZero() {
+		t.Fatal("filters don't match `--instance`:", stdout)
+	}
+
 	args = []string{"-d", filepath.Join(testDir, "dump.tar.gz"), "--pmm-url", pmm.PMMURL(), "--dump-qan", "--click-house-url", pmm.ClickhouseURL()}
 
 	pmm.Log("Exporting data to", filepath.Join(testDir, "dump.tar.gz"))
@@ -66,7 +81,7 @@ func TestExportImport(t *testing.T) {
 
 	args = []string{"-d", filepath.Join(testDir, "dump.tar.gz"), "--pmm-url", newPMM.PMMURL(), "--dump-qan", "--click-house-url", newPMM.ClickhouseURL()}
 	pmm.Log("Importing data from", filepath.Join(testDir, "dump.tar.gz"))
-	stdout, stderr, err = b.Run(append([]string{"import"}, args...)...)
+	stdout, stderr, err = b.Run(append([]string{"import", "--yes"}, args...)...)
 	if err != nil {
 		t.Fatal("failed to import", err, stdout, stderr)
 	}
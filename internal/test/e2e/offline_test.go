@@ -0,0 +1,64 @@
+//go:build e2e
+
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package e2e
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"pmm-dump/internal/test/util"
+)
+
+// TestOfflineCommands checks that the commands working with dump files don't require PMM connection.
+func TestOfflineCommands(t *testing.T) {
+	t.Setenv("PMM_URL", "")
+	t.Setenv("PMM_HOST", "")
+
+	testDir := t.TempDir()
+	dumpPath := filepath.Join(testDir, "dump.tar.gz")
+	if err := generateFakeDump(dumpPath); err != nil {
+		t.Fatal(err)
+	}
+
+	var b util.Binary
+	tests := [][]string{
+		{"show-meta", "-d", dumpPath},
+		{"show-meta", "-d", dumpPath, "--no-prettify"},
+		{"cardinality", "-d", dumpPath},
+		{"scrub", "-d", dumpPath, "-o", filepath.Join(testDir, "scrubbed.tar.gz"), "--redact-label", "instance"},
+		{"version"},
+	}
+	for _, args := range tests {
+		t.Run(args[0], func(t *testing.T) {
+			stdout, stderr, err := b.Run(args...)
+			if err != nil {
+				t.Fatal(err, stdout, stderr)
+			}
+		})
+	}
+
+	t.Run("export requires pmm-url", func(t *testing.T) {
+		stdout, stderr, err := b.Run("export", "-d", filepath.Join(testDir, "export.tar.gz"))
+		if err == nil {
+			t.Fatal("export without pmm-url should fail", stdout, stderr)
+		}
+		if !strings.Contains(stderr, "--pmm-url") {
+			t.Fatal("expected error about `--pmm-url`, got", stderr)
+		}
+	})
+}
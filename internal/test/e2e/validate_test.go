@@ -90,6 +90,7 @@ func TestValidate(t *testing.T) {
 	pmm.Log("Importing data from", xDumpPath)
 	stdout, stderr, err = b.Run(
 		"import",
+		"--yes",
 		"-d", xDumpPath,
 		"--pmm-url", newPMM.PMMURL(),
 		"--dump-qan",
@@ -217,12 +218,7 @@ func chCompareChunks(t *testing.T, pmm *deployment.PMM, filename string, xDump,
 		}
 		recordsMap := make(map[string][]string)
 		for _, r := range records {
-			data, err := json.Marshal(r)
-			if err != nil {
-				t.Fatal(err)
-			}
-			hash := fmt.Sprintf("%x", sha256.Sum256(data))
-			recordsMap[hash] = r
+			recordsMap[tsv.RecordHash(r)] = r
 		}
 		return recordsMap
 	}
@@ -400,44 +396,23 @@ func (vm vmMetric) Hash() string {
 }
 
 func (vm vmMetric) MetricHash() string {
-	data, err := json.Marshal(vm.Metric)
-	if err != nil {
-		panic(err)
-	}
-	return fmt.Sprintf("%x", sha256.Sum256(data))
+	return victoriametrics.Metric(vm).MetricHash()
 }
 
 func (vm vmMetric) CompareTimestampValues(pmm *deployment.PMM, with vmMetric) int {
-	xMap := make(map[int64]float64)
-	for i, v := range vm.Timestamps {
-		xMap[v] = vm.Values[i]
-	}
-	yMap := make(map[int64]float64)
-	for i, v := range with.Timestamps {
-		yMap[v] = with.Values[i]
-	}
-
-	for timestamp, xValue := range xMap {
-		yValue, ok := yMap[timestamp]
-		if !ok {
-			pmm.Log(fmt.Sprintf("Value and timestamp not found for metric %s in second dump: wanted %v for %d", vm.MetricString(), xValue, timestamp))
-			continue
-		}
-		if xValue != yValue {
-			pmm.Log(fmt.Sprintf("Values for timestamp %d in metric %s are not the same: %v and %v", timestamp, vm.MetricString(), xValue, yValue))
-			continue
-		}
-		delete(xMap, timestamp)
-		delete(yMap, timestamp)
-	}
-
-	for timestamp, yValue := range yMap {
-		_, ok := xMap[timestamp]
-		if !ok {
-			pmm.Log(fmt.Sprintf("Value and timestamp not found for metric %s in first dump: wanted %v for %d", vm.MetricString(), yValue, timestamp))
-			continue
+	var xMissing, yMissing int
+	for _, d := range victoriametrics.Metric(vm).CompareTimestampValues(victoriametrics.Metric(with)) {
+		switch {
+		case d.Y == nil:
+			pmm.Log(fmt.Sprintf("Value and timestamp not found for metric %s in second dump: wanted %v for %d", vm.MetricString(), *d.X, d.Timestamp))
+			yMissing++
+		case d.X == nil:
+			pmm.Log(fmt.Sprintf("Value and timestamp not found for metric %s in first dump: wanted %v for %d", vm.MetricString(), *d.Y, d.Timestamp))
+			xMissing++
+		default:
+			pmm.Log(fmt.Sprintf("Values for timestamp %d in metric %s are not the same: %v and %v", d.Timestamp, vm.MetricString(), *d.X, *d.Y))
 		}
 	}
 
-	return int(math.Abs(float64(len(xMap) - len(yMap))))
+	return int(math.Abs(float64(xMissing - yMissing)))
 }
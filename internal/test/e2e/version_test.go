@@ -55,7 +55,7 @@ func TestPMMCompatibility(t *testing.T) {
 		}
 		if dumpPath != "" {
 			pmm.Log("Importing data from", dumpPath)
-			stdout, stderr, err := b.Run("import", "-d", dumpPath, "--pmm-url", pmm.PMMURL())
+			stdout, stderr, err := b.Run("import", "--yes", "-d", dumpPath, "--pmm-url", pmm.PMMURL())
 			if err != nil {
 				t.Fatal("failed to import", err, stdout, stderr)
 			}
@@ -70,7 +70,7 @@ func TestPMMCompatibility(t *testing.T) {
 		}
 
 		pmm.Log("Importing data from", dumpPath)
-		stdout, stderr, err = b.Run("import", "-d", dumpPath, "--pmm-url", pmm.PMMURL())
+		stdout, stderr, err = b.Run("import", "--yes", "-d", dumpPath, "--pmm-url", pmm.PMMURL())
 		if err != nil {
 			t.Fatal("failed to import", err, stdout, stderr)
 		}
@@ -0,0 +1,84 @@
+//go:build e2e
+
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package e2e
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"pmm-dump/internal/test/deployment"
+	"pmm-dump/internal/test/util"
+	"pmm-dump/pkg/transferer"
+)
+
+func TestWatch(t *testing.T) {
+	c := deployment.NewController(t)
+	pmm := c.NewPMM("watch", ".env.test")
+
+	ctx := context.Background()
+	if err := pmm.Deploy(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var b util.Binary
+	testDir := t.TempDir()
+
+	pmm.Log("Exporting 3 watch cycles to", testDir)
+	stdout, stderr, err := b.Run(
+		"export",
+		"--ignore-load",
+		"-d", testDir,
+		"--pmm-url", pmm.PMMURL(),
+		"--watch",
+		"--interval", "5s",
+		"--watch-count", "3",
+		"--chunk-time-range", "1m")
+	if err != nil {
+		t.Fatal("failed to export", err, stdout, stderr)
+	}
+
+	entries, err := os.ReadDir(testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 dumps, got %d", len(entries))
+	}
+	dumps := make([]string, 0, len(entries))
+	for _, e := range entries {
+		dumps = append(dumps, filepath.Join(testDir, e.Name()))
+	}
+	sort.Strings(dumps)
+
+	var prevEnd int64
+	for _, d := range dumps {
+		start, end, ok, err := transferer.ReadTimeRangeFromDump(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			t.Fatalf("dump %s has no core metrics chunks", d)
+		}
+		if start.Unix() < prevEnd {
+			t.Fatalf("dump %s overlaps with the previous dump", d)
+		}
+		prevEnd = end.Unix()
+	}
+}
@@ -37,3 +37,31 @@ func RetryOnError(ctx context.Context, f func() error) error {
 		}
 	}
 }
+
+// RetryOnErrorWithBackoff retries f until it succeeds, doubling the delay between attempts up to maxDelay.
+func RetryOnErrorWithBackoff(ctx context.Context, initialDelay, maxDelay time.Duration, f func() error) error {
+	delay := initialDelay
+	var err error
+	for {
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+			err = f()
+			if err == nil {
+				return nil
+			}
+			delay = nextBackoff(delay, maxDelay)
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Wrap(err, "timeout")
+		}
+	}
+}
+
+func nextBackoff(delay, maxDelay time.Duration) time.Duration {
+	delay *= 2
+	if delay > maxDelay {
+		return maxDelay
+	}
+	return delay
+}
@@ -0,0 +1,74 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestNextBackoff(t *testing.T) {
+	want := []time.Duration{
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+		time.Second,
+	}
+
+	var got []time.Duration
+	delay := 100 * time.Millisecond
+	for range want {
+		delay = nextBackoff(delay, time.Second)
+		got = append(got, delay)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+}
+
+func TestRetryOnErrorWithBackoff(t *testing.T) {
+	ctx := context.Background()
+
+	var attempts []time.Time
+	err := RetryOnErrorWithBackoff(ctx, 10*time.Millisecond, 40*time.Millisecond, func() error {
+		attempts = append(attempts, time.Now())
+		if len(attempts) < 4 {
+			return errors.New("not ready")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, minDelay := range []time.Duration{20 * time.Millisecond, 40 * time.Millisecond, 40 * time.Millisecond} {
+		if d := attempts[i+1].Sub(attempts[i]); d < minDelay {
+			t.Fatalf("attempt %d: want delay at least %v, got %v", i+2, minDelay, d)
+		}
+	}
+
+	tCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	err = RetryOnErrorWithBackoff(tCtx, 10*time.Millisecond, 20*time.Millisecond, func() error {
+		return errors.New("not ready")
+	})
+	if err == nil {
+		t.Fatal("should be error")
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clickhouse
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"pmm-dump/pkg/dump"
+)
+
+// Checkpoint is the progress of QAN export saved to a file, so a stopped export can be resumed from it.
+type Checkpoint struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// Watermark is the period_start before which all rows of the time range are written to dumps.
+	Watermark *time.Time `json:"watermark,omitempty"`
+	// Written are period_start ranges after the watermark which are written to dumps too, as chunks are written in any order.
+	Written []PeriodRange `json:"written,omitempty"`
+}
+
+// PeriodRange is the range of period_start of a chunk, the start is inclusive and the end is exclusive. Nil bounds are unbounded.
+type PeriodRange struct {
+	Start *time.Time `json:"start,omitempty"`
+	End   *time.Time `json:"end,omitempty"`
+}
+
+// Complete checks if all rows of the time range are written.
+func (c Checkpoint) Complete() bool {
+	return c.Watermark != nil && !c.Watermark.Before(c.End)
+}
+
+// conditions returns the conditions selecting rows which are not written yet.
+func (c Checkpoint) conditions() []string {
+	var conditions []string
+	if c.Watermark != nil {
+		conditions = append(conditions, fmt.Sprintf("period_start >= %d", c.Watermark.Unix()))
+	}
+	for _, r := range c.Written {
+		written := periodConditions(dump.ChunkMeta{PeriodStart: r.Start, PeriodEnd: r.End})
+		switch len(written) {
+		case 0:
+			conditions = append(conditions, "0")
+		case 1:
+			conditions = append(conditions, "NOT "+written[0])
+		default:
+			conditions = append(conditions, fmt.Sprintf("NOT (%s AND %s)", written[0], written[1]))
+		}
+	}
+	return conditions
+}
+
+// ReadCheckpoint reads the checkpoint file. It returns nil if the file doesn't exist.
+func ReadCheckpoint(filename string) (*Checkpoint, error) {
+	content, err := os.ReadFile(filename) //nolint:gosec
+	if os.IsNotExist(err) {
+		return nil, nil //nolint:nilnil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read checkpoint")
+	}
+	cp := new(Checkpoint)
+	if err := json.Unmarshal(content, cp); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal checkpoint")
+	}
+	return cp, nil
+}
+
+// WriteCheckpoint writes the checkpoint to a temporary file and renames it, so the file is never partially written.
+func WriteCheckpoint(filename string, cp Checkpoint) error {
+	content, err := json.Marshal(cp)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal checkpoint")
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return errors.Wrap(err, "failed to create checkpoint")
+	}
+	defer os.Remove(tmp.Name()) //nolint:errcheck
+	if _, err := tmp.Write(content); err != nil {
+		_ = tmp.Close()
+		return errors.Wrap(err, "failed to write checkpoint")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "failed to close checkpoint")
+	}
+	if err := os.Rename(tmp.Name(), filename); err != nil {
+		return errors.Wrap(err, "failed to rename checkpoint")
+	}
+	return nil
+}
+
+// Progress advances the checkpoint as chunks are written to the dump.
+type Progress struct {
+	mu      sync.Mutex
+	cp      Checkpoint
+	pending []dump.ChunkMeta
+}
+
+// NewProgress returns the progress of the planned chunks, which are not written yet.
+func NewProgress(cp Checkpoint, chunks []dump.ChunkMeta) *Progress {
+	pending := make([]dump.ChunkMeta, len(chunks))
+	copy(pending, chunks)
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].Index < pending[j].Index
+	})
+	return &Progress{cp: cp, pending: pending}
+}
+
+// Written marks the chunk as written and returns the updated checkpoint.
+// The watermark is moved to the start of the first chunk not written yet, the chunks written after it are kept in the checkpoint.
+func (p *Progress) Written(m dump.ChunkMeta) Checkpoint {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i, c := range p.pending {
+		if c.Index == m.Index {
+			p.pending = append(p.pending[:i], p.pending[i+1:]...)
+			break
+		}
+	}
+	if len(p.pending) == 0 {
+		end := p.cp.End
+		p.cp.Watermark = &end
+		p.cp.Written = nil
+		return p.checkpoint()
+	}
+
+	p.cp.Written = append(p.cp.Written, PeriodRange{Start: m.PeriodStart, End: m.PeriodEnd})
+	if first := p.pending[0]; first.PeriodStart != nil {
+		p.cp.Watermark = first.PeriodStart
+	}
+	written := p.cp.Written[:0]
+	for _, r := range p.cp.Written {
+		if p.cp.Watermark != nil && r.End != nil && !r.End.After(*p.cp.Watermark) {
+			continue
+		}
+		written = append(written, r)
+	}
+	p.cp.Written = written
+	return p.checkpoint()
+}
+
+// checkpoint returns a copy of the checkpoint, so it's not changed by the next writes.
+func (p *Progress) checkpoint() Checkpoint {
+	cp := p.cp
+	cp.Written = make([]PeriodRange, len(p.cp.Written))
+	copy(cp.Written, p.cp.Written)
+	return cp
+}
@@ -0,0 +1,164 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clickhouse
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"pmm-dump/pkg/dump"
+)
+
+func TestCheckpointConditions(t *testing.T) {
+	watermark := time.Unix(100, 0)
+	written := time.Unix(200, 0)
+	writtenEnd := time.Unix(300, 0)
+	last := time.Unix(400, 0)
+	cp := Checkpoint{
+		Watermark: &watermark,
+		Written:   []PeriodRange{{Start: &written, End: &writtenEnd}, {Start: &last}},
+	}
+	want := "period_start >= 100\n" +
+		"NOT (period_start >= 200 AND period_start < 300)\n" +
+		"NOT period_start >= 400"
+	if got := strings.Join(cp.conditions(), "\n"); got != want {
+		t.Fatalf("want conditions:\n%s\ngot:\n%s", want, got)
+	}
+}
+
+func TestCheckpointResume(t *testing.T) {
+	start := time.Unix(1700000000, 0).UTC()
+	end := start.Add(time.Hour)
+	period := func(minute int) time.Time {
+		return start.Add(time.Duration(minute)*time.Minute + time.Second)
+	}
+
+	// Rows of the table are identified by their period_start minute and index
+	type row struct {
+		minute, id int
+	}
+	var table []row
+	for minute := 1; minute <= 10; minute++ {
+		for id := 0; id < minute%3+1; id++ {
+			table = append(table, row{minute, id})
+		}
+	}
+	// notWritten selects rows the same way the checkpoint conditions do
+	notWritten := func(cp Checkpoint, p time.Time) bool {
+		if cp.Watermark != nil && p.Before(*cp.Watermark) {
+			return false
+		}
+		for _, r := range cp.Written {
+			if (r.Start == nil || !p.Before(*r.Start)) && (r.End == nil || p.Before(*r.End)) {
+				return false
+			}
+		}
+		return true
+	}
+	countPeriods := func(cp Checkpoint) []periodRows {
+		var periods []periodRows
+		for _, r := range table {
+			if !notWritten(cp, period(r.minute)) {
+				continue
+			}
+			if len(periods) > 0 && periods[len(periods)-1].period.Equal(period(r.minute)) {
+				periods[len(periods)-1].rows++
+				continue
+			}
+			periods = append(periods, periodRows{period: period(r.minute), rows: 1})
+		}
+		return periods
+	}
+	readChunk := func(cp Checkpoint, m dump.ChunkMeta) []row {
+		var rows []row
+		for _, r := range table {
+			p := period(r.minute)
+			if !p.After(*m.Start) || !p.Before(*m.End) || !notWritten(cp, p) ||
+				(m.PeriodStart != nil && p.Before(*m.PeriodStart)) || (m.PeriodEnd != nil && !p.Before(*m.PeriodEnd)) {
+				continue
+			}
+			rows = append(rows, r)
+		}
+		return rows
+	}
+
+	seen := make(map[row]int)
+	checkpointFile := filepath.Join(t.TempDir(), "checkpoint.json")
+	// export writes the chunks in the given order, a negative index stops the export
+	export := func(order []int) Checkpoint {
+		t.Helper()
+		cp, err := ReadCheckpoint(checkpointFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cp == nil {
+			cp = &Checkpoint{Start: start, End: end}
+		}
+		chunks, _, _ := splitPeriods(countPeriods(*cp), start, end, cp.Watermark, 4, 0)
+		if order == nil {
+			for i := len(chunks) - 1; i >= 0; i-- {
+				order = append(order, i)
+			}
+		}
+		progress := NewProgress(*cp, chunks)
+		for _, i := range order {
+			if i < 0 {
+				break
+			}
+			for _, r := range readChunk(*cp, chunks[i]) {
+				seen[r]++
+			}
+			if err := WriteCheckpoint(checkpointFile, progress.Written(chunks[i])); err != nil {
+				t.Fatal(err)
+			}
+		}
+		cp, err = ReadCheckpoint(checkpointFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return *cp
+	}
+
+	// The third chunk is written before the second one, and the export is stopped
+	cp := export([]int{0, 2, -1})
+	if cp.Complete() || cp.Watermark == nil || len(cp.Written) != 1 {
+		t.Fatalf("want watermark and one written chunk after it, got %+v", cp)
+	}
+
+	// Rows inserted while the export is stopped, into periods not written yet
+	table = append(table, row{5, 100}, row{10, 100}, row{11, 100})
+	cp = export([]int{1, -1})
+	if cp.Complete() {
+		t.Fatalf("export shouldn't be complete: %+v", cp)
+	}
+	cp = export(nil)
+	if !cp.Complete() {
+		t.Fatalf("export should be complete: %+v", cp)
+	}
+
+	for _, r := range table {
+		if seen[r] != 1 {
+			t.Fatalf("row %v is exported %d times", r, seen[r])
+		}
+	}
+	if len(seen) != len(table) {
+		t.Fatalf("want %d rows exported, got %d", len(table), len(seen))
+	}
+	if cp := export(nil); !cp.Complete() || len(cp.Written) != 0 {
+		t.Fatalf("complete export shouldn't change: %+v", cp)
+	}
+}
@@ -14,7 +14,45 @@
 
 package clickhouse
 
+import "time"
+
+const (
+	defaultInitRetries    = 3
+	defaultInitRetryDelay = time.Second
+)
+
 type Config struct {
 	ConnectionURL string
 	Where         string
+	MaxRows       int
+	AsyncInsert   bool
+	// Final reads metrics table with FINAL modifier, so rows not merged yet by ReplacingMergeTree are deduplicated.
+	Final bool
+	// Dedup inserts rows into a staging table first and copies only the rows missing in metrics table.
+	Dedup bool
+	// Checkpoint of the stopped export to resume: rows written to dumps already are not read again.
+	Checkpoint *Checkpoint
+	// Since skips rows with period_start up to it, which are exported to a prior dump already.
+	Since *time.Time
+	// SpoolDir is the directory read chunks are written to row by row, so a chunk isn't held in memory. Chunks are read into memory if it's empty.
+	SpoolDir string
+
+	// InitRetries is the number of retries of the initial transaction begin, 3 by default.
+	InitRetries int
+	// InitRetryDelay is the delay before the first retry, it's doubled on every next retry. 1s by default.
+	InitRetryDelay time.Duration
+}
+
+func (c Config) initRetries() int {
+	if c.InitRetries <= 0 {
+		return defaultInitRetries
+	}
+	return c.InitRetries
+}
+
+func (c Config) initRetryDelay() time.Duration {
+	if c.InitRetryDelay <= 0 {
+		return defaultInitRetryDelay
+	}
+	return c.InitRetryDelay
 }
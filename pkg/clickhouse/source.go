@@ -20,6 +20,10 @@ import (
 	"database/sql"
 	"fmt"
 	"io"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
 	"strings"
 	"time"
 
@@ -31,12 +35,26 @@ import (
 	"pmm-dump/pkg/dump"
 )
 
+// ErrMetricsTableNotFound is returned if ClickHouse has no QAN metrics table.
+var ErrMetricsTableNotFound = errors.New("QAN metrics table not found — is Query Analytics enabled on this PMM?")
+
+// chunkExtension and contentType describe chunks of QAN metrics rows.
+const (
+	chunkExtension = ".tsv"
+	contentType    = "text/tab-separated-values"
+)
+
+// unknownTableCode is the code of ClickHouse exception for missing tables.
+const unknownTableCode = 60
+
 type Source struct {
 	db   *sql.DB
 	cfg  Config
 	tx   *sql.Tx
 	ct   []*sql.ColumnType
 	stmt *sql.Stmt
+	// staging is the table rows are inserted into before they are merged into metrics table, if Dedup is enabled.
+	staging string
 }
 
 func NewSource(ctx context.Context, cfg Config) (*Source, error) {
@@ -55,32 +73,187 @@ func NewSource(ctx context.Context, cfg Config) (*Source, error) {
 		}
 		return nil, errors.Wrap(err, "ping")
 	}
-	tx, err := db.Begin()
-	if err != nil {
-		return nil, errors.Wrap(err, "begin")
+
+	if cfg.AsyncInsert {
+		db, err = enableAsyncInsert(db, cfg.ConnectionURL)
+		if err != nil {
+			return nil, errors.Wrap(err, "enable async insert")
+		}
 	}
 
-	ct, err := columnTypes(db)
+	tx, ct, err := beginWrites(db, cfg.initRetries(), cfg.initRetryDelay())
 	if err != nil {
-		return nil, errors.Wrap(err, "column types")
+		return nil, err
+	}
+
+	if cfg.Final {
+		engine, err := tableEngine(db, "metrics")
+		if err != nil {
+			_ = tx.Rollback()
+			return nil, errors.Wrap(err, "failed to get metrics table engine")
+		}
+		if !supportsFinal(engine) {
+			log.Warn().Msgf("QAN metrics table has %s engine, which doesn't merge rows: reading it without FINAL", engine)
+			cfg.Final = false
+		}
+	}
+
+	table := "metrics"
+	var staging string
+	if cfg.Dedup {
+		staging, err = createStagingTable(db, time.Now())
+		if err != nil {
+			_ = tx.Rollback()
+			return nil, errors.Wrap(err, "create staging table")
+		}
+		table = staging
 	}
 
-	stmt, err := prepareInsertStatement(tx, len(ct))
+	stmt, err := prepareInsertStatement(tx, table, len(ct))
 	if err != nil {
 		return nil, errors.Wrap(err, "prepare insert statement")
 	}
 	return &Source{
-		cfg:  cfg,
-		db:   db,
-		tx:   tx,
-		ct:   ct,
-		stmt: stmt,
+		cfg:     cfg,
+		db:      db,
+		tx:      tx,
+		ct:      ct,
+		stmt:    stmt,
+		staging: staging,
 	}, nil
 }
 
+// createStagingTable creates the table with the structure of metrics table for the rows of the import.
+func createStagingTable(db *sql.DB, now time.Time) (string, error) {
+	table := fmt.Sprintf("pmm_dump_import_%d", now.UnixNano())
+	if _, err := db.Exec(fmt.Sprintf("CREATE TABLE %s AS metrics ENGINE = MergeTree ORDER BY tuple()", table)); err != nil {
+		return "", err
+	}
+	return table, nil
+}
+
+// mergeStagingTable inserts rows of the staging table missing in metrics table and drops the staging table.
+// Rows are compared by the hash of all columns within the time range of the staged rows.
+func mergeStagingTable(db *sql.DB, staging string) error {
+	defer func() {
+		if _, err := db.Exec("DROP TABLE IF EXISTS " + staging); err != nil {
+			log.Warn().Err(err).Msgf("Failed to drop staging table %s", staging)
+		}
+	}()
+
+	query := fmt.Sprintf("INSERT INTO metrics SELECT * FROM %[1]s WHERE cityHash64(*) NOT IN ("+
+		"SELECT cityHash64(*) FROM metrics WHERE period_start >= (SELECT min(period_start) FROM %[1]s) "+
+		"AND period_start <= (SELECT max(period_start) FROM %[1]s))", staging)
+	if _, err := db.Exec(query); err != nil {
+		return errors.Wrap(err, "failed to insert new rows from staging table")
+	}
+	return nil
+}
+
+// beginWrites begins the transaction and gets columns of metrics table, retrying while ClickHouse is not ready.
+func beginWrites(db *sql.DB, retries int, delay time.Duration) (*sql.Tx, []*sql.ColumnType, error) {
+	var tx *sql.Tx
+	var ct []*sql.ColumnType
+	var err error
+	for attempt := 0; ; attempt++ {
+		tx, ct, err = tryBeginWrites(db)
+		if err == nil || attempt == retries || errors.Is(err, ErrMetricsTableNotFound) {
+			return tx, ct, err
+		}
+		log.Warn().Err(err).Msgf("ClickHouse is not ready, retrying in %v", delay)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+func tryBeginWrites(db *sql.DB) (*sql.Tx, []*sql.ColumnType, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "begin")
+	}
+
+	ct, err := columnTypes(db)
+	if err != nil {
+		_ = tx.Rollback()
+		if errors.Is(err, ErrMetricsTableNotFound) {
+			return nil, nil, err
+		}
+		return nil, nil, errors.Wrap(err, "column types")
+	}
+	return tx, ct, nil
+}
+
+// tableEngine returns the engine of the table in the current database.
+func tableEngine(db *sql.DB, table string) (string, error) {
+	var engine string
+	err := db.QueryRow("SELECT engine FROM system.tables WHERE database = currentDatabase() AND name = ?", table).Scan(&engine)
+	return engine, err
+}
+
+// supportsFinal checks if FINAL modifier merges rows of the table engine, ex. ReplacingMergeTree or ReplicatedSummingMergeTree.
+func supportsFinal(engine string) bool {
+	engine = strings.TrimPrefix(engine, "Replicated")
+	return engine != "MergeTree" && strings.HasSuffix(engine, "MergeTree")
+}
+
+// fromMetrics returns the metrics table for reads, with FINAL modifier if it's enabled.
+func (s Source) fromMetrics() string {
+	if s.cfg.Final {
+		return "metrics FINAL"
+	}
+	return "metrics"
+}
+
+// minAsyncInsertVersion is the first ClickHouse version with async_insert setting.
+var minAsyncInsertVersion = [2]int{21, 11}
+
+// enableAsyncInsert reopens db with async inserts if they are supported by the server.
+func enableAsyncInsert(db *sql.DB, connectionURL string) (*sql.DB, error) {
+	var version string
+	if err := db.QueryRow("SELECT version()").Scan(&version); err != nil {
+		return nil, errors.Wrap(err, "failed to get ClickHouse version")
+	}
+	if !versionAtLeast(version, minAsyncInsertVersion) {
+		log.Warn().Msgf("ClickHouse %s doesn't support async inserts, using regular inserts", version)
+		return db, nil
+	}
+
+	u, err := url.Parse(connectionURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse connection url")
+	}
+	q := u.Query()
+	q.Set("async_insert", "1")
+	q.Set("wait_for_async_insert", "1")
+	u.RawQuery = q.Encode()
+
+	if err := db.Close(); err != nil {
+		return nil, errors.Wrap(err, "close")
+	}
+	db, err = sql.Open("clickhouse", u.String())
+	if err != nil {
+		return nil, errors.Wrap(err, "sql open")
+	}
+	log.Debug().Msg("Enabled ClickHouse async inserts")
+	return db, nil
+}
+
+func versionAtLeast(version string, minVersion [2]int) bool {
+	var major, minor int
+	if _, err := fmt.Sscanf(version, "%d.%d", &major, &minor); err != nil {
+		return false
+	}
+	return major > minVersion[0] || (major == minVersion[0] && minor >= minVersion[1])
+}
+
+// columnTypes returns the column types of the metrics table. The query reads no rows,
+// so the types are discovered on an empty table too.
 func columnTypes(db *sql.DB) ([]*sql.ColumnType, error) {
-	rows, err := db.Query("SELECT * FROM metrics LIMIT 1")
+	rows, err := db.Query("SELECT * FROM metrics WHERE 0")
 	if err != nil {
+		if isUnknownTable(err) {
+			return nil, ErrMetricsTableNotFound
+		}
 		return nil, err
 	}
 	defer rows.Close() //nolint:errcheck
@@ -90,55 +263,110 @@ func columnTypes(db *sql.DB) ([]*sql.ColumnType, error) {
 	return rows.ColumnTypes()
 }
 
+// isUnknownTable checks if err is the ClickHouse exception about the missing table.
+func isUnknownTable(err error) bool {
+	var exception *clickhouse.Exception
+	if errors.As(err, &exception) {
+		return exception.Code == unknownTableCode
+	}
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "unknown table") || (strings.Contains(msg, "table") && strings.Contains(msg, "doesn't exist"))
+}
+
+// ContentType returns the content type of chunks: rows in TSV format.
+func (s Source) ContentType() string {
+	return contentType
+}
+
 func (s Source) Type() dump.SourceType {
 	return dump.ClickHouse
 }
 
-func (s Source) ReadChunk(m dump.ChunkMeta) (*dump.Chunk, error) {
-	offset := m.Index * m.RowsLen
-	limit := m.RowsLen
-	query := "SELECT * FROM metrics"
-	query += " " + prepareWhereClause(s.cfg.Where, m.Start, m.End)
-	query += fmt.Sprintf(" ORDER BY period_start, queryid LIMIT %d OFFSET %d", limit, offset)
-	rows, err := s.db.Query(query)
+func (s Source) ReadChunk(ctx context.Context, m dump.ChunkMeta) (*dump.Chunk, error) {
+	query := "SELECT * FROM " + s.fromMetrics()
+	query += " " + prepareWhereClause(s.cfg.Where, m.Start, m.End, append(periodConditions(m), s.exportConditions()...)...)
+	query += " ORDER BY period_start, queryid"
+	if s.cfg.MaxRows > 0 {
+		// Rows inserted during export shouldn't exceed the limit
+		query += fmt.Sprintf(" LIMIT %d", m.RowsLen)
+	}
+	rows, err := s.db.QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close() //nolint:errcheck
 
+	c := &dump.Chunk{
+		ChunkMeta: m,
+		Filename:  fmt.Sprintf("%s-%d%s", m.String(), m.Index, chunkExtension),
+	}
+	if s.cfg.SpoolDir != "" {
+		if err := spoolRows(rows, c, s.cfg.SpoolDir); err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+
+	var buf bytes.Buffer
+	if err := writeRows(rows, &buf); err != nil {
+		return nil, err
+	}
+	c.Content = buf.Bytes()
+	return c, nil
+}
+
+// spoolRows writes the rows to a temporary file in dir, which becomes the content of the chunk.
+// The file is removed right away, so it's freed when the chunk is closed or pmm-dump exits.
+func spoolRows(rows *sql.Rows, c *dump.Chunk, dir string) error {
+	f, err := os.CreateTemp(dir, "pmm-dump-qan-*"+chunkExtension)
+	if err != nil {
+		return errors.Wrap(err, "failed to create spool file")
+	}
+	if err := os.Remove(f.Name()); err != nil {
+		_ = f.Close()
+		return errors.Wrap(err, "failed to remove spool file")
+	}
+
+	if err := writeRows(rows, f); err != nil {
+		_ = f.Close()
+		return err
+	}
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		_ = f.Close()
+		return errors.Wrap(err, "failed to get spool file size")
+	}
+	c.File = f
+	c.FileSize = size
+	return nil
+}
+
+// writeRows writes the rows to w in TSV format one by one, the TSV writer buffers only a few rows.
+func writeRows(rows *sql.Rows, w io.Writer) error {
 	columns, err := rows.Columns()
 	if err != nil {
-		return nil, err
+		return err
 	}
 	values := make([]interface{}, len(columns))
 	for i := range columns {
 		var ei interface{}
 		values[i] = &ei
 	}
-	var buf bytes.Buffer
-	writer := tsv.NewWriter(&buf)
+	writer := tsv.NewWriter(w)
 	for rows.Next() {
 		if err := rows.Scan(values...); err != nil {
-			return nil, err
+			return err
 		}
 		valuesStr := toStringSlice(values)
 		if err := writer.Write(valuesStr); err != nil {
-			return nil, err
+			return err
 		}
 	}
 	if err := rows.Err(); err != nil {
-		return nil, err
+		return err
 	}
 	writer.Flush()
-	if err = writer.Error(); err != nil {
-		return nil, err
-	}
-
-	return &dump.Chunk{
-		ChunkMeta: m,
-		Content:   buf.Bytes(),
-		Filename:  fmt.Sprintf("%d.tsv", m.Index),
-	}, err
+	return writer.Error()
 }
 
 func toStringSlice(iSlice []interface{}) []string {
@@ -149,13 +377,62 @@ func toStringSlice(iSlice []interface{}) []string {
 			values = append(values, "")
 			continue
 		}
-		values = append(values, fmt.Sprintf("%v", *value))
+		values = append(values, tsv.FormatValue(*value))
 	}
 	return values
 }
 
 func (s Source) WriteChunk(_ string, r io.Reader) error {
-	reader := tsv.NewReader(r, s.ColumnTypes())
+	return insertRows(s.stmt, s.ColumnTypes(), r)
+}
+
+// ChunkWriter writes chunks to ClickHouse in its own transaction. Rows are sent to ClickHouse only on commit,
+// so the rolled back writer leaves no rows.
+type ChunkWriter struct {
+	tx   *sql.Tx
+	ct   []*sql.ColumnType
+	stmt *sql.Stmt
+}
+
+// NewChunkWriter begins the transaction of the import worker. Rows are inserted into the same table
+// as by WriteChunk: the staging table if Dedup is enabled.
+func (s Source) NewChunkWriter() (dump.ChunkWriter, error) { //nolint:ireturn,nolintlint
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, errors.Wrap(err, "begin")
+	}
+	table := "metrics"
+	if s.staging != "" {
+		table = s.staging
+	}
+	stmt, err := prepareInsertStatement(tx, table, len(s.ct))
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, errors.Wrap(err, "prepare insert statement")
+	}
+	return &ChunkWriter{tx: tx, ct: s.ct, stmt: stmt}, nil
+}
+
+func (w *ChunkWriter) WriteChunk(_ string, r io.Reader) error {
+	return insertRows(w.stmt, w.ct, r)
+}
+
+func (w *ChunkWriter) Commit() error {
+	if err := w.stmt.Close(); err != nil {
+		_ = w.tx.Rollback()
+		return err
+	}
+	return w.tx.Commit()
+}
+
+func (w *ChunkWriter) Rollback() error {
+	_ = w.stmt.Close()
+	return w.tx.Rollback()
+}
+
+// insertRows inserts rows of the TSV chunk content with the prepared statement.
+func insertRows(stmt *sql.Stmt, ct []*sql.ColumnType, r io.Reader) error {
+	reader := tsv.NewReader(r, ct)
 
 	for {
 		records, err := reader.Read()
@@ -165,7 +442,12 @@ func (s Source) WriteChunk(_ string, r io.Reader) error {
 			}
 			return err
 		}
-		_, err = s.stmt.Exec(records...)
+		for i, v := range records {
+			if isNil(v) {
+				records[i] = nil
+			}
+		}
+		_, err = stmt.Exec(records...)
 		if err != nil {
 			return err
 		}
@@ -174,13 +456,39 @@ func (s Source) WriteChunk(_ string, r io.Reader) error {
 	return nil
 }
 
-func prepareInsertStatement(tx *sql.Tx, columnsCount int) (*sql.Stmt, error) {
+// CountMetrics counts rows in the TSV chunk content.
+func (s Source) CountMetrics(content io.Reader) (int, error) {
+	reader := tsv.NewReader(content, s.ColumnTypes())
+
+	count := 0
+	for {
+		if _, err := reader.Reader.Read(); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return 0, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// isNil checks if v is nil or a typed nil pointer, which is used for NULL values of nullable columns.
+func isNil(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	return rv.Kind() == reflect.Ptr && rv.IsNil()
+}
+
+func prepareInsertStatement(tx *sql.Tx, table string, columnsCount int) (*sql.Stmt, error) {
 	var query strings.Builder
 
-	queryStart := "INSERT INTO metrics VALUES ("
+	queryStart := "INSERT INTO " + table + " VALUES ("
 
 	query.Grow(len(queryStart) + columnsCount*2)
-	query.WriteString("INSERT INTO metrics VALUES (")
+	query.WriteString(queryStart)
 	for i := 0; i < columnsCount-1; i++ {
 		query.WriteString("?,")
 	}
@@ -192,13 +500,52 @@ func (s Source) FinalizeWrites() error {
 	if err := s.stmt.Close(); err != nil {
 		return err
 	}
-	return s.tx.Commit()
+	if err := s.tx.Commit(); err != nil {
+		return err
+	}
+	if s.staging != "" {
+		return mergeStagingTable(s.db, s.staging)
+	}
+	return nil
+}
+
+// QuoteString returns the single-quoted SQL string literal with escaped quotes and backslashes.
+func QuoteString(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+// Placeholders of the WHERE statement, which are replaced with the export start and end in unix seconds.
+const (
+	WhereStartPlaceholder = "{{start}}"
+	WhereEndPlaceholder   = "{{end}}"
+)
+
+// HasWherePlaceholders checks if the WHERE statement references the export time range.
+func HasWherePlaceholders(whereCondition string) bool {
+	return strings.Contains(whereCondition, WhereStartPlaceholder) || strings.Contains(whereCondition, WhereEndPlaceholder)
+}
+
+// ExpandWhereTemplate replaces placeholders of the WHERE statement with the unix time of start and end.
+// Placeholders of missing bounds are kept as is.
+func ExpandWhereTemplate(whereCondition string, start, end *time.Time) string {
+	var oldnew []string
+	if start != nil {
+		oldnew = append(oldnew, WhereStartPlaceholder, strconv.FormatInt(start.Unix(), 10))
+	}
+	if end != nil {
+		oldnew = append(oldnew, WhereEndPlaceholder, strconv.FormatInt(end.Unix(), 10))
+	}
+	if len(oldnew) == 0 {
+		return whereCondition
+	}
+	return strings.NewReplacer(oldnew...).Replace(whereCondition)
 }
 
-func prepareWhereClause(whereCondition string, start, end *time.Time) string {
+// prepareWhereClause returns the WHERE clause with the statement, the time range and the additional conditions.
+func prepareWhereClause(whereCondition string, start, end *time.Time, conditions ...string) string {
 	var where []string
 	if whereCondition != "" {
-		where = append(where, fmt.Sprintf("(%s)", whereCondition))
+		where = append(where, fmt.Sprintf("(%s)", ExpandWhereTemplate(whereCondition, start, end)))
 	}
 	if start != nil {
 		where = append(where, fmt.Sprintf("period_start > %d", start.Unix()))
@@ -206,6 +553,7 @@ func prepareWhereClause(whereCondition string, start, end *time.Time) string {
 	if end != nil {
 		where = append(where, fmt.Sprintf("period_start < %d", end.Unix()))
 	}
+	where = append(where, conditions...)
 
 	query := ""
 	for i := range where {
@@ -221,10 +569,7 @@ func prepareWhereClause(whereCondition string, start, end *time.Time) string {
 
 func (s Source) Count(where string, startTime, endTime *time.Time) (int, error) {
 	var count int
-	query := "SELECT COUNT(*) FROM metrics"
-	if where != "" {
-		query += " " + prepareWhereClause(where, startTime, endTime)
-	}
+	query := "SELECT COUNT(*) FROM " + s.fromMetrics() + " " + prepareWhereClause(where, startTime, endTime)
 	row := s.db.QueryRow(query)
 	if err := row.Scan(&count); err != nil {
 		return 0, err
@@ -232,42 +577,170 @@ func (s Source) Count(where string, startTime, endTime *time.Time) (int, error)
 	return count, nil
 }
 
+// HasMetrics checks if ClickHouse has any QAN rows within the time range, which match the WHERE statement
+// and weren't exported before.
+func (s Source) HasMetrics(start, end time.Time) (bool, error) {
+	var exists int
+	query := "SELECT 1 FROM metrics " + prepareWhereClause(s.cfg.Where, &start, &end, s.exportConditions()...) + " LIMIT 1"
+	err := s.db.QueryRow(query).Scan(&exists)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 func (s Source) ColumnTypes() []*sql.ColumnType {
 	return s.ct
 }
 
-func (s Source) SplitIntoChunks(startTime, endTime time.Time, chunkRowsLen int) ([]dump.ChunkMeta, error) {
-	if chunkRowsLen <= 0 {
-		return nil, errors.Errorf("invalid chunk rows len: %v", chunkRowsLen)
+// periodConditions returns conditions on period_start of the chunk rows.
+func periodConditions(m dump.ChunkMeta) []string {
+	var conditions []string
+	if m.PeriodStart != nil {
+		conditions = append(conditions, fmt.Sprintf("period_start >= %d", m.PeriodStart.Unix()))
+	}
+	if m.PeriodEnd != nil {
+		conditions = append(conditions, fmt.Sprintf("period_start < %d", m.PeriodEnd.Unix()))
 	}
+	return conditions
+}
 
-	totalRows, err := s.Count(s.cfg.Where, &startTime, &endTime)
+// exportConditions returns the conditions skipping rows exported before: to a prior dump or before the checkpoint.
+func (s Source) exportConditions() []string {
+	conditions := s.sinceConditions()
+	if s.cfg.Checkpoint != nil {
+		conditions = append(conditions, s.cfg.Checkpoint.conditions()...)
+	}
+	return conditions
+}
+
+// sinceConditions returns the condition skipping rows exported to the prior dump.
+func (s Source) sinceConditions() []string {
+	if s.cfg.Since == nil {
+		return nil
+	}
+	return []string{fmt.Sprintf("period_start > %d", s.cfg.Since.Unix())}
+}
+
+// MaxPeriodStart returns the max period_start of rows within the time range, or nil if there are no rows.
+// Rows written before the checkpoint are taken into account, as they are exported to the same time range.
+func (s Source) MaxPeriodStart(startTime, endTime time.Time) (*time.Time, error) {
+	query := "SELECT toUnixTimestamp(max(period_start)), COUNT(*) FROM " + s.fromMetrics() + " " +
+		prepareWhereClause(s.cfg.Where, &startTime, &endTime, s.sinceConditions()...)
+	var period int64
+	var count int
+	if err := s.db.QueryRow(query).Scan(&period, &count); err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, nil //nolint:nilnil
+	}
+	maxPeriod := time.Unix(period, 0).UTC()
+	return &maxPeriod, nil
+}
+
+// periodRows is the number of rows with the same period_start.
+type periodRows struct {
+	period time.Time
+	rows   int
+}
+
+// countPeriodRows counts rows of every period_start within the time range.
+func (s Source) countPeriodRows(startTime, endTime time.Time) ([]periodRows, error) {
+	query := "SELECT toUnixTimestamp(period_start) AS period, COUNT(*) FROM " + s.fromMetrics() + " " +
+		prepareWhereClause(s.cfg.Where, &startTime, &endTime, s.exportConditions()...) + " GROUP BY period ORDER BY period"
+	rows, err := s.db.Query(query)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to get amount of ClickHouse records")
-	}
-
-	rowsCounter := totalRows
-	chunksLen := rowsCounter/chunkRowsLen + 1
-	chunks := make([]dump.ChunkMeta, 0, chunksLen)
-	i := 0
-	for rowsCounter > 0 {
-		newChunk := dump.ChunkMeta{
-			Source:  dump.ClickHouse,
-			RowsLen: chunkRowsLen,
-			Index:   i,
-			Start:   &startTime,
-			End:     &endTime,
+		return nil, err
+	}
+	defer rows.Close() //nolint:errcheck
+
+	var periods []periodRows
+	for rows.Next() {
+		var period int64
+		var count int
+		if err := rows.Scan(&period, &count); err != nil {
+			return nil, err
 		}
-		chunks = append(chunks, newChunk)
-		rowsCounter -= chunkRowsLen
-		i++
+		periods = append(periods, periodRows{period: time.Unix(period, 0).UTC(), rows: count})
+	}
+	return periods, rows.Err()
+}
+
+// SplitIntoChunks splits rows of the time range into chunks of about chunkRowsLen rows.
+// Chunks are bounded by period_start instead of row offsets, so rows inserted during export can't shift
+// to another chunk and be missed or exported twice. Rows of the same period_start are never split between chunks,
+// so a chunk may have more than chunkRowsLen rows. It reports if the rows are capped to the max rows of the config.
+func (s Source) SplitIntoChunks(startTime, endTime time.Time, chunkRowsLen int) ([]dump.ChunkMeta, bool, error) {
+	if chunkRowsLen <= 0 {
+		return nil, false, errors.Errorf("invalid chunk rows len: %v", chunkRowsLen)
 	}
 
+	periods, err := s.countPeriodRows(startTime, endTime)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "failed to get amount of ClickHouse records")
+	}
+
+	var watermark *time.Time
+	if s.cfg.Checkpoint != nil {
+		watermark = s.cfg.Checkpoint.Watermark
+	}
+	chunks, totalRows, capped := splitPeriods(periods, startTime, endTime, watermark, chunkRowsLen, s.cfg.MaxRows)
+
 	log.Debug().
 		Int("rows", totalRows).
 		Int("chunk_size", chunkRowsLen).
 		Int("chunks", len(chunks)).
 		Msg("Split Click House rows into chunks")
 
-	return chunks, nil
+	return chunks, capped, nil
+}
+
+// splitPeriods groups sorted periods into chunks. Every chunk starts at the period_start of its first row
+// and ends at the start of the next chunk. The first chunk starts at the watermark, if it's set, and the last chunk
+// is bounded by the time range only.
+// The last chunk is cut if there are more than maxRows rows. It returns the chunks, the total rows count
+// and if the rows are capped.
+func splitPeriods(periods []periodRows, startTime, endTime time.Time, watermark *time.Time, chunkRowsLen, maxRows int) ([]dump.ChunkMeta, int, bool) {
+	total := 0
+	for _, p := range periods {
+		total += p.rows
+	}
+	capped := maxRows > 0 && total > maxRows
+	if capped {
+		log.Warn().Msgf("QAN export is capped to %d of %d rows", maxRows, total)
+		total = maxRows
+	}
+
+	var chunks []dump.ChunkMeta
+	rows := 0
+	for i := 0; i < len(periods) && rows < total; {
+		chunk := dump.ChunkMeta{
+			Source:     dump.ClickHouse,
+			RowsOffset: rows,
+			Index:      len(chunks),
+			Start:      &startTime,
+			End:        &endTime,
+		}
+		if len(chunks) > 0 {
+			chunk.PeriodStart = &periods[i].period
+		} else {
+			chunk.PeriodStart = watermark
+		}
+		for ; i < len(periods) && chunk.RowsLen < chunkRowsLen && rows+chunk.RowsLen < total; i++ {
+			chunk.RowsLen += periods[i].rows
+		}
+		if rows+chunk.RowsLen > total {
+			chunk.RowsLen = total - rows
+		}
+		if i < len(periods) && rows+chunk.RowsLen < total {
+			chunk.PeriodEnd = &periods[i].period
+		}
+		rows += chunk.RowsLen
+		chunks = append(chunks, chunk)
+	}
+	return chunks, total, capped
 }
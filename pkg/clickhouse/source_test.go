@@ -0,0 +1,745 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clickhouse
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/pkg/errors"
+
+	"pmm-dump/pkg/dump"
+)
+
+func TestQuoteString(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		want string
+	}{
+		{
+			name: "plain",
+			s:    "mongo",
+			want: `'mongo'`,
+		},
+		{
+			name: "single quotes",
+			s:    `mongo' OR '1'='1`,
+			want: `'mongo\' OR \'1\'=\'1'`,
+		},
+		{
+			name: "backslashes",
+			s:    `mongo\'`,
+			want: `'mongo\\\''`,
+		},
+		{
+			name: "double quotes and braces",
+			s:    `{"mongo"}`,
+			want: `'{"mongo"}'`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := QuoteString(tt.s); got != tt.want {
+				t.Fatalf("want %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestPrepareWhereClauseQuoted(t *testing.T) {
+	where := prepareWhereClause("service_name="+QuoteString(`it's`), nil, nil)
+	if want := `WHERE (service_name='it\'s')`; where != want {
+		t.Fatalf("want %s, got %s", want, where)
+	}
+}
+
+func TestPrepareWhereClauseTemplate(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	end := time.Unix(1700003600, 0)
+	tests := []struct {
+		name       string
+		where      string
+		start, end *time.Time
+		want       string
+	}{
+		{
+			name:  "start and end",
+			where: "period_start >= {{start}} AND period_start < {{end}} - 60",
+			start: &start,
+			end:   &end,
+			want:  "WHERE (period_start >= 1700000000 AND period_start < 1700003600 - 60) AND period_start > 1700000000 AND period_start < 1700003600",
+		},
+		{
+			name:  "repeated placeholder",
+			where: "{{end}} - period_start < 600 OR period_start = {{end}}",
+			end:   &end,
+			want:  "WHERE (1700003600 - period_start < 600 OR period_start = 1700003600) AND period_start < 1700003600",
+		},
+		{
+			name:  "no time range",
+			where: "period_start > {{start}}",
+			want:  "WHERE (period_start > {{start}})",
+		},
+		{
+			name:  "no placeholders",
+			where: "service_name='mongo'",
+			start: &start,
+			want:  "WHERE (service_name='mongo') AND period_start > 1700000000",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := prepareWhereClause(tt.where, tt.start, tt.end); got != tt.want {
+				t.Fatalf("want %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSplitPeriods(t *testing.T) {
+	start := time.Unix(1700000000, 0).UTC()
+	end := start.Add(time.Hour)
+	period := func(minute int) time.Time {
+		return start.Add(time.Duration(minute)*time.Minute + time.Second)
+	}
+
+	// Rows of the table are identified by their period_start minute and index
+	type row struct {
+		minute, id int
+	}
+	var table []row
+	for minute := 1; minute <= 10; minute++ {
+		for id := 0; id < minute%3+1; id++ {
+			table = append(table, row{minute, id})
+		}
+	}
+	countPeriods := func() []periodRows {
+		var periods []periodRows
+		for _, r := range table {
+			if len(periods) > 0 && periods[len(periods)-1].period.Equal(period(r.minute)) {
+				periods[len(periods)-1].rows++
+				continue
+			}
+			periods = append(periods, periodRows{period: period(r.minute), rows: 1})
+		}
+		return periods
+	}
+	// readChunk selects rows the same way ReadChunk does with periodConditions
+	readChunk := func(m dump.ChunkMeta, maxRows int) []row {
+		var rows []row
+		for _, r := range table {
+			p := period(r.minute)
+			if !p.After(*m.Start) || !p.Before(*m.End) ||
+				(m.PeriodStart != nil && p.Before(*m.PeriodStart)) || (m.PeriodEnd != nil && !p.Before(*m.PeriodEnd)) {
+				continue
+			}
+			rows = append(rows, r)
+		}
+		if maxRows > 0 && len(rows) > m.RowsLen {
+			rows = rows[:m.RowsLen]
+		}
+		return rows
+	}
+
+	tests := []struct {
+		name       string
+		chunkRows  int
+		maxRows    int
+		wantChunks int
+		wantRows   int
+		wantCapped bool
+	}{
+		{
+			name:       "chunks of periods",
+			chunkRows:  4,
+			wantChunks: 4,
+			wantRows:   20,
+		},
+		{
+			name:       "single chunk",
+			chunkRows:  100,
+			wantChunks: 1,
+			wantRows:   20,
+		},
+		{
+			name:       "capped",
+			chunkRows:  4,
+			maxRows:    10,
+			wantChunks: 2,
+			wantRows:   10,
+			wantCapped: true,
+		},
+		{
+			name:       "max rows",
+			chunkRows:  4,
+			maxRows:    20,
+			wantChunks: 4,
+			wantRows:   20,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := table
+			defer func() { table = original }()
+
+			chunks, total, capped := splitPeriods(countPeriods(), start, end, nil, tt.chunkRows, tt.maxRows)
+			if len(chunks) != tt.wantChunks || total != tt.wantRows {
+				t.Fatalf("want %d chunks of %d rows, got %d chunks of %d rows", tt.wantChunks, tt.wantRows, len(chunks), total)
+			}
+			if capped != tt.wantCapped {
+				t.Fatalf("want capped %v, got %v", tt.wantCapped, capped)
+			}
+
+			// Rows inserted during export: before the first period, into existing periods and into new periods
+			table = append([]row(nil), table...)
+			table = append(table, row{0, 100}, row{1, 100}, row{4, 100}, row{5, 100}, row{7, 100}, row{11, 100})
+			sort.Slice(table, func(i, j int) bool {
+				if table[i].minute != table[j].minute {
+					return table[i].minute < table[j].minute
+				}
+				return table[i].id < table[j].id
+			})
+
+			seen := make(map[row]int)
+			exported := 0
+			for _, c := range chunks {
+				for _, r := range readChunk(c, tt.maxRows) {
+					seen[r]++
+					exported++
+				}
+			}
+			for r, n := range seen {
+				if n > 1 {
+					t.Fatalf("row %v is exported %d times", r, n)
+				}
+			}
+			if tt.maxRows > 0 {
+				if exported != tt.maxRows {
+					t.Fatalf("want %d rows exported, got %d", tt.maxRows, exported)
+				}
+				return
+			}
+			for _, r := range table {
+				if seen[r] != 1 {
+					t.Fatalf("row %v is missing", r)
+				}
+			}
+		})
+	}
+}
+
+func TestPeriodConditions(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	end := time.Unix(1700003600, 0)
+	periodStart := time.Unix(1700000600, 0)
+	periodEnd := time.Unix(1700001200, 0)
+	m := dump.ChunkMeta{Start: &start, End: &end, PeriodStart: &periodStart, PeriodEnd: &periodEnd}
+	want := "WHERE (service_name='mongo') AND period_start > 1700000000 AND period_start < 1700003600 AND " +
+		"period_start >= 1700000600 AND period_start < 1700001200"
+	if got := prepareWhereClause("service_name='mongo'", m.Start, m.End, periodConditions(m)...); got != want {
+		t.Fatalf("want %s, got %s", want, got)
+	}
+}
+
+func TestExportConditions(t *testing.T) {
+	since := time.Unix(1700000000, 0)
+	watermark := time.Unix(1700000600, 0)
+	tests := []struct {
+		name string
+		cfg  Config
+		want []string
+	}{
+		{
+			name: "no conditions",
+		},
+		{
+			name: "since prior dump",
+			cfg:  Config{Since: &since},
+			want: []string{"period_start > 1700000000"},
+		},
+		{
+			name: "since prior dump and checkpoint",
+			cfg:  Config{Since: &since, Checkpoint: &Checkpoint{Watermark: &watermark}},
+			want: []string{"period_start > 1700000000", "period_start >= 1700000600"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Source{cfg: tt.cfg}.exportConditions()
+			if strings.Join(got, " AND ") != strings.Join(tt.want, " AND ") {
+				t.Fatalf("want %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSupportsFinal(t *testing.T) {
+	tests := []struct {
+		engine string
+		want   bool
+	}{
+		{engine: "MergeTree", want: false},
+		{engine: "ReplicatedMergeTree", want: false},
+		{engine: "ReplacingMergeTree", want: true},
+		{engine: "ReplicatedReplacingMergeTree", want: true},
+		{engine: "CollapsingMergeTree", want: true},
+		{engine: "Memory", want: false},
+		{engine: "Distributed", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.engine, func(t *testing.T) {
+			if got := supportsFinal(tt.engine); got != tt.want {
+				t.Fatalf("want %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestReadChunkFinal(t *testing.T) {
+	d := new(fakeDriver)
+	db := sql.OpenDB(d)
+	defer db.Close() //nolint:errcheck
+
+	start := time.Unix(1700000000, 0)
+	end := time.Unix(1700003600, 0)
+	s := Source{db: db, cfg: Config{Final: true}}
+	if _, err := s.ReadChunk(context.Background(), dump.ChunkMeta{Start: &start, End: &end}); err != nil {
+		t.Fatal(err)
+	}
+	want := "SELECT * FROM metrics FINAL WHERE period_start > 1700000000 AND period_start < 1700003600 ORDER BY period_start, queryid"
+	if len(d.queries) != 1 || d.queries[0] != want {
+		t.Fatalf("want query %s, got %v", want, d.queries)
+	}
+}
+
+func TestReadChunkSpool(t *testing.T) {
+	d := &fakeDriver{rows: [][]driver.Value{
+		{"q1", time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{"q2", nil},
+	}}
+	db := sql.OpenDB(d)
+	defer db.Close() //nolint:errcheck
+
+	start := time.Unix(1700000000, 0)
+	end := time.Unix(1700003600, 0)
+	m := dump.ChunkMeta{Start: &start, End: &end}
+	inMemory, err := Source{db: db}.ReadChunk(context.Background(), m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spoolDir := t.TempDir()
+	spooled, err := Source{db: db, cfg: Config{SpoolDir: spoolDir}}.ReadChunk(context.Background(), m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spooled.Close() //nolint:errcheck
+	if spooled.File == nil || spooled.Content != nil {
+		t.Fatal("chunk content should be spooled to a file")
+	}
+	if files, err := os.ReadDir(spoolDir); err != nil || len(files) != 0 {
+		t.Fatalf("spool file should be removed, got %v, %v", files, err)
+	}
+
+	r, err := spooled.Reader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(content) == 0 || string(content) != string(inMemory.Content) || spooled.Len() != inMemory.Len() {
+		t.Fatalf("want spooled content %q, got %q of %d bytes", inMemory.Content, content, spooled.Len())
+	}
+}
+
+func TestBeginWrites(t *testing.T) {
+	tests := []struct {
+		name          string
+		beginFailures int
+		retries       int
+		shouldErr     bool
+	}{
+		{
+			name: "ready",
+		},
+		{
+			name:          "ready after retries",
+			beginFailures: 2,
+			retries:       3,
+		},
+		{
+			name:          "not ready",
+			beginFailures: 5,
+			retries:       2,
+			shouldErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &fakeDriver{beginFailures: tt.beginFailures}
+			db := sql.OpenDB(d)
+			defer db.Close() //nolint:errcheck
+
+			tx, ct, err := beginWrites(db, tt.retries, time.Millisecond)
+			if err != nil {
+				if !tt.shouldErr {
+					t.Fatal(err)
+				}
+				return
+			}
+			if tt.shouldErr {
+				t.Fatal("should be error")
+			}
+			defer tx.Rollback() //nolint:errcheck
+			if len(ct) != len(fakeColumns) {
+				t.Fatalf("want %d columns, got %d", len(fakeColumns), len(ct))
+			}
+		})
+	}
+}
+
+func TestBeginWritesNoMetricsTable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{
+			name: "exception",
+			err:  &clickhouse.Exception{Code: unknownTableCode, Message: "Table default.metrics doesn't exist"},
+		},
+		{
+			name: "message",
+			err:  errors.New("code: 60, message: Table pmm.metrics doesn't exist"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &fakeDriver{queryErr: tt.err}
+			db := sql.OpenDB(d)
+			defer db.Close() //nolint:errcheck
+
+			_, _, err := beginWrites(db, 3, time.Hour)
+			if !errors.Is(err, ErrMetricsTableNotFound) {
+				t.Fatalf("want %v, got %v", ErrMetricsTableNotFound, err)
+			}
+		})
+	}
+}
+
+// fakeRows has no rows, as the empty metrics table.
+func TestColumnTypesEmptyTable(t *testing.T) {
+	d := new(fakeDriver)
+	db := sql.OpenDB(d)
+	defer db.Close() //nolint:errcheck
+
+	ct, err := columnTypes(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ct) != len(fakeColumns) {
+		t.Fatalf("want %d column types, got %d", len(fakeColumns), len(ct))
+	}
+	for i, c := range ct {
+		if c.Name() != fakeColumns[i] || c.ScanType() != fakeScanTypes[i] {
+			t.Fatalf("want column %s of %v, got %s of %v", fakeColumns[i], fakeScanTypes[i], c.Name(), c.ScanType())
+		}
+	}
+	if want := []string{"SELECT * FROM metrics WHERE 0"}; !reflect.DeepEqual(d.queries, want) {
+		t.Fatalf("want queries %v, got %v", want, d.queries)
+	}
+}
+
+// TestWriteChunkNull exports rows with NULLs, which are scanned as nil or nil pointers, and imports them back.
+func TestWriteChunkNull(t *testing.T) {
+	d := &fakeDriver{rows: [][]driver.Value{
+		{"q1", time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{"q2", nil},
+		{"q3", (*time.Time)(nil)},
+	}}
+	db := sql.OpenDB(d)
+	defer db.Close() //nolint:errcheck
+
+	start := time.Unix(1700000000, 0)
+	end := time.Unix(1700003600, 0)
+	chunk, err := Source{db: db}.ReadChunk(context.Background(), dump.ChunkMeta{Start: &start, End: &end})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := chunk.Reader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "q1\t2023-01-02 03:04:05 +0000 UTC\nq2\t\\N\nq3\t\\N\n"; string(content) != want {
+		t.Fatalf("want content %q, got %q", want, content)
+	}
+
+	tx, ct, err := beginWrites(db, 0, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stmt, err := prepareInsertStatement(tx, "metrics", len(ct))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := Source{db: db, tx: tx, ct: ct, stmt: stmt}
+	if err := s.WriteChunk("0.tsv", strings.NewReader(string(content))); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.FinalizeWrites(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(d.execArgs) != 3 {
+		t.Fatalf("want 3 inserts, got %d", len(d.execArgs))
+	}
+	want := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	if got, ok := d.execArgs[0][1].(time.Time); !ok || !got.Equal(want) {
+		t.Fatalf("want %v, got %v", want, d.execArgs[0][1])
+	}
+	for _, args := range d.execArgs[1:] {
+		if args[1] != nil {
+			t.Fatalf("want nil, got %v", args[1])
+		}
+	}
+}
+
+func TestWriteChunkDedup(t *testing.T) {
+	d := new(fakeDriver)
+	db := sql.OpenDB(d)
+	defer db.Close() //nolint:errcheck
+
+	tx, ct, err := beginWrites(db, 0, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	staging, err := createStagingTable(db, time.Unix(1700000000, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stmt, err := prepareInsertStatement(tx, staging, len(ct))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := Source{db: db, tx: tx, ct: ct, stmt: stmt, staging: staging}
+
+	if err := s.WriteChunk("0.tsv", strings.NewReader("q1\t2023-01-02 03:04:05 +0000 UTC\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.FinalizeWrites(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"SELECT * FROM metrics WHERE 0",
+		"CREATE TABLE pmm_dump_import_1700000000000000000 AS metrics ENGINE = MergeTree ORDER BY tuple()",
+		"INSERT INTO pmm_dump_import_1700000000000000000 VALUES (?,?)",
+		"INSERT INTO metrics SELECT * FROM pmm_dump_import_1700000000000000000 WHERE cityHash64(*) NOT IN (",
+		"DROP TABLE IF EXISTS pmm_dump_import_1700000000000000000",
+	}
+	if len(d.queries) != len(want) {
+		t.Fatalf("want %d queries, got %v", len(want), d.queries)
+	}
+	for i := range want {
+		if !strings.HasPrefix(d.queries[i], want[i]) {
+			t.Fatalf("want query %s, got %s", want[i], d.queries[i])
+		}
+	}
+}
+
+var (
+	fakeColumns   = []string{"queryid", "period_start"}
+	fakeScanTypes = []reflect.Type{reflect.TypeOf(""), reflect.TypeOf((*time.Time)(nil))}
+)
+
+func TestChunkWriter(t *testing.T) {
+	d := new(fakeDriver)
+	db := sql.OpenDB(d)
+	defer db.Close() //nolint:errcheck
+
+	ct, err := columnTypes(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := Source{db: db, ct: ct, staging: "pmm_dump_import_1"}
+
+	committed, err := s.NewChunkWriter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rolledBack, err := s.NewChunkWriter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := committed.WriteChunk("0.tsv", strings.NewReader("q1\t2023-01-02 03:04:05 +0000 UTC\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := rolledBack.WriteChunk("1.tsv", strings.NewReader("q2\t\\N\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := committed.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := rolledBack.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	if d.commits != 1 || d.rollbacks != 1 {
+		t.Fatalf("want 1 commit and 1 rollback, got %d commits and %d rollbacks", d.commits, d.rollbacks)
+	}
+	want := []string{
+		"SELECT * FROM metrics WHERE 0",
+		"INSERT INTO pmm_dump_import_1 VALUES (?,?)",
+		"INSERT INTO pmm_dump_import_1 VALUES (?,?)",
+	}
+	if !reflect.DeepEqual(d.queries, want) {
+		t.Fatalf("want queries %v, got %v", want, d.queries)
+	}
+	if len(d.execArgs) != 2 {
+		t.Fatalf("want 2 inserts, got %d", len(d.execArgs))
+	}
+}
+
+// fakeDriver is a database/sql connector which fails to begin transactions the first beginFailures times.
+// Queries fail with queryErr, if it's set, or return rows. It records all prepared queries and arguments of executed statements,
+// and counts committed and rolled back transactions.
+type fakeDriver struct {
+	mu            sync.Mutex
+	beginFailures int
+	queryErr      error
+	rows          [][]driver.Value
+	queries       []string
+	execArgs      [][]driver.Value
+	commits       int
+	rollbacks     int
+}
+
+func (d *fakeDriver) Connect(_ context.Context) (driver.Conn, error) {
+	return &fakeConn{d}, nil
+}
+
+func (d *fakeDriver) Driver() driver.Driver {
+	return nil
+}
+
+type fakeConn struct {
+	d *fakeDriver
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	c.d.mu.Lock()
+	defer c.d.mu.Unlock()
+	c.d.queries = append(c.d.queries, query)
+	return fakeStmt{c.d}, nil
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	c.d.mu.Lock()
+	defer c.d.mu.Unlock()
+	if c.d.beginFailures > 0 {
+		c.d.beginFailures--
+		return nil, errors.New("not ready")
+	}
+	return fakeTx{c.d}, nil
+}
+
+type fakeStmt struct {
+	d *fakeDriver
+}
+
+func (fakeStmt) Close() error {
+	return nil
+}
+
+func (fakeStmt) NumInput() int {
+	return -1
+}
+
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	s.d.execArgs = append(s.d.execArgs, args)
+	return driver.RowsAffected(0), nil
+}
+
+func (s fakeStmt) Query(_ []driver.Value) (driver.Rows, error) {
+	if s.d.queryErr != nil {
+		return nil, s.d.queryErr
+	}
+	return &fakeRows{rows: s.d.rows}, nil
+}
+
+type fakeRows struct {
+	rows [][]driver.Value
+}
+
+func (*fakeRows) Columns() []string {
+	return fakeColumns
+}
+
+func (*fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func (*fakeRows) ColumnTypeScanType(index int) reflect.Type {
+	return fakeScanTypes[index]
+}
+
+type fakeTx struct {
+	d *fakeDriver
+}
+
+func (tx fakeTx) Commit() error {
+	tx.d.mu.Lock()
+	defer tx.d.mu.Unlock()
+	tx.d.commits++
+	return nil
+}
+
+func (tx fakeTx) Rollback() error {
+	tx.d.mu.Lock()
+	defer tx.d.mu.Unlock()
+	tx.d.rollbacks++
+	return nil
+}
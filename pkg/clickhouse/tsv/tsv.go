@@ -15,8 +15,10 @@
 package tsv
 
 import (
+	"crypto/sha256"
 	"database/sql"
 	"encoding/csv"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"reflect"
@@ -71,6 +73,16 @@ func (r *Reader) Read() ([]interface{}, error) {
 	return values, nil
 }
 
+// RecordHash returns the hash of the TSV record values. Equal records have the same hash.
+func RecordHash(record []string) string {
+	h := sha256.New()
+	for _, v := range record {
+		h.Write([]byte(v))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
 func parseSlice(slice string, st reflect.Type) (interface{}, error) {
 	slice = strings.TrimSpace(slice[1 : len(slice)-1])
 	elements := strings.Split(slice, ",")
@@ -88,10 +100,44 @@ func parseSlice(slice string, st reflect.Type) (interface{}, error) {
 	return result, nil
 }
 
+// nullValue is the ClickHouse TSV sentinel for NULL.
+const nullValue = `\N`
+
+// FormatValue returns the TSV record of the scanned value. NULLs, which are scanned as nil or nil pointers
+// of nullable columns, are written as nullValue, so they are read back as NULLs.
+func FormatValue(v interface{}) string {
+	if v == nil {
+		return nullValue
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nullValue
+		}
+		v = rv.Elem().Interface()
+	}
+	return fmt.Sprintf("%v", v)
+}
+
 func parseElement(record string, st reflect.Type) (interface{}, error) {
+	if record == nullValue {
+		if st.Kind() == reflect.Ptr {
+			return reflect.Zero(st).Interface(), nil
+		}
+		return nil, nil
+	}
+
 	var value interface{}
 	var err error
 	switch st.Kind() {
+	case reflect.Ptr:
+		elem, err := parseElement(record, st.Elem())
+		if err != nil {
+			return nil, err
+		}
+		ptr := reflect.New(st.Elem())
+		ptr.Elem().Set(reflect.ValueOf(elem))
+		value = ptr.Interface()
 	case reflect.Slice:
 		value, err = parseSlice(record, st.Elem())
 		if err != nil {
@@ -0,0 +1,108 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dump
+
+import (
+	"compress/gzip"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+const (
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+	CompressionNone = "none"
+)
+
+// Compression is the compression of the dump archive. Level is used by gzip only.
+type Compression struct {
+	Algorithm string
+	Level     int
+}
+
+// DefaultCompression is the compression of dumps with no compression in the meta.
+var DefaultCompression = Compression{Algorithm: CompressionGzip, Level: gzip.BestCompression}
+
+// ParseCompression parses the compression in the format of String: `gzip:1`..`gzip:9`, `zstd` or `none`.
+// `gzip` without the level is DefaultCompression.
+func ParseCompression(s string) (Compression, error) {
+	algorithm, level, hasLevel := strings.Cut(s, ":")
+	switch algorithm {
+	case CompressionGzip:
+		if !hasLevel {
+			return DefaultCompression, nil
+		}
+		l, err := strconv.Atoi(level)
+		if err != nil || l < gzip.BestSpeed || l > gzip.BestCompression {
+			return Compression{}, errors.Errorf("invalid gzip level %q: should be between %d and %d", level, gzip.BestSpeed, gzip.BestCompression)
+		}
+		return Compression{Algorithm: CompressionGzip, Level: l}, nil
+	case CompressionZstd, CompressionNone:
+		if hasLevel {
+			return Compression{}, errors.Errorf("%s doesn't have levels", algorithm)
+		}
+		return Compression{Algorithm: algorithm}, nil
+	}
+	return Compression{}, errors.Errorf("unknown compression %q: should be gzip:1..9, zstd or none", s)
+}
+
+func (c Compression) String() string {
+	if c.Algorithm == CompressionGzip {
+		return c.Algorithm + ":" + strconv.Itoa(c.Level)
+	}
+	return c.Algorithm
+}
+
+// Extension returns the extension of the dump file with the compression, ex. `.tar.zst`.
+func (c Compression) Extension() string {
+	switch c.Algorithm {
+	case CompressionZstd:
+		return ".tar.zst"
+	case CompressionNone:
+		return ".tar"
+	}
+	return ".tar.gz"
+}
+
+// compressWriter is implemented by gzip.Writer and zstd.Encoder.
+type compressWriter interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// newCompressWriter returns the writer compressing to w. It's nil for no compression.
+func newCompressWriter(w io.Writer, c Compression) (compressWriter, error) {
+	switch c.Algorithm {
+	case CompressionGzip:
+		gzw, err := gzip.NewWriterLevel(w, c.Level)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create gzip writer")
+		}
+		return gzw, nil
+	case CompressionZstd:
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create zstd writer")
+		}
+		return zw, nil
+	case CompressionNone:
+		return nil, nil //nolint:nilnil
+	}
+	return nil, errors.Errorf("unknown compression %s", c.Algorithm)
+}
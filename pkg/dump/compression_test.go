@@ -0,0 +1,113 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dump
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestParseCompression(t *testing.T) {
+	tests := []struct {
+		value     string
+		want      Compression
+		shouldErr bool
+	}{
+		{value: "gzip", want: DefaultCompression},
+		{value: "gzip:1", want: Compression{Algorithm: CompressionGzip, Level: 1}},
+		{value: "gzip:9", want: DefaultCompression},
+		{value: "zstd", want: Compression{Algorithm: CompressionZstd}},
+		{value: "none", want: Compression{Algorithm: CompressionNone}},
+		{value: "gzip:0", shouldErr: true},
+		{value: "gzip:10", shouldErr: true},
+		{value: "gzip:fast", shouldErr: true},
+		{value: "zstd:3", shouldErr: true},
+		{value: "lz4", shouldErr: true},
+		{value: "", shouldErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseCompression(tt.value)
+			if tt.shouldErr {
+				if err == nil {
+					t.Fatalf("error expected, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("want %v, got %v", tt.want, got)
+			}
+			if parsed, err := ParseCompression(got.String()); err != nil || parsed != got {
+				t.Fatalf("%s doesn't parse back: %v, %v", got, parsed, err)
+			}
+		})
+	}
+}
+
+func TestCompressedWriterReader(t *testing.T) {
+	files := []File{
+		{Name: "vm/1-2.bin", Content: bytes.Repeat([]byte("chunk"), 1000)},
+		{Name: MetaFilename, Content: []byte("{}")},
+	}
+	for _, c := range []Compression{{Algorithm: CompressionGzip, Level: 1}, DefaultCompression, {Algorithm: CompressionZstd}, {Algorithm: CompressionNone}} {
+		t.Run(c.String(), func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := NewCompressedWriterSize(&buf, DefaultWriteBufferSize, c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, f := range files {
+				if err := w.AddFile(f.Name, f.Content); err != nil {
+					t.Fatal(err)
+				}
+				// Flushes between files shouldn't break any compression
+				if err := w.Flush(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			r, err := NewReader(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close() //nolint:errcheck
+			for _, f := range files {
+				header, err := r.Next()
+				if err != nil {
+					t.Fatal(err)
+				}
+				content, err := io.ReadAll(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if header.Name != f.Name || !bytes.Equal(content, f.Content) {
+					t.Fatalf("want %s with %d bytes, got %s with %d bytes", f.Name, len(f.Content), header.Name, len(content))
+				}
+			}
+			if _, err := r.Next(); !errors.Is(err, io.EOF) {
+				t.Fatalf("want end of dump, got %v", err)
+			}
+		})
+	}
+}
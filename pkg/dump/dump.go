@@ -15,7 +15,15 @@
 package dump
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
 	"sync"
 	"time"
 
@@ -24,18 +32,146 @@ import (
 )
 
 const (
-	MetaFilename = "meta.json"
-	LogFilename  = "log.json"
+	MetaFilename             = "meta.json"
+	LogFilename              = "log.json"
+	AgentConfigFilename      = "pmm/agent-config.yaml"
+	ChunkStatsFilename       = "chunk-stats.json"
+	AnnotationsFilename      = "grafana/annotations.json"
+	VMMetadataFilename       = "vm/metadata.json"
+	FiltersFilename          = "filters.json"
+	VMMetricMetadataFilename = "vm/metric-metadata.json"
 )
 
+// File is a non-chunk file stored in the dump.
+type File struct {
+	Name    string
+	Content []byte
+}
+
+// AgentStatus is the status of pmm-agents returned by the `/v1/management/AgentStatus` PMM API. The dump stores
+// the whole response as YAML, only the agents and the services registered on them are read from it.
+type AgentStatus struct {
+	Agents []AgentStatusAgent `yaml:"agents"`
+}
+
+type AgentStatusAgent struct {
+	AgentID      string               `yaml:"agent_id"`
+	RunsOnNodeID string               `yaml:"runs_on_node_id"`
+	NodeName     string               `yaml:"node_name"`
+	Connected    bool                 `yaml:"connected"`
+	Services     []AgentStatusService `yaml:"services"`
+}
+
+type AgentStatusService struct {
+	ServiceID   string `yaml:"service_id"`
+	ServiceName string `yaml:"service_name"`
+	ServiceType string `yaml:"service_type"`
+	Address     string `yaml:"address"`
+	Port        int    `yaml:"port"`
+	Socket      string `yaml:"socket"`
+}
+
+// Annotation is a Grafana annotation. Time and TimeEnd are Unix timestamps in milliseconds.
+type Annotation struct {
+	DashboardUID string   `json:"dashboardUID,omitempty"`
+	PanelID      int64    `json:"panelId,omitempty"`
+	Time         int64    `json:"time"`
+	TimeEnd      int64    `json:"timeEnd,omitempty"`
+	Tags         []string `json:"tags"`
+	Text         string   `json:"text"`
+}
+
+// VMMetadata describes VictoriaMetrics storage of the exported PMM server. Status fields are raw API responses.
+type VMMetadata struct {
+	RetentionPeriod string          `json:"retention-period,omitempty"`
+	TotalSeries     int             `json:"total-series"`
+	TSDBStatus      json.RawMessage `json:"tsdb-status,omitempty"`
+	ActiveQueries   json.RawMessage `json:"active-queries,omitempty"`
+}
+
+// MetricMetadata is the type, help and unit of a metric, as returned by VictoriaMetrics `/api/v1/metadata`.
+// The metric metadata file of the dump maps metric names to their metadata.
+type MetricMetadata struct {
+	Type string `json:"type"`
+	Help string `json:"help"`
+	Unit string `json:"unit,omitempty"`
+}
+
+// Filters describe the data selected for export: the time range, resolved VM selectors and CH WHERE statement,
+// and the filter options they are resolved from.
+type Filters struct {
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	VMSelectors []string  `json:"vm-selectors,omitempty"`
+	CHWhere     string    `json:"ch-where,omitempty"`
+	Instances   []string  `json:"instances,omitempty"`
+	Dashboards  []string  `json:"dashboards,omitempty"`
+	Metrics     []string  `json:"metrics,omitempty"`
+}
+
 type Meta struct {
-	Version           PMMDumpVersion     `json:"version"`
-	PMMServerVersion  string             `json:"pmm-server-version"`
-	MaxChunkSize      int64              `json:"max_chunk_size"`
-	PMMTimezone       *string            `json:"pmm-server-timezone"`
-	Arguments         string             `json:"arguments"`
-	VMDataFormat      string             `json:"vm-data-format"`
-	PMMServerServices []PMMServerService `json:"pmm-server-services,omitempty"`
+	Version                  PMMDumpVersion     `json:"version"`
+	PMMServerVersion         string             `json:"pmm-server-version"`
+	MaxChunkSize             int64              `json:"max_chunk_size"`
+	PMMTimezone              *string            `json:"pmm-server-timezone"`
+	Arguments                string             `json:"arguments"`
+	VMDataFormat             string             `json:"vm-data-format"`
+	PMMServerServices        []PMMServerService `json:"pmm-server-services,omitempty"`
+	AgentConfigExported      bool               `json:"agent-config-exported,omitempty"`
+	QANRowsCapped            bool               `json:"qan-rows-capped,omitempty"`
+	AnnotationsExported      bool               `json:"annotations-exported,omitempty"`
+	VMMetadataExported       bool               `json:"vm-metadata-exported,omitempty"`
+	VMRetentionDays          int                `json:"vm-retention-days,omitempty"`
+	VMMaxChunkSize           uint64             `json:"vm-max-chunk-size,omitempty"`
+	VMMetricMetadataExported bool               `json:"vm-metric-metadata-exported,omitempty"`
+	SkippedChunks            []string           `json:"skipped-chunks,omitempty"`
+	VMNameRegex              string             `json:"vm-name-regex,omitempty"`
+	QANMaxPeriodStart        *time.Time         `json:"qan-max-period-start,omitempty"`
+	ChunkContentTypes        map[string]string  `json:"chunk-content-types,omitempty"`
+	Compression              string             `json:"compression,omitempty"`
+	// ChunkChecksums are SHA-256 checksums of chunks by their paths in the dump, if the export stored them.
+	ChunkChecksums map[string]string `json:"chunk-checksums,omitempty"`
+}
+
+// NewChecksum returns the hash of the chunk content for Meta.ChunkChecksums.
+func NewChecksum() hash.Hash {
+	return sha256.New()
+}
+
+// ChecksumString returns the hex string of the chunk checksum.
+func ChecksumString(h hash.Hash) string {
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// MetaCompatible checks that the dumps described by a and b can be combined into a single dump.
+// Dumps are compatible if they were exported from the same major PMM version and have the same VM data format:
+// import picks a single VM data format for the whole dump, so json and native chunks can't be mixed.
+func MetaCompatible(a, b Meta) error {
+	aMajor, bMajor := MajorVersion(a.PMMServerVersion), MajorVersion(b.PMMServerVersion)
+	if aMajor != bMajor {
+		return errors.Errorf("PMM major versions mismatch: %s and %s", a.PMMServerVersion, b.PMMServerVersion)
+	}
+
+	aFormat, bFormat := vmDataFormat(a), vmDataFormat(b)
+	if aFormat != bFormat {
+		return errors.Errorf("VM data formats mismatch: %s and %s", aFormat, bFormat)
+	}
+
+	return nil
+}
+
+// MajorVersion returns the major part of PMM version, ex. 2 of 2.41.0.
+func MajorVersion(v string) string {
+	major, _, _ := strings.Cut(v, ".")
+	return major
+}
+
+// vmDataFormat returns VM data format of the dump. Dumps without `vm-data-format` were exported in native format.
+func vmDataFormat(m Meta) string {
+	if m.VMDataFormat == "" {
+		return "native"
+	}
+	return m.VMDataFormat
 }
 
 type PMMServerService struct {
@@ -55,8 +191,14 @@ type ChunkMeta struct {
 	Start  *time.Time
 	End    *time.Time
 
-	Index   int
-	RowsLen int
+	Index      int
+	RowsLen    int
+	RowsOffset int
+
+	// PeriodStart and PeriodEnd bound period_start of ClickHouse chunk rows: PeriodStart <= period_start < PeriodEnd.
+	// Nil bounds are limited by Start and End only.
+	PeriodStart *time.Time
+	PeriodEnd   *time.Time
 }
 
 func (c ChunkMeta) String() string {
@@ -70,16 +212,69 @@ func (c ChunkMeta) String() string {
 	return fmt.Sprintf("%d-%d", s, e)
 }
 
+// ParseChunkTimeRange returns the time range from the chunk filename: `<start>-<end>.bin` for VictoriaMetrics
+// and `<start>-<end>-<index>.tsv` for ClickHouse. It returns false for ClickHouse chunks of older dumps named `<index>.tsv`.
+func ParseChunkTimeRange(filename string) (time.Time, time.Time, bool) {
+	var s, e int64
+	if _, err := fmt.Sscanf(filename, "%d-%d", &s, &e); err != nil {
+		return time.Time{}, time.Time{}, false
+	}
+	return time.Unix(s, 0).UTC(), time.Unix(e, 0).UTC(), true
+}
+
 type Chunk struct {
 	ChunkMeta
 	Content  []byte
 	Filename string
+	// File has the content instead of Content if the source spooled the chunk to a temporary file, so it's not held in memory.
+	File     *os.File
+	FileSize int64
+	// Parts is the number of chunks the chunk meta is split into by the source, 0 if it's not split.
+	Parts int
+
+	ReadDuration time.Duration
+}
+
+// Len returns the size of the chunk content.
+func (c *Chunk) Len() int64 {
+	if c.File != nil {
+		return c.FileSize
+	}
+	return int64(len(c.Content))
+}
+
+// Reader returns the reader of the chunk content from the start.
+func (c *Chunk) Reader() (io.Reader, error) {
+	if c.File == nil {
+		return bytes.NewReader(c.Content), nil
+	}
+	if _, err := c.File.Seek(0, io.SeekStart); err != nil {
+		return nil, errors.Wrapf(err, "failed to seek spooled chunk %s", c.Filename)
+	}
+	return io.LimitReader(c.File, c.FileSize), nil
+}
+
+// Close closes the temporary file of the spooled chunk. The file is expected to be removed already, so it's freed on close.
+func (c *Chunk) Close() error {
+	if c.File == nil {
+		return nil
+	}
+	return c.File.Close()
+}
+
+// ChunkStats describes a chunk written to the dump. Stats of each source are stored in <source>/chunk-stats.json.
+type ChunkStats struct {
+	Filename     string `json:"filename"`
+	Bytes        int64  `json:"bytes"`
+	DurationMs   int64  `json:"duration_ms"`
+	MetricsCount int    `json:"metrics_count,omitempty"`
 }
 
 type ChunkPool struct {
 	mu         sync.Mutex
 	chunks     []ChunkMeta
 	currentIdx int
+	created    time.Time
 }
 
 func NewChunkPool(c []ChunkMeta) (*ChunkPool, error) {
@@ -90,7 +285,8 @@ func NewChunkPool(c []ChunkMeta) (*ChunkPool, error) {
 	log.Debug().Msgf("Created pool with %d chunks in total", len(c))
 
 	return &ChunkPool{
-		chunks: c,
+		chunks:  c,
+		created: time.Now(),
 	}, nil
 }
 
@@ -109,3 +305,26 @@ func (p *ChunkPool) Next() (ChunkMeta, bool) {
 
 	return m, true
 }
+
+// Progress returns the number of chunks taken from the pool, the total number of chunks and the time since the pool is created.
+func (p *ChunkPool) Progress() (done, total int, elapsed time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.currentIdx, len(p.chunks), time.Since(p.created)
+}
+
+// Skip removes the chunks which are done already, ex. exported before the export is resumed, and returns their number.
+func (p *ChunkPool) Skip(done func(ChunkMeta) bool) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	chunks := make([]ChunkMeta, 0, len(p.chunks))
+	for _, c := range p.chunks {
+		if !done(c) {
+			chunks = append(chunks, c)
+		}
+	}
+	skipped := len(p.chunks) - len(chunks)
+	p.chunks = chunks
+	return skipped
+}
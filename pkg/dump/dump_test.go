@@ -0,0 +1,99 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dump
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMetaCompatible(t *testing.T) {
+	tests := []struct {
+		name      string
+		a         Meta
+		b         Meta
+		shouldErr bool
+	}{
+		{
+			name: "same versions and format",
+			a:    Meta{PMMServerVersion: "2.41.0", VMDataFormat: "json"},
+			b:    Meta{PMMServerVersion: "2.41.0", VMDataFormat: "json"},
+		},
+		{
+			name: "different minor versions",
+			a:    Meta{PMMServerVersion: "2.34.0-20.2301131343.a7f5d22.el7", VMDataFormat: "native"},
+			b:    Meta{PMMServerVersion: "2.41.2", VMDataFormat: "native"},
+		},
+		{
+			name:      "different major versions",
+			a:         Meta{PMMServerVersion: "2.41.0", VMDataFormat: "json"},
+			b:         Meta{PMMServerVersion: "3.0.0", VMDataFormat: "json"},
+			shouldErr: true,
+		},
+		{
+			name:      "different formats",
+			a:         Meta{PMMServerVersion: "2.41.0", VMDataFormat: "json"},
+			b:         Meta{PMMServerVersion: "2.41.0", VMDataFormat: "native"},
+			shouldErr: true,
+		},
+		{
+			name: "empty format is native",
+			a:    Meta{PMMServerVersion: "2.41.0"},
+			b:    Meta{PMMServerVersion: "2.41.0", VMDataFormat: "native"},
+		},
+		{
+			name:      "empty format is not json",
+			a:         Meta{PMMServerVersion: "2.41.0"},
+			b:         Meta{PMMServerVersion: "2.41.0", VMDataFormat: "json"},
+			shouldErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := MetaCompatible(tt.a, tt.b)
+			if err != nil && !tt.shouldErr {
+				t.Fatal(err)
+			}
+			if err == nil && tt.shouldErr {
+				t.Fatal("should be error")
+			}
+		})
+	}
+}
+
+func TestParseChunkTimeRange(t *testing.T) {
+	tests := []struct {
+		filename  string
+		wantStart int64
+		wantEnd   int64
+		wantOk    bool
+	}{
+		{filename: "1700000000-1700000300.bin", wantStart: 1700000000, wantEnd: 1700000300, wantOk: true},
+		{filename: "1700000000-1700000300-2.tsv", wantStart: 1700000000, wantEnd: 1700000300, wantOk: true},
+		{filename: "2.tsv"},
+		{filename: "chunk-stats.json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filename, func(t *testing.T) {
+			start, end, ok := ParseChunkTimeRange(tt.filename)
+			if ok != tt.wantOk {
+				t.Fatalf("want ok %v, got %v", tt.wantOk, ok)
+			}
+			if ok && (!start.Equal(time.Unix(tt.wantStart, 0)) || !end.Equal(time.Unix(tt.wantEnd, 0))) {
+				t.Fatalf("want %d-%d, got %d-%d", tt.wantStart, tt.wantEnd, start.Unix(), end.Unix())
+			}
+		})
+	}
+}
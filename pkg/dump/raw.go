@@ -0,0 +1,131 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dump
+
+import (
+	"archive/tar"
+	"encoding/binary"
+	"io"
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// RawMagic starts the raw dump stream, an alternative to the tar archive for pipelines where tar is inconvenient.
+// It's followed by the dump files in the same order as in the archive, every file is:
+//   - the name length, uint16 big endian;
+//   - the name, ex. `vm/1700000000-1700000300.bin`, the directory of chunks is their source;
+//   - the content length, uint64 big endian;
+//   - the content.
+//
+// The stream ends after the last file, which is the meta.
+const RawMagic = "pmm-dump-raw\n"
+
+// rawWriter writes files of the raw dump stream. It has the methods of tar.Writer used by Writer.
+type rawWriter struct {
+	w       io.Writer
+	started bool
+}
+
+func (w *rawWriter) WriteHeader(header *tar.Header) error {
+	if len(header.Name) > math.MaxUint16 {
+		return errors.Errorf("file name is too long: %d bytes", len(header.Name))
+	}
+	if !w.started {
+		if _, err := io.WriteString(w.w, RawMagic); err != nil {
+			return err
+		}
+		w.started = true
+	}
+	buf := binary.BigEndian.AppendUint16(nil, uint16(len(header.Name)))
+	buf = append(buf, header.Name...)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(header.Size))
+	_, err := w.w.Write(buf)
+	return err
+}
+
+func (w *rawWriter) Write(p []byte) (int, error) {
+	return w.w.Write(p)
+}
+
+func (w *rawWriter) Flush() error {
+	return nil
+}
+
+func (w *rawWriter) Close() error {
+	return nil
+}
+
+// rawReader reads files of the raw dump stream after the magic. It has the methods of tar.Reader used by Reader.
+type rawReader struct {
+	r    io.Reader
+	file *io.LimitedReader
+}
+
+func (r *rawReader) Next() (*tar.Header, error) {
+	// The rest of the current file is skipped, as tar.Reader does
+	if r.file != nil {
+		if _, err := io.Copy(io.Discard, r.file); err != nil {
+			return nil, err
+		}
+		if r.file.N > 0 {
+			return nil, io.ErrUnexpectedEOF
+		}
+	}
+
+	var nameLen uint16
+	if err := binary.Read(r.r, binary.BigEndian, &nameLen); err != nil {
+		// EOF between files is the end of the stream
+		return nil, err
+	}
+	name := make([]byte, nameLen)
+	if _, err := io.ReadFull(r.r, name); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	var size uint64
+	if err := binary.Read(r.r, binary.BigEndian, &size); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if size > math.MaxInt64 {
+		return nil, errors.Errorf("file %s is too large: %d bytes", name, size)
+	}
+
+	r.file = &io.LimitedReader{R: r.r, N: int64(size)}
+	return &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     string(name),
+		Size:     int64(size),
+		Mode:     filePermission,
+	}, nil
+}
+
+func (r *rawReader) Read(p []byte) (int, error) {
+	if r.file == nil {
+		return 0, io.EOF
+	}
+	n, err := r.file.Read(p)
+	if errors.Is(err, io.EOF) && r.file.N > 0 {
+		return n, io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// unexpectedEOF returns io.ErrUnexpectedEOF for EOF in the middle of a file header.
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,88 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dump
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestRawStream(t *testing.T) {
+	files := []File{
+		{Name: "vm/1-2.bin", Content: bytes.Repeat([]byte("chunk"), 1000)},
+		{Name: "ch/1-2-0.tsv"},
+		{Name: MetaFilename, Content: []byte("{}")},
+	}
+
+	var buf bytes.Buffer
+	w := NewRawWriterSize(&buf, 16)
+	for _, f := range files {
+		if err := w.AddFile(f.Name, f.Content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), RawMagic) {
+		t.Fatalf("raw stream should start with the magic, got %q", buf.String()[:len(RawMagic)])
+	}
+
+	r, err := NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, f := range files {
+		header, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.Name != f.Name || header.Size != int64(len(f.Content)) {
+			t.Fatalf("unexpected header for %s: %+v", f.Name, header)
+		}
+		// The first file is read partially, the rest of it should be skipped by Next
+		if i == 0 {
+			if _, err := r.Read(make([]byte, 10)); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		content, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(content, f.Content) {
+			t.Fatalf("want %s content %q, got %q", f.Name, f.Content, content)
+		}
+	}
+	if _, err := r.Next(); !errors.Is(err, io.EOF) {
+		t.Fatalf("want end of stream, got %v", err)
+	}
+
+	truncated, err := NewReader(bytes.NewReader(buf.Bytes()[:len(RawMagic)+100]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := truncated.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(truncated); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("want unexpected EOF of truncated stream, got %v", err)
+	}
+}
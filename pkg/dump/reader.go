@@ -0,0 +1,125 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dump
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+// Reader reads files from the dump archive or from the raw dump stream.
+type Reader struct {
+	// dr is nil for the raw dump stream and for the uncompressed archive.
+	dr io.ReadCloser
+	tr archiveReader
+}
+
+// archiveReader is implemented by tar.Reader and rawReader.
+type archiveReader interface {
+	Next() (*tar.Header, error)
+	Read(p []byte) (int, error)
+}
+
+// gzipMagic is the header of gzip streams.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// zstdMagic is the header of zstd frames.
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// HasStreamMagic reports whether b starts with the header of a gzip stream or a zstd frame.
+func HasStreamMagic(b []byte) bool {
+	return HasGzipMagic(b) || bytes.HasPrefix(b, zstdMagic)
+}
+
+// HasGzipMagic reports whether b starts with the header of a gzip stream.
+func HasGzipMagic(b []byte) bool {
+	return bytes.HasPrefix(b, gzipMagic)
+}
+
+// NewReader opens the dump archive compressed with gzip or zstd, recognized by the stream header, so the compression
+// in the meta at the end of the dump isn't needed. If the stream is not compressed, ex. it was exported with `none`
+// compression or it's already decompressed upstream in a pipeline, it's read as a plain tar archive.
+// The raw dump stream is recognized by RawMagic.
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(len(RawMagic)); err == nil && string(magic) == RawMagic {
+		if _, err := br.Discard(len(RawMagic)); err != nil {
+			return nil, errors.Wrap(err, "failed to read raw dump stream")
+		}
+		return &Reader{
+			tr: &rawReader{r: br},
+		}, nil
+	}
+
+	if header, err := br.Peek(len(zstdMagic)); err == nil && bytes.Equal(header, zstdMagic) {
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to open as zstd")
+		}
+		dr := zr.IOReadCloser()
+		return &Reader{
+			dr: dr,
+			tr: tar.NewReader(dr),
+		}, nil
+	}
+
+	header, err := br.Peek(len(gzipMagic))
+	if err == nil && !bytes.Equal(header, gzipMagic) {
+		return &Reader{
+			tr: tar.NewReader(br),
+		}, nil
+	}
+
+	// Streams too short for the header are opened as gzip, which is the default compression
+	gzr, err := gzip.NewReader(br)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open as gzip")
+	}
+
+	return &Reader{
+		dr: gzr,
+		tr: tar.NewReader(gzr),
+	}, nil
+}
+
+// Next advances to the next file in the dump and returns its header. It returns io.EOF at the end of the dump.
+func (r *Reader) Next() (*tar.Header, error) {
+	header, err := r.tr.Next()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, io.EOF
+		}
+		return nil, errors.Wrap(err, "failed to read file from dump")
+	}
+	return header, nil
+}
+
+// Read reads the content of the current file.
+func (r *Reader) Read(p []byte) (int, error) {
+	return r.tr.Read(p)
+}
+
+func (r *Reader) Close() error {
+	if r.dr == nil {
+		return nil
+	}
+	return r.dr.Close()
+}
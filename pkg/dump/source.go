@@ -14,15 +14,51 @@
 
 package dump
 
-import "io"
+import (
+	"context"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// ErrEmptyChunk is returned by ReadChunk if the chunk has no data, so it's not written to the dump.
+var ErrEmptyChunk = errors.New("chunk has no data")
 
 type Source interface {
 	Type() SourceType
-	ReadChunk(meta ChunkMeta) (*Chunk, error)
+	ReadChunk(ctx context.Context, meta ChunkMeta) (*Chunk, error)
 	WriteChunk(filename string, r io.Reader) error
 	FinalizeWrites() error
 }
 
+// ChunkSplitter is implemented by sources that split read chunks before they are written to the dump.
+type ChunkSplitter interface {
+	SplitChunk(c *Chunk) ([]*Chunk, error)
+}
+
+// ChunkConcatenator is implemented by sources which can write several chunks at once, concatenated into a single content.
+type ChunkConcatenator interface {
+	CanConcatenateChunks() bool
+}
+
+// ConcurrentWriter is implemented by sources which write chunks in transactions, so every import worker
+// writes to its own transaction instead of sharing one.
+type ConcurrentWriter interface {
+	NewChunkWriter() (ChunkWriter, error)
+}
+
+// ChunkWriter writes chunks in its own transaction, which is committed or rolled back after all chunks are written.
+type ChunkWriter interface {
+	WriteChunk(filename string, r io.Reader) error
+	Commit() error
+	Rollback() error
+}
+
+// MetricsCounter is implemented by sources that can count metrics in the chunk content.
+type MetricsCounter interface {
+	CountMetrics(r io.Reader) (int, error)
+}
+
 type SourceType int
 
 const (
@@ -53,3 +89,8 @@ func ParseSourceType(v string) SourceType {
 		return UndefinedSource
 	}
 }
+
+// ContentTyper is implemented by sources that describe the content type of their chunks, it's stored in the dump meta.
+type ContentTyper interface {
+	ContentType() string
+}
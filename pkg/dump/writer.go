@@ -0,0 +1,201 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dump
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const filePermission = 0o600
+
+// Writer writes files to the dump archive or to the raw dump stream.
+type Writer struct {
+	bw *bufio.Writer
+	// cw is nil for the raw dump stream and for the uncompressed archive. It's replaced by Checkpoint,
+	// which writes it to compressed with the same compression.
+	cw          compressWriter
+	compressed  io.Writer
+	compression Compression
+	tw          archiveWriter
+}
+
+// cwWriter writes to the current compression writer, so tar.Writer keeps writing to it after Checkpoint.
+type cwWriter struct {
+	w *Writer
+}
+
+func (c cwWriter) Write(p []byte) (int, error) {
+	return c.w.cw.Write(p)
+}
+
+// archiveWriter is implemented by tar.Writer and rawWriter.
+type archiveWriter interface {
+	WriteHeader(header *tar.Header) error
+	Write(p []byte) (int, error)
+	Flush() error
+	Close() error
+}
+
+// DefaultWriteBufferSize is the size of the buffer the compressed dump is written through.
+// Gzip makes a lot of small writes, which are slow on network file systems.
+const DefaultWriteBufferSize = 1 << 20
+
+// NewWriter returns the writer buffered with DefaultWriteBufferSize.
+func NewWriter(w io.Writer) (*Writer, error) {
+	return NewWriterSize(w, DefaultWriteBufferSize)
+}
+
+// NewWriterSize returns the writer with the write buffer of the given size. 0 disables buffering.
+func NewWriterSize(w io.Writer, bufSize int) (*Writer, error) {
+	return NewCompressedWriterSize(w, bufSize, DefaultCompression)
+}
+
+// NewCompressedWriterSize returns the writer of the archive with the given compression and the write buffer of the given size.
+// 0 disables buffering.
+func NewCompressedWriterSize(w io.Writer, bufSize int, c Compression) (*Writer, error) {
+	var bw *bufio.Writer
+	if bufSize > 0 {
+		bw = bufio.NewWriterSize(w, bufSize)
+		w = bw
+	}
+	cw, err := newCompressWriter(w, c)
+	if err != nil {
+		return nil, err
+	}
+
+	dw := &Writer{
+		bw:          bw,
+		cw:          cw,
+		compressed:  w,
+		compression: c,
+	}
+	if cw != nil {
+		w = cwWriter{w: dw}
+	}
+	dw.tw = tar.NewWriter(w)
+	return dw, nil
+}
+
+// NewRawWriterSize returns the writer of the raw dump stream with the write buffer of the given size. 0 disables buffering.
+func NewRawWriterSize(w io.Writer, bufSize int) *Writer {
+	var bw *bufio.Writer
+	if bufSize > 0 {
+		bw = bufio.NewWriterSize(w, bufSize)
+		w = bw
+	}
+	return &Writer{
+		bw: bw,
+		tw: &rawWriter{w: w},
+	}
+}
+
+// AddFile writes a regular file with the given content to the dump.
+func (w *Writer) AddFile(name string, content []byte) error {
+	return w.AddFileFrom(name, bytes.NewReader(content), int64(len(content)))
+}
+
+// AddFileFrom writes a regular file of the given size to the dump, copying the content from r.
+func (w *Writer) AddFileFrom(name string, r io.Reader, size int64) error {
+	err := w.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     filePermission,
+		ModTime:  time.Now(),
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to write %s header", name)
+	}
+
+	n, err := io.Copy(w.tw, r)
+	if err != nil {
+		return errors.Wrapf(err, "failed to write %s content", name)
+	}
+	if n != size {
+		return errors.Errorf("failed to write %s content: want %d bytes, got %d", name, size, n)
+	}
+
+	return nil
+}
+
+// Flush writes the files added so far through to the underlying writer, so they can be read from a partial dump.
+// It makes compression a bit worse, so it shouldn't be called after every file without a need.
+func (w *Writer) Flush() error {
+	if err := w.tw.Flush(); err != nil {
+		return errors.Wrap(err, "failed to flush tar writer")
+	}
+	if w.cw != nil {
+		if err := w.cw.Flush(); err != nil {
+			return errors.Wrap(err, "failed to flush compression writer")
+		}
+	}
+	if w.bw != nil {
+		if err := w.bw.Flush(); err != nil {
+			return errors.Wrap(err, "failed to flush write buffer")
+		}
+	}
+	return nil
+}
+
+// Checkpoint ends the compressed stream after the files added so far, writes it through to the underlying writer
+// and starts a new stream for the next files. A dump truncated at this point is valid without the end of the archive,
+// and can be continued by a new writer appending to it: gzip members and zstd frames are read as a single stream.
+func (w *Writer) Checkpoint() error {
+	if err := w.tw.Flush(); err != nil {
+		return errors.Wrap(err, "failed to flush tar writer")
+	}
+	if w.cw != nil {
+		if err := w.cw.Close(); err != nil {
+			return errors.Wrap(err, "failed to close compression writer")
+		}
+		cw, err := newCompressWriter(w.compressed, w.compression)
+		if err != nil {
+			return err
+		}
+		w.cw = cw
+	}
+	if w.bw != nil {
+		if err := w.bw.Flush(); err != nil {
+			return errors.Wrap(err, "failed to flush write buffer")
+		}
+	}
+	return nil
+}
+
+func (w *Writer) Close() error {
+	if err := w.tw.Close(); err != nil {
+		if w.cw != nil {
+			_ = w.cw.Close()
+		}
+		return errors.Wrap(err, "failed to close tar writer")
+	}
+	if w.cw != nil {
+		if err := w.cw.Close(); err != nil {
+			return errors.Wrap(err, "failed to close compression writer")
+		}
+	}
+	if w.bw != nil {
+		if err := w.bw.Flush(); err != nil {
+			return errors.Wrap(err, "failed to flush write buffer")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dump
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestWriterAddFile(t *testing.T) {
+	files := []File{
+		{Name: "vm/1-2.bin", Content: bytes.Repeat([]byte("chunk"), 1000)},
+		{Name: "empty.txt"},
+		{Name: MetaFilename, Content: []byte("{}")},
+	}
+
+	for _, bufSize := range []int{0, 16, DefaultWriteBufferSize} {
+		t.Run(strconv.Itoa(bufSize), func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := NewWriterSize(&buf, bufSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, f := range files {
+				if err := w.AddFile(f.Name, f.Content); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			gzr, err := gzip.NewReader(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tr := tar.NewReader(gzr)
+			for _, f := range files {
+				header, err := tr.Next()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if header.Name != f.Name || header.Typeflag != tar.TypeReg || header.Mode != filePermission {
+					t.Fatalf("unexpected header for %s: %+v", f.Name, header)
+				}
+				content, err := io.ReadAll(tr)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(content, f.Content) {
+					t.Fatalf("want %s content %q, got %q", f.Name, f.Content, content)
+				}
+			}
+			if _, err := tr.Next(); !errors.Is(err, io.EOF) {
+				t.Fatalf("want end of archive, got %v", err)
+			}
+		})
+	}
+}
+
+func TestWriterAddFileFrom(t *testing.T) {
+	content := bytes.Repeat([]byte("row\t1\n"), 1000)
+	f, err := os.CreateTemp(t.TempDir(), "chunk-*.tsv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	c := &Chunk{Filename: "1-2-0.tsv", File: f, FileSize: int64(len(content))}
+	defer c.Close() //nolint:errcheck
+
+	for name, newWriter := range map[string]func(w io.Writer) (*Writer, error){
+		"tar": NewWriter,
+		"raw": func(w io.Writer) (*Writer, error) { return NewRawWriterSize(w, 0), nil },
+	} {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := newWriter(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r, err := c.Reader()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := w.AddFileFrom("ch/"+c.Filename, r, c.Len()); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			dr, err := NewReader(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			header, err := dr.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(dr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if header.Name != "ch/"+c.Filename || !bytes.Equal(got, content) {
+				t.Fatalf("want %s of %d bytes, got %s of %d bytes", c.Filename, len(content), header.Name, len(got))
+			}
+
+			if err := w.AddFileFrom("short.txt", strings.NewReader("short"), 10); err == nil {
+				t.Fatal("should be error for the content shorter than the size")
+			}
+		})
+	}
+}
+
+func TestWriterCheckpoint(t *testing.T) {
+	files := []File{
+		{Name: "vm/1-2.bin", Content: bytes.Repeat([]byte("first"), 1000)},
+		{Name: "vm/2-3.bin", Content: bytes.Repeat([]byte("second"), 1000)},
+		{Name: MetaFilename, Content: []byte("{}")},
+	}
+	for _, c := range []Compression{DefaultCompression, {Algorithm: CompressionZstd}, {Algorithm: CompressionNone}} {
+		t.Run(c.String(), func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := NewCompressedWriterSize(&buf, DefaultWriteBufferSize, c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := w.AddFile(files[0].Name, files[0].Content); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Checkpoint(); err != nil {
+				t.Fatal(err)
+			}
+			size := buf.Len()
+
+			// The export fails after the checkpoint: the rest is cut and written again by a new writer
+			if err := w.AddFile(files[1].Name, files[1].Content); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			buf.Truncate(size)
+
+			w, err = NewCompressedWriterSize(&buf, DefaultWriteBufferSize, c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, f := range files[1:] {
+				if err := w.AddFile(f.Name, f.Content); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			r, err := NewReader(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close() //nolint:errcheck
+			for _, f := range files {
+				header, err := r.Next()
+				if err != nil {
+					t.Fatal(err)
+				}
+				content, err := io.ReadAll(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if header.Name != f.Name || !bytes.Equal(content, f.Content) {
+					t.Fatalf("want %s with %d bytes, got %s with %d bytes", f.Name, len(f.Content), header.Name, len(content))
+				}
+			}
+			if _, err := r.Next(); !errors.Is(err, io.EOF) {
+				t.Fatalf("want end of dump, got %v", err)
+			}
+		})
+	}
+}
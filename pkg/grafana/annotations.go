@@ -0,0 +1,74 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grafana
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/valyala/fasthttp"
+
+	"pmm-dump/pkg/dump"
+	"pmm-dump/pkg/grafana/client"
+)
+
+// annotationsLimit is the max amount of annotations requested from Grafana, it returns 100 by default.
+const annotationsLimit = 10000
+
+// GetAnnotations returns Grafana annotations which start within the time range.
+func GetAnnotations(c *client.Client, pmmURL string, from, to time.Time) ([]dump.Annotation, error) {
+	link := fmt.Sprintf("%s/graph/api/annotations?from=%d&to=%d&limit=%d", pmmURL, from.UnixMilli(), to.UnixMilli(), annotationsLimit)
+	status, data, err := c.Get(link)
+	if err != nil {
+		return nil, err
+	}
+	if status != fasthttp.StatusOK {
+		return nil, fmt.Errorf("non-ok status: %d", status)
+	}
+
+	var annotations []dump.Annotation
+	if err := json.Unmarshal(data, &annotations); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal annotations")
+	}
+	return FilterAnnotations(annotations, from, to), nil
+}
+
+// FilterAnnotations returns annotations which start within the time range.
+// Grafana also returns the annotations which just overlap the range, e.g. started long before it.
+func FilterAnnotations(annotations []dump.Annotation, from, to time.Time) []dump.Annotation {
+	var result []dump.Annotation
+	for _, a := range annotations {
+		if a.Time >= from.UnixMilli() && a.Time <= to.UnixMilli() {
+			result = append(result, a)
+		}
+	}
+	return result
+}
+
+// ImportAnnotations creates the annotations in Grafana.
+func ImportAnnotations(c *client.Client, pmmURL string, annotations []dump.Annotation) error {
+	for _, a := range annotations {
+		status, data, err := c.PostJSON(pmmURL+"/graph/api/annotations", a)
+		if err != nil {
+			return err
+		}
+		if status != fasthttp.StatusOK {
+			return fmt.Errorf("non-ok status: %d: %s", status, string(data))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grafana
+
+import (
+	"testing"
+	"time"
+
+	"pmm-dump/pkg/dump"
+)
+
+func TestFilterAnnotations(t *testing.T) {
+	from := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	to := from.Add(time.Hour)
+
+	annotations := []dump.Annotation{
+		{Text: "before", Time: from.Add(-time.Minute).UnixMilli(), TimeEnd: from.Add(time.Minute).UnixMilli()},
+		{Text: "start", Time: from.UnixMilli()},
+		{Text: "inside", Time: from.Add(time.Minute).UnixMilli()},
+		{Text: "end", Time: to.UnixMilli()},
+		{Text: "after", Time: to.Add(time.Minute).UnixMilli()},
+	}
+
+	got := FilterAnnotations(annotations, from, to)
+	want := []string{"start", "inside", "end"}
+	if len(got) != len(want) {
+		t.Fatalf("want %d annotations, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if got[i].Text != want[i] {
+			t.Fatalf("want %s annotation, got %s", want[i], got[i].Text)
+		}
+	}
+}
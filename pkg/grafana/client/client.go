@@ -18,9 +18,11 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
 	"github.com/valyala/fasthttp"
 )
 
@@ -32,28 +34,40 @@ type AuthParams struct {
 }
 
 func (p *AuthParams) Validate() error {
-	var i int
+	var methods []string
 	if p.User != "" {
-		i++
+		methods = append(methods, "user/password")
 	}
 	if p.APIToken != "" {
-		i++
+		methods = append(methods, "API token")
 	}
 	if p.AuthCookie != "" {
-		i++
+		methods = append(methods, "auth cookie")
 	}
 
-	if i > 1 {
-		return errors.New("only one authentication method can be specified (user/password, API token or auth cookie")
+	if len(methods) > 1 {
+		last := len(methods) - 1
+		return errors.Errorf("only one auth method allowed, got: %s and %s", strings.Join(methods[:last], ", "), methods[last])
 	}
 
-	if i == 0 {
+	if len(methods) == 0 {
 		return errors.New("missing authentication credentials. API token, cookie or user/password should be provided.")
 	}
 
 	return nil
 }
 
+// ValidateAll is the same as Validate, but also checks that the password is provided for the user.
+func (p *AuthParams) ValidateAll() error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	if p.User != "" && p.Password == "" {
+		return errors.Errorf("missing password for user %s", p.User)
+	}
+	return nil
+}
+
 func NewClient(httpC *fasthttp.Client, params AuthParams) (*Client, error) {
 	if err := params.Validate(); err != nil {
 		return nil, err
@@ -74,24 +88,76 @@ type Client struct {
 	token      string
 	user       string
 	password   string
+	headers    map[string]string
+	trace      bool
+}
+
+// WithHeaders returns a copy of the client which also sends the headers with every request,
+// ex. `X-Scope-OrgID` for multi-tenant stores. Auth headers can't be overridden by them.
+func (c *Client) WithHeaders(headers map[string]string) *Client {
+	if len(headers) == 0 {
+		return c
+	}
+	withHeaders := *c
+	withHeaders.headers = make(map[string]string, len(c.headers)+len(headers))
+	for k, v := range c.headers {
+		withHeaders.headers[k] = v
+	}
+	for k, v := range headers {
+		withHeaders.headers[k] = v
+	}
+	return &withHeaders
+}
+
+// WithTrace returns a copy of the client which logs URL, status, body sizes and duration of every request at debug level.
+func (c *Client) WithTrace(enabled bool) *Client {
+	if !enabled {
+		return c
+	}
+	withTrace := *c
+	withTrace.trace = true
+	return &withTrace
 }
 
 const AuthCookieName = "grafana_session"
 
 func (c *Client) Do(req *fasthttp.Request) (*fasthttp.Response, error) {
-	c.setAuthHeaders(req)
-	httpResp := fasthttp.AcquireResponse()
-	err := c.client.Do(req, httpResp)
-	return httpResp, errors.Wrap(err, "failed to make request in network client")
+	return c.do(req, func(resp *fasthttp.Response) error {
+		return c.client.Do(req, resp)
+	})
 }
 
 func (c *Client) DoWithTimeout(req *fasthttp.Request, timeout time.Duration) (*fasthttp.Response, error) {
-	c.setAuthHeaders(req)
+	return c.do(req, func(resp *fasthttp.Response) error {
+		return c.client.DoTimeout(req, resp, timeout)
+	})
+}
+
+func (c *Client) do(req *fasthttp.Request, send func(resp *fasthttp.Response) error) (*fasthttp.Response, error) {
+	c.setHeaders(req)
 	httpResp := fasthttp.AcquireResponse()
-	err := c.client.DoTimeout(req, httpResp, timeout)
+	start := time.Now()
+	err := send(httpResp)
+	if c.trace {
+		traceRequest(req, httpResp, time.Since(start), err)
+	}
 	return httpResp, errors.Wrap(err, "failed to make request in network client")
 }
 
+func traceRequest(req *fasthttp.Request, resp *fasthttp.Response, elapsed time.Duration, err error) {
+	// Body of a streamed request is already consumed, so its size is unknown
+	sent := -1
+	if !req.IsBodyStream() {
+		sent = len(req.Body())
+	}
+	if err != nil {
+		log.Debug().Msgf("HTTP %s %s failed after %v: sent %d bytes: %v", req.Header.Method(), req.URI(), elapsed, sent, err)
+		return
+	}
+	log.Debug().Msgf("HTTP %s %s: status %d in %v: sent %d bytes, received %d bytes",
+		req.Header.Method(), req.URI(), resp.StatusCode(), elapsed, sent, len(resp.Body()))
+}
+
 func (c *Client) Post(url string) (int, []byte, error) {
 	req := fasthttp.AcquireRequest()
 	defer fasthttp.ReleaseRequest(req)
@@ -151,7 +217,11 @@ func (c *Client) GetWithTimeout(url string, timeout time.Duration) (int, []byte,
 	return httpResp.StatusCode(), httpResp.Body(), err
 }
 
-func (c *Client) setAuthHeaders(req *fasthttp.Request) {
+func (c *Client) setHeaders(req *fasthttp.Request) {
+	for k, v := range c.headers {
+		req.Header.Set(k, v)
+	}
+
 	if c.user != "" {
 		h := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", c.user, c.password)))
 		req.Header.Set("Authorization", "Basic "+h)
@@ -0,0 +1,157 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/valyala/fasthttp"
+)
+
+func TestAuthParamsValidateAll(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  AuthParams
+		wantErr string
+	}{
+		{
+			name:   "user/password",
+			params: AuthParams{User: "admin", Password: "admin"},
+		},
+		{
+			name:   "API token",
+			params: AuthParams{APIToken: "token"},
+		},
+		{
+			name:   "auth cookie",
+			params: AuthParams{AuthCookie: "cookie"},
+		},
+		{
+			name:    "no auth",
+			params:  AuthParams{},
+			wantErr: "missing authentication credentials. API token, cookie or user/password should be provided.",
+		},
+		{
+			name:    "user and token",
+			params:  AuthParams{User: "admin", Password: "admin", APIToken: "token"},
+			wantErr: "only one auth method allowed, got: user/password and API token",
+		},
+		{
+			name:    "user and cookie",
+			params:  AuthParams{User: "admin", Password: "admin", AuthCookie: "cookie"},
+			wantErr: "only one auth method allowed, got: user/password and auth cookie",
+		},
+		{
+			name:    "token and cookie",
+			params:  AuthParams{APIToken: "token", AuthCookie: "cookie"},
+			wantErr: "only one auth method allowed, got: API token and auth cookie",
+		},
+		{
+			name:    "user, token and cookie",
+			params:  AuthParams{User: "admin", Password: "admin", APIToken: "token", AuthCookie: "cookie"},
+			wantErr: "only one auth method allowed, got: user/password, API token and auth cookie",
+		},
+		{
+			name:    "user without password",
+			params:  AuthParams{User: "admin"},
+			wantErr: "missing password for user admin",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.params.ValidateAll()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("should be error")
+			}
+			if err.Error() != tt.wantErr {
+				t.Fatalf("want error %q, got %q", tt.wantErr, err.Error())
+			}
+		})
+	}
+}
+
+func TestClientWithHeaders(t *testing.T) {
+	var got http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
+		got = req.Header
+	}))
+	defer server.Close()
+
+	c, err := NewClient(&fasthttp.Client{}, AuthParams{APIToken: "token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	withHeaders := c.WithHeaders(map[string]string{"X-Scope-OrgID": "tenant1", "Authorization": "Bearer other"})
+
+	if _, _, err := withHeaders.Get(server.URL); err != nil {
+		t.Fatal(err)
+	}
+	if got.Get("X-Scope-OrgID") != "tenant1" {
+		t.Fatalf("want X-Scope-OrgID header, got %v", got)
+	}
+	if got.Get("Authorization") != "Bearer token" {
+		t.Fatalf("auth header shouldn't be overridden, got %s", got.Get("Authorization"))
+	}
+
+	if _, _, err := c.Get(server.URL); err != nil {
+		t.Fatal(err)
+	}
+	if got.Get("X-Scope-OrgID") != "" {
+		t.Fatal("original client shouldn't send the headers")
+	}
+}
+
+func TestClientWithTrace(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		_, _ = rw.Write([]byte("response"))
+	}))
+	defer server.Close()
+
+	var buf bytes.Buffer
+	logger := log.Logger
+	log.Logger = zerolog.New(&buf).Level(zerolog.DebugLevel)
+	defer func() { log.Logger = logger }()
+
+	c, err := NewClient(&fasthttp.Client{}, AuthParams{APIToken: "token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.Get(server.URL); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("client without trace shouldn't log requests, got %s", buf.String())
+	}
+
+	if _, _, err := c.WithTrace(true).PostJSON(server.URL+"/path", map[string]string{"a": "b"}); err != nil {
+		t.Fatal(err)
+	}
+	want := "HTTP POST " + server.URL + "/path: status 200"
+	if !strings.Contains(buf.String(), want) || !strings.Contains(buf.String(), "sent 9 bytes, received 8 bytes") {
+		t.Fatalf("want trace of %q, got %s", want, buf.String())
+	}
+}
@@ -36,6 +36,8 @@ type VMExprParser struct {
 	to       time.Time
 	varOrder []string
 	vars     map[string]templating.TemplatingVariable
+
+	labelValuesLimit int
 }
 
 func (p *VMExprParser) allVariables() []templating.TemplatingVariable {
@@ -70,7 +72,8 @@ func durationToStr(dur time.Duration, suffix string) string {
 	return ""
 }
 
-func NewVMParser(dashboard types.DashboardPanel, serviceNames []string, c *client.Client, vmURL string, from time.Time, to time.Time) *VMExprParser {
+// NewVMParser returns the parser of dashboard selectors. A positive labelValuesLimit limits the values of query variables fetched from VictoriaMetrics.
+func NewVMParser(dashboard types.DashboardPanel, serviceNames []string, c *client.Client, vmURL string, from time.Time, to time.Time, labelValuesLimit int) *VMExprParser {
 	newVar := func(name string, values ...string) templating.TemplatingVariable {
 		return templating.TemplatingVariable{
 			Model: types.VariableModel{
@@ -120,6 +123,8 @@ func NewVMParser(dashboard types.DashboardPanel, serviceNames []string, c *clien
 		vars:         vM,
 		pmmURL:       vmURL,
 		varOrder:     vOrder,
+
+		labelValuesLimit: labelValuesLimit,
 	}
 }
 
@@ -17,11 +17,13 @@ package expr
 import (
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
 	"github.com/valyala/fasthttp"
 
 	"pmm-dump/pkg/grafana/client"
@@ -73,17 +75,21 @@ func (p *VMExprParser) parseTemplatingQuery(v types.VariableModel) (templating.T
 		}
 
 		var values []string
+		var limited bool
 		if label == "" {
-			values, err = labelValuesSingleLabel(p.c, p.pmmURL, query, p.from, p.to)
+			values, limited, err = labelValuesSingleLabel(p.c, p.pmmURL, query, p.from, p.to, p.labelValuesLimit)
 			if err != nil {
 				return templating.TemplatingVariable{}, err
 			}
 		} else {
-			values, err = labelValues(p.c, p.pmmURL, label, metric, p.from, p.to, p.allVariables())
+			values, limited, err = labelValues(p.c, p.pmmURL, label, metric, p.from, p.to, p.allVariables(), p.labelValuesLimit)
 			if err != nil {
 				return templating.TemplatingVariable{}, err
 			}
 		}
+		if limited {
+			log.Warn().Msgf("Values of dashboard variable %s are limited to %d, selectors resolved with it may be incomplete", v.Name, p.labelValuesLimit)
+		}
 
 		return templating.TemplatingVariable{
 			Model:  v,
@@ -93,18 +99,23 @@ func (p *VMExprParser) parseTemplatingQuery(v types.VariableModel) (templating.T
 	return templating.TemplatingVariable{}, errors.Errorf("invalid query: %s", query)
 }
 
-func labelValuesSingleLabel(c *client.Client, pmmURL, label string, from, to time.Time) ([]string, error) {
+// labelValuesSingleLabel returns values of the label. A positive limit is passed to VictoriaMetrics and checked on the response,
+// limited is true if the values may be incomplete because of it.
+func labelValuesSingleLabel(c *client.Client, pmmURL, label string, from, to time.Time, limit int) (values []string, limited bool, err error) {
 	q := fasthttp.AcquireArgs()
 	defer fasthttp.ReleaseArgs(q)
 	q.Add("start", strconv.FormatInt(from.Unix(), 10))
 	q.Add("end", strconv.FormatInt(to.Unix(), 10))
+	if limit > 0 {
+		q.Add("limit", strconv.Itoa(limit))
+	}
 
 	status, data, err := c.Get(fmt.Sprintf("%s/prometheus/api/v1/label/%s/values?%s", pmmURL, label, q.String()))
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 	if status != fasthttp.StatusOK {
-		return nil, errors.Errorf("non-ok status: %d", status)
+		return nil, false, errors.Errorf("non-ok status: %d", status)
 	}
 
 	type VMQueryResp struct {
@@ -113,34 +124,40 @@ func labelValuesSingleLabel(c *client.Client, pmmURL, label string, from, to tim
 	}
 	resp := new(VMQueryResp)
 	if err := json.Unmarshal(data, resp); err != nil {
-		return nil, err
+		return nil, false, err
 	}
 
-	return resp.Data, nil
+	values, limited = limitValues(resp.Data, limit)
+	return values, limited, nil
 }
 
-func labelValues(c *client.Client, pmmURL, label, metric string, from, to time.Time, vars []templating.TemplatingVariable) ([]string, error) {
+// labelValues returns values of the label of series matching the metric. A positive limit is passed to VictoriaMetrics
+// as the limit of series and checked on the response, limited is true if the values may be incomplete because of it.
+func labelValues(c *client.Client, pmmURL, label, metric string, from, to time.Time, vars []templating.TemplatingVariable, limit int) (values []string, limited bool, err error) {
 	q := fasthttp.AcquireArgs()
 	defer fasthttp.ReleaseArgs(q)
-	metric, err := templating.InterpolateQuery(metric, from, to, vars)
+	metric, err = templating.InterpolateQuery(metric, from, to, vars)
 	if err != nil {
-		return nil, errors.Wrap(err, "interpolate query")
+		return nil, false, errors.Wrap(err, "interpolate query")
 	}
 	label, err = templating.InterpolateQuery(label, from, to, vars)
 	if err != nil {
-		return nil, errors.Wrap(err, "interpolate query")
+		return nil, false, errors.Wrap(err, "interpolate query")
 	}
 
 	q.Add("match[]", metric)
 	q.Add("start", strconv.FormatInt(from.Unix(), 10))
 	q.Add("end", strconv.FormatInt(to.Unix(), 10))
+	if limit > 0 {
+		q.Add("limit", strconv.Itoa(limit))
+	}
 
 	status, data, err := c.Get(fmt.Sprintf("%s/prometheus/api/v1/series?%s", pmmURL, q.String()))
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 	if status != fasthttp.StatusOK {
-		return nil, errors.Errorf("non-ok status: %d", status)
+		return nil, false, errors.Errorf("non-ok status: %d", status)
 	}
 	type VMQueryResp struct {
 		Status string              `json:"status"`
@@ -148,23 +165,35 @@ func labelValues(c *client.Client, pmmURL, label, metric string, from, to time.T
 	}
 	resp := new(VMQueryResp)
 	if err := json.Unmarshal(data, resp); err != nil {
-		return nil, err
+		return nil, false, err
 	}
-	values := make(map[string]struct{})
+	seriesLimited := limit > 0 && len(resp.Data) >= limit
+	uniqueValues := make(map[string]struct{})
 	for _, metric := range resp.Data {
 		v, ok := metric[label]
 		if !ok {
 			continue
 		}
-		values[v] = struct{}{}
+		uniqueValues[v] = struct{}{}
 	}
 
-	valuesSlice := make([]string, 0, len(values))
-	for v := range values {
+	valuesSlice := make([]string, 0, len(uniqueValues))
+	for v := range uniqueValues {
 		valuesSlice = append(valuesSlice, v)
 	}
 
-	return valuesSlice, nil
+	values, limited = limitValues(valuesSlice, limit)
+	return values, limited || seriesLimited, nil
+}
+
+// limitValues keeps the first limit values in sorted order, so the same values are kept whatever order they are returned in.
+// Reaching the limit is reported as limited, as VictoriaMetrics returns exactly limit values if there are more.
+func limitValues(values []string, limit int) ([]string, bool) {
+	if limit <= 0 || len(values) < limit {
+		return values, false
+	}
+	sort.Strings(values)
+	return values[:limit], true
 }
 
 func queryResult(c *client.Client, pmmURL, query string) ([]string, error) {
@@ -0,0 +1,95 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package expr
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+
+	"pmm-dump/pkg/grafana/client"
+)
+
+func TestLabelValuesLimit(t *testing.T) {
+	var gotLimit string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		gotLimit = req.URL.Query().Get("limit")
+		// The server ignores the limit, so it's enforced by the client
+		switch req.URL.Path {
+		case "/prometheus/api/v1/series":
+			_, _ = rw.Write([]byte(`{"status":"success","data":[{"service_name":"c"},{"service_name":"a"},{"service_name":"a"},{"node_name":"n"},{"service_name":"b"}]}`))
+		case "/prometheus/api/v1/label/service_name/values":
+			_, _ = rw.Write([]byte(`{"status":"success","data":["c","a","b"]}`))
+		default:
+			rw.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	c, err := client.NewClient(&fasthttp.Client{}, client.AuthParams{APIToken: "token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name        string
+		single      bool
+		limit       int
+		want        []string
+		wantLimited bool
+	}{
+		{name: "series no limit", want: []string{"a", "b", "c"}},
+		{name: "series limit above", limit: 6, want: []string{"a", "b", "c"}},
+		{name: "series limit of series", limit: 5, want: []string{"a", "b", "c"}, wantLimited: true},
+		{name: "series limit", limit: 2, want: []string{"a", "b"}, wantLimited: true},
+		{name: "label no limit", single: true, want: []string{"a", "b", "c"}},
+		{name: "label limit above", single: true, limit: 4, want: []string{"a", "b", "c"}},
+		{name: "label limit", single: true, limit: 2, want: []string{"a", "b"}, wantLimited: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			var limited bool
+			var err error
+			if tt.single {
+				got, limited, err = labelValuesSingleLabel(c, server.URL, "service_name", time.Unix(0, 0), time.Unix(60, 0), tt.limit)
+			} else {
+				got, limited, err = labelValues(c, server.URL, "service_name", "up", time.Unix(0, 0), time.Unix(60, 0), nil, tt.limit)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantLimit := ""
+			if tt.limit > 0 {
+				wantLimit = strconv.Itoa(tt.limit)
+			}
+			if gotLimit != wantLimit {
+				t.Fatalf("want limit param %q, got %q", wantLimit, gotLimit)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("want %v, got %v", tt.want, got)
+			}
+			if limited != tt.wantLimited {
+				t.Fatalf("want limited %v, got %v", tt.wantLimited, limited)
+			}
+		})
+	}
+}
@@ -27,7 +27,7 @@ import (
 	"pmm-dump/pkg/grafana/types"
 )
 
-func GetSelectorsFromDashboards(c *client.Client, pmmURL string, dashboardNames, serviceNames []string, from, to time.Time) ([]string, error) {
+func GetSelectorsFromDashboards(c *client.Client, pmmURL string, dashboardNames, serviceNames []string, from, to time.Time, labelValuesLimit int) ([]string, error) {
 	selectorMap := make(map[string]struct{})
 
 	for _, name := range dashboardNames {
@@ -35,7 +35,7 @@ func GetSelectorsFromDashboards(c *client.Client, pmmURL string, dashboardNames,
 		if err != nil {
 			return nil, errors.Wrapf(err, "get dashboard: %s", name)
 		}
-		sel, err := getSelectorsFromDashboard(c, pmmURL, dashboard, serviceNames, from, to)
+		sel, err := getSelectorsFromDashboard(c, pmmURL, dashboard, serviceNames, from, to, labelValuesLimit)
 		if err != nil {
 			return nil, errors.Wrap(err, "get selectors from dashboard")
 		}
@@ -51,8 +51,8 @@ func GetSelectorsFromDashboards(c *client.Client, pmmURL string, dashboardNames,
 	return selectors, nil
 }
 
-func getSelectorsFromDashboard(c *client.Client, pmmURL string, dashboard types.DashboardPanel, serviceNames []string, from, to time.Time) ([]string, error) {
-	parser := expr.NewVMParser(dashboard, serviceNames, c, pmmURL, from, to)
+func getSelectorsFromDashboard(c *client.Client, pmmURL string, dashboard types.DashboardPanel, serviceNames []string, from, to time.Time, labelValuesLimit int) ([]string, error) {
+	parser := expr.NewVMParser(dashboard, serviceNames, c, pmmURL, from, to, labelValuesLimit)
 	selectors, err := parser.GetSelectors(dashboard)
 	if err != nil {
 		return nil, errors.Wrap(err, "get selectors")
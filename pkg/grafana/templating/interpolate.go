@@ -15,7 +15,6 @@
 package templating
 
 import (
-	"regexp"
 	"strings"
 	"time"
 
@@ -110,30 +109,36 @@ func findVariable(name string, vars []TemplatingVariable) (TemplatingVariable, b
 	return TemplatingVariable{}, false
 }
 
+// allValueRegex is the regex matching all values, which variables with "All" option selected are expanded to.
+const allValueRegex = ".*"
+
+// hasAllValue checks if values contain Grafana's "All" option sentinel.
+func hasAllValue(values []string) bool {
+	for _, v := range values {
+		if v == "$__all" || v == "__all" {
+			return true
+		}
+	}
+	return false
+}
+
 func (v TemplatingVariable) Interpolate(format template.VariableFormat) (string, error) {
 	if format == "" {
 		format = template.FormatPipe
 	}
 	values := v.Values
-	if v.Model.Regex != nil && *v.Model.Regex != "" {
-		pattern := *v.Model.Regex
-		firstSlash := strings.IndexByte(pattern, '/')
-		lastSlash := strings.LastIndexByte(pattern, '/')
-		pattern = strings.TrimSpace(pattern[firstSlash+1 : lastSlash])
-		r, err := regexp.Compile(pattern)
-		if err != nil {
-			return "", errors.Errorf("failed to compile regexp: %s", *v.Model.Regex)
+	if hasAllValue(values) {
+		if v.Model.AllValue != nil && *v.Model.AllValue != "" {
+			return *v.Model.AllValue, nil
 		}
-
-		var filteredValues []string
-		for _, v := range values {
-			if r.FindString(v) == "" {
-				continue
-			}
-			filteredValues = append(filteredValues, r.FindString(v))
+		return allValueRegex, nil
+	}
+	if v.Model.Regex != nil {
+		var err error
+		values, err = FilterValuesByRegex(values, *v.Model.Regex)
+		if err != nil {
+			return "", err
 		}
-
-		values = filteredValues
 	}
 	if len(values) == 0 {
 		if v.Model.IncludeAll == nil || !*v.Model.IncludeAll {
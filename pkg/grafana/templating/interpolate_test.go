@@ -0,0 +1,79 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package templating
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"pmm-dump/pkg/grafana/types"
+)
+
+func TestInterpolateAllValue(t *testing.T) {
+	multi, includeAll := true, true
+
+	tests := []struct {
+		name     string
+		values   []string
+		allValue *string
+		want     string
+	}{
+		{
+			name:   "multiple values",
+			values: []string{"mysql-1", "mysql-2"},
+			want:   `up{service_name=~"mysql-1|mysql-2"}`,
+		},
+		{
+			name:   "all",
+			values: []string{"$__all"},
+			want:   `up{service_name=~".*"}`,
+		},
+		{
+			name:   "all with other values",
+			values: []string{"mysql-1", "__all"},
+			want:   `up{service_name=~".*"}`,
+		},
+		{
+			name:     "custom all value",
+			values:   []string{"$__all"},
+			allValue: ptr("mysql-.*"),
+			want:     `up{service_name=~"mysql-.*"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := TemplatingVariable{
+				Model: types.VariableModel{
+					Name:       "service",
+					Multi:      &multi,
+					IncludeAll: &includeAll,
+					AllValue:   tt.allValue,
+				},
+				Values: tt.values,
+			}
+			got, err := InterpolateQuery(`up{service_name=~"$service"}`, time.Now().Add(-time.Hour), time.Now(), []TemplatingVariable{v})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("want %s, got %s", tt.want, got)
+			}
+			if _, err := regexp.Compile(got[len(`up{service_name=~"`) : len(got)-2]); err != nil {
+				t.Fatalf("invalid regex in %s: %v", got, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package templating
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// FilterValuesByRegex returns the values matching the variable regex in Grafana format, ex. "/^mongo.*/".
+// If the regex has capture groups, the first group is returned instead of the whole match.
+func FilterValuesByRegex(values []string, regexPattern string) ([]string, error) {
+	if regexPattern == "" {
+		return values, nil
+	}
+
+	pattern := regexPattern
+	firstSlash := strings.IndexByte(pattern, '/')
+	lastSlash := strings.LastIndexByte(pattern, '/')
+	if firstSlash >= 0 && lastSlash > firstSlash {
+		pattern = pattern[firstSlash+1 : lastSlash]
+	}
+	r, err := regexp.Compile(strings.TrimSpace(pattern))
+	if err != nil {
+		return nil, errors.Errorf("failed to compile regexp: %s", regexPattern)
+	}
+
+	var filteredValues []string
+	for _, v := range values {
+		match := r.FindStringSubmatch(v)
+		if match == nil {
+			continue
+		}
+		value := match[0]
+		if len(match) > 1 {
+			value = match[1]
+		}
+		if value == "" {
+			continue
+		}
+		filteredValues = append(filteredValues, value)
+	}
+	return filteredValues, nil
+}
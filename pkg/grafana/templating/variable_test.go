@@ -0,0 +1,91 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package templating
+
+import (
+	"reflect"
+	"testing"
+
+	"pmm-dump/pkg/grafana/types"
+)
+
+func TestFilterValuesByRegex(t *testing.T) {
+	values := []string{"mongo-1", "mongo-2", "mysql-1"}
+
+	tests := []struct {
+		name      string
+		regex     *string
+		want      []string
+		shouldErr bool
+	}{
+		{
+			name: "nil regex",
+			want: values,
+		},
+		{
+			name:  "empty regex",
+			regex: ptr(""),
+			want:  values,
+		},
+		{
+			name:  "matching some values",
+			regex: ptr("/^mongo.*/"),
+			want:  []string{"mongo-1", "mongo-2"},
+		},
+		{
+			name:  "matching no values",
+			regex: ptr("/^postgres.*/"),
+			want:  nil,
+		},
+		{
+			name:      "invalid regex",
+			regex:     ptr("/mongo-[/"),
+			shouldErr: true,
+		},
+		{
+			name:  "capture groups",
+			regex: ptr("/^[a-z]+-([0-9]+)$/"),
+			want:  []string{"1", "2", "1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := TemplatingVariable{
+				Model:  types.VariableModel{Regex: tt.regex},
+				Values: values,
+			}
+			var got []string
+			var err error
+			if v.Model.Regex == nil {
+				got = v.Values
+			} else {
+				got, err = FilterValuesByRegex(v.Values, *v.Model.Regex)
+			}
+			if err != nil && !tt.shouldErr {
+				t.Fatal(err)
+			}
+			if err == nil && tt.shouldErr {
+				t.Fatal("should be error")
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("want %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func ptr(s string) *string {
+	return &s
+}
@@ -0,0 +1,202 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	s3Scheme        = "s3://"
+	s3DefaultRegion = "us-east-1"
+	// s3PartSize is the size of multipart upload parts. The size of the uploaded dump is unknown, so it limits
+	// the dump to 10000 parts, i.e. 625 GiB. Every concurrent part upload holds the part in memory.
+	s3PartSize          = 64 << 20
+	s3UploadConcurrency = 2
+)
+
+// errS3UploadAborted is read by the uploader from the aborted upload, so it discards the uploaded parts.
+var errS3UploadAborted = errors.New("upload is aborted")
+
+// ParseS3URL returns the bucket and the key of the `s3://bucket/key` URL. It returns false if s isn't an S3 URL.
+func ParseS3URL(s string) (bucket, key string, ok bool) {
+	if !strings.HasPrefix(s, s3Scheme) {
+		return "", "", false
+	}
+	bucket, key, _ = strings.Cut(strings.TrimPrefix(s, s3Scheme), "/")
+	return bucket, key, true
+}
+
+// S3Config is the configuration of the S3 client. Credentials and the region are loaded by the AWS SDK
+// default chain: environment variables, shared config and credentials files with profiles, web identity and IMDS.
+type S3Config struct {
+	// Endpoint is the URL of the S3-compatible storage, which path-style requests are sent to.
+	// AWS S3 of the region is used, if it's empty.
+	Endpoint string
+
+	InsecureSkipVerify bool
+}
+
+// S3Client reads and writes S3 objects.
+type S3Client struct {
+	c        *s3.Client
+	partSize int64
+}
+
+func NewS3Client(ctx context.Context, cfg S3Config) (*S3Client, error) {
+	var opts []func(*config.LoadOptions) error
+	if cfg.InsecureSkipVerify {
+		httpC := awshttp.NewBuildableClient().WithTransportOptions(func(tr *http.Transport) {
+			tr.TLSClientConfig.InsecureSkipVerify = true //nolint:gosec
+		})
+		opts = append(opts, config.WithHTTPClient(httpC))
+	}
+	awsCfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load AWS config")
+	}
+	if awsCfg.Region == "" {
+		awsCfg.Region = s3DefaultRegion
+	}
+	c := s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		if cfg.Endpoint != "" {
+			o.BaseEndpoint = aws.String(cfg.Endpoint)
+			o.UsePathStyle = true
+		}
+	})
+	return &S3Client{c: c, partSize: s3PartSize}, nil
+}
+
+// Open returns the reader of the object content, which is streamed from the storage.
+func (c *S3Client) Open(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	if err := checkS3Object(bucket, key); err != nil {
+		return nil, err
+	}
+	out, err := c.c.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to download s3://%s/%s", bucket, key)
+	}
+	return out.Body, nil
+}
+
+// ReadTail reads the last length bytes of the object content, or the whole content if the object is smaller.
+// It returns the size of the object too.
+func (c *S3Client) ReadTail(ctx context.Context, bucket, key string, length int64) ([]byte, int64, error) {
+	if err := checkS3Object(bucket, key); err != nil {
+		return nil, 0, err
+	}
+	out, err := c.c.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Range:  aws.String(fmt.Sprintf("bytes=-%d", length)),
+	})
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "failed to read s3://%s/%s", bucket, key)
+	}
+	defer out.Body.Close() //nolint:errcheck
+	content, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "failed to read s3://%s/%s", bucket, key)
+	}
+	if out.ContentRange == nil {
+		// The whole content is returned if the storage ignores the range
+		return content, int64(len(content)), nil
+	}
+	// Content-Range is `bytes <first>-<last>/<size>`
+	_, total, _ := strings.Cut(*out.ContentRange, "/")
+	size, err := strconv.ParseInt(total, 10, 64)
+	if err != nil {
+		return nil, 0, errors.Errorf("failed to read s3://%s/%s: invalid Content-Range %q", bucket, key, *out.ContentRange)
+	}
+	return content, size, nil
+}
+
+// Create starts the upload of the object. The content is uploaded in parts while it's written,
+// so it's not held in memory. The object is created on Close, and Abort discards the uploaded parts.
+func (c *S3Client) Create(ctx context.Context, bucket, key string) (*S3Writer, error) {
+	if err := checkS3Object(bucket, key); err != nil {
+		return nil, err
+	}
+	uploader := manager.NewUploader(c.c, func(u *manager.Uploader) {
+		u.PartSize = c.partSize
+		u.Concurrency = s3UploadConcurrency
+	})
+	pr, pw := io.Pipe()
+	w := &S3Writer{pw: pw, done: make(chan struct{})}
+	go func() {
+		defer close(w.done)
+		_, err := uploader.Upload(ctx, &s3.PutObjectInput{Bucket: aws.String(bucket), Key: aws.String(key), Body: pr})
+		if err != nil {
+			w.err = errors.Wrapf(err, "failed to upload s3://%s/%s", bucket, key)
+		} else {
+			log.Debug().Msgf("Uploaded s3://%s/%s", bucket, key)
+		}
+		// The uploader has aborted the failed upload already, so writes to it fail too
+		_ = pr.CloseWithError(w.err)
+	}()
+	return w, nil
+}
+
+// S3Writer uploads the object content written to it.
+type S3Writer struct {
+	pw      *io.PipeWriter
+	done    chan struct{}
+	err     error
+	aborted bool
+}
+
+// Write returns the error of the upload, if it has failed.
+func (w *S3Writer) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+// Close uploads the rest of the content and completes the upload, creating the object.
+// It returns the error of the upload, so the failed upload is never completed.
+func (w *S3Writer) Close() error {
+	if w.aborted {
+		return nil
+	}
+	_ = w.pw.Close()
+	<-w.done
+	return w.err
+}
+
+// Abort discards the uploaded parts, so the object isn't created.
+func (w *S3Writer) Abort() error {
+	w.aborted = true
+	_ = w.pw.CloseWithError(errS3UploadAborted)
+	<-w.done
+	return nil
+}
+
+func checkS3Object(bucket, key string) error {
+	if bucket == "" || key == "" {
+		return errors.Errorf("invalid S3 object s3://%s/%s: bucket and key are required", bucket, key)
+	}
+	return nil
+}
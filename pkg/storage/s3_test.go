@@ -0,0 +1,330 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+)
+
+func TestParseS3URL(t *testing.T) {
+	tests := []struct {
+		url    string
+		bucket string
+		key    string
+		ok     bool
+	}{
+		{url: "s3://bucket/dumps/dump.tar.gz", bucket: "bucket", key: "dumps/dump.tar.gz", ok: true},
+		{url: "s3://bucket/dumps/", bucket: "bucket", key: "dumps/", ok: true},
+		{url: "s3://bucket", bucket: "bucket", ok: true},
+		{url: "dump.tar.gz"},
+		{url: "/tmp/s3://bucket/dump.tar.gz"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			bucket, key, ok := ParseS3URL(tt.url)
+			if bucket != tt.bucket || key != tt.key || ok != tt.ok {
+				t.Fatalf("want %q, %q, %v, got %q, %q, %v", tt.bucket, tt.key, tt.ok, bucket, key, ok)
+			}
+		})
+	}
+}
+
+// fakeS3 is the S3 storage with one bucket, which supports requests of S3Client.
+type fakeS3 struct {
+	bucket string
+
+	mu      sync.Mutex
+	objects map[string][]byte
+	uploads map[string]map[int][]byte
+	parts   int
+	aborted int
+	// failPart is the number of the part, which upload fails
+	failPart int
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ") {
+		s.error(w, http.StatusForbidden, "AccessDenied", "Access Denied")
+		return
+	}
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != s.bucket {
+		s.error(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	query := r.URL.Query()
+	uploadID := query.Get("uploadId")
+
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		uploadID = strconv.Itoa(len(s.uploads) + 1)
+		s.uploads[uploadID] = make(map[int][]byte)
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><UploadId>%s</UploadId></InitiateMultipartUploadResult>", uploadID)
+	case r.Method == http.MethodPut && uploadID != "":
+		number, _ := strconv.Atoi(query.Get("partNumber"))
+		if number == s.failPart {
+			s.error(w, http.StatusBadRequest, "InvalidPart", "One or more of the specified parts could not be found.")
+			return
+		}
+		s.uploads[uploadID][number] = body
+		s.parts++
+		w.Header().Set("ETag", fmt.Sprintf("%q", strconv.Itoa(number)))
+	case r.Method == http.MethodPut:
+		s.objects[key] = body
+		w.Header().Set("ETag", `"object"`)
+	case r.Method == http.MethodPost && uploadID != "":
+		parts := s.uploads[uploadID]
+		numbers := make([]int, 0, len(parts))
+		for number := range parts {
+			numbers = append(numbers, number)
+		}
+		sort.Ints(numbers)
+		var content []byte
+		for _, number := range numbers {
+			content = append(content, parts[number]...)
+		}
+		s.objects[key] = content
+		delete(s.uploads, uploadID)
+		fmt.Fprint(w, "<CompleteMultipartUploadResult></CompleteMultipartUploadResult>")
+	case r.Method == http.MethodDelete && uploadID != "":
+		delete(s.uploads, uploadID)
+		s.aborted++
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		content, ok := s.objects[key]
+		if !ok {
+			s.error(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+			return
+		}
+		http.ServeContent(w, r, key, time.Time{}, bytes.NewReader(content))
+	default:
+		s.error(w, http.StatusNotImplemented, "NotImplemented", r.Method)
+	}
+}
+
+func (s *fakeS3) error(w http.ResponseWriter, status int, code, message string) {
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, message)
+}
+
+func newFakeS3Client(t *testing.T) (*S3Client, *fakeS3) {
+	t.Helper()
+	s := &fakeS3{
+		bucket:  "dumps",
+		objects: make(map[string][]byte),
+		uploads: make(map[string]map[int][]byte),
+	}
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	// The credentials are loaded by the default chain, so local AWS config must not be used
+	dir := t.TempDir()
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+	t.Setenv("AWS_ACCESS_KEY_ID", "key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_REGION", "")
+	t.Setenv("AWS_DEFAULT_REGION", "")
+	c, err := NewS3Client(context.Background(), S3Config{Endpoint: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.partSize = manager.MinUploadPartSize
+	return c, s
+}
+
+func TestS3Client(t *testing.T) {
+	ctx := context.Background()
+	c, s := newFakeS3Client(t)
+
+	content := bytes.Repeat([]byte("pmm-dump"), 1<<20+1000)
+	w, err := c.Create(ctx, "dumps", "dir/dump.tar.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range [][]byte{content[:10], content[10 : 6<<20], content[6<<20:]} {
+		if _, err := w.Write(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if s.parts != 2 {
+		t.Fatalf("want 2 parts, got %d", s.parts)
+	}
+
+	r, err := c.Open(ctx, "dumps", "dir/dump.tar.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(r)
+	_ = r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("want %d bytes, got %d", len(content), len(got))
+	}
+
+	tail, size, err := c.ReadTail(ctx, "dumps", "dir/dump.tar.gz", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(tail, content[len(content)-10:]) || size != int64(len(content)) {
+		t.Fatalf("want tail %q of %d bytes, got %q of %d bytes", content[len(content)-10:], len(content), tail, size)
+	}
+	tail, size, err = c.ReadTail(ctx, "dumps", "dir/dump.tar.gz", int64(len(content))+10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(tail, content) || size != int64(len(content)) {
+		t.Fatalf("want whole content of %d bytes, got %d of %d bytes", len(content), len(tail), size)
+	}
+
+	w, err = c.Create(ctx, "dumps", "failed.tar.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Abort(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.objects["failed.tar.gz"]; ok || s.aborted != 1 {
+		t.Fatalf("want aborted upload, got %d aborted uploads", s.aborted)
+	}
+}
+
+func TestS3WriterFailedPart(t *testing.T) {
+	ctx := context.Background()
+	c, s := newFakeS3Client(t)
+	s.failPart = 2
+
+	w, err := c.Create(ctx, "dumps", "dump.tar.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The parts are uploaded concurrently, so writes fail after the upload of the failed part returns
+	content := bytes.Repeat([]byte("pmm-dump"), 1<<17)
+	for i := 0; ; i++ {
+		if i == 100 {
+			t.Fatal("should be error")
+		}
+		if _, err := w.Write(content); err != nil {
+			break
+		}
+	}
+	if _, err := w.Write(content); err == nil {
+		t.Fatal("write after failed part should be error")
+	}
+	if err := w.Close(); err == nil {
+		t.Fatal("close after failed part should be error")
+	}
+	if _, ok := s.objects["dump.tar.gz"]; ok || s.aborted != 1 {
+		t.Fatalf("want aborted upload, got %d aborted uploads", s.aborted)
+	}
+}
+
+func TestS3ClientErrors(t *testing.T) {
+	ctx := context.Background()
+	c, _ := newFakeS3Client(t)
+
+	tests := []struct {
+		name string
+		do   func() error
+		want string
+	}{
+		{
+			name: "missing bucket",
+			do: func() error {
+				_, err := c.Open(ctx, "missing", "dump.tar.gz")
+				return err
+			},
+			want: "NoSuchBucket: The specified bucket does not exist",
+		},
+		{
+			name: "missing key",
+			do: func() error {
+				_, err := c.Open(ctx, "dumps", "missing.tar.gz")
+				return err
+			},
+			want: "NoSuchKey",
+		},
+		{
+			name: "missing key tail",
+			do: func() error {
+				_, _, err := c.ReadTail(ctx, "dumps", "missing.tar.gz", 10)
+				return err
+			},
+			want: "NoSuchKey",
+		},
+		{
+			name: "upload to missing bucket",
+			do: func() error {
+				w, err := c.Create(ctx, "missing", "dump.tar.gz")
+				if err != nil {
+					return err
+				}
+				if _, err := w.Write([]byte("pmm-dump")); err != nil {
+					return err
+				}
+				return w.Close()
+			},
+			want: "NoSuchBucket",
+		},
+		{
+			name: "empty key",
+			do: func() error {
+				_, err := c.Open(ctx, "dumps", "")
+				return err
+			},
+			want: "bucket and key are required",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.do()
+			if err == nil {
+				t.Fatal("should be error")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("want error with %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
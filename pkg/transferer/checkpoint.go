@@ -0,0 +1,156 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transferer
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"pmm-dump/pkg/dump"
+)
+
+// ExportCheckpoint is the progress of export saved to a file, so a failed export can be resumed from it
+// by appending the chunks not exported yet to the partial dump.
+type ExportCheckpoint struct {
+	DumpPath    string    `json:"dump-path"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	Compression string    `json:"compression"`
+	// Chunks are all chunks of the export, so the resumed export has the same chunks.
+	Chunks []dump.ChunkMeta `json:"chunks"`
+	// Completed are names of chunks written to the first Size bytes of the dump, see chunkName.
+	Completed []string `json:"completed,omitempty"`
+	// Size is the size of the dump with completed chunks. The rest of the partial dump is cut on resume.
+	Size         int64 `json:"size"`
+	MaxChunkSize int64 `json:"max-chunk-size,omitempty"`
+	// ChunkChecksums are checksums of the written chunks, if the export stores them in the meta.
+	ChunkChecksums map[string]string `json:"chunk-checksums,omitempty"`
+	// QANRowsCapped is set if QAN chunks are capped by the max rows, as it can't be known from the chunks.
+	QANRowsCapped bool `json:"qan-rows-capped,omitempty"`
+}
+
+// IsCompleted checks if the chunk is written to the dump according to the checkpoint.
+func (c ExportCheckpoint) IsCompleted(m dump.ChunkMeta) bool {
+	name := chunkName(m)
+	for _, completed := range c.Completed {
+		if completed == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadExportCheckpoint reads the checkpoint file. It returns nil if the file doesn't exist.
+func ReadExportCheckpoint(filename string) (*ExportCheckpoint, error) {
+	content, err := os.ReadFile(filename) //nolint:gosec
+	if os.IsNotExist(err) {
+		return nil, nil //nolint:nilnil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read checkpoint")
+	}
+	cp := new(ExportCheckpoint)
+	if err := json.Unmarshal(content, cp); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal checkpoint")
+	}
+	return cp, nil
+}
+
+// WriteExportCheckpoint writes the checkpoint to a temporary file and renames it, so the file is never partially written.
+func WriteExportCheckpoint(filename string, cp ExportCheckpoint) error {
+	content, err := json.Marshal(cp)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal checkpoint")
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return errors.Wrap(err, "failed to create checkpoint")
+	}
+	defer os.Remove(tmp.Name()) //nolint:errcheck
+	if _, err := tmp.Write(content); err != nil {
+		_ = tmp.Close()
+		return errors.Wrap(err, "failed to write checkpoint")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "failed to close checkpoint")
+	}
+	if err := os.Rename(tmp.Name(), filename); err != nil {
+		return errors.Wrap(err, "failed to rename checkpoint")
+	}
+	return nil
+}
+
+// checkpointer saves the checkpoint after chunks are written.
+// Parts of split chunks are written in any order, so it's saved only when no chunk is written partially.
+type checkpointer struct {
+	cp       ExportCheckpoint
+	filename string
+	file     *countingWriter
+	// parts are the numbers of parts left to write of partially written chunks.
+	parts map[string]int
+}
+
+func newCheckpointer(file io.Writer, cp ExportCheckpoint, filename string) *checkpointer {
+	return &checkpointer{
+		cp:       cp,
+		filename: filename,
+		file:     &countingWriter{w: file, n: cp.Size},
+		parts:    make(map[string]int),
+	}
+}
+
+// written marks the part of the chunk as written. When all written chunks are complete, it ends the compressed stream
+// of the dump and saves the checkpoint with the dump size.
+func (c *checkpointer) written(w *dump.Writer, chunk *dump.Chunk, maxChunkSize int64, checksums map[string]string) error {
+	name := chunkName(chunk.ChunkMeta)
+	left, ok := c.parts[name]
+	if !ok {
+		left = max(chunk.Parts, 1)
+	}
+	if left--; left > 0 {
+		c.parts[name] = left
+	} else {
+		delete(c.parts, name)
+		c.cp.Completed = append(c.cp.Completed, name)
+	}
+	if len(c.parts) > 0 {
+		return nil
+	}
+
+	if err := w.Checkpoint(); err != nil {
+		return err
+	}
+	c.cp.Size = c.file.n
+	c.cp.MaxChunkSize = maxChunkSize
+	c.cp.ChunkChecksums = checksums
+	return WriteExportCheckpoint(c.filename, c.cp)
+}
+
+// countingWriter counts the size of the dump, starting from the size of the partial dump it appends to.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,43 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transferer
+
+import (
+	"github.com/pkg/errors"
+)
+
+// checksumVerifier verifies imported chunks against the checksums of the dump meta, which is read before import.
+type checksumVerifier struct {
+	expected map[string]string
+}
+
+func newChecksumVerifier(expected map[string]string) (*checksumVerifier, error) {
+	if len(expected) == 0 {
+		return nil, errors.New("no chunk checksums to verify: the dump meta with chunk checksums should be read before import")
+	}
+	return &checksumVerifier{expected: expected}, nil
+}
+
+// verify checks the checksum of the chunk at the path in the dump.
+func (v *checksumVerifier) verify(name, checksum string) error {
+	want, ok := v.expected[name]
+	if !ok {
+		return errors.Errorf("chunk %s has no checksum in the dump meta", name)
+	}
+	if checksum != want {
+		return errors.Errorf("chunk %s is corrupted: its checksum %s doesn't match %s of the dump meta", name, checksum, want)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transferer
+
+import "testing"
+
+func TestChecksumVerifier(t *testing.T) {
+	expected := map[string]string{"vm/1-2.bin": "a", "vm/2-3.bin": "b"}
+	tests := []struct {
+		name      string
+		expected  map[string]string
+		read      map[string]string
+		shouldErr bool
+	}{
+		{name: "verified", expected: expected, read: map[string]string{"vm/1-2.bin": "a", "vm/2-3.bin": "b"}},
+		{name: "mismatch", expected: expected, read: map[string]string{"vm/1-2.bin": "b"}, shouldErr: true},
+		{name: "unknown chunk", expected: expected, read: map[string]string{"vm/3-4.bin": "c"}, shouldErr: true},
+		{name: "no checksums", expected: map[string]string{}, shouldErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := func() error {
+				v, err := newChecksumVerifier(tt.expected)
+				if err != nil {
+					return err
+				}
+				for name, checksum := range tt.read {
+					if err := v.verify(name, checksum); err != nil {
+						return err
+					}
+				}
+				return nil
+			}()
+			if tt.shouldErr {
+				if err == nil {
+					t.Fatal("should be error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
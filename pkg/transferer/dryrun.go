@@ -0,0 +1,138 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transferer
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+
+	"pmm-dump/pkg/dump"
+)
+
+// ExportEstimate is the estimated size of the chunks of one source, see EstimateExport.
+type ExportEstimate struct {
+	Source dump.SourceType
+	Chunks int
+	// NoData reports whether the source has no data matching the filters in the time range of its chunks,
+	// so no chunk is sampled.
+	NoData bool
+	// SampleSize is the size of the first chunk of the source, as it would be written to the dump.
+	SampleSize int64
+	// SampleUncompressedSize is the size of the first chunk content, decompressed if the source gzips it.
+	SampleUncompressedSize int64
+	// SampleEmpty reports whether the first chunk has no data, so the estimate is likely too low.
+	SampleEmpty bool
+}
+
+// Size returns the estimated size of all chunks of the source: the sample size times the chunks count.
+func (e ExportEstimate) Size() int64 {
+	return e.SampleSize * int64(e.Chunks)
+}
+
+// UncompressedSize returns the estimated size of the decompressed content of all chunks of the source.
+func (e ExportEstimate) UncompressedSize() int64 {
+	return e.SampleUncompressedSize * int64(e.Chunks)
+}
+
+// metricsChecker is implemented by sources which can check for data matching the filters within a time range.
+type metricsChecker interface {
+	HasMetrics(start, end time.Time) (bool, error)
+}
+
+// EstimateExport estimates the export without writing the dump: it counts the chunks of every source
+// and reads the first chunk of every source as the sample of the chunk size. Sources without data
+// in the time range of their chunks aren't sampled. Estimates are in the order of sources.
+func EstimateExport(ctx context.Context, sources []dump.Source, chunks []dump.ChunkMeta) ([]ExportEstimate, error) {
+	estimates := make([]ExportEstimate, 0, len(sources))
+	for _, s := range sources {
+		e := ExportEstimate{Source: s.Type()}
+		var first *dump.ChunkMeta
+		var start, end time.Time
+		for i, c := range chunks {
+			if c.Source != s.Type() {
+				continue
+			}
+			if first == nil {
+				first = &chunks[i]
+			}
+			if c.Start != nil && (start.IsZero() || c.Start.Before(start)) {
+				start = *c.Start
+			}
+			if c.End != nil && c.End.After(end) {
+				end = *c.End
+			}
+			e.Chunks++
+		}
+		if first == nil {
+			estimates = append(estimates, e)
+			continue
+		}
+		if c, ok := s.(metricsChecker); ok && !start.IsZero() && !end.IsZero() {
+			hasMetrics, err := c.HasMetrics(start, end)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to check %s data", s.Type())
+			}
+			if !hasMetrics {
+				e.NoData = true
+				estimates = append(estimates, e)
+				continue
+			}
+		}
+
+		log.Debug().Msgf("Reading chunk %s to estimate the export size", chunkName(*first))
+		chunk, err := s.ReadChunk(ctx, *first)
+		switch {
+		case errors.Is(err, dump.ErrEmptyChunk):
+			e.SampleEmpty = true
+		case err != nil:
+			return nil, errors.Wrapf(err, "failed to read sample chunk %s", chunkName(*first))
+		default:
+			e.SampleSize = chunk.Len()
+			e.SampleUncompressedSize, err = uncompressedSize(chunk)
+			if closeErr := chunk.Close(); closeErr != nil {
+				log.Debug().Err(closeErr).Msgf("Failed to close sample chunk %s", chunkName(*first))
+			}
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to decompress sample chunk %s", chunkName(*first))
+			}
+		}
+		estimates = append(estimates, e)
+	}
+	return estimates, nil
+}
+
+// uncompressedSize returns the size of the chunk content, decompressed if it's gzipped, ex. core metrics chunks.
+func uncompressedSize(c *dump.Chunk) (int64, error) {
+	r, err := c.Reader()
+	if err != nil {
+		return 0, err
+	}
+	br := bufio.NewReader(r)
+	if header, _ := br.Peek(2); !dump.HasGzipMagic(header) { //nolint:mnd
+		return c.Len(), nil
+	}
+	gzr, err := gzip.NewReader(br)
+	if err != nil {
+		return 0, err
+	}
+	defer gzr.Close() //nolint:errcheck
+	return io.Copy(io.Discard, gzr)
+}
@@ -0,0 +1,150 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transferer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"pmm-dump/pkg/dump"
+)
+
+// emptySource is the source which has no data in the chunks.
+type emptySource struct {
+	fakeSource
+}
+
+func (s emptySource) ReadChunk(context.Context, dump.ChunkMeta) (*dump.Chunk, error) {
+	return nil, dump.ErrEmptyChunk
+}
+
+// noDataSource is the source which has no data matching the filters.
+type noDataSource struct {
+	fakeSource
+}
+
+func (s noDataSource) HasMetrics(time.Time, time.Time) (bool, error) {
+	return false, nil
+}
+
+// gzipSource is the source which gzips the chunk content, like VictoriaMetrics.
+type gzipSource struct {
+	fakeSource
+	content []byte
+}
+
+func (s gzipSource) ReadChunk(_ context.Context, m dump.ChunkMeta) (*dump.Chunk, error) {
+	return &dump.Chunk{ChunkMeta: m, Content: s.content, Filename: m.String() + ".bin"}, nil
+}
+
+func TestEstimateExport(t *testing.T) {
+	start := time.Now().Add(-time.Hour)
+	vmChunks := prepareFakeChunks(start, time.Now(), time.Minute, dump.VictoriaMetrics)
+	chChunks := prepareFakeChunks(start, time.Now(), 10*time.Minute, dump.ClickHouse)
+	chunks := append(vmChunks, chChunks...) //nolint:gocritic
+	contentSize := int64(len("content"))
+
+	var gzipped bytes.Buffer
+	gw := gzip.NewWriter(&gzipped)
+	if _, err := gw.Write(bytes.Repeat([]byte("content"), 100)); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		sources   []dump.Source
+		chunks    []dump.ChunkMeta
+		want      []ExportEstimate
+		shouldErr bool
+	}{
+		{
+			name:    "vm and ch",
+			sources: []dump.Source{fakeSource{sourceType: dump.VictoriaMetrics}, fakeSource{sourceType: dump.ClickHouse}},
+			chunks:  chunks,
+			want: []ExportEstimate{
+				{Source: dump.VictoriaMetrics, Chunks: len(vmChunks), SampleSize: contentSize, SampleUncompressedSize: contentSize},
+				{Source: dump.ClickHouse, Chunks: len(chChunks), SampleSize: contentSize, SampleUncompressedSize: contentSize},
+			},
+		},
+		{
+			name:    "no ch chunks",
+			sources: []dump.Source{fakeSource{sourceType: dump.VictoriaMetrics}, fakeSource{sourceType: dump.ClickHouse}},
+			chunks:  vmChunks,
+			want: []ExportEstimate{
+				{Source: dump.VictoriaMetrics, Chunks: len(vmChunks), SampleSize: contentSize, SampleUncompressedSize: contentSize},
+				{Source: dump.ClickHouse},
+			},
+		},
+		{
+			name:    "empty sample",
+			sources: []dump.Source{emptySource{fakeSource{sourceType: dump.VictoriaMetrics}}},
+			chunks:  vmChunks,
+			want:    []ExportEstimate{{Source: dump.VictoriaMetrics, Chunks: len(vmChunks), SampleEmpty: true}},
+		},
+		{
+			name:    "gzipped sample",
+			sources: []dump.Source{gzipSource{fakeSource: fakeSource{sourceType: dump.VictoriaMetrics}, content: gzipped.Bytes()}},
+			chunks:  vmChunks,
+			want: []ExportEstimate{{
+				Source:                 dump.VictoriaMetrics,
+				Chunks:                 len(vmChunks),
+				SampleSize:             int64(gzipped.Len()),
+				SampleUncompressedSize: 100 * contentSize,
+			}},
+		},
+		{
+			name:    "no data",
+			sources: []dump.Source{noDataSource{fakeSource{sourceType: dump.ClickHouse}}},
+			chunks:  chChunks,
+			want:    []ExportEstimate{{Source: dump.ClickHouse, Chunks: len(chChunks), NoData: true}},
+		},
+		{
+			name:      "invalid gzipped sample",
+			sources:   []dump.Source{gzipSource{fakeSource: fakeSource{sourceType: dump.VictoriaMetrics}, content: gzipped.Bytes()[:20]}},
+			chunks:    vmChunks,
+			shouldErr: true,
+		},
+		{
+			name:      "failed sample",
+			sources:   []dump.Source{failingSource{fakeSource: fakeSource{sourceType: dump.VictoriaMetrics}, failAt: *vmChunks[0].Start}},
+			chunks:    vmChunks,
+			shouldErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := EstimateExport(context.Background(), tt.sources, tt.chunks)
+			if tt.shouldErr {
+				if err == nil {
+					t.Fatal("should be error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("want %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
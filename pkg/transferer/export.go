@@ -15,12 +15,13 @@
 package transferer
 
 import (
-	"archive/tar"
 	"bytes"
-	"compress/gzip"
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"path"
+	"sort"
 	"sync"
 	"time"
 
@@ -31,7 +32,106 @@ import (
 	"pmm-dump/pkg/dump"
 )
 
-func (t Transferer) Export(ctx context.Context, lc LoadStatusGetter, meta dump.Meta, pool ChunkPool, logBuffer *bytes.Buffer) error {
+type ExportOptions struct {
+	// HeadFiles are additional non-chunk files stored before chunks, so they are in partial dumps too, ex. export filters.
+	HeadFiles []dump.File
+	// Files are additional non-chunk files to be stored in the dump.
+	Files []dump.File
+	// ChunkStats enables writing per-chunk statistics of each source to the dump.
+	ChunkStats bool
+	// PrintLoadInterval enables logging the current load values at this interval.
+	PrintLoadInterval time.Duration
+	// ProgressInterval enables logging the export progress at this interval, if the chunk pool is a ProgressReporter.
+	ProgressInterval time.Duration
+	// MaxInFlightBytes limits the total size of chunks read from sources, but not written to the dump yet.
+	// Every reading worker can hold one more chunk over the limit while it waits. 0 means no limit.
+	MaxInFlightBytes int64
+	// ChunkDeadline limits the reading time of a single chunk. Chunks read longer are skipped and listed in the meta.
+	// 0 means no limit.
+	ChunkDeadline time.Duration
+	// WriteBufferSize is the size of the buffer the dump is written through. 0 disables buffering.
+	WriteBufferSize int
+	// RawStream writes the raw dump stream instead of the compressed tar archive, see dump.RawMagic.
+	RawStream bool
+	// Compression is the compression of the tar archive. The zero value is dump.DefaultCompression.
+	Compression dump.Compression
+	// ChunkChecksums enables storing checksums of chunks in the meta, so import can verify them.
+	ChunkChecksums bool
+	// OnChunkWritten is called after every chunk is written and flushed to the dump, ex. to save the export progress.
+	OnChunkWritten func(dump.ChunkMeta) error
+	// Checkpoint is the progress of the export, which is saved to CheckpointFile after chunks are written.
+	// If it has completed chunks, the file of the transferer should be the partial dump truncated to the checkpoint size.
+	Checkpoint     *ExportCheckpoint
+	CheckpointFile string
+	// Partial is the partial dump of the resumed export. Its kept chunks are copied to the dump before new ones.
+	Partial *PartialDump
+}
+
+// Aborter is implemented by dump files which discard the written content instead of closing, ex. S3 uploads.
+type Aborter interface {
+	Abort() error
+}
+
+// Export writes the dump to the file of the transferer. The file is closed after export, if it's an io.Closer:
+// some file systems, ex. NFS, report errors of the last writes only on close, and the dump would be silently truncated.
+// If export fails, the file is aborted instead, if it's an Aborter.
+func (t Transferer) Export(ctx context.Context, lc LoadStatusGetter, meta dump.Meta, pool ChunkPool, logBuffer *bytes.Buffer, opts ExportOptions) error {
+	err := t.export(ctx, t.file, lc, meta, pool, logBuffer, opts)
+	if a, ok := t.file.(Aborter); ok && err != nil {
+		if abortErr := a.Abort(); abortErr != nil {
+			log.Error().Err(abortErr).Msg("Failed to abort the dump file")
+		}
+		return err
+	}
+	if c, ok := t.file.(io.Closer); ok {
+		if closeErr := c.Close(); closeErr != nil && err == nil {
+			return errors.Wrap(closeErr, "failed to close the dump file")
+		}
+	}
+	return err
+}
+
+// ExportToReader runs export in background and returns the reader of the dump stream.
+// Closing the reader or canceling the context stops the export.
+func (t Transferer) ExportToReader(ctx context.Context, lc LoadStatusGetter, meta dump.Meta, pool ChunkPool, logBuffer *bytes.Buffer, opts ExportOptions) (io.ReadCloser, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		pw.CloseWithError(t.export(ctx, pw, lc, meta, pool, logBuffer, opts))
+	}()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			pw.CloseWithError(ctx.Err())
+		case <-done:
+		}
+	}()
+
+	return &exportReader{
+		PipeReader: pr,
+		cancel:     cancel,
+		done:       done,
+	}, nil
+}
+
+type exportReader struct {
+	*io.PipeReader
+	cancel context.CancelFunc
+	done   <-chan struct{}
+}
+
+func (r *exportReader) Close() error {
+	r.cancel()
+	err := r.PipeReader.Close()
+	<-r.done
+	return err
+}
+
+func (t Transferer) export(ctx context.Context, file io.Writer, lc LoadStatusGetter, meta dump.Meta, pool ChunkPool, logBuffer *bytes.Buffer, opts ExportOptions) error {
 	log.Info().Msg("Exporting metrics...")
 
 	chunksCh := make(chan *dump.Chunk, maxChunksInMem)
@@ -41,6 +141,16 @@ func (t Transferer) Export(ctx context.Context, lc LoadStatusGetter, meta dump.M
 
 	var readWG sync.WaitGroup
 	g, gCtx := errgroup.WithContext(ctx)
+	ll := newLoadValuesLogger(lc, opts.PrintLoadInterval)
+	il := newInflightLimiter(opts.MaxInFlightBytes)
+	skipped := new(skippedChunks)
+
+	if pr, ok := pool.(ProgressReporter); ok && opts.ProgressInterval > 0 {
+		defer logProgress(opts.ProgressInterval, func() string {
+			done, total, elapsed := pr.Progress()
+			return progressMessage("Export", int64(done), int64(total), "chunks", elapsed)
+		})()
+	}
 
 	log.Debug().Msgf("Starting %d goroutines to read chunks from sources...", t.workersCount)
 	readWG.Add(t.workersCount)
@@ -49,7 +159,7 @@ func (t Transferer) Export(ctx context.Context, lc LoadStatusGetter, meta dump.M
 			defer log.Debug().Msgf("Exiting from read chunks goroutine")
 			defer readWG.Done()
 
-			if err := t.readChunksFromSource(gCtx, lc, pool, chunksCh); err != nil {
+			if err := t.readChunksFromSource(gCtx, lc, ll, il, pool, chunksCh, opts.ChunkDeadline, skipped); err != nil {
 				return errors.Wrap(err, "failed to read chunks from source")
 			}
 			return nil
@@ -66,7 +176,7 @@ func (t Transferer) Export(ctx context.Context, lc LoadStatusGetter, meta dump.M
 	log.Debug().Msg("Starting single goroutine for writing chunks to the dump...")
 	g.Go(func() error {
 		defer log.Debug().Msgf("Exiting from write chunks goroutine")
-		if err := t.writeChunksToFile(meta, chunksCh, logBuffer); err != nil {
+		if err := t.writeChunksToFile(file, meta, chunksCh, il, skipped, logBuffer, opts); err != nil {
 			return errors.Wrap(err, "failed to write chunks to the dump")
 		}
 		return nil
@@ -82,9 +192,10 @@ func (t Transferer) Export(ctx context.Context, lc LoadStatusGetter, meta dump.M
 	return nil
 }
 
-func (t Transferer) readChunksFromSource(ctx context.Context, lc LoadStatusGetter, p ChunkPool, chunkC chan<- *dump.Chunk) error {
+func (t Transferer) readChunksFromSource(ctx context.Context, lc LoadStatusGetter, ll *loadValuesLogger, il *inflightLimiter, p ChunkPool, chunkC chan<- *dump.Chunk, deadline time.Duration, skipped *skippedChunks) error {
 	for {
 		log.Debug().Msg("New chunks reading loop iteration has been started")
+		ll.logIfDue()
 
 		select {
 		case <-ctx.Done():
@@ -120,45 +231,226 @@ func (t Transferer) readChunksFromSource(ctx context.Context, lc LoadStatusGette
 				return errors.New("failed to find source to read chunk")
 			}
 
-			c, err := s.ReadChunk(chMeta)
+			start := time.Now()
+			c, deadlineExceeded, err := readChunk(ctx, s, chMeta, deadline)
+			if deadlineExceeded {
+				log.Warn().
+					Stringer("source", chMeta.Source).
+					Str("chunk", chMeta.String()).
+					Stringer("deadline", deadline).
+					Msg("Chunk reading exceeded the deadline, skipping it. There will be a gap in metrics")
+				skipped.add(chMeta)
+				continue
+			}
+			if errors.Is(err, dump.ErrEmptyChunk) {
+				log.Info().
+					Stringer("source", chMeta.Source).
+					Str("chunk", chMeta.String()).
+					Msg("Chunk has no data, skipping it. There may be a gap in metrics")
+				continue
+			}
 			if err != nil {
 				return errors.Wrap(err, "failed to read chunk")
 			}
+			c.ReadDuration = time.Since(start)
+
+			chunks := []*dump.Chunk{c}
+			if cs, ok := s.(dump.ChunkSplitter); ok {
+				chunks, err = cs.SplitChunk(c)
+				if err != nil {
+					return errors.Wrap(err, "failed to split chunk")
+				}
+				for _, part := range chunks {
+					part.Parts = len(chunks)
+				}
+			}
 
 			log.Debug().
 				Stringer("source", c.Source).
 				Str("filename", c.Filename).
 				Msg("Successfully read chunk. Sending to chunks channel...")
 
-			chunkC <- c
+			for _, c := range chunks {
+				if err := il.acquire(ctx, int64(len(c.Content))); err != nil {
+					log.Debug().Msg("Context is done, stopping chunks reading")
+					return err
+				}
+				select {
+				case chunkC <- c:
+				case <-ctx.Done():
+					log.Debug().Msg("Context is done, stopping chunks reading")
+					return ctx.Err()
+				}
+			}
 		}
 	}
 }
 
-func (t Transferer) writeChunksToFile(meta dump.Meta, chunkC <-chan *dump.Chunk, logBuffer *bytes.Buffer) error {
-	gzw, err := gzip.NewWriterLevel(t.file, gzip.BestCompression)
+// readChunk reads the chunk from the source, canceling the read if it takes longer than deadline.
+// It reports whether the chunk was canceled by the deadline, and not by the export context.
+func readChunk(ctx context.Context, s dump.Source, m dump.ChunkMeta, deadline time.Duration) (*dump.Chunk, bool, error) {
+	if deadline <= 0 {
+		c, err := s.ReadChunk(ctx, m)
+		return c, false, err
+	}
+	readCtx, cancel := context.WithTimeout(ctx, deadline)
+	defer cancel()
+	c, err := s.ReadChunk(readCtx, m)
+	if err != nil && ctx.Err() == nil && errors.Is(readCtx.Err(), context.DeadlineExceeded) {
+		return nil, true, err
+	}
+	return c, false, err
+}
+
+// skippedChunks collects chunks skipped by reading goroutines, so the gaps are recorded in the dump meta.
+type skippedChunks struct {
+	mu     sync.Mutex
+	chunks []string
+}
+
+func (s *skippedChunks) add(m dump.ChunkMeta) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.chunks = append(s.chunks, chunkName(m))
+}
+
+// chunkName returns the name of the chunk meta, the same as its filename without extension: `<source>/<start>-<end>`,
+// and `<source>/<start>-<end>-<index>` for ClickHouse.
+func chunkName(m dump.ChunkMeta) string {
+	name := path.Join(m.Source.String(), m.String())
+	if m.Source == dump.ClickHouse {
+		name = fmt.Sprintf("%s-%d", name, m.Index)
+	}
+	return name
+}
+
+// list returns the sorted names of skipped chunks, the same as their filenames without extensions.
+func (s *skippedChunks) list() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	chunks := make([]string, len(s.chunks))
+	copy(chunks, s.chunks)
+	sort.Strings(chunks)
+	return chunks
+}
+
+// newDumpWriter returns the writer of the dump archive or of the raw dump stream.
+func newDumpWriter(file io.Writer, opts ExportOptions) (*dump.Writer, error) {
+	if opts.RawStream {
+		return dump.NewRawWriterSize(file, opts.WriteBufferSize), nil
+	}
+	return dump.NewCompressedWriterSize(file, opts.WriteBufferSize, opts.compression())
+}
+
+func (o ExportOptions) compression() dump.Compression {
+	if o.Compression == (dump.Compression{}) {
+		return dump.DefaultCompression
+	}
+	return o.Compression
+}
+
+func (t Transferer) writeChunksToFile(file io.Writer, meta dump.Meta, chunkC <-chan *dump.Chunk, il *inflightLimiter, skipped *skippedChunks, logBuffer *bytes.Buffer, opts ExportOptions) error {
+	var cp *checkpointer
+	if opts.Checkpoint != nil {
+		cp = newCheckpointer(file, *opts.Checkpoint, opts.CheckpointFile)
+		file = cp.file
+		if cp.cp.MaxChunkSize > meta.MaxChunkSize {
+			meta.MaxChunkSize = cp.cp.MaxChunkSize
+		}
+	}
+
+	var checksums map[string]string
+	if opts.ChunkChecksums {
+		checksums = make(map[string]string)
+		if cp != nil && len(cp.cp.Completed) > 0 {
+			if cp.cp.ChunkChecksums == nil {
+				return errors.New("the checkpoint has no chunk checksums: the export was started without them")
+			}
+			for name, checksum := range cp.cp.ChunkChecksums {
+				checksums[name] = checksum
+			}
+		}
+	}
+
+	w, err := newDumpWriter(file, opts)
 	if err != nil {
-		return errors.Wrap(err, "failed to create gzip writer")
+		return errors.Wrap(err, "failed to create dump writer")
+	}
+	defer w.Close() //nolint:errcheck
+
+	// The dump resumed from the checkpoint has head files already
+	var headFiles []dump.File
+	if cp == nil || cp.cp.Size == 0 {
+		headFiles = opts.HeadFiles
+	}
+	for _, f := range headFiles {
+		log.Debug().Msgf("Writing %s", f.Name)
+		if err := w.AddFile(f.Name, f.Content); err != nil {
+			return err
+		}
+	}
+
+	if opts.Partial != nil {
+		maxChunkSize, err := opts.Partial.copyChunks(w, checksums)
+		if err != nil {
+			return errors.Wrap(err, "failed to copy chunks of the partial dump")
+		}
+		if err := w.Flush(); err != nil {
+			return errors.Wrap(err, "failed to flush copied chunks")
+		}
+		opts.Partial.Copied = true
+		if maxChunkSize > meta.MaxChunkSize {
+			meta.MaxChunkSize = maxChunkSize
+		}
 	}
-	defer gzw.Close() //nolint:errcheck
 
-	tw := tar.NewWriter(gzw)
-	defer tw.Close() //nolint:errcheck
+	chunkStats := make(map[dump.SourceType][]dump.ChunkStats)
 
 	for {
 		log.Debug().Msg("New chunks writing loop iteration has been started")
 
 		c, ok := <-chunkC
 		if !ok {
-			if err := writeMetafile(tw, meta); err != nil {
-				return err
+			if opts.ChunkStats {
+				if err := t.writeChunkStats(w, chunkStats); err != nil {
+					return err
+				}
+			}
+
+			for _, f := range opts.Files {
+				log.Debug().Msgf("Writing %s", f.Name)
+				if err := w.AddFile(f.Name, f.Content); err != nil {
+					return err
+				}
 			}
 
-			if err = writeLog(tw, logBuffer); err != nil {
+			if chunks := skipped.list(); len(chunks) > 0 {
+				meta.SkippedChunks = chunks
+			}
+			meta.ChunkContentTypes = t.chunkContentTypes()
+			meta.ChunkChecksums = checksums
+			meta.Compression = opts.compression().String()
+			if opts.RawStream {
+				meta.Compression = dump.CompressionNone
+			}
+			// The meta starts a new compressed stream, so it can be read from the end of the dump, see ReadMetaFromTail
+			if err := w.Checkpoint(); err != nil {
+				return errors.Wrap(err, "failed to end the dump before meta")
+			}
+			if err := writeMetafile(w, meta); err != nil {
 				return err
 			}
 
+			log.Debug().Msg("Writing dump log")
+			if err = w.AddFile(dump.LogFilename, logBuffer.Bytes()); err != nil {
+				return errors.Wrap(err, "failed to write dump log")
+			}
+
 			log.Debug().Msg("Chunks channel is closed: stopping chunks writing")
+			// The deferred close is for failures only, the final writes of the archive should be checked
+			if err := w.Close(); err != nil {
+				return errors.Wrap(err, "failed to close dump writer")
+			}
 			return nil
 		}
 
@@ -169,47 +461,114 @@ func (t Transferer) writeChunksToFile(meta dump.Meta, chunkC <-chan *dump.Chunk,
 			Str("filename", c.Filename).
 			Msg("Writing chunk to the dump...")
 
-		chunkSize := int64(len(c.Content))
-		if chunkSize > meta.MaxChunkSize {
+		if chunkSize := c.Len(); chunkSize > meta.MaxChunkSize {
 			meta.MaxChunkSize = chunkSize
 		}
 
-		err = tw.WriteHeader(&tar.Header{
-			Typeflag: tar.TypeReg,
-			Name:     path.Join(s.Type().String(), c.Filename),
-			Size:     chunkSize,
-			Mode:     filePermission,
-			ModTime:  time.Now(),
-		})
-		if err != nil {
-			return errors.Wrap(err, "failed to write file header")
+		if err := writeChunk(w, path.Join(s.Type().String(), c.Filename), c, checksums); err != nil {
+			return err
 		}
+		// Spooled chunks don't hold their content in memory
+		il.release(int64(len(c.Content)))
 
-		if _, err = tw.Write(c.Content); err != nil {
-			return errors.Wrap(err, "failed to write chunk content")
+		if opts.OnChunkWritten != nil {
+			if err := w.Flush(); err != nil {
+				return errors.Wrap(err, "failed to flush chunk")
+			}
+			if err := opts.OnChunkWritten(c.ChunkMeta); err != nil {
+				return errors.Wrapf(err, "failed to handle written chunk %s", c.Filename)
+			}
+		}
+		if cp != nil {
+			if err := cp.written(w, c, meta.MaxChunkSize, checksums); err != nil {
+				return errors.Wrapf(err, "failed to save checkpoint after chunk %s", c.Filename)
+			}
+		}
+
+		if opts.ChunkStats {
+			chunkStats[c.Source] = append(chunkStats[c.Source], newChunkStats(s, c))
+		}
+		if err := c.Close(); err != nil {
+			return errors.Wrapf(err, "failed to close chunk %s", c.Filename)
 		}
 	}
 }
 
-func writeLog(tw *tar.Writer, logBuffer *bytes.Buffer) error {
-	log.Debug().Msg("Writing dump log")
+// writeChunk writes the chunk content to the dump, streaming it from the temporary file if the chunk is spooled.
+// The checksum of the content is added to checksums, if they are not nil.
+func writeChunk(w *dump.Writer, name string, c *dump.Chunk, checksums map[string]string) error {
+	r, err := c.Reader()
+	if err != nil {
+		return errors.Wrap(err, "failed to read chunk")
+	}
+	return addFileWithChecksum(w, name, r, c.Len(), checksums)
+}
 
-	byteLog := logBuffer.Bytes()
+// addFileWithChecksum adds the file to the dump and its checksum to checksums, if they are not nil.
+func addFileWithChecksum(w *dump.Writer, name string, r io.Reader, size int64, checksums map[string]string) error {
+	h := dump.NewChecksum()
+	if checksums != nil {
+		r = io.TeeReader(r, h)
+	}
+	if err := w.AddFileFrom(name, r, size); err != nil {
+		return errors.Wrap(err, "failed to write chunk")
+	}
+	if checksums != nil {
+		checksums[name] = dump.ChecksumString(h)
+	}
+	return nil
+}
 
-	err := tw.WriteHeader(&tar.Header{
-		Typeflag: tar.TypeReg,
-		Name:     dump.LogFilename,
-		Size:     int64(len(byteLog)),
-		Mode:     filePermission,
-		ModTime:  time.Now(),
-	})
-	if err != nil {
-		return errors.Wrap(err, "failed to write dump log header")
+// chunkContentTypes returns content types of chunks by the directories of their sources.
+func (t Transferer) chunkContentTypes() map[string]string {
+	var types map[string]string
+	for _, s := range t.sources {
+		ct, ok := s.(dump.ContentTyper)
+		if !ok {
+			continue
+		}
+		if types == nil {
+			types = make(map[string]string)
+		}
+		types[s.Type().String()] = ct.ContentType()
 	}
+	return types
+}
 
-	if _, err = tw.Write(byteLog); err != nil {
-		return errors.Wrap(err, "failed to write dump log content")
+func newChunkStats(s dump.Source, c *dump.Chunk) dump.ChunkStats {
+	stats := dump.ChunkStats{
+		Filename:   c.Filename,
+		Bytes:      c.Len(),
+		DurationMs: c.ReadDuration.Milliseconds(),
+	}
+	if mc, ok := s.(dump.MetricsCounter); ok {
+		r, err := c.Reader()
+		if err != nil {
+			log.Debug().Err(err).Msgf("Failed to count metrics in chunk %s", c.Filename)
+			return stats
+		}
+		count, err := mc.CountMetrics(r)
+		if err != nil {
+			log.Debug().Err(err).Msgf("Failed to count metrics in chunk %s", c.Filename)
+		}
+		stats.MetricsCount = count
 	}
+	return stats
+}
 
+func (t Transferer) writeChunkStats(w *dump.Writer, chunkStats map[dump.SourceType][]dump.ChunkStats) error {
+	for _, s := range t.sources {
+		stats, ok := chunkStats[s.Type()]
+		if !ok {
+			continue
+		}
+		content, err := json.Marshal(stats)
+		if err != nil {
+			return errors.Wrap(err, "failed to marshal chunk stats")
+		}
+		if err := w.AddFile(path.Join(s.Type().String(), dump.ChunkStatsFilename), content); err != nil {
+			return errors.Wrap(err, "failed to write chunk stats")
+		}
+	}
 	return nil
 }
@@ -15,11 +15,19 @@
 package transferer
 
 import (
+	"archive/tar"
 	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path"
 	"testing"
 	"time"
 
+	"github.com/pkg/errors"
+
 	"pmm-dump/pkg/dump"
 )
 
@@ -39,6 +47,8 @@ func TestExport(t *testing.T) {
 		chunkTimeRange  time.Duration
 		sourceType      dump.SourceType
 		chunkSourceType dump.SourceType
+		chunkStats      bool
+		closeErr        bool
 		shouldErr       bool
 	}{
 		{
@@ -94,6 +104,19 @@ func TestExport(t *testing.T) {
 			chunkTimeRange: time.Minute,
 			sourceType:     dump.ClickHouse,
 		},
+		{
+			name:           "chunk stats",
+			loadStatus:     lsOpts{status: LoadStatusOK},
+			chunkTimeRange: time.Minute,
+			chunkStats:     true,
+		},
+		{
+			name:           "file close error",
+			loadStatus:     lsOpts{status: LoadStatusOK},
+			chunkTimeRange: time.Minute,
+			closeErr:       true,
+			shouldErr:      true,
+		},
 	}
 	options := []struct {
 		suffix       string
@@ -123,11 +146,15 @@ func TestExport(t *testing.T) {
 						&fakeSource{tt.sourceType, false},
 					}
 				}
-				tr := Transferer{
-					sources:      sources,
-					workersCount: opt.workersCount,
-					file:         bytes.NewBuffer(nil),
+				file := new(closingBuffer)
+				if tt.closeErr {
+					file.err = errors.New("no space left on device")
+				}
+				tr, err := New(file, sources, opt.workersCount)
+				if err != nil {
+					t.Fatal(err, "failed to create transferer")
 				}
+				checkTransfererSources(t, tr, sources, opt.workersCount)
 				var meta dump.Meta
 				var chunks []dump.ChunkMeta
 				if tt.chunkSourceType != dump.UndefinedSource {
@@ -141,7 +168,7 @@ func TestExport(t *testing.T) {
 				if err != nil {
 					t.Fatal(err, "failed to create new chunk pool")
 				}
-				err = tr.Export(ctx, fakeStatusGetter{status: tt.loadStatus.status, waitCount: tt.loadStatus.waitCount, statusAfterWait: tt.loadStatus.statusAfterWait, count: new(int)}, meta, pool, new(bytes.Buffer))
+				err = tr.Export(ctx, fakeStatusGetter{status: tt.loadStatus.status, waitCount: tt.loadStatus.waitCount, statusAfterWait: tt.loadStatus.statusAfterWait, count: new(int)}, meta, pool, new(bytes.Buffer), ExportOptions{ChunkStats: tt.chunkStats})
 				if err != nil {
 					if tt.shouldErr {
 						return
@@ -150,11 +177,403 @@ func TestExport(t *testing.T) {
 				} else if tt.shouldErr {
 					t.Fatal("error is empty")
 				}
+				if tt.chunkStats {
+					checkChunkStats(t, file.Bytes(), chunks)
+				}
 			})
 		}
 	}
 }
 
+// closingBuffer is the dump file which returns err on close, as file systems reporting errors of the last writes do.
+type closingBuffer struct {
+	bytes.Buffer
+	err error
+}
+
+func (b *closingBuffer) Close() error {
+	return b.err
+}
+
+// abortingBuffer is the dump file which discards the content on abort, as S3 uploads do.
+type abortingBuffer struct {
+	bytes.Buffer
+	closed  bool
+	aborted bool
+}
+
+func (b *abortingBuffer) Close() error {
+	b.closed = true
+	return nil
+}
+
+func (b *abortingBuffer) Abort() error {
+	b.aborted = true
+	return nil
+}
+
+func TestExportAbort(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     LoadStatus
+		wantAbort  bool
+		wantClosed bool
+	}{
+		{name: "complete", status: LoadStatusOK, wantClosed: true},
+		{name: "failed", status: LoadStatusTerminate, wantAbort: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := new(abortingBuffer)
+			tr, err := New(file, []dump.Source{&fakeSource{dump.VictoriaMetrics, false}}, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pool, err := dump.NewChunkPool(prepareFakeChunks(time.Now().Add(-time.Hour), time.Now(), time.Minute, dump.VictoriaMetrics))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = tr.Export(context.Background(), fakeStatusGetter{status: tt.status, count: new(int)}, dump.Meta{}, pool, new(bytes.Buffer), ExportOptions{})
+			if (err != nil) != tt.wantAbort {
+				t.Fatalf("unexpected export error: %v", err)
+			}
+			if file.aborted != tt.wantAbort || file.closed != tt.wantClosed {
+				t.Fatalf("want aborted %v and closed %v, got %v and %v", tt.wantAbort, tt.wantClosed, file.aborted, file.closed)
+			}
+		})
+	}
+}
+
+func TestExportToReader(t *testing.T) {
+	ctx := context.Background()
+
+	newTransferer := func(t *testing.T) (*Transferer, *dump.ChunkPool, []dump.ChunkMeta) {
+		t.Helper()
+		tr, err := New(bytes.NewBuffer(nil), []dump.Source{&fakeSource{dump.VictoriaMetrics, false}}, 1)
+		if err != nil {
+			t.Fatal(err, "failed to create transferer")
+		}
+		chunks := prepareFakeChunks(time.Now().Add(-time.Hour), time.Now(), time.Minute, dump.VictoriaMetrics)
+		pool, err := dump.NewChunkPool(chunks)
+		if err != nil {
+			t.Fatal(err, "failed to create new chunk pool")
+		}
+		return tr, pool, chunks
+	}
+
+	tests := []struct {
+		name            string
+		opts            ExportOptions
+		wantCompression string
+	}{
+		{name: "complete dump", wantCompression: "gzip:9"},
+		{name: "complete zstd dump", opts: ExportOptions{Compression: dump.Compression{Algorithm: dump.CompressionZstd}}, wantCompression: "zstd"},
+		{name: "complete raw stream", opts: ExportOptions{RawStream: true}, wantCompression: "none"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr, pool, chunks := newTransferer(t)
+			r, err := tr.ExportToReader(ctx, fakeStatusGetter{status: LoadStatusOK, count: new(int)}, dump.Meta{}, pool, new(bytes.Buffer), tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close() //nolint:errcheck
+
+			dr, err := dump.NewReader(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			files := make(map[string]bool)
+			for {
+				header, err := dr.Next()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				files[header.Name] = true
+				if header.Name != dump.MetaFilename {
+					continue
+				}
+				meta, err := readMetafile(dr)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if ct := meta.ChunkContentTypes["vm"]; ct != "text/plain" {
+					t.Fatalf("want vm chunks content type in meta, got %v", meta.ChunkContentTypes)
+				}
+				if meta.Compression != tt.wantCompression {
+					t.Fatalf("want compression %s in meta, got %s", tt.wantCompression, meta.Compression)
+				}
+			}
+			if !files[dump.MetaFilename] || !files[dump.LogFilename] {
+				t.Fatalf("meta or log is missing in dump: %v", files)
+			}
+			if len(files) != len(chunks)+2 {
+				t.Fatalf("want %d files in dump, got %d", len(chunks)+2, len(files))
+			}
+		})
+	}
+
+	t.Run("close before read", func(t *testing.T) {
+		tr, pool, _ := newTransferer(t)
+		r, err := tr.ExportToReader(ctx, fakeStatusGetter{status: LoadStatusOK, count: new(int)}, dump.Meta{}, pool, new(bytes.Buffer), ExportOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Close(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("canceled context", func(t *testing.T) {
+		tr, pool, _ := newTransferer(t)
+		cCtx, cancel := context.WithCancel(ctx)
+		r, err := tr.ExportToReader(cCtx, fakeStatusGetter{status: LoadStatusOK, count: new(int)}, dump.Meta{}, pool, new(bytes.Buffer), ExportOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close() //nolint:errcheck
+		cancel()
+		if _, err := io.ReadAll(r); err == nil {
+			t.Fatal("should be error")
+		}
+	})
+}
+
+// slowSource is a fake source which reads every fifth chunk until the context is done.
+type slowSource struct {
+	fakeSource
+}
+
+func (s slowSource) ReadChunk(ctx context.Context, m dump.ChunkMeta) (*dump.Chunk, error) {
+	if isSlowChunk(m) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return s.fakeSource.ReadChunk(ctx, m)
+}
+
+func isSlowChunk(m dump.ChunkMeta) bool {
+	return m.Start.Unix()/60%5 == 0
+}
+
+func TestExportChunkDeadline(t *testing.T) {
+	var file bytes.Buffer
+	tr, err := New(&file, []dump.Source{slowSource{fakeSource{sourceType: dump.VictoriaMetrics}}}, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks := prepareFakeChunks(time.Now().Add(-time.Hour), time.Now(), time.Minute, dump.VictoriaMetrics)
+	var wantSkipped int
+	for _, c := range chunks {
+		if isSlowChunk(c) {
+			wantSkipped++
+		}
+	}
+	pool, err := dump.NewChunkPool(chunks)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tr.Export(context.Background(), fakeStatusGetter{status: LoadStatusOK, count: new(int)}, dump.Meta{}, pool, new(bytes.Buffer), ExportOptions{ChunkDeadline: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dr, err := dump.NewReader(&file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var meta *dump.Meta
+	var files int
+	for {
+		header, err := dr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		files++
+		if header.Name == dump.MetaFilename {
+			if meta, err = readMetafile(dr); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if meta == nil {
+		t.Fatal("meta is missing in dump")
+	}
+	if len(meta.SkippedChunks) != wantSkipped {
+		t.Fatalf("want %d skipped chunks, got %v", wantSkipped, meta.SkippedChunks)
+	}
+	if want := len(chunks) - wantSkipped + 2; files != want {
+		t.Fatalf("want %d files in dump, got %d", want, files)
+	}
+}
+
+// failingSource is a fake source which fails to read the chunk starting at failAt.
+type failingSource struct {
+	fakeSource
+	failAt time.Time
+}
+
+func (s failingSource) ReadChunk(ctx context.Context, m dump.ChunkMeta) (*dump.Chunk, error) {
+	if m.Start.Equal(s.failAt) {
+		return nil, errors.New("connection reset by peer")
+	}
+	return s.fakeSource.ReadChunk(ctx, m)
+}
+
+func TestExportResume(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	dumpPath := path.Join(dir, "dump.tar.gz")
+	checkpointFile := path.Join(dir, "checkpoint.json")
+	chunks := prepareFakeChunks(time.Now().Add(-time.Hour), time.Now(), time.Minute, dump.VictoriaMetrics)
+
+	export := func(t *testing.T, file *os.File, s dump.Source, cp *ExportCheckpoint) error {
+		t.Helper()
+		tr, err := New(file, []dump.Source{s}, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pool, err := dump.NewChunkPool(cp.Chunks)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pool.Skip(cp.IsCompleted)
+		opts := ExportOptions{Checkpoint: cp, CheckpointFile: checkpointFile, ChunkChecksums: true}
+		return tr.Export(ctx, fakeStatusGetter{status: LoadStatusOK, count: new(int)}, dump.Meta{}, pool, new(bytes.Buffer), opts)
+	}
+
+	file, err := os.Create(dumpPath) //nolint:gosec
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp := &ExportCheckpoint{DumpPath: dumpPath, Chunks: chunks}
+	failing := failingSource{fakeSource: fakeSource{sourceType: dump.VictoriaMetrics}, failAt: *chunks[len(chunks)/2].Start}
+	if err := export(t, file, failing, cp); err == nil {
+		t.Fatal("should be error")
+	}
+
+	cp, err = ReadExportCheckpoint(checkpointFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp == nil || len(cp.Completed) == 0 || len(cp.Completed) >= len(chunks) {
+		t.Fatalf("want part of chunks completed in checkpoint, got %+v", cp)
+	}
+	info, err := os.Stat(dumpPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() < cp.Size {
+		t.Fatalf("want partial dump of at least %d bytes, got %d", cp.Size, info.Size())
+	}
+
+	// The resumed export cuts the chunks written after the checkpoint and appends the rest to the partial dump
+	file, err = os.OpenFile(dumpPath, os.O_WRONLY, 0) //nolint:gosec
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Truncate(cp.Size); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Seek(0, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	if err := export(t, file, fakeSource{sourceType: dump.VictoriaMetrics}, cp); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err = os.Open(dumpPath) //nolint:gosec
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close() //nolint:errcheck
+	dr, err := dump.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]int)
+	for {
+		header, err := dr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[header.Name]++
+	}
+	for _, c := range chunks {
+		if name := path.Join("vm", c.String()+".bin"); files[name] != 1 {
+			t.Fatalf("want chunk %s once in dump, got %d times", name, files[name])
+		}
+	}
+	if files[dump.MetaFilename] != 1 || len(files) != len(chunks)+2 {
+		t.Fatalf("want %d chunks, meta and log in dump, got %v", len(chunks), files)
+	}
+
+	// Checksums of the chunks written before the checkpoint are kept in it
+	meta, err := ReadMetaFromDump(dumpPath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(meta.ChunkChecksums) != len(chunks) {
+		t.Fatalf("want checksums of %d chunks, got %d", len(chunks), len(meta.ChunkChecksums))
+	}
+}
+
+func checkChunkStats(t *testing.T, data []byte, chunks []dump.ChunkMeta) {
+	t.Helper()
+
+	gzr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gzr)
+
+	stats := make(map[string][]dump.ChunkStats)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if path.Base(header.Name) != dump.ChunkStatsFilename {
+			continue
+		}
+		var s []dump.ChunkStats
+		if err := json.NewDecoder(tr).Decode(&s); err != nil {
+			t.Fatal(err)
+		}
+		stats[path.Dir(header.Name)] = s
+	}
+
+	for _, st := range []dump.SourceType{dump.VictoriaMetrics, dump.ClickHouse} {
+		want := 0
+		for _, c := range chunks {
+			if c.Source == st {
+				want++
+			}
+		}
+		if got := len(stats[st.String()]); got != want {
+			t.Fatalf("want %d %s chunk stats, got %d", want, st, got)
+		}
+		for _, s := range stats[st.String()] {
+			if s.Filename == "" || s.Bytes != int64(len("content")) {
+				t.Fatalf("unexpected %s chunk stats: %+v", st, s)
+			}
+		}
+	}
+}
+
 type fakeStatusGetter struct {
 	status          LoadStatus
 	count           *int
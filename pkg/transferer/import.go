@@ -15,12 +15,15 @@
 package transferer
 
 import (
-	"archive/tar"
 	"bytes"
-	"compress/gzip"
 	"context"
+	"fmt"
 	"io"
 	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
@@ -29,31 +32,144 @@ import (
 	"pmm-dump/pkg/dump"
 )
 
-func (t Transferer) Import(ctx context.Context, runtimeMeta dump.Meta) error {
-	log.Info().Msg("Importing metrics...")
-	gzr, err := gzip.NewReader(t.file)
+type ImportOptions struct {
+	// ChunkGlob limits import to the chunks whose path in the dump matches the pattern (see path.Match).
+	ChunkGlob string
+	// SummaryOnly reports the chunks that would be imported without writing them to the sources.
+	SummaryOnly bool
+	// ProgressInterval enables logging the import progress at this interval. The ETA is estimated for dump files only,
+	// as the size of a piped dump is unknown.
+	ProgressInterval time.Duration
+	// VerifyChecksums verifies imported chunks against ChunkChecksums as they are read and fails on a corrupted chunk.
+	// VictoriaMetrics chunks read before the corrupted one are already written: they can't be rolled back.
+	VerifyChecksums bool
+	// ChunkChecksums are the checksums of the dump meta, which should be read before import, see dump.Meta.ChunkChecksums.
+	ChunkChecksums map[string]string
+}
+
+// batchChunkSize is the size up to which small chunks of concatenable sources are batched on import,
+// so dumps with many small chunks don't need a request per chunk. Chunks aren't batched with the chunk glob,
+// so the selected chunks are written one by one.
+const batchChunkSize = 1 << 20
+
+// chunkBatch accumulates small chunks to write them at once. The names of its chunks are kept for errors.
+type chunkBatch struct {
+	content []byte
+	names   []string
+}
+
+func (b *chunkBatch) chunk(st dump.SourceType) *dump.Chunk {
+	return &dump.Chunk{
+		ChunkMeta: dump.ChunkMeta{
+			Source: st,
+		},
+		Content:  b.content,
+		Filename: strings.Join(b.names, ", "),
+	}
+}
+
+// chunkWriters are the writers of concurrent writer sources opened by import workers.
+// They are committed only after all workers are done, so a failed worker doesn't leave rows written by others.
+type chunkWriters struct {
+	mu      sync.Mutex
+	writers []dump.ChunkWriter
+}
+
+func (w *chunkWriters) open(s dump.ConcurrentWriter) (dump.ChunkWriter, error) { //nolint:ireturn,nolintlint
+	cw, err := s.NewChunkWriter()
 	if err != nil {
-		return errors.Wrap(err, "failed to open as gzip")
+		return nil, err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.writers = append(w.writers, cw)
+	return cw, nil
+}
+
+// commit commits all writers concurrently, as rows may be sent only on commit, and waits for all of them.
+func (w *chunkWriters) commit() error {
+	var g errgroup.Group
+	for _, cw := range w.writers {
+		g.Go(cw.Commit)
 	}
-	defer gzr.Close() //nolint:errcheck
+	return g.Wait()
+}
 
-	tr := tar.NewReader(gzr)
+func (w *chunkWriters) rollback() {
+	for _, cw := range w.writers {
+		if err := cw.Rollback(); err != nil {
+			log.Warn().Err(err).Msg("Failed to roll back chunk writes")
+		}
+	}
+}
+
+type chunksSummary struct {
+	count int
+	bytes int64
+}
+
+func (t Transferer) Import(ctx context.Context, runtimeMeta dump.Meta, opts ImportOptions) error {
+	log.Info().Msg("Importing metrics...")
+	cr := &countingReader{r: t.file}
+	tr, err := dump.NewReader(cr)
+	if err != nil {
+		return err
+	}
+	defer tr.Close() //nolint:errcheck
+
+	var processedChunks atomic.Int64
+	if opts.ProgressInterval > 0 && !opts.SummaryOnly {
+		start := time.Now()
+		size := fileSize(t.file)
+		defer logProgress(opts.ProgressInterval, func() string {
+			if size == 0 {
+				return fmt.Sprintf("Import progress: %d chunks, elapsed %s", processedChunks.Load(), time.Since(start).Round(time.Second))
+			}
+			return progressMessage("Import", cr.n.Load(), size, "bytes of the dump read", time.Since(start))
+		})()
+	}
 
 	var metafileExists bool
+	summary := make(map[dump.SourceType]*chunksSummary)
+
+	var verifier *checksumVerifier
+	if opts.VerifyChecksums && !opts.SummaryOnly {
+		verifier, err = newChecksumVerifier(opts.ChunkChecksums)
+		if err != nil {
+			return err
+		}
+	}
+	// stopErr stops reading the dump on a corrupted chunk or a failed streamed write, all chunk writes are rolled back then
+	var stopErr error
+	// streamWriter writes chunks of the streaming source. It's opened by chunkWriters, if the source is
+	// a dump.ConcurrentWriter, so the streamed writes are committed or rolled back with the writes of import workers.
+	var streamWriter chunkWriter
 
 	chunksC := make(chan *dump.Chunk, maxChunksInMem)
+	batches := make(map[dump.SourceType]*chunkBatch)
 
+	writers := new(chunkWriters)
 	g, gCtx := errgroup.WithContext(ctx)
 	for i := 0; i < t.workersCount; i++ {
 		g.Go(func() error {
 			defer log.Debug().Msgf("Exiting from write chunks goroutine")
-			if err := t.writeChunksToSource(gCtx, chunksC); err != nil {
+			if err := t.writeChunksToSource(gCtx, chunksC, writers); err != nil {
 				return errors.Wrap(err, "failed to write chunks to source")
 			}
 			return nil
 		})
 	}
 
+	sendChunk := func(ch *dump.Chunk) bool {
+		select {
+		case <-gCtx.Done():
+			return false
+		case chunksC <- ch:
+			log.Debug().Msgf("Sending chunk '%s' to the channel...", ch.Filename)
+			return true
+		}
+	}
+
 	for {
 		log.Debug().Msg("Reading file from dump...")
 
@@ -65,7 +181,7 @@ func (t Transferer) Import(ctx context.Context, runtimeMeta dump.Meta) error {
 		}
 
 		if err != nil {
-			return errors.Wrap(err, "failed to read file from dump")
+			return err
 		}
 
 		dir, filename := path.Split(header.Name)
@@ -76,7 +192,14 @@ func (t Transferer) Import(ctx context.Context, runtimeMeta dump.Meta) error {
 			continue
 		}
 
-		if filename == dump.LogFilename {
+		if filename == dump.LogFilename || filename == dump.ChunkStatsFilename ||
+			header.Name == dump.AgentConfigFilename || header.Name == dump.AnnotationsFilename || header.Name == dump.VMMetadataFilename ||
+			header.Name == dump.FiltersFilename {
+			continue
+		}
+
+		if header.Name == dump.VMMetricMetadataFilename {
+			log.Info().Msg("Metric metadata is not imported: VictoriaMetrics has no API to write it")
 			continue
 		}
 
@@ -84,13 +207,66 @@ func (t Transferer) Import(ctx context.Context, runtimeMeta dump.Meta) error {
 			return errors.Errorf("corrupted dump: found unknown file %s", filename)
 		}
 
-		log.Info().Msgf("Processing chunk '%s'...", header.Name)
-
 		st := dump.ParseSourceType(dir[:len(dir)-1])
 		if st == dump.UndefinedSource {
 			return errors.Errorf("corrupted dump: found undefined source: %s", dir)
 		}
 
+		if opts.ChunkGlob != "" {
+			matched, err := path.Match(opts.ChunkGlob, header.Name)
+			if err != nil {
+				return errors.Wrap(err, "failed to match chunk glob")
+			}
+			if !matched {
+				log.Debug().Msgf("Chunk '%s' doesn't match chunk glob, skipping", header.Name)
+				continue
+			}
+		}
+
+		if opts.SummaryOnly {
+			log.Info().Msgf("Chunk '%s' (%d bytes)", header.Name, header.Size)
+			if _, ok := summary[st]; !ok {
+				summary[st] = new(chunksSummary)
+			}
+			summary[st].count++
+			summary[st].bytes += header.Size
+			continue
+		}
+
+		log.Info().Msgf("Processing chunk '%s'...", header.Name)
+		processedChunks.Add(1)
+
+		if s, ok := t.streamingSource(st); ok {
+			if header.Size == 0 {
+				log.Warn().Msgf("Chunk '%s' is empty, skipping", header.Name)
+				continue
+			}
+			var r io.Reader = tr
+			h := dump.NewChecksum()
+			if verifier != nil {
+				r = io.TeeReader(tr, h)
+			}
+			if streamWriter == nil {
+				streamWriter = s
+				if cw, ok := s.(dump.ConcurrentWriter); ok {
+					if streamWriter, err = writers.open(cw); err != nil {
+						return errors.Wrapf(err, "failed to open %s chunk writer", st)
+					}
+				}
+			}
+			if err := streamWriter.WriteChunk(filename, r); err != nil {
+				stopErr = errors.Wrap(err, "failed to write chunk")
+				break
+			}
+			if verifier != nil {
+				if stopErr = verifier.verify(header.Name, dump.ChecksumString(h)); stopErr != nil {
+					break
+				}
+			}
+			log.Info().Msgf("Successfully processed '%v'", filename)
+			continue
+		}
+
 		content, err := io.ReadAll(tr)
 		if err != nil {
 			return errors.Wrap(err, "failed to read chunk content")
@@ -101,6 +277,14 @@ func (t Transferer) Import(ctx context.Context, runtimeMeta dump.Meta) error {
 			continue
 		}
 
+		if verifier != nil {
+			h := dump.NewChecksum()
+			_, _ = h.Write(content)
+			if stopErr = verifier.verify(header.Name, dump.ChecksumString(h)); stopErr != nil {
+				break
+			}
+		}
+
 		ch := &dump.Chunk{
 			ChunkMeta: dump.ChunkMeta{
 				Source: st,
@@ -109,30 +293,59 @@ func (t Transferer) Import(ctx context.Context, runtimeMeta dump.Meta) error {
 			Filename: filename,
 		}
 
-		isDone := false
-		select {
-		case <-gCtx.Done():
-			isDone = true
-		case chunksC <- ch:
-			log.Debug().Msgf("Sending chunk '%s' to the channel...", header.Name)
+		if opts.ChunkGlob == "" && len(content) < batchChunkSize && t.canConcatenateChunks(st) {
+			b, ok := batches[st]
+			if !ok {
+				b = new(chunkBatch)
+				batches[st] = b
+			}
+			b.content = append(b.content, content...)
+			b.names = append(b.names, filename)
+			if len(b.content) < batchChunkSize {
+				continue
+			}
+			delete(batches, st)
+			ch = b.chunk(st)
 		}
-		if isDone {
+
+		if !sendChunk(ch) {
 			break
 		}
 	}
 
+	if stopErr == nil {
+		for st, b := range batches {
+			if !sendChunk(b.chunk(st)) {
+				break
+			}
+		}
+	}
+
 	close(chunksC)
 	if err := g.Wait(); err != nil {
 		log.Debug().Msg("Got error, finishing import")
+		writers.rollback()
 		return err
 	}
+	if stopErr != nil {
+		writers.rollback()
+		return stopErr
+	}
 
 	if !metafileExists {
 		log.Error().Msg("No meta file found in dump. No version checks performed")
 	}
 
+	if opts.SummaryOnly {
+		t.logImportSummary(summary)
+		return nil
+	}
+
 	log.Debug().Msg("Finalizing writes...")
 
+	if err := writers.commit(); err != nil {
+		return errors.Wrap(err, "failed to commit chunk writes")
+	}
 	for _, s := range t.sources {
 		if err = s.FinalizeWrites(); err != nil {
 			return errors.Wrap(err, "failed to finalize import")
@@ -144,7 +357,51 @@ func (t Transferer) Import(ctx context.Context, runtimeMeta dump.Meta) error {
 	return nil
 }
 
-func (t Transferer) writeChunksToSource(ctx context.Context, chunkC <-chan *dump.Chunk) error {
+func (t Transferer) logImportSummary(summary map[dump.SourceType]*chunksSummary) {
+	for _, st := range []dump.SourceType{dump.VictoriaMetrics, dump.ClickHouse} {
+		cs, ok := summary[st]
+		if !ok {
+			continue
+		}
+		if _, ok := t.sourceByType(st); !ok {
+			log.Info().Msgf("Dump has %d %s chunks (%d bytes), they would be skipped", cs.count, st, cs.bytes)
+			continue
+		}
+		log.Info().Msgf("Dump has %d %s chunks (%d bytes), they would be imported", cs.count, st, cs.bytes)
+	}
+	if len(summary) == 0 {
+		log.Info().Msg("Dump has no chunks to import")
+	}
+	log.Info().Msg("Summary only: nothing was imported")
+}
+
+// chunkWriter writes chunks either to the source or to the writer of the import worker.
+type chunkWriter interface {
+	WriteChunk(filename string, r io.Reader) error
+}
+
+func (t Transferer) canConcatenateChunks(st dump.SourceType) bool {
+	s, ok := t.sourceByType(st)
+	if !ok {
+		return false
+	}
+	cc, ok := s.(dump.ChunkConcatenator)
+	return ok && cc.CanConcatenateChunks()
+}
+
+// streamingSource returns the source which chunks can be written directly from the dump without buffering them.
+// It's possible only for ClickHouse being the single source, as it parses TSV chunks line by line.
+func (t Transferer) streamingSource(st dump.SourceType) (dump.Source, bool) { //nolint:ireturn,nolintlint
+	if st != dump.ClickHouse || len(t.sources) != 1 {
+		return nil, false
+	}
+	return t.sourceByType(st)
+}
+
+// writeChunksToSource writes chunks from the channel until it's closed. Chunks of concurrent writer sources
+// are written to the writers of this worker, which are opened on the first chunk of the source.
+func (t Transferer) writeChunksToSource(ctx context.Context, chunkC <-chan *dump.Chunk, writers *chunkWriters) error {
+	workerWriters := make(map[dump.SourceType]dump.ChunkWriter)
 	for {
 		log.Debug().Msg("New chunks writing loop iteration has been started")
 
@@ -172,8 +429,20 @@ func (t Transferer) writeChunksToSource(ctx context.Context, chunkC <-chan *dump
 				continue
 			}
 
+			var w chunkWriter = s
+			if cw, ok := s.(dump.ConcurrentWriter); ok {
+				if workerWriters[c.Source] == nil {
+					wr, err := writers.open(cw)
+					if err != nil {
+						return errors.Wrapf(err, "failed to open %s chunk writer", c.Source)
+					}
+					workerWriters[c.Source] = wr
+				}
+				w = workerWriters[c.Source]
+			}
+
 			log.Debug().Msgf("Writing chunk '%v' to the source...", c.Filename)
-			if err := s.WriteChunk(c.Filename, bytes.NewBuffer(c.Content)); err != nil {
+			if err := w.WriteChunk(c.Filename, bytes.NewBuffer(c.Content)); err != nil {
 				return errors.Wrap(err, "failed to write chunk")
 			}
 			log.Info().Msgf("Successfully processed '%v'", c.Filename)
@@ -20,12 +20,20 @@ import (
 	"compress/gzip"
 	"context"
 	"crypto/rand"
+	"encoding/json"
 	"fmt"
 	"io"
 	"path"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
 	"pmm-dump/pkg/dump"
 )
 
@@ -37,6 +45,8 @@ func TestImport(t *testing.T) {
 		dumpPath      string
 		shouldErr     bool
 		finalizerFail bool
+		chunkGlob     string
+		summaryOnly   bool
 	}{
 		{
 			name: "basic test",
@@ -51,10 +61,24 @@ func TestImport(t *testing.T) {
 			shouldErr: true,
 			dumpPath:  "dumpwithinvalidchunk.tar.gz",
 		},
+		{
+			name:      "invalid chunk skipped by glob",
+			dumpPath:  "dumpwithinvalidchunk.tar.gz",
+			chunkGlob: "*/chunk-[0-5].bin",
+		},
+		{
+			name:      "invalid glob",
+			shouldErr: true,
+			chunkGlob: "vm/[",
+		},
 		{
 			name:     "empty chunk",
 			dumpPath: "dumpwithemptychunk.tar.gz",
 		},
+		{
+			name:     "decompressed dump",
+			dumpPath: "decompresseddump.tar",
+		},
 		{
 			name:      "invalid tar",
 			shouldErr: true,
@@ -75,6 +99,12 @@ func TestImport(t *testing.T) {
 			shouldErr:     true,
 			finalizerFail: true,
 		},
+		{
+			name:          "summary only",
+			dumpPath:      "dumpwithinvalidchunk.tar.gz",
+			finalizerFail: true,
+			summaryOnly:   true,
+		},
 	}
 	options := []struct {
 		suffix       string
@@ -106,6 +136,7 @@ func TestImport(t *testing.T) {
 		"dumpwithinvalidchunk.tar.gz":    fakeFileData(t, fakeFileOpts{withInvalidChunk: true}),
 		"dumpwithemptychunk.tar.gz":      fakeFileData(t, fakeFileOpts{withEmptyChunk: true}),
 		"dumpwithinvalidtar.tar.gz":      fakeFileData(t, fakeFileOpts{withInvalidTar: true}),
+		"decompresseddump.tar":           fakeFileData(t, fakeFileOpts{withoutGzip: true}),
 		"dumpwithinvalidfile.tar.gz":     fakeFileData(t, fakeFileOpts{withInvalidFile: true}),
 		"dumpwithundefinedsource.tar.gz": fakeFileData(t, fakeFileOpts{withUndefinedSource: true}),
 	}
@@ -123,13 +154,13 @@ func TestImport(t *testing.T) {
 				if opt.sourceType != dump.SourceType(0) {
 					sources = []dump.Source{&fakeSource{opt.sourceType, tt.finalizerFail}}
 				}
-				tr := Transferer{
-					sources:      sources,
-					workersCount: opt.workersCount,
-					file:         buf,
+				tr, err := New(buf, sources, opt.workersCount)
+				if err != nil {
+					t.Fatal(err, "failed to create transferer")
 				}
+				checkTransfererSources(t, tr, sources, opt.workersCount)
 				meta := dump.Meta{}
-				err := tr.Import(ctx, meta)
+				err = tr.Import(ctx, meta, ImportOptions{ChunkGlob: tt.chunkGlob, SummaryOnly: tt.summaryOnly})
 				if err != nil {
 					if tt.shouldErr {
 						return
@@ -155,13 +186,17 @@ func fakeFileData(t *testing.T, opts fakeFileOpts) []byte {
 func writeFakeFile(t *testing.T, w io.Writer, opts fakeFileOpts) {
 	t.Helper()
 
-	gzw, err := gzip.NewWriterLevel(w, gzip.BestCompression)
-	if err != nil {
-		t.Fatal(err, "failed to create gzip writer")
+	out := w
+	if !opts.withoutGzip {
+		gzw, err := gzip.NewWriterLevel(w, gzip.BestCompression)
+		if err != nil {
+			t.Fatal(err, "failed to create gzip writer")
+		}
+		defer gzw.Close() //nolint:errcheck
+		out = gzw
 	}
-	defer gzw.Close() //nolint:errcheck
 
-	tw := tar.NewWriter(gzw)
+	tw := tar.NewWriter(out)
 	defer tw.Close() //nolint:errcheck
 	if opts.withInvalidTar {
 		var content bytes.Buffer
@@ -169,7 +204,7 @@ func writeFakeFile(t *testing.T, w io.Writer, opts fakeFileOpts) {
 		if err != nil {
 			t.Fatal(err, "failed to fill content")
 		}
-		_, err = gzw.Write(content.Bytes())
+		_, err = out.Write(content.Bytes())
 		if err != nil {
 			t.Fatal(err, "failed to write invalid tar")
 		}
@@ -197,14 +232,17 @@ func writeFakeFile(t *testing.T, w io.Writer, opts fakeFileOpts) {
 		}
 	}
 	if !opts.withoutMetafile {
-		if err := writeMetafile(tw, dump.Meta{}); err != nil {
+		metaContent, err := json.Marshal(dump.Meta{})
+		if err != nil {
 			t.Fatal(err)
 		}
+		writeFakeTarFile(t, tw, dump.MetaFilename, metaContent)
 	}
 
-	if err = writeLog(tw, bytes.NewBufferString("logs")); err != nil {
-		t.Fatal(err)
-	}
+	writeFakeTarFile(t, tw, dump.LogFilename, []byte("logs"))
+	writeFakeTarFile(t, tw, dump.AnnotationsFilename, []byte("[]"))
+	writeFakeTarFile(t, tw, dump.VMMetadataFilename, []byte("{}"))
+	writeFakeTarFile(t, tw, dump.FiltersFilename, []byte("{}"))
 
 	if opts.withInvalidFile {
 		var content bytes.Buffer
@@ -244,7 +282,7 @@ func writeFakeFile(t *testing.T, w io.Writer, opts fakeFileOpts) {
 		}
 		chunkSize := int64(len(content.Bytes()))
 
-		err = tw.WriteHeader(&tar.Header{
+		err := tw.WriteHeader(&tar.Header{
 			Typeflag: tar.TypeReg,
 			Name:     path.Join("vm", fmt.Sprintf("chunk-%d.bin", i)),
 			Size:     chunkSize,
@@ -275,6 +313,24 @@ func writeFakeFile(t *testing.T, w io.Writer, opts fakeFileOpts) {
 	}
 }
 
+func writeFakeTarFile(t *testing.T, tw *tar.Writer, name string, content []byte) {
+	t.Helper()
+
+	err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     int64(len(content)),
+		Mode:     filePermission,
+		ModTime:  time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err, "failed to write file header")
+	}
+	if _, err = tw.Write(content); err != nil {
+		t.Fatal(err, "failed to write file content")
+	}
+}
+
 type fakeFileOpts struct {
 	withInvalidChunk    bool
 	withEmptyChunk      bool
@@ -282,4 +338,397 @@ type fakeFileOpts struct {
 	withInvalidFile     bool
 	withUndefinedSource bool
 	withoutMetafile     bool
+	// withoutGzip writes a plain tar archive, as if it's decompressed upstream in a pipeline
+	withoutGzip bool
+}
+
+func TestImportSummaryOnlyListsChunks(t *testing.T) {
+	var logs bytes.Buffer
+	logger := log.Logger
+	log.Logger = zerolog.New(&logs).Level(zerolog.InfoLevel)
+	defer func() { log.Logger = logger }()
+
+	sources := []dump.Source{&fakeSource{sourceType: dump.VictoriaMetrics}, &fakeSource{sourceType: dump.ClickHouse}}
+	tr, err := New(bytes.NewBuffer(fakeFileData(t, fakeFileOpts{})), sources, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Import(context.Background(), dump.Meta{}, ImportOptions{ChunkGlob: "*/chunk-[0-1].bin", SummaryOnly: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	var listed []string
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var entry struct {
+			Message string `json:"message"`
+		}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasPrefix(entry.Message, "Chunk ") {
+			listed = append(listed, entry.Message)
+		}
+	}
+	want := []string{
+		"Chunk 'vm/chunk-0.bin' (1024 bytes)",
+		"Chunk 'ch/chunk-0.bin' (1024 bytes)",
+		"Chunk 'vm/chunk-1.bin' (1024 bytes)",
+		"Chunk 'ch/chunk-1.bin' (1024 bytes)",
+	}
+	if !reflect.DeepEqual(listed, want) {
+		t.Fatalf("want matching chunks %v, got %v", want, listed)
+	}
+}
+
+// concatenatingSource is a fake source which can write concatenated chunks. It records written filenames and contents.
+type concatenatingSource struct {
+	fakeSource
+	mu        sync.Mutex
+	filenames []string
+	writes    []string
+}
+
+func (s *concatenatingSource) CanConcatenateChunks() bool {
+	return true
+}
+
+func (s *concatenatingSource) WriteChunk(filename string, r io.Reader) error {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.filenames = append(s.filenames, filename)
+	s.writes = append(s.writes, string(content))
+	return nil
+}
+
+func TestImportBatchesSmallChunks(t *testing.T) {
+	tests := []struct {
+		name          string
+		chunkGlob     string
+		wantFilenames []string
+	}{
+		{
+			name:          "batched",
+			wantFilenames: []string{"0-1.bin, 1-2.bin, 2-3.bin"},
+		},
+		{
+			name:          "chunk glob",
+			chunkGlob:     "vm/*",
+			wantFilenames: []string{"0-1.bin", "1-2.bin", "2-3.bin"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := dump.NewWriter(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := ""
+			for i := 0; i < 3; i++ {
+				content := fmt.Sprintf("chunk-%d;", i)
+				want += content
+				if err := w.AddFile(fmt.Sprintf("vm/%d-%d.bin", i, i+1), []byte(content)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			s := &concatenatingSource{fakeSource: fakeSource{sourceType: dump.VictoriaMetrics}}
+			tr, err := New(&buf, []dump.Source{s}, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := tr.Import(context.Background(), dump.Meta{}, ImportOptions{ChunkGlob: tt.chunkGlob}); err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(s.filenames, tt.wantFilenames) {
+				t.Fatalf("want writes of %v, got %v", tt.wantFilenames, s.filenames)
+			}
+			if got := strings.Join(s.writes, ""); got != want {
+				t.Fatalf("want %q, got %q", want, got)
+			}
+		})
+	}
+}
+
+// transactionalSource is a fake source which writes chunks with a writer per import worker.
+type transactionalSource struct {
+	fakeSource
+	mu      sync.Mutex
+	writers []*fakeChunkWriter
+}
+
+func (s *transactionalSource) NewChunkWriter() (dump.ChunkWriter, error) { //nolint:ireturn,nolintlint
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w := new(fakeChunkWriter)
+	s.writers = append(s.writers, w)
+	return w, nil
+}
+
+func (s *transactionalSource) WriteChunk(string, io.Reader) error {
+	return errors.New("chunks should be written by the chunk writers")
+}
+
+// fakeChunkWriter records the count of written chunks and whether it was committed or rolled back.
+type fakeChunkWriter struct {
+	chunks     int
+	committed  bool
+	rolledBack bool
+}
+
+func (w *fakeChunkWriter) WriteChunk(_ string, r io.Reader) error {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if string(content) == "invalid" {
+		return errors.New("invalid chunk")
+	}
+	w.chunks++
+	return nil
+}
+
+func (w *fakeChunkWriter) Commit() error {
+	w.committed = true
+	return nil
+}
+
+func (w *fakeChunkWriter) Rollback() error {
+	w.rolledBack = true
+	return nil
+}
+
+func TestImportConcurrentWriters(t *testing.T) {
+	tests := []struct {
+		name      string
+		invalid   bool
+		shouldErr bool
+	}{
+		{
+			name: "committed",
+		},
+		{
+			name:      "rolled back",
+			invalid:   true,
+			shouldErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := dump.NewWriter(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			const chunksCount = 20
+			for i := 0; i < chunksCount; i++ {
+				content := "content"
+				if tt.invalid && i == chunksCount/2 {
+					content = "invalid"
+				}
+				for _, dir := range []string{"vm", "ch"} {
+					if err := w.AddFile(fmt.Sprintf("%s/%d-%d.bin", dir, i, i+1), []byte(content)); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			s := &transactionalSource{fakeSource: fakeSource{sourceType: dump.ClickHouse}}
+			tr, err := New(&buf, []dump.Source{&fakeSource{sourceType: dump.VictoriaMetrics}, s}, 4)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = tr.Import(context.Background(), dump.Meta{}, ImportOptions{})
+			if tt.shouldErr {
+				if err == nil {
+					t.Fatal("should be error")
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(s.writers) == 0 || len(s.writers) > 4 {
+				t.Fatalf("want a writer per worker, got %d writers", len(s.writers))
+			}
+			chunks := 0
+			for _, w := range s.writers {
+				if w.committed == tt.shouldErr || w.rolledBack != tt.shouldErr {
+					t.Fatalf("want all writers committed %v and rolled back %v, got %+v", !tt.shouldErr, tt.shouldErr, *w)
+				}
+				chunks += w.chunks
+			}
+			if !tt.shouldErr && chunks != chunksCount {
+				t.Fatalf("want %d chunks written, got %d", chunksCount, chunks)
+			}
+		})
+	}
+}
+
+func exportFakeDump(t *testing.T, chunks []dump.ChunkMeta, opts ExportOptions) []byte {
+	t.Helper()
+
+	var file bytes.Buffer
+	tr, err := New(&file, []dump.Source{fakeSource{sourceType: dump.VictoriaMetrics}}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool, err := dump.NewChunkPool(chunks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Export(context.Background(), fakeStatusGetter{status: LoadStatusOK, count: new(int)}, dump.Meta{}, pool, new(bytes.Buffer), opts); err != nil {
+		t.Fatal(err)
+	}
+	return file.Bytes()
+}
+
+func TestImportVerifyChecksums(t *testing.T) {
+	chunks := prepareFakeChunks(time.Now().Add(-10*time.Minute), time.Now(), time.Minute, dump.VictoriaMetrics)
+	withChecksums := exportFakeDump(t, chunks, ExportOptions{ChunkChecksums: true})
+	withoutChecksums := exportFakeDump(t, chunks, ExportOptions{})
+
+	meta, err := ReadMetaFromReader(bytes.NewReader(withChecksums))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(meta.ChunkChecksums) != len(chunks) {
+		t.Fatalf("want %d chunk checksums, got %v", len(chunks), meta.ChunkChecksums)
+	}
+	corrupted := make(map[string]string)
+	var corruptedChunk string
+	for name, checksum := range meta.ChunkChecksums {
+		if corruptedChunk == "" {
+			corruptedChunk = name
+			checksum = strings.Repeat("0", len(checksum))
+		}
+		corrupted[name] = checksum
+	}
+
+	tests := []struct {
+		name      string
+		dump      []byte
+		checksums map[string]string
+		wantErr   string
+	}{
+		{
+			name:      "verified",
+			dump:      withChecksums,
+			checksums: meta.ChunkChecksums,
+		},
+		{
+			name:      "corrupted chunk",
+			dump:      withChecksums,
+			checksums: corrupted,
+			wantErr:   corruptedChunk + " is corrupted",
+		},
+		{
+			name:    "no checksums",
+			dump:    withoutChecksums,
+			wantErr: "no chunk checksums to verify",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr, err := New(bytes.NewBuffer(tt.dump), []dump.Source{fakeSource{sourceType: dump.VictoriaMetrics}}, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = tr.Import(context.Background(), dump.Meta{}, ImportOptions{VerifyChecksums: true, ChunkChecksums: tt.checksums})
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("want error with %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestImportStreamingRollback(t *testing.T) {
+	const chunksCount = 5
+	tests := []struct {
+		name      string
+		corrupted bool
+		invalid   bool
+		shouldErr bool
+	}{
+		{
+			name: "committed",
+		},
+		{
+			name:      "corrupted chunk",
+			corrupted: true,
+			shouldErr: true,
+		},
+		{
+			name:      "failed write",
+			invalid:   true,
+			shouldErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := dump.NewWriter(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checksums := make(map[string]string)
+			for i := 0; i < chunksCount; i++ {
+				name := fmt.Sprintf("ch/%d-%d.bin", i, i+1)
+				content := "content"
+				if tt.invalid && i == chunksCount-1 {
+					content = "invalid"
+				}
+				if err := w.AddFile(name, []byte(content)); err != nil {
+					t.Fatal(err)
+				}
+				h := dump.NewChecksum()
+				_, _ = h.Write([]byte(content))
+				checksums[name] = dump.ChecksumString(h)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if tt.corrupted {
+				checksums[fmt.Sprintf("ch/%d-%d.bin", chunksCount-1, chunksCount)] = "corrupted"
+			}
+
+			// ClickHouse being the single source, its chunks are streamed from the dump
+			s := &transactionalSource{fakeSource: fakeSource{sourceType: dump.ClickHouse}}
+			tr, err := New(&buf, []dump.Source{s}, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = tr.Import(context.Background(), dump.Meta{}, ImportOptions{VerifyChecksums: true, ChunkChecksums: checksums})
+			if tt.shouldErr {
+				if err == nil {
+					t.Fatal("should be error")
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(s.writers) != 1 {
+				t.Fatalf("want a single writer of streamed chunks, got %d writers", len(s.writers))
+			}
+			if w := s.writers[0]; w.committed == tt.shouldErr || w.rolledBack != tt.shouldErr {
+				t.Fatalf("want writer committed %v and rolled back %v, got %+v", !tt.shouldErr, tt.shouldErr, *w)
+			}
+		})
+	}
 }
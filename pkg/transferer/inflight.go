@@ -0,0 +1,88 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transferer
+
+import (
+	"context"
+	"sync"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// inflightLimiter limits the total size of chunks read from sources, but not written to the dump yet.
+// A chunk larger than the limit is admitted alone, so it can't block the export forever.
+// The nil limiter doesn't limit anything.
+type inflightLimiter struct {
+	sem   *semaphore.Weighted
+	limit int64
+
+	mu    sync.Mutex
+	bytes int64
+	peak  int64
+}
+
+func newInflightLimiter(limit int64) *inflightLimiter {
+	if limit <= 0 {
+		return nil
+	}
+	return &inflightLimiter{
+		sem:   semaphore.NewWeighted(limit),
+		limit: limit,
+	}
+}
+
+// acquire blocks until the chunk of n bytes fits into the limit or ctx is done.
+func (l *inflightLimiter) acquire(ctx context.Context, n int64) error {
+	if l == nil {
+		return nil
+	}
+	if err := l.sem.Acquire(ctx, l.weight(n)); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.bytes += n
+	if l.bytes > l.peak {
+		l.peak = l.bytes
+	}
+	return nil
+}
+
+// release frees n bytes acquired before.
+func (l *inflightLimiter) release(n int64) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	l.bytes -= n
+	l.mu.Unlock()
+
+	l.sem.Release(l.weight(n))
+}
+
+// peakBytes returns the max total size of chunks in flight.
+func (l *inflightLimiter) peakBytes() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.peak
+}
+
+func (l *inflightLimiter) weight(n int64) int64 {
+	if n > l.limit {
+		return l.limit
+	}
+	return n
+}
@@ -0,0 +1,102 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transferer
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"pmm-dump/pkg/dump"
+)
+
+func TestInflightLimiter(t *testing.T) {
+	const limit = 300
+	sizes := []int64{10, 200, 50, 1000, 120, 300, 5, 700, 90}
+
+	l := newInflightLimiter(limit)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, n := range sizes {
+				if err := l.acquire(context.Background(), n); err != nil {
+					t.Error(err)
+					return
+				}
+				time.Sleep(time.Millisecond)
+				l.release(n)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// the largest chunk is admitted alone, the others fit into the limit
+	if peak := l.peakBytes(); peak > 1000 {
+		t.Fatalf("peak in-flight bytes %d exceed the largest chunk size", peak)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := l.acquire(ctx, limit); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if err := l.acquire(ctx, 1); err == nil {
+		t.Fatal("acquire over the limit should fail on canceled context")
+	}
+}
+
+// sizedSource is a fake source which returns chunks of sizes depending on the chunk start.
+type sizedSource struct {
+	fakeSource
+	sizes []int
+}
+
+func (s sizedSource) ReadChunk(_ context.Context, m dump.ChunkMeta) (*dump.Chunk, error) {
+	size := s.sizes[int(m.Start.Unix()/60)%len(s.sizes)]
+	return &dump.Chunk{
+		ChunkMeta: m,
+		Content:   bytes.Repeat([]byte("a"), size),
+		Filename:  m.String() + ".bin",
+	}, nil
+}
+
+func TestExportMaxInFlightBytes(t *testing.T) {
+	sources := []dump.Source{sizedSource{fakeSource: fakeSource{sourceType: dump.VictoriaMetrics}, sizes: []int{10, 2000, 300, 50}}}
+	tr, err := New(new(bytes.Buffer), sources, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool, err := dump.NewChunkPool(prepareFakeChunks(time.Now().Add(-time.Hour), time.Now(), time.Minute, dump.VictoriaMetrics))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- tr.Export(context.Background(), fakeStatusGetter{status: LoadStatusOK, count: new(int)}, dump.Meta{}, pool, new(bytes.Buffer), ExportOptions{MaxInFlightBytes: 500})
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("export with chunks larger than in-flight limit is stuck")
+	}
+}
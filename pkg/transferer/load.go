@@ -72,6 +72,7 @@ type LoadChecker struct {
 
 	m            sync.RWMutex
 	latestStatus LoadStatus
+	latestValues map[ThresholdKey]float64
 
 	latestStatusCount int
 }
@@ -82,6 +83,7 @@ func NewLoadChecker(ctx context.Context, c *client.Client, url string, threshold
 		connectionURL: url,
 		thresholds:    thresholds,
 		latestStatus:  LoadStatusWait,
+		latestValues:  make(map[ThresholdKey]float64),
 	}
 
 	lc.updateStatus()
@@ -99,6 +101,23 @@ func (c *LoadChecker) GetLatestStatus() (LoadStatus, int) {
 	return c.latestStatus, c.latestStatusCount
 }
 
+// CurrentValues returns a snapshot of the latest sampled load values per threshold key.
+func (c *LoadChecker) CurrentValues() map[ThresholdKey]float64 {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	values := make(map[ThresholdKey]float64, len(c.latestValues))
+	for k, v := range c.latestValues {
+		values[k] = v
+	}
+	return values
+}
+
+func (c *LoadChecker) setLatestValue(k ThresholdKey, v float64) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.latestValues[k] = v
+}
+
 func (c *LoadChecker) setLatestStatus(s LoadStatus, count int) {
 	c.m.Lock()
 	defer c.m.Unlock()
@@ -163,6 +182,7 @@ func (c *LoadChecker) checkMetricsLoad() (LoadStatus, error) {
 		if err != nil {
 			return LoadStatusNone, fmt.Errorf("failed to retrieve threshold value for %s: %w", t.Key, err)
 		}
+		c.setLatestValue(t.Key, value)
 		switch {
 		case value >= t.CriticalLoad:
 			log.Debug().Msgf("Checked %s threshold: it exceeds critical load limit. Terminating", t.Key)
@@ -221,6 +241,54 @@ func (c *LoadChecker) getMetricCurrentValue(m Threshold) (float64, error) {
 	return fVal, nil
 }
 
+// loadValuesLogger periodically logs the current load values. It's shared by reading workers, so values
+// are logged once per interval regardless of the workers count.
+type loadValuesLogger struct {
+	getter   LoadValuesGetter
+	interval time.Duration
+
+	m          sync.Mutex
+	lastLogged time.Time
+}
+
+// newLoadValuesLogger returns nil if logging is disabled or lc doesn't expose load values.
+func newLoadValuesLogger(lc LoadStatusGetter, interval time.Duration) *loadValuesLogger {
+	getter, ok := lc.(LoadValuesGetter)
+	if !ok || interval <= 0 {
+		return nil
+	}
+	return &loadValuesLogger{
+		getter:     getter,
+		interval:   interval,
+		lastLogged: time.Now(),
+	}
+}
+
+func (l *loadValuesLogger) logIfDue() {
+	if l == nil {
+		return
+	}
+	l.m.Lock()
+	if time.Since(l.lastLogged) < l.interval {
+		l.m.Unlock()
+		return
+	}
+	l.lastLogged = time.Now()
+	l.m.Unlock()
+
+	values := l.getter.CurrentValues()
+	var pairs []string
+	for _, k := range AllThresholdKeys() {
+		if v, ok := values[k]; ok {
+			pairs = append(pairs, fmt.Sprintf("%s=%.2f", k, v))
+		}
+	}
+	if len(pairs) == 0 {
+		return
+	}
+	log.Info().Msgf("Current load: %s", strings.Join(pairs, ", "))
+}
+
 type ThresholdKey = string
 
 const (
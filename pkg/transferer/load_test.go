@@ -0,0 +1,73 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transferer
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+
+	"pmm-dump/pkg/grafana/client"
+)
+
+func TestCurrentValues(t *testing.T) {
+	values := []string{"12.5", "30", "75.25"}
+	requestsCount := 0
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		value := values[requestsCount%len(values)]
+		requestsCount++
+		fmt.Fprintf(rw, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1700000000,"%s"]}]}}`, value)
+	}))
+	defer server.Close()
+
+	grafanaC, err := client.NewClient(&fasthttp.Client{ReadTimeout: time.Minute}, client.AuthParams{
+		User:     "admin",
+		Password: "admin",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lc := &LoadChecker{
+		c:             grafanaC,
+		connectionURL: server.URL,
+		thresholds: []Threshold{
+			{Key: ThresholdCPU, Query: "cpu", MaxLoad: 70, CriticalLoad: 90},
+		},
+		latestValues: make(map[ThresholdKey]float64),
+	}
+
+	if got := lc.CurrentValues(); len(got) != 0 {
+		t.Fatalf("want no values before status update, got %v", got)
+	}
+
+	for _, want := range []float64{12.5, 30, 75.25} {
+		lc.updateStatus()
+		got := lc.CurrentValues()
+		if got[ThresholdCPU] != want {
+			t.Fatalf("want %v CPU load, got %v", want, got[ThresholdCPU])
+		}
+	}
+
+	snapshot := lc.CurrentValues()
+	snapshot[ThresholdCPU] = 0
+	if lc.CurrentValues()[ThresholdCPU] == 0 {
+		t.Fatal("current values should be a snapshot")
+	}
+}
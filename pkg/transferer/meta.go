@@ -15,8 +15,7 @@
 package transferer
 
 import (
-	"archive/tar"
-	"compress/gzip"
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -30,6 +29,11 @@ import (
 	"pmm-dump/pkg/dump"
 )
 
+// maxMetaSize limits the meta file size, so a corrupted header doesn't make reading it allocate a lot of memory.
+const maxMetaSize = 64 << 20
+
+const tarBlockSize = 512
+
 func ReadMetaFromDump(dumpPath string, piped bool) (*dump.Meta, error) {
 	var file *os.File
 	if piped {
@@ -43,13 +47,16 @@ func ReadMetaFromDump(dumpPath string, piped bool) (*dump.Meta, error) {
 	}
 	defer file.Close() //nolint:errcheck
 
-	gzr, err := gzip.NewReader(file)
+	return ReadMetaFromReader(file)
+}
+
+// ReadMetaFromReader reads the dump stream until the meta, ex. the dump downloaded from a storage.
+func ReadMetaFromReader(r io.Reader) (*dump.Meta, error) {
+	tr, err := dump.NewReader(r)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to open as gzip")
+		return nil, err
 	}
-	defer gzr.Close() //nolint:errcheck
-
-	tr := tar.NewReader(gzr)
+	defer tr.Close() //nolint:errcheck
 
 	for {
 		log.Debug().Msg("Reading files from dump...")
@@ -62,7 +69,7 @@ func ReadMetaFromDump(dumpPath string, piped bool) (*dump.Meta, error) {
 		}
 
 		if err != nil {
-			return nil, errors.Wrap(err, "failed to read a file from dump")
+			return nil, err
 		}
 
 		_, filename := path.Split(header.Name)
@@ -73,7 +80,15 @@ func ReadMetaFromDump(dumpPath string, piped bool) (*dump.Meta, error) {
 
 		log.Debug().Msg("Found meta file")
 
-		meta, err := readMetafile(tr)
+		if header.Size > maxMetaSize {
+			return nil, errors.Errorf("meta file is too large: %d bytes", header.Size)
+		}
+		// Reading stops at the end of the meta content, so errors of a dump truncated or corrupted after it don't matter
+		content := make([]byte, header.Size)
+		if _, err := io.ReadFull(tr, content); err != nil {
+			return nil, errors.Wrap(err, "failed to read meta file")
+		}
+		meta, err := unmarshalMeta(content)
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to read meta file")
 		}
@@ -82,7 +97,131 @@ func ReadMetaFromDump(dumpPath string, piped bool) (*dump.Meta, error) {
 	}
 }
 
-func writeMetafile(tw *tar.Writer, meta dump.Meta) error {
+// ReadMetaFromTail reads the meta from the end of the dump, ex. read by a range request without downloading the whole dump.
+// The meta is found if it starts a compressed stream within the tail, as it does in dumps of this version.
+func ReadMetaFromTail(tail []byte) (*dump.Meta, error) {
+	for i := len(tail) - 1; i >= 0; i-- {
+		// Files of uncompressed dumps start at tar blocks
+		if !dump.HasStreamMagic(tail[i:]) && (len(tail)-i)%tarBlockSize != 0 {
+			continue
+		}
+		dr, err := dump.NewReader(bytes.NewReader(tail[i:]))
+		if err != nil {
+			continue
+		}
+		header, err := dr.Next()
+		if err != nil || header.Name != dump.MetaFilename || header.Size > maxMetaSize {
+			_ = dr.Close()
+			continue
+		}
+		content := make([]byte, header.Size)
+		_, err = io.ReadFull(dr, content)
+		_ = dr.Close()
+		if err != nil {
+			continue
+		}
+		return unmarshalMeta(content)
+	}
+	return nil, errors.New("no meta file found in the end of dump")
+}
+
+// ReadFilesFromDump reads the files with the specified paths from the dump in a single pass.
+// Files that are not found in the dump are absent in the result.
+func ReadFilesFromDump(dumpPath string, piped bool, names ...string) (map[string][]byte, error) {
+	var file *os.File
+	if piped {
+		file = os.Stdin
+	} else {
+		var err error
+		file, err = os.Open(dumpPath) //nolint:gosec
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to open file")
+		}
+	}
+	defer file.Close() //nolint:errcheck
+
+	tr, err := dump.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	defer tr.Close() //nolint:errcheck
+
+	wanted := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		wanted[name] = struct{}{}
+	}
+
+	files := make(map[string][]byte, len(names))
+	for len(files) < len(wanted) {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := wanted[header.Name]; !ok {
+			continue
+		}
+
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read %s", header.Name)
+		}
+		files[header.Name] = content
+	}
+
+	return files, nil
+}
+
+// ReadTimeRangeFromDump returns the time range covered by chunks of the dump.
+// It returns false if the dump has no chunks with the time range in their names.
+func ReadTimeRangeFromDump(dumpPath string) (time.Time, time.Time, bool, error) {
+	file, err := os.Open(dumpPath) //nolint:gosec
+	if err != nil {
+		return time.Time{}, time.Time{}, false, errors.Wrap(err, "failed to open file")
+	}
+	defer file.Close() //nolint:errcheck
+
+	r, err := dump.NewReader(file)
+	if err != nil {
+		return time.Time{}, time.Time{}, false, err
+	}
+	defer r.Close() //nolint:errcheck
+
+	var start, end time.Time
+	found := false
+	for {
+		header, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return time.Time{}, time.Time{}, false, errors.Wrap(err, "failed to read a file from dump")
+		}
+
+		dir, filename := path.Split(header.Name)
+		if dump.ParseSourceType(path.Clean(dir)) == dump.UndefinedSource || filename == dump.ChunkStatsFilename {
+			continue
+		}
+		s, e, ok := dump.ParseChunkTimeRange(filename)
+		if !ok {
+			continue
+		}
+		if !found || s.Before(start) {
+			start = s
+		}
+		if !found || e.After(end) {
+			end = e
+		}
+		found = true
+	}
+
+	return start, end, found, nil
+}
+
+func writeMetafile(w *dump.Writer, meta dump.Meta) error {
 	log.Debug().Msg("Writing dump meta")
 
 	metaContent, err := json.Marshal(meta)
@@ -90,21 +229,10 @@ func writeMetafile(tw *tar.Writer, meta dump.Meta) error {
 		return fmt.Errorf("failed to marshal dump meta: %w", err)
 	}
 
-	err = tw.WriteHeader(&tar.Header{
-		Typeflag: tar.TypeReg,
-		Name:     dump.MetaFilename,
-		Size:     int64(len(metaContent)),
-		Mode:     filePermission,
-		ModTime:  time.Now(),
-	})
-	if err != nil {
+	if err = w.AddFile(dump.MetaFilename, metaContent); err != nil {
 		return errors.Wrap(err, "failed to write dump meta")
 	}
 
-	if _, err = tw.Write(metaContent); err != nil {
-		return errors.Wrap(err, "failed to write dump meta content")
-	}
-
 	return nil
 }
 
@@ -113,9 +241,12 @@ func readMetafile(r io.Reader) (*dump.Meta, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to read bytes")
 	}
+	return unmarshalMeta(metaBytes)
+}
 
+func unmarshalMeta(content []byte) (*dump.Meta, error) {
 	var meta dump.Meta
-	if err := json.Unmarshal(metaBytes, &meta); err != nil {
+	if err := json.Unmarshal(content, &meta); err != nil {
 		return nil, errors.Wrap(err, "failed to unmarshal")
 	}
 
@@ -129,13 +260,63 @@ func readAndCompareDumpMeta(r io.Reader, runtimeMeta dump.Meta) {
 		return
 	}
 
-	if dumpMeta.PMMServerVersion != runtimeMeta.PMMServerVersion {
-		log.Warn().Msgf("PMM Versions mismatch\nExported:\t%v\nCurrent:\t%v",
-			dumpMeta.PMMServerVersion, runtimeMeta.PMMServerVersion)
+	c := CompareMeta(*dumpMeta, runtimeMeta)
+	for _, msg := range append(c.Problems, c.Warnings...) {
+		log.Warn().Msg(msg)
+	}
+}
+
+// MetaComparison is the result of comparing the dump meta with the meta of the target PMM.
+type MetaComparison struct {
+	// Problems are differences that make the dump incompatible with the target PMM.
+	Problems []string
+	// Warnings are differences that don't prevent the import.
+	Warnings []string
+}
+
+// Verdict returns the compatibility of the dump with the target PMM.
+func (c MetaComparison) Verdict() string {
+	switch {
+	case len(c.Problems) > 0:
+		return "incompatible"
+	case len(c.Warnings) > 0:
+		return "compatible with warnings"
+	default:
+		return "compatible"
+	}
+}
+
+// CompareMeta compares versions, timezone and VM data format of the dump with the target PMM.
+// The dump is incompatible if it's from another major PMM version or its VM data format differs from the imported one.
+func CompareMeta(dumpMeta, runtimeMeta dump.Meta) MetaComparison {
+	var c MetaComparison
+
+	if err := dump.MetaCompatible(dumpMeta, runtimeMeta); err != nil {
+		c.Problems = append(c.Problems, err.Error())
+	}
+
+	if dumpMeta.PMMServerVersion != runtimeMeta.PMMServerVersion &&
+		dump.MajorVersion(dumpMeta.PMMServerVersion) == dump.MajorVersion(runtimeMeta.PMMServerVersion) {
+		c.Warnings = append(c.Warnings, fmt.Sprintf("PMM Versions mismatch\nExported:\t%v\nCurrent:\t%v",
+			dumpMeta.PMMServerVersion, runtimeMeta.PMMServerVersion))
 	}
 
 	if dumpMeta.Version.GitCommit != runtimeMeta.Version.GitCommit {
-		log.Warn().Msgf("pmm-dump version mismatch\nExported:\t%v\nCurrent:\t%v",
-			dumpMeta.Version.GitCommit, runtimeMeta.Version.GitCommit)
+		c.Warnings = append(c.Warnings, fmt.Sprintf("pmm-dump version mismatch\nExported:\t%v\nCurrent:\t%v",
+			dumpMeta.Version.GitCommit, runtimeMeta.Version.GitCommit))
+	}
+
+	if dumpTz, runtimeTz := timezone(dumpMeta), timezone(runtimeMeta); dumpTz != runtimeTz {
+		c.Warnings = append(c.Warnings, fmt.Sprintf("PMM timezones mismatch\nExported:\t%v\nCurrent:\t%v", dumpTz, runtimeTz))
+	}
+
+	return c
+}
+
+// timezone returns the PMM timezone of the meta. Meta has no timezone if PMM uses the browser one.
+func timezone(m dump.Meta) string {
+	if m.PMMTimezone == nil {
+		return "browser"
 	}
+	return *m.PMMTimezone
 }
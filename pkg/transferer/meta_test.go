@@ -0,0 +1,303 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transferer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"pmm-dump/pkg/dump"
+)
+
+func TestReadTimeRangeFromDump(t *testing.T) {
+	tests := []struct {
+		name      string
+		files     []string
+		wantStart int64
+		wantEnd   int64
+		wantOk    bool
+	}{
+		{
+			name:      "vm chunks",
+			files:     []string{dump.MetaFilename, "vm/1700000300-1700000600.bin", "vm/1700000000-1700000300.bin", "ch/0.tsv"},
+			wantStart: 1700000000,
+			wantEnd:   1700000600,
+			wantOk:    true,
+		},
+		{
+			name:      "ch chunks",
+			files:     []string{dump.MetaFilename, "ch/1700000000-1700000900-0.tsv", "ch/1700000000-1700000900-1.tsv"},
+			wantStart: 1700000000,
+			wantEnd:   1700000900,
+			wantOk:    true,
+		},
+		{
+			name:  "only ch chunks without time range",
+			files: []string{dump.MetaFilename, "ch/0.tsv", "ch/1.tsv"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dumpPath := filepath.Join(t.TempDir(), "dump.tar.gz")
+			file, err := os.Create(dumpPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w, err := dump.NewWriter(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range tt.files {
+				if err := w.AddFile(name, []byte("content")); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if err := file.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			start, end, ok, err := ReadTimeRangeFromDump(dumpPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != tt.wantOk {
+				t.Fatalf("want ok %v, got %v", tt.wantOk, ok)
+			}
+			if !ok {
+				return
+			}
+			if !start.Equal(time.Unix(tt.wantStart, 0)) || !end.Equal(time.Unix(tt.wantEnd, 0)) {
+				t.Fatalf("want %d-%d, got %d-%d", tt.wantStart, tt.wantEnd, start.Unix(), end.Unix())
+			}
+		})
+	}
+}
+
+func TestReadMetaFromTruncatedDump(t *testing.T) {
+	meta, err := json.Marshal(dump.Meta{PMMServerVersion: "2.41.0", VMDataFormat: "json"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The chunk is incompressible, so the dump can be truncated in the middle of it
+	chunk := make([]byte, 1<<16)
+	if _, err := rand.New(rand.NewSource(1)).Read(chunk); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		files     []string
+		shouldErr bool
+	}{
+		{
+			name:  "chunk after meta",
+			files: []string{dump.MetaFilename, "vm/1700000000-1700000300.bin"},
+		},
+		{
+			name:      "chunk before meta",
+			files:     []string{"vm/1700000000-1700000300.bin", dump.MetaFilename},
+			shouldErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dumpPath := filepath.Join(t.TempDir(), "dump.tar.gz")
+			file, err := os.Create(dumpPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w, err := dump.NewWriter(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var size int64
+			for i, name := range tt.files {
+				content := chunk
+				if name == dump.MetaFilename {
+					content = meta
+				}
+				if err := w.AddFile(name, content); err != nil {
+					t.Fatal(err)
+				}
+				if i == len(tt.files)-2 {
+					if err := w.Flush(); err != nil {
+						t.Fatal(err)
+					}
+					info, err := file.Stat()
+					if err != nil {
+						t.Fatal(err)
+					}
+					size = info.Size()
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			info, err := file.Stat()
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The dump is truncated in the middle of the last file
+			if err := file.Truncate(size + (info.Size()-size)/2); err != nil {
+				t.Fatal(err)
+			}
+			if err := file.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := ReadMetaFromDump(dumpPath, false)
+			if tt.shouldErr {
+				if err == nil {
+					t.Fatal("error expected")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.PMMServerVersion != "2.41.0" {
+				t.Fatalf("want PMM version 2.41.0, got %s", got.PMMServerVersion)
+			}
+		})
+	}
+}
+
+func TestReadMetaFromDumpCutEnd(t *testing.T) {
+	meta, err := json.Marshal(dump.Meta{PMMServerVersion: "2.41.0", VMDataFormat: "json"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	w, err := dump.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.AddFile("vm/1700000000-1700000300.bin", []byte("content")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.AddFile(dump.MetaFilename, meta); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The gzip footer and the tar end are cut, the meta should be read while its content is complete
+	dir := t.TempDir()
+	for size := buf.Len() - 1; size > 0; size-- {
+		gzr, err := gzip.NewReader(bytes.NewReader(buf.Bytes()[:size]))
+		if err != nil {
+			break
+		}
+		decompressed, _ := io.ReadAll(gzr)
+		if !bytes.Contains(decompressed, meta) {
+			break
+		}
+		dumpPath := filepath.Join(dir, fmt.Sprintf("dump-%d.tar.gz", size))
+		if err := os.WriteFile(dumpPath, buf.Bytes()[:size], 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ReadMetaFromDump(dumpPath, false); err != nil {
+			t.Fatalf("dump cut to %d of %d bytes: %v", size, buf.Len(), err)
+		}
+	}
+}
+
+func TestReadMetaFromTail(t *testing.T) {
+	for _, c := range []dump.Compression{dump.DefaultCompression, {Algorithm: dump.CompressionZstd}, {Algorithm: dump.CompressionNone}} {
+		t.Run(c.String(), func(t *testing.T) {
+			var file bytes.Buffer
+			tr, err := New(&file, []dump.Source{fakeSource{sourceType: dump.VictoriaMetrics}}, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pool, err := dump.NewChunkPool(prepareFakeChunks(time.Now().Add(-time.Hour), time.Now(), time.Minute, dump.VictoriaMetrics))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = tr.Export(context.Background(), fakeStatusGetter{status: LoadStatusOK, count: new(int)}, dump.Meta{PMMServerVersion: "2.41.0"}, pool, bytes.NewBufferString("log"), ExportOptions{Compression: c})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tail := file.Bytes()[file.Len()-min(file.Len(), 4096):]
+			meta, err := ReadMetaFromTail(tail)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if meta.PMMServerVersion != "2.41.0" || meta.Compression != c.String() {
+				t.Fatalf("unexpected meta: %+v", meta)
+			}
+			if _, err := ReadMetaFromTail(tail[len(tail)-64:]); err == nil {
+				t.Fatal("should be error for the tail without meta")
+			}
+		})
+	}
+}
+
+func TestCompareMeta(t *testing.T) {
+	utc := "UTC"
+	current := dump.Meta{
+		Version:          dump.PMMDumpVersion{GitCommit: "abc"},
+		PMMServerVersion: "2.41.0",
+		PMMTimezone:      &utc,
+		VMDataFormat:     "json",
+	}
+	tests := []struct {
+		name        string
+		dumpMeta    dump.Meta
+		wantVerdict string
+	}{
+		{
+			name:        "same",
+			dumpMeta:    current,
+			wantVerdict: "compatible",
+		},
+		{
+			name:        "different minor versions and timezone",
+			dumpMeta:    dump.Meta{Version: current.Version, PMMServerVersion: "2.40.1", VMDataFormat: "json"},
+			wantVerdict: "compatible with warnings",
+		},
+		{
+			name:        "different major versions",
+			dumpMeta:    dump.Meta{Version: current.Version, PMMServerVersion: "3.0.0", PMMTimezone: &utc, VMDataFormat: "json"},
+			wantVerdict: "incompatible",
+		},
+		{
+			name:        "different formats",
+			dumpMeta:    dump.Meta{Version: current.Version, PMMServerVersion: "2.41.0", PMMTimezone: &utc, VMDataFormat: "native"},
+			wantVerdict: "incompatible",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := CompareMeta(tt.dumpMeta, current)
+			if got := c.Verdict(); got != tt.wantVerdict {
+				t.Fatalf("want verdict %q, got %q: %+v", tt.wantVerdict, got, c)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transferer
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultProgressInterval is the interval of export and import progress logs.
+const DefaultProgressInterval = 5 * time.Second
+
+// ProgressReporter is implemented by chunk pools which report how many chunks are taken for export, ex. dump.ChunkPool.
+type ProgressReporter interface {
+	Progress() (done, total int, elapsed time.Duration)
+}
+
+// estimateRemaining returns the time left if the rest of the work goes at the same pace: elapsed / done * (total - done).
+// It's 0 if nothing is done yet, as there is no pace to estimate from.
+func estimateRemaining(done, total int64, elapsed time.Duration) time.Duration {
+	if done <= 0 || done >= total {
+		return 0
+	}
+	return time.Duration(float64(elapsed) / float64(done) * float64(total-done))
+}
+
+// progressMessage formats the progress of the action, ex. `Export progress: 10/40 chunks (25%), elapsed 1m0s, ETA 3m0s`.
+func progressMessage(action string, done, total int64, unit string, elapsed time.Duration) string {
+	msg := fmt.Sprintf("%s progress: %d/%d %s", action, done, total, unit)
+	if total > 0 {
+		msg += fmt.Sprintf(" (%d%%)", done*100/total)
+	}
+	msg += fmt.Sprintf(", elapsed %s", elapsed.Round(time.Second))
+	if done > 0 {
+		msg += fmt.Sprintf(", ETA %s", estimateRemaining(done, total, elapsed).Round(time.Second))
+	}
+	return msg
+}
+
+// logProgress logs the message returned by progress every interval until the returned function is called.
+// Logs go to stderr, so they don't mix with the dump written to stdout.
+func logProgress(interval time.Duration, progress func() string) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				log.Info().Msg(progress())
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// countingReader counts the bytes read, which are read by the import goroutine and logged by the progress one.
+type countingReader struct {
+	r io.Reader
+	n atomic.Int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n.Add(int64(n))
+	return n, err
+}
+
+// fileSize returns the size of the regular file, or 0 if r is not one, ex. the dump is piped to stdin.
+func fileSize(r io.Reader) int64 {
+	f, ok := r.(*os.File)
+	if !ok {
+		return 0
+	}
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return 0
+	}
+	return info.Size()
+}
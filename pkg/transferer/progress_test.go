@@ -0,0 +1,64 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transferer
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestProgressMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		done    int64
+		total   int64
+		elapsed time.Duration
+		want    string
+	}{
+		{name: "not started", total: 40, elapsed: time.Second, want: "Export progress: 0/40 chunks (0%), elapsed 1s"},
+		{name: "quarter", done: 10, total: 40, elapsed: time.Minute, want: "Export progress: 10/40 chunks (25%), elapsed 1m0s, ETA 3m0s"},
+		{name: "uneven", done: 3, total: 7, elapsed: 1500 * time.Millisecond, want: "Export progress: 3/7 chunks (42%), elapsed 2s, ETA 2s"},
+		{name: "done", done: 40, total: 40, elapsed: time.Minute, want: "Export progress: 40/40 chunks (100%), elapsed 1m0s, ETA 0s"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := progressMessage("Export", tt.done, tt.total, "chunks", tt.elapsed); got != tt.want {
+				t.Fatalf("want %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestFileSize(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "dump.tar.gz")
+	if err := os.WriteFile(filename, make([]byte, 100), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(filename) //nolint:gosec
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close() //nolint:errcheck
+
+	if size := fileSize(file); size != 100 {
+		t.Fatalf("want size 100, got %d", size)
+	}
+	if size := fileSize(bytes.NewReader(make([]byte, 100))); size != 0 {
+		t.Fatalf("want no size of a stream, got %d", size)
+	}
+}
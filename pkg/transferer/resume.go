@@ -0,0 +1,144 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transferer
+
+import (
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+
+	"pmm-dump/pkg/dump"
+)
+
+// PartialDump is the dump of a failed export. The resumed export copies the kept chunks of it to the new dump
+// and exports only the missing ones.
+type PartialDump struct {
+	Path string
+	// Filters is the content of the export filters file, nil if the partial dump has none.
+	Filters []byte
+	// chunks are filenames of complete chunks by their names, see chunkName.
+	chunks map[string]string
+	kept   map[string]bool
+	// Copied reports whether the kept chunks are copied to the new dump, so it can replace the partial one even if export fails.
+	Copied bool
+}
+
+// ReadPartialDump reads the names of complete chunks of the partial dump. Reading stops at the first truncated file.
+func ReadPartialDump(filename string) (*PartialDump, error) {
+	file, err := os.Open(filename) //nolint:gosec
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open partial dump")
+	}
+	defer file.Close() //nolint:errcheck
+
+	dr, err := dump.NewReader(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read partial dump")
+	}
+	defer dr.Close() //nolint:errcheck
+
+	p := &PartialDump{
+		Path:   filename,
+		chunks: make(map[string]string),
+		kept:   make(map[string]bool),
+	}
+	for {
+		header, err := dr.Next()
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.Debug().Err(err).Msg("Partial dump is truncated")
+			}
+			return p, nil
+		}
+		if header.Name == dump.FiltersFilename {
+			content, err := io.ReadAll(dr)
+			if err != nil {
+				log.Debug().Err(err).Msgf("Partial dump is truncated in %s", header.Name)
+				return p, nil
+			}
+			p.Filters = content
+			continue
+		}
+		// The size is checked by reading the whole content, as the content of the last file may be cut
+		if n, err := io.Copy(io.Discard, dr); err != nil || n != header.Size {
+			log.Debug().Err(err).Msgf("Partial dump is truncated in %s", header.Name)
+			return p, nil
+		}
+		if name, ok := partialChunkName(header.Name); ok {
+			p.chunks[name] = header.Name
+		}
+	}
+}
+
+// partialChunkName returns the chunk name of the chunk filename, ex. `vm/<start>-<end>` of `vm/<start>-<end>.bin`.
+// It returns false for other files.
+func partialChunkName(filename string) (string, bool) {
+	dir, base := path.Split(filename)
+	if (dir != dump.VictoriaMetrics.String()+"/" && dir != dump.ClickHouse.String()+"/") || base == dump.ChunkStatsFilename {
+		return "", false
+	}
+	name, _, _ := strings.Cut(base, ".")
+	return dir + name, true
+}
+
+// Keep reports whether the chunk is complete in the partial dump, and marks it to be copied to the new dump.
+// Parts of split chunks don't match the chunk name, so split chunks are exported again.
+func (p *PartialDump) Keep(m dump.ChunkMeta) bool {
+	name := chunkName(m)
+	if _, ok := p.chunks[name]; !ok {
+		return false
+	}
+	p.kept[name] = true
+	return true
+}
+
+// copyChunks copies the kept chunks of the partial dump to the new one. It returns the max size of the copied chunks.
+func (p *PartialDump) copyChunks(w *dump.Writer, checksums map[string]string) (int64, error) {
+	file, err := os.Open(p.Path) //nolint:gosec
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to open partial dump")
+	}
+	defer file.Close() //nolint:errcheck
+
+	dr, err := dump.NewReader(file)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to read partial dump")
+	}
+	defer dr.Close() //nolint:errcheck
+
+	var maxChunkSize int64
+	for copied := 0; copied < len(p.kept); {
+		header, err := dr.Next()
+		if err != nil {
+			return 0, errors.Wrapf(err, "failed to read partial dump: %d of %d chunks are copied", copied, len(p.kept))
+		}
+		name, ok := partialChunkName(header.Name)
+		if !ok || !p.kept[name] || p.chunks[name] != header.Name {
+			continue
+		}
+		log.Debug().Msgf("Copying chunk %s of the partial dump", header.Name)
+		if err := addFileWithChecksum(w, header.Name, dr, header.Size, checksums); err != nil {
+			return 0, errors.Wrap(err, "failed to copy chunk")
+		}
+		maxChunkSize = max(maxChunkSize, header.Size)
+		copied++
+	}
+	log.Info().Msgf("Copied %d chunks of the partial dump %s", len(p.kept), p.Path)
+	return maxChunkSize, nil
+}
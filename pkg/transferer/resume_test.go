@@ -0,0 +1,144 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transferer
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"pmm-dump/pkg/dump"
+)
+
+func TestPartialChunkName(t *testing.T) {
+	tests := []struct {
+		filename string
+		want     string
+		wantOK   bool
+	}{
+		{filename: "vm/1-2.bin", want: "vm/1-2", wantOK: true},
+		{filename: "vm/1-2.prom.gz", want: "vm/1-2", wantOK: true},
+		{filename: "vm/1-2-node_load1.bin", want: "vm/1-2-node_load1", wantOK: true},
+		{filename: "ch/1-2-0.tsv", want: "ch/1-2-0", wantOK: true},
+		{filename: "vm/" + dump.ChunkStatsFilename},
+		{filename: dump.FiltersFilename},
+		{filename: dump.MetaFilename},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filename, func(t *testing.T) {
+			got, ok := partialChunkName(tt.filename)
+			if got != tt.want || ok != tt.wantOK {
+				t.Fatalf("want %q, %v, got %q, %v", tt.want, tt.wantOK, got, ok)
+			}
+		})
+	}
+}
+
+func TestExportResumePartialDump(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	chunks := prepareFakeChunks(time.Now().Add(-time.Hour), time.Now(), time.Minute, dump.VictoriaMetrics)
+	filters := dump.File{Name: dump.FiltersFilename, Content: []byte(`{"metrics":["up"]}`)}
+
+	export := func(t *testing.T, dumpPath string, s dump.Source, partial *PartialDump) error {
+		t.Helper()
+		file, err := os.Create(dumpPath) //nolint:gosec
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr, err := New(file, []dump.Source{s}, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pool, err := dump.NewChunkPool(chunks)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts := ExportOptions{HeadFiles: []dump.File{filters}}
+		if partial != nil {
+			pool.Skip(partial.Keep)
+			opts.Partial = partial
+		}
+		return tr.Export(ctx, fakeStatusGetter{status: LoadStatusOK, count: new(int)}, dump.Meta{}, pool, new(bytes.Buffer), opts)
+	}
+
+	partialPath := filepath.Join(dir, "partial.tar.gz")
+	failing := failingSource{fakeSource: fakeSource{sourceType: dump.VictoriaMetrics}, failAt: *chunks[len(chunks)/2].Start}
+	if err := export(t, partialPath, failing, nil); err == nil {
+		t.Fatal("should be error")
+	}
+	// The dropped connection may leave the partial dump cut anywhere
+	info, err := os.Stat(partialPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(partialPath, info.Size()-10); err != nil {
+		t.Fatal(err)
+	}
+
+	partial, err := ReadPartialDump(partialPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(partial.Filters, filters.Content) {
+		t.Fatalf("want filters %s in partial dump, got %s", filters.Content, partial.Filters)
+	}
+	if len(partial.chunks) == 0 || len(partial.chunks) >= len(chunks) {
+		t.Fatalf("want part of chunks in partial dump, got %d of %d", len(partial.chunks), len(chunks))
+	}
+
+	resumedPath := filepath.Join(dir, "resumed.tar.gz")
+	if err := export(t, resumedPath, fakeSource{sourceType: dump.VictoriaMetrics}, partial); err != nil {
+		t.Fatal(err)
+	}
+	if !partial.Copied || len(partial.kept) != len(partial.chunks) {
+		t.Fatalf("want %d chunks of partial dump copied, got %d", len(partial.chunks), len(partial.kept))
+	}
+
+	file, err := os.Open(resumedPath) //nolint:gosec
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close() //nolint:errcheck
+	dr, err := dump.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]int)
+	for {
+		header, err := dr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[header.Name]++
+	}
+	for _, c := range chunks {
+		if name := "vm/" + c.String() + ".bin"; files[name] != 1 {
+			t.Fatalf("want chunk %s once in dump, got %d times", name, files[name])
+		}
+	}
+	if files[dump.FiltersFilename] != 1 || files[dump.MetaFilename] != 1 || len(files) != len(chunks)+3 {
+		t.Fatalf("want %d chunks, filters, meta and log in dump, got %v", len(chunks), files)
+	}
+}
@@ -50,6 +50,16 @@ func New(file io.ReadWriter, s []dump.Source, workersCount int) (*Transferer, er
 	}, nil
 }
 
+// Sources returns the sources the transferer exports from or imports to.
+func (t Transferer) Sources() []dump.Source {
+	return t.sources
+}
+
+// WorkersCount returns the number of workers used to read or write chunks.
+func (t Transferer) WorkersCount() int {
+	return t.workersCount
+}
+
 type ChunkPool interface {
 	Next() (dump.ChunkMeta, bool)
 }
@@ -58,6 +68,11 @@ type LoadStatusGetter interface {
 	GetLatestStatus() (LoadStatus, int)
 }
 
+// LoadValuesGetter is implemented by load status getters which expose the latest sampled load values.
+type LoadValuesGetter interface {
+	CurrentValues() map[ThresholdKey]float64
+}
+
 func (t Transferer) sourceByType(st dump.SourceType) (dump.Source, bool) { //nolint:ireturn,nolintlint
 	for _, s := range t.sources {
 		if s.Type() == st {
@@ -15,8 +15,10 @@
 package transferer
 
 import (
+	"context"
 	"io"
 	"os"
+	"runtime"
 	"testing"
 
 	"github.com/pkg/errors"
@@ -35,7 +37,7 @@ func (s fakeSource) Type() dump.SourceType {
 	return s.sourceType
 }
 
-func (s fakeSource) ReadChunk(m dump.ChunkMeta) (*dump.Chunk, error) {
+func (s fakeSource) ReadChunk(_ context.Context, m dump.ChunkMeta) (*dump.Chunk, error) {
 	return &dump.Chunk{
 		ChunkMeta: m,
 		Content:   []byte("content"),
@@ -43,6 +45,10 @@ func (s fakeSource) ReadChunk(m dump.ChunkMeta) (*dump.Chunk, error) {
 	}, nil
 }
 
+func (s fakeSource) ContentType() string {
+	return "text/plain"
+}
+
 func (s fakeSource) WriteChunk(_ string, r io.Reader) error {
 	chunkContent, err := io.ReadAll(r)
 	if err != nil {
@@ -64,6 +70,80 @@ func (s fakeSource) FinalizeWrites() error {
 	return nil
 }
 
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name         string
+		sources      []dump.Source
+		workersCount int
+		wantWorkers  int
+		shouldErr    bool
+	}{
+		{
+			name:      "no sources",
+			shouldErr: true,
+		},
+		{
+			name:         "default workers count",
+			sources:      []dump.Source{&fakeSource{dump.VictoriaMetrics, false}},
+			workersCount: 0,
+			wantWorkers:  runtime.NumCPU(),
+		},
+		{
+			name:         "qan only",
+			sources:      []dump.Source{&fakeSource{dump.ClickHouse, false}},
+			workersCount: 2,
+			wantWorkers:  2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr, err := New(nil, tt.sources, tt.workersCount)
+			if err != nil {
+				if tt.shouldErr {
+					return
+				}
+				t.Fatal(err)
+			}
+			if tt.shouldErr {
+				t.Fatal("error is empty")
+			}
+			checkTransfererSources(t, tr, tt.sources, tt.wantWorkers)
+		})
+	}
+}
+
+func checkTransfererSources(t *testing.T, tr *Transferer, sources []dump.Source, workersCount int) {
+	t.Helper()
+
+	if tr.WorkersCount() != workersCount {
+		t.Fatalf("want %d workers, got %d", workersCount, tr.WorkersCount())
+	}
+	if len(tr.Sources()) != len(sources) {
+		t.Fatalf("want %d sources, got %d", len(sources), len(tr.Sources()))
+	}
+	for i, s := range tr.Sources() {
+		if s.Type() != sources[i].Type() {
+			t.Fatalf("want %v source, got %v", sources[i].Type(), s.Type())
+		}
+	}
+	for _, st := range []dump.SourceType{dump.VictoriaMetrics, dump.ClickHouse} {
+		_, want := sourceByType(sources, st)
+		_, got := tr.sourceByType(st)
+		if want != got {
+			t.Fatalf("%v source presence mismatch: want %v, got %v", st, want, got)
+		}
+	}
+}
+
+func sourceByType(sources []dump.Source, st dump.SourceType) (dump.Source, bool) { //nolint:ireturn
+	for _, s := range sources {
+		if s.Type() == st {
+			return s, true
+		}
+	}
+	return nil, false
+}
+
 func TestMain(m *testing.M) {
 	log.Logger = zerolog.Nop()
 	m.Run()
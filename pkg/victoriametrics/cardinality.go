@@ -0,0 +1,106 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package victoriametrics
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Cardinality counts unique series in VictoriaMetrics chunks by label names and label name/value pairs.
+type Cardinality struct {
+	series      map[string]struct{}
+	labelNames  map[string]int
+	labelValues map[string]int
+}
+
+type LabelCount struct {
+	Label  string
+	Series int
+}
+
+func NewCardinality() *Cardinality {
+	return &Cardinality{
+		series:      make(map[string]struct{}),
+		labelNames:  make(map[string]int),
+		labelValues: make(map[string]int),
+	}
+}
+
+// AddChunk adds series from the gzipped chunk in JSON format.
+func (c *Cardinality) AddChunk(content []byte) error {
+	metrics, err := decompressChunk(content)
+	if err != nil {
+		return errors.Wrap(err, "failed to decompress chunk")
+	}
+	for _, m := range metrics {
+		c.Add(m)
+	}
+	return nil
+}
+
+// Add adds the metric series, if it wasn't added before.
+func (c *Cardinality) Add(m Metric) {
+	labels := make([]string, 0, len(m.Metric))
+	for name, value := range m.Metric {
+		labels = append(labels, name+"="+value)
+	}
+	sort.Strings(labels)
+
+	key := strings.Join(labels, ",")
+	if _, ok := c.series[key]; ok {
+		return
+	}
+	c.series[key] = struct{}{}
+
+	for name, value := range m.Metric {
+		c.labelNames[name]++
+		c.labelValues[name+"="+value]++
+	}
+}
+
+// Series returns the number of unique series.
+func (c *Cardinality) Series() int {
+	return len(c.series)
+}
+
+// TopLabelNames returns n label names with the most series.
+func (c *Cardinality) TopLabelNames(n int) []LabelCount {
+	return topLabels(c.labelNames, n)
+}
+
+// TopLabelValues returns n label name/value pairs with the most series.
+func (c *Cardinality) TopLabelValues(n int) []LabelCount {
+	return topLabels(c.labelValues, n)
+}
+
+func topLabels(counts map[string]int, n int) []LabelCount {
+	result := make([]LabelCount, 0, len(counts))
+	for label, series := range counts {
+		result = append(result, LabelCount{Label: label, Series: series})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Series != result[j].Series {
+			return result[i].Series > result[j].Series
+		}
+		return result[i].Label < result[j].Label
+	})
+	if len(result) > n {
+		result = result[:n]
+	}
+	return result
+}
@@ -0,0 +1,66 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package victoriametrics
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCardinality(t *testing.T) {
+	metrics := []Metric{
+		{Metric: map[string]string{"__name__": "up", "service_name": "mongo"}},
+		{Metric: map[string]string{"__name__": "up", "service_name": "mysql"}},
+		{Metric: map[string]string{"__name__": "cpu", "service_name": "mongo", "mode": "idle"}},
+		// the same series from another chunk
+		{Metric: map[string]string{"service_name": "mongo", "__name__": "up"}},
+	}
+	chunk, err := compressChunk(metrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewCardinality()
+	if err := c.AddChunk(chunk); err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Series() != 3 {
+		t.Fatalf("want 3 series, got %d", c.Series())
+	}
+
+	wantNames := []LabelCount{
+		{Label: "__name__", Series: 3},
+		{Label: "service_name", Series: 3},
+	}
+	if got := c.TopLabelNames(2); !reflect.DeepEqual(got, wantNames) {
+		t.Fatalf("want %v label names, got %v", wantNames, got)
+	}
+
+	wantValues := []LabelCount{
+		{Label: "__name__=up", Series: 2},
+		{Label: "service_name=mongo", Series: 2},
+		{Label: "__name__=cpu", Series: 1},
+		{Label: "mode=idle", Series: 1},
+		{Label: "service_name=mysql", Series: 1},
+	}
+	if got := c.TopLabelValues(10); !reflect.DeepEqual(got, wantValues) {
+		t.Fatalf("want %v label values, got %v", wantValues, got)
+	}
+
+	if err := c.AddChunk([]byte("invalid")); err == nil {
+		t.Fatal("should be error")
+	}
+}
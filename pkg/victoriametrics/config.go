@@ -14,9 +14,158 @@
 
 package victoriametrics
 
+import (
+	"compress/gzip"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Data formats of VictoriaMetrics chunks, stored in the dump meta as `vm-data-format`.
+const (
+	FormatJSON        = "json"
+	FormatNative      = "native"
+	FormatOpenMetrics = "openmetrics"
+)
+
+// Extensions of chunk filenames of the data formats. JSON chunks keep the extension of older dumps.
+// Native and OpenMetrics chunks were named `.bin` too before, import doesn't depend on the extension.
+const (
+	jsonChunkExtension        = ".bin"
+	nativeChunkExtension      = ".nbin"
+	openMetricsChunkExtension = ".prom.gz"
+)
+
+// Content types of chunks of the data formats, stored in the dump meta. VictoriaMetrics returns gzipped exports.
+const (
+	jsonContentType        = "application/x-ndjson+gzip"
+	nativeContentType      = "application/x-victoriametrics-native+gzip"
+	openMetricsContentType = "application/openmetrics-text+gzip"
+)
+
 type Config struct {
 	ConnectionURL       string
 	TimeSeriesSelectors []string
 	NativeData          bool
-	ContentLimit        int
+	// OpenMetrics enables chunks in the Prometheus text exposition format. They are exported as JSON and converted.
+	OpenMetrics bool
+	// ContentLimit is the max chunk content size in bytes. Larger chunks are split on export and on import.
+	ContentLimit int
+	// SplitByName splits exported JSON chunks into chunks of a single metric name.
+	SplitByName bool
+	// ExclusiveEnd excludes samples at the chunk end, as they belong to the next aligned chunk.
+	ExclusiveEnd bool
+	// ImportMatcher limits imported JSON chunks to the matching series.
+	ImportMatcher *SeriesMatcher
+	// ImportLabels are added to every series of imported JSON chunks.
+	ImportLabels map[string]string
+	// ChunkCompressionLevel is the gzip level (1-9) of chunks compressed by pmm-dump, ex. split or converted ones.
+	// Chunks written as returned by VictoriaMetrics keep their compression. 0 means the default level.
+	ChunkCompressionLevel int
+	// Dedup merges duplicate series of exported JSON chunks and collapses samples with the same timestamp.
+	Dedup bool
+	// ExportParams are additional query params of export requests, see ValidateExportParams.
+	ExportParams map[string]string
+
+	// FinalizeRetries is the number of retries of the cache reset after import, 3 by default.
+	FinalizeRetries int
+	// FinalizeRetryDelay is the delay before the first retry, it's doubled on every next retry. 1s by default.
+	FinalizeRetryDelay time.Duration
+}
+
+const (
+	defaultFinalizeRetries    = 3
+	defaultFinalizeRetryDelay = time.Second
+)
+
+func (c Config) finalizeRetries() int {
+	if c.FinalizeRetries <= 0 {
+		return defaultFinalizeRetries
+	}
+	return c.FinalizeRetries
+}
+
+func (c Config) finalizeRetryDelay() time.Duration {
+	if c.FinalizeRetryDelay <= 0 {
+		return defaultFinalizeRetryDelay
+	}
+	return c.FinalizeRetryDelay
+}
+
+// chunkExtension returns the extension of chunk filenames of the data format.
+func (c Config) chunkExtension() string {
+	switch {
+	case c.NativeData:
+		return nativeChunkExtension
+	case c.OpenMetrics:
+		return openMetricsChunkExtension
+	default:
+		return jsonChunkExtension
+	}
+}
+
+// contentType returns the content type of chunks of the data format.
+func (c Config) contentType() string {
+	switch {
+	case c.NativeData:
+		return nativeContentType
+	case c.OpenMetrics:
+		return openMetricsContentType
+	default:
+		return jsonContentType
+	}
+}
+
+// exportParams are the export query params which tune VictoriaMetrics, but don't change the exported series and their format.
+var exportParams = map[string]func(value string) error{
+	"reduce_mem_usage": func(value string) error {
+		_, err := strconv.ParseBool(value)
+		return err
+	},
+	"max_rows_per_line": func(value string) error {
+		rows, err := strconv.Atoi(value)
+		if err == nil && rows <= 0 {
+			return errors.New("should be positive")
+		}
+		return err
+	},
+}
+
+// ValidateExportParams checks that the params are known to be safe for export and have valid values.
+func ValidateExportParams(params map[string]string) error {
+	for key, value := range params {
+		validate, ok := exportParams[key]
+		if !ok {
+			known := make([]string, 0, len(exportParams))
+			for k := range exportParams {
+				known = append(known, k)
+			}
+			sort.Strings(known)
+			return errors.Errorf("export param %s is not supported, supported params: %v", key, known)
+		}
+		if err := validate(value); err != nil {
+			return errors.Wrapf(err, "invalid value %q of export param %s", value, key)
+		}
+	}
+	return nil
+}
+
+// Validate checks that the content limit is used only with JSON data, as other formats can't be split.
+func (c Config) Validate() error {
+	if c.ContentLimit != 0 && c.NativeData {
+		return errors.New("content limit is not supported for native data")
+	}
+	if c.ContentLimit != 0 && c.OpenMetrics {
+		return errors.New("content limit is not supported for OpenMetrics data")
+	}
+	return nil
+}
+
+func (c Config) gzipLevel() int {
+	if c.ChunkCompressionLevel == 0 {
+		return gzip.DefaultCompression
+	}
+	return c.ChunkCompressionLevel
 }
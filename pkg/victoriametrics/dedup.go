@@ -0,0 +1,90 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package victoriametrics
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// dedupMetrics merges series with the same labels and collapses samples with the same timestamp, keeping the last value.
+// Series keep the order of their first occurrence, samples are sorted by timestamp. It returns the number of dropped samples.
+func dedupMetrics(metrics []Metric) ([]Metric, int) {
+	index := make(map[string]int, len(metrics))
+	result := make([]Metric, 0, len(metrics))
+	for _, m := range metrics {
+		key := seriesKey(m.Metric)
+		i, ok := index[key]
+		if !ok {
+			index[key] = len(result)
+			result = append(result, Metric{Metric: m.Metric})
+			i = len(result) - 1
+		}
+		result[i].Values = append(result[i].Values, m.Values...)
+		result[i].Timestamps = append(result[i].Timestamps, m.Timestamps...)
+	}
+
+	dropped := 0
+	for i := range result {
+		dropped += dedupSamples(&result[i])
+	}
+	return result, dropped
+}
+
+// dedupSamples sorts samples of the series by timestamp and keeps the last value of equal timestamps.
+func dedupSamples(m *Metric) int {
+	n := len(m.Timestamps)
+	if len(m.Values) < n {
+		n = len(m.Values)
+	}
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return m.Timestamps[order[i]] < m.Timestamps[order[j]]
+	})
+
+	values := make([]float64, 0, n)
+	timestamps := make([]int64, 0, n)
+	for _, i := range order {
+		if last := len(timestamps) - 1; last >= 0 && timestamps[last] == m.Timestamps[i] {
+			values[last] = m.Values[i]
+			continue
+		}
+		values = append(values, m.Values[i])
+		timestamps = append(timestamps, m.Timestamps[i])
+	}
+	m.Values, m.Timestamps = values, timestamps
+	return n - len(timestamps)
+}
+
+// dedupChunk deduplicates series and samples of the gzipped JSON chunk content. The content is returned as is if it has no duplicates.
+func dedupChunk(content []byte, level int) ([]byte, int, error) {
+	metrics, err := decompressChunk(content)
+	if err != nil {
+		return nil, 0, err
+	}
+	deduped, dropped := dedupMetrics(metrics)
+	if dropped == 0 && len(deduped) == len(metrics) {
+		return content, 0, nil
+	}
+	result, err := compressChunkLevel(deduped, level)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "failed to compress chunk")
+	}
+	return result, dropped, nil
+}
@@ -0,0 +1,112 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package victoriametrics
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDedupMetrics(t *testing.T) {
+	pgUp := map[string]string{"__name__": "pg_up", "service_name": "pg", "job": "postgres_exporter_agent_id"}
+	pgUpOther := map[string]string{"job": "postgres_exporter_agent_id", "service_name": "pg2", "__name__": "pg_up"}
+	// Labels of the same series may be in another order
+	pgUpCopy := map[string]string{"job": "postgres_exporter_agent_id", "service_name": "pg", "__name__": "pg_up"}
+
+	tests := []struct {
+		name        string
+		metrics     []Metric
+		want        []Metric
+		wantDropped int
+	}{
+		{
+			name: "no duplicates",
+			metrics: []Metric{
+				{Metric: pgUp, Values: []float64{1, 1}, Timestamps: []int64{1000, 2000}},
+				{Metric: pgUpOther, Values: []float64{0}, Timestamps: []int64{1000}},
+			},
+			want: []Metric{
+				{Metric: pgUp, Values: []float64{1, 1}, Timestamps: []int64{1000, 2000}},
+				{Metric: pgUpOther, Values: []float64{0}, Timestamps: []int64{1000}},
+			},
+		},
+		{
+			name: "duplicate series",
+			metrics: []Metric{
+				{Metric: pgUp, Values: []float64{1, 1}, Timestamps: []int64{1000, 2000}},
+				{Metric: pgUpOther, Values: []float64{0}, Timestamps: []int64{1000}},
+				{Metric: pgUpCopy, Values: []float64{0, 0, 1}, Timestamps: []int64{2000, 1000, 3000}},
+			},
+			want: []Metric{
+				{Metric: pgUp, Values: []float64{0, 0, 1}, Timestamps: []int64{1000, 2000, 3000}},
+				{Metric: pgUpOther, Values: []float64{0}, Timestamps: []int64{1000}},
+			},
+			wantDropped: 2,
+		},
+		{
+			name: "duplicate timestamps",
+			metrics: []Metric{
+				{Metric: pgUp, Values: []float64{1, 2, 3, 4}, Timestamps: []int64{1000, 1000, 2000, 1000}},
+			},
+			want: []Metric{
+				{Metric: pgUp, Values: []float64{4, 3}, Timestamps: []int64{1000, 2000}},
+			},
+			wantDropped: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, dropped := dedupMetrics(tt.metrics)
+			if dropped != tt.wantDropped {
+				t.Fatalf("want %d dropped samples, got %d", tt.wantDropped, dropped)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("want %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestDedupChunk(t *testing.T) {
+	pgUp := map[string]string{"__name__": "pg_up", "service_name": "pg"}
+	content, err := compressData([]byte(`{"metric":{"__name__":"pg_up","service_name":"pg"},"values":[1],"timestamps":[1000]}` +
+		`{"metric":{"__name__":"pg_up","service_name":"pg"},"values":[0],"timestamps":[1000]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	deduped, dropped, err := dedupChunk(content, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dropped != 1 {
+		t.Fatalf("want 1 dropped sample, got %d", dropped)
+	}
+	metrics, err := decompressChunk(deduped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Metric{{Metric: pgUp, Values: []float64{0}, Timestamps: []int64{1000}}}
+	if !reflect.DeepEqual(metrics, want) {
+		t.Fatalf("want %v, got %v", want, metrics)
+	}
+
+	same, dropped, err := dedupChunk(deduped, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dropped != 0 || &same[0] != &deduped[0] {
+		t.Fatal("chunk without duplicates should be returned as is")
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package victoriametrics
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// SeriesSet collects series from VictoriaMetrics chunks. Samples of the series with the same labels are merged.
+type SeriesSet struct {
+	series map[string]*Metric
+}
+
+func NewSeriesSet() *SeriesSet {
+	return &SeriesSet{series: make(map[string]*Metric)}
+}
+
+// AddChunk adds series from the gzipped chunk in JSON format.
+func (s *SeriesSet) AddChunk(content []byte) error {
+	metrics, err := decompressChunk(content)
+	if err != nil {
+		return errors.Wrap(err, "failed to decompress chunk")
+	}
+	for _, m := range metrics {
+		s.Add(m)
+	}
+	return nil
+}
+
+// Add adds samples of the series.
+func (s *SeriesSet) Add(m Metric) {
+	hash := m.MetricHash()
+	existing, ok := s.series[hash]
+	if !ok {
+		s.series[hash] = &Metric{Metric: m.Metric, Values: m.Values, Timestamps: m.Timestamps}
+		return
+	}
+	existing.Values = append(existing.Values, m.Values...)
+	existing.Timestamps = append(existing.Timestamps, m.Timestamps...)
+}
+
+// Len returns the number of unique series.
+func (s *SeriesSet) Len() int {
+	return len(s.series)
+}
+
+// SeriesDiff is the difference between two series sets. Series are sorted by labels.
+type SeriesDiff struct {
+	// OnlyX and OnlyY are series present in one of the sets only.
+	OnlyX, OnlyY []Metric
+	// Different are series present in both sets, but with different samples.
+	Different []SamplesDiff
+}
+
+// SamplesDiff is the difference between samples of the series with the same labels.
+type SamplesDiff struct {
+	Metric  map[string]string
+	Samples []SampleDiff
+}
+
+// Empty checks if the sets have the same series and samples.
+func (d SeriesDiff) Empty() bool {
+	return len(d.OnlyX) == 0 && len(d.OnlyY) == 0 && len(d.Different) == 0
+}
+
+// DiffSeries compares series sets x and y.
+func DiffSeries(x, y *SeriesSet) SeriesDiff {
+	var d SeriesDiff
+	for hash, xm := range x.series {
+		ym, ok := y.series[hash]
+		if !ok {
+			d.OnlyX = append(d.OnlyX, *xm)
+			continue
+		}
+		if samples := xm.CompareTimestampValues(*ym); len(samples) > 0 {
+			d.Different = append(d.Different, SamplesDiff{Metric: xm.Metric, Samples: samples})
+		}
+	}
+	for hash, ym := range y.series {
+		if _, ok := x.series[hash]; !ok {
+			d.OnlyY = append(d.OnlyY, *ym)
+		}
+	}
+
+	sortMetrics(d.OnlyX)
+	sortMetrics(d.OnlyY)
+	sort.Slice(d.Different, func(i, j int) bool {
+		return seriesKey(d.Different[i].Metric) < seriesKey(d.Different[j].Metric)
+	})
+	return d
+}
+
+func sortMetrics(metrics []Metric) {
+	sort.Slice(metrics, func(i, j int) bool {
+		return seriesKey(metrics[i].Metric) < seriesKey(metrics[j].Metric)
+	})
+}
@@ -0,0 +1,86 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package victoriametrics
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompareTimestampValues(t *testing.T) {
+	x := Metric{Values: []float64{1, 2, 3}, Timestamps: []int64{1000, 2000, 3000}}
+	y := Metric{Values: []float64{1, 5, 4}, Timestamps: []int64{1000, 2000, 4000}}
+
+	two, three, four, five := 2.0, 3.0, 4.0, 5.0
+	want := []SampleDiff{
+		{Timestamp: 2000, X: &two, Y: &five},
+		{Timestamp: 3000, X: &three},
+		{Timestamp: 4000, Y: &four},
+	}
+	if got := x.CompareTimestampValues(y); !reflect.DeepEqual(got, want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+	if got := x.CompareTimestampValues(x); len(got) != 0 {
+		t.Fatalf("want no diffs, got %v", got)
+	}
+}
+
+func TestDiffSeries(t *testing.T) {
+	up := map[string]string{"__name__": "up", "job": "node"}
+	load := map[string]string{"__name__": "node_load1", "job": "node"}
+	mysqlUp := map[string]string{"__name__": "mysql_up", "job": "mysql"}
+	mongoUp := map[string]string{"__name__": "mongodb_up", "job": "mongodb"}
+
+	x := NewSeriesSet()
+	x.Add(Metric{Metric: up, Values: []float64{1}, Timestamps: []int64{1000}})
+	x.Add(Metric{Metric: load, Values: []float64{0.5}, Timestamps: []int64{1000}})
+	x.Add(Metric{Metric: mysqlUp, Values: []float64{1}, Timestamps: []int64{1000}})
+	// Samples of the next chunk
+	x.Add(Metric{Metric: up, Values: []float64{1}, Timestamps: []int64{2000}})
+
+	y := NewSeriesSet()
+	y.Add(Metric{Metric: up, Values: []float64{1, 1}, Timestamps: []int64{1000, 2000}})
+	y.Add(Metric{Metric: load, Values: []float64{0.7}, Timestamps: []int64{1000}})
+	y.Add(Metric{Metric: mongoUp, Values: []float64{1}, Timestamps: []int64{1000}})
+
+	if x.Len() != 3 || y.Len() != 3 {
+		t.Fatalf("want 3 series in both sets, got %d and %d", x.Len(), y.Len())
+	}
+
+	d := DiffSeries(x, y)
+	if d.Empty() {
+		t.Fatal("diff shouldn't be empty")
+	}
+	if len(d.OnlyX) != 1 || !reflect.DeepEqual(d.OnlyX[0].Metric, mysqlUp) {
+		t.Fatalf("want only %v in x, got %v", mysqlUp, d.OnlyX)
+	}
+	if len(d.OnlyY) != 1 || !reflect.DeepEqual(d.OnlyY[0].Metric, mongoUp) {
+		t.Fatalf("want only %v in y, got %v", mongoUp, d.OnlyY)
+	}
+	if len(d.Different) != 1 || !reflect.DeepEqual(d.Different[0].Metric, load) || len(d.Different[0].Samples) != 1 {
+		t.Fatalf("want different samples of %v, got %v", load, d.Different)
+	}
+
+	if d := DiffSeries(x, x); !d.Empty() {
+		t.Fatalf("want empty diff, got %v", d)
+	}
+}
+
+func TestFormatLabels(t *testing.T) {
+	got := FormatLabels(map[string]string{"job": "node", "__name__": "up", "path": `C:\`})
+	if want := `{__name__="up", job="node", path="C:\\"}`; got != want {
+		t.Fatalf("want %s, got %s", want, got)
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package victoriametrics
+
+import (
+	"regexp"
+
+	"github.com/pkg/errors"
+)
+
+var labelNameRe = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// ValidateExtraLabels checks that labels can be added to imported series: names should be valid and not reserved.
+func ValidateExtraLabels(labels map[string]string) error {
+	for name := range labels {
+		if !labelNameRe.MatchString(name) {
+			return errors.Errorf("invalid label name %q", name)
+		}
+		if name == "__name__" {
+			return errors.New("metric name can't be changed")
+		}
+	}
+	return nil
+}
+
+// addLabels returns the gzipped JSON chunk content with the labels set on every series.
+// Existing labels with the same names are overwritten.
+func addLabels(content []byte, labels map[string]string, level int) ([]byte, error) {
+	metrics, err := decompressChunk(content)
+	if err != nil {
+		return nil, err
+	}
+	for i := range metrics {
+		if metrics[i].Metric == nil {
+			metrics[i].Metric = make(map[string]string, len(labels))
+		}
+		for name, value := range labels {
+			metrics[i].Metric[name] = value
+		}
+	}
+	result, err := compressChunkLevel(metrics, level)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to compress chunk")
+	}
+	return result, nil
+}
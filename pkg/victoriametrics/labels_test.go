@@ -0,0 +1,76 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package victoriametrics
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidateExtraLabels(t *testing.T) {
+	tests := []struct {
+		name      string
+		labels    map[string]string
+		shouldErr bool
+	}{
+		{
+			name:   "valid",
+			labels: map[string]string{"source_pmm": "serverA", "_dc": "eu-1"},
+		},
+		{
+			name:      "invalid name",
+			labels:    map[string]string{"source-pmm": "serverA"},
+			shouldErr: true,
+		},
+		{
+			name:      "metric name",
+			labels:    map[string]string{"__name__": "up"},
+			shouldErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateExtraLabels(tt.labels)
+			if err != nil && !tt.shouldErr {
+				t.Fatal(err)
+			} else if err == nil && tt.shouldErr {
+				t.Fatal("should be error")
+			}
+		})
+	}
+}
+
+func TestAddLabels(t *testing.T) {
+	content, err := compressData([]byte(`{"metric":{"__name__":"up","source_pmm":"old"},"values":[1],"timestamps":[1000]}` +
+		`{"metric":{"__name__":"node_load1"},"values":[0.5],"timestamps":[1000]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := addLabels(content, map[string]string{"source_pmm": "serverA"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics, err := decompressChunk(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Metric{
+		{Metric: map[string]string{"__name__": "up", "source_pmm": "serverA"}, Values: []float64{1}, Timestamps: []int64{1000}},
+		{Metric: map[string]string{"__name__": "node_load1", "source_pmm": "serverA"}, Values: []float64{0.5}, Timestamps: []int64{1000}},
+	}
+	if !reflect.DeepEqual(metrics, want) {
+		t.Fatalf("want %v, got %v", want, metrics)
+	}
+}
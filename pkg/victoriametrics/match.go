@@ -0,0 +1,191 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package victoriametrics
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/VictoriaMetrics/metricsql"
+	"github.com/pkg/errors"
+)
+
+// SeriesMatcher matches series labels against a time series selector, the same way VictoriaMetrics does.
+type SeriesMatcher struct {
+	// filterss are alternatives of the `or` selector, filters of every alternative should match all together.
+	filterss [][]labelFilter
+}
+
+type labelFilter struct {
+	label    string
+	value    string
+	negative bool
+	re       *regexp.Regexp
+}
+
+// NewSeriesMatcher parses the time series selector, ex. `{service_name="mongo"}` or `node_load1{node_name=~"db.*"}`.
+func NewSeriesMatcher(selector string) (*SeriesMatcher, error) {
+	expr, err := metricsql.Parse(selector)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse selector")
+	}
+	me, ok := expr.(*metricsql.MetricExpr)
+	if !ok {
+		return nil, errors.Errorf("%s is not a time series selector", selector)
+	}
+
+	m := new(SeriesMatcher)
+	for _, lfs := range me.LabelFilterss {
+		filters := make([]labelFilter, 0, len(lfs))
+		for _, lf := range lfs {
+			f := labelFilter{
+				label:    lf.Label,
+				value:    lf.Value,
+				negative: lf.IsNegative,
+			}
+			if lf.IsRegexp {
+				// VictoriaMetrics regexps are anchored to the whole label value
+				f.re, err = regexp.Compile("^(?:" + lf.Value + ")$")
+				if err != nil {
+					return nil, errors.Wrapf(err, "failed to compile regexp of label %s", lf.Label)
+				}
+			}
+			filters = append(filters, f)
+		}
+		m.filterss = append(m.filterss, filters)
+	}
+	return m, nil
+}
+
+// NameRegexSelector returns the time series selector matching metric names by the regexp.
+func NameRegexSelector(nameRegex string) string {
+	return "{__name__=~" + QuoteLabelValue(nameRegex) + "}"
+}
+
+// ScopeSelectors adds the filter of metric names by the regexp to every selector, so only matching metrics are exported.
+// Without selectors it returns the selector of the regexp only.
+func ScopeSelectors(selectors []string, nameRegex string) ([]string, error) {
+	if _, err := regexp.Compile(nameRegex); err != nil {
+		return nil, errors.Wrap(err, "failed to compile name regexp")
+	}
+	if len(selectors) == 0 {
+		return []string{NameRegexSelector(nameRegex)}, nil
+	}
+	scoped := make([]string, 0, len(selectors))
+	for _, selector := range selectors {
+		expr, err := metricsql.Parse(selector)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse selector %s", selector)
+		}
+		me, ok := expr.(*metricsql.MetricExpr)
+		if !ok {
+			return nil, errors.Errorf("%s is not a time series selector", selector)
+		}
+		for i := range me.LabelFilterss {
+			me.LabelFilterss[i] = append(me.LabelFilterss[i], metricsql.LabelFilter{Label: "__name__", Value: nameRegex, IsRegexp: true})
+		}
+		scoped = append(scoped, string(me.AppendString(nil)))
+	}
+	return scoped, nil
+}
+
+// GlobalSelector adds the label filters of the global selector, ex. `cluster="prod"` or `{cluster="prod"}`, to every selector,
+// so only series having the labels are exported. Without selectors it returns the global selector only.
+func GlobalSelector(selectors []string, globalSelector string) ([]string, error) {
+	if !strings.HasPrefix(strings.TrimSpace(globalSelector), "{") {
+		globalSelector = "{" + globalSelector + "}"
+	}
+	expr, err := metricsql.Parse(globalSelector)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse global selector")
+	}
+	global, ok := expr.(*metricsql.MetricExpr)
+	if !ok || len(global.LabelFilterss) != 1 {
+		return nil, errors.Errorf("%s is not a label set: it should be label filters without `or`", globalSelector)
+	}
+	filters := global.LabelFilterss[0]
+	if len(selectors) == 0 {
+		return []string{string(global.AppendString(nil))}, nil
+	}
+
+	merged := make([]string, 0, len(selectors))
+	for _, selector := range selectors {
+		expr, err := metricsql.Parse(selector)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse selector %s", selector)
+		}
+		me, ok := expr.(*metricsql.MetricExpr)
+		if !ok {
+			return nil, errors.Errorf("%s is not a time series selector", selector)
+		}
+		for i := range me.LabelFilterss {
+			me.LabelFilterss[i] = append(me.LabelFilterss[i], filters...)
+		}
+		merged = append(merged, string(me.AppendString(nil)))
+	}
+	return merged, nil
+}
+
+// Match checks if the series labels match the selector. Missing labels are treated as empty ones.
+func (m *SeriesMatcher) Match(labels map[string]string) bool {
+	if len(m.filterss) == 0 {
+		return true
+	}
+	for _, filters := range m.filterss {
+		if matchFilters(filters, labels) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchFilters(filters []labelFilter, labels map[string]string) bool {
+	for _, f := range filters {
+		value := labels[f.label]
+		var matched bool
+		if f.re != nil {
+			matched = f.re.MatchString(value)
+		} else {
+			matched = value == f.value
+		}
+		if matched == f.negative {
+			return false
+		}
+	}
+	return true
+}
+
+// FilterChunk returns the gzipped JSON chunk content with the matching series only.
+// It has to decode and encode the whole chunk, so it's much slower than writing the chunk as is.
+func (m *SeriesMatcher) FilterChunk(content []byte) ([]byte, int, error) {
+	metrics, err := decompressChunk(content)
+	if err != nil {
+		return nil, 0, err
+	}
+	matched := metrics[:0]
+	for _, metric := range metrics {
+		if m.Match(metric.Metric) {
+			matched = append(matched, metric)
+		}
+	}
+	if len(matched) == 0 {
+		return nil, 0, nil
+	}
+	filtered, err := compressChunk(matched)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "failed to compress chunk")
+	}
+	return filtered, len(matched), nil
+}
@@ -0,0 +1,254 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package victoriametrics
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestSeriesMatcher(t *testing.T) {
+	mongo := map[string]string{"__name__": "mongodb_up", "service_name": "mongo", "node_name": "db1"}
+	mysql := map[string]string{"__name__": "mysql_up", "service_name": "mysql", "node_name": "db2"}
+	node := map[string]string{"__name__": "node_load1", "node_name": "db1"}
+
+	tests := []struct {
+		name      string
+		selector  string
+		want      []bool
+		shouldErr bool
+	}{
+		{
+			name:     "label",
+			selector: `{service_name="mongo"}`,
+			want:     []bool{true, false, false},
+		},
+		{
+			name:     "metric name",
+			selector: `node_load1`,
+			want:     []bool{false, false, true},
+		},
+		{
+			name:     "anchored regexp",
+			selector: `{__name__=~"m.*_up"}`,
+			want:     []bool{true, true, false},
+		},
+		{
+			name:     "negative regexp",
+			selector: `{node_name!~"db2|db3"}`,
+			want:     []bool{true, false, true},
+		},
+		{
+			name:     "missing label",
+			selector: `{service_name=""}`,
+			want:     []bool{false, false, true},
+		},
+		{
+			name:     "or",
+			selector: `{service_name="mysql" or __name__="node_load1"}`,
+			want:     []bool{false, true, true},
+		},
+		{
+			name:      "not a selector",
+			selector:  `rate(node_load1[5m])`,
+			shouldErr: true,
+		},
+		{
+			name:      "invalid",
+			selector:  `{service_name=`,
+			shouldErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := NewSeriesMatcher(tt.selector)
+			if err != nil {
+				if tt.shouldErr {
+					return
+				}
+				t.Fatal(err)
+			} else if tt.shouldErr {
+				t.Fatal("should be error")
+			}
+			for i, labels := range []map[string]string{mongo, mysql, node} {
+				if got := m.Match(labels); got != tt.want[i] {
+					t.Fatalf("want %v for %v, got %v", tt.want[i], labels, got)
+				}
+			}
+		})
+	}
+}
+
+func TestFilterChunk(t *testing.T) {
+	var data []byte
+	for _, service := range []string{"mongo", "mysql", "mongo"} {
+		m, err := json.Marshal(Metric{
+			Metric:     map[string]string{"__name__": "up", "service_name": service},
+			Values:     []float64{1},
+			Timestamps: []int64{1700000000000},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, m...)
+	}
+	content, err := compressData(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := NewSeriesMatcher(`{service_name="mongo"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filtered, count, err := m.FilterChunk(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Fatalf("want 2 series, got %d", count)
+	}
+	metrics, err := decompressChunk(filtered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, metric := range metrics {
+		if metric.Metric["service_name"] != "mongo" {
+			t.Fatalf("unexpected series %v", metric.Metric)
+		}
+	}
+
+	m, err = NewSeriesMatcher(`{service_name="postgres"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filtered, count, err = m.FilterChunk(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 || filtered != nil {
+		t.Fatalf("want no series, got %d", count)
+	}
+}
+
+func TestScopeSelectors(t *testing.T) {
+	tests := []struct {
+		name      string
+		selectors []string
+		want      []string
+		shouldErr bool
+	}{
+		{
+			name: "no selectors",
+			want: []string{`{__name__=~"mysql_.*"}`},
+		},
+		{
+			name:      "selectors",
+			selectors: []string{`{service_name="mysql"}`, `node_load1`},
+			want:      []string{`{service_name="mysql",__name__=~"mysql_.*"}`, `node_load1{__name__=~"mysql_.*"}`},
+		},
+		{
+			name:      "or",
+			selectors: []string{`{service_name="a" or node_name="b"}`},
+			want:      []string{`{service_name="a",__name__=~"mysql_.*" or node_name="b",__name__=~"mysql_.*"}`},
+		},
+		{
+			name:      "not a selector",
+			selectors: []string{`rate(node_load1[5m])`},
+			shouldErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ScopeSelectors(tt.selectors, "mysql_.*")
+			if err != nil {
+				if tt.shouldErr {
+					return
+				}
+				t.Fatal(err)
+			} else if tt.shouldErr {
+				t.Fatal("should be error")
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Fatalf("want %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	if _, err := ScopeSelectors(nil, "mysql_("); err == nil {
+		t.Fatal("invalid regexp should be error")
+	}
+}
+
+func TestGlobalSelector(t *testing.T) {
+	tests := []struct {
+		name           string
+		selectors      []string
+		globalSelector string
+		want           []string
+		shouldErr      bool
+	}{
+		{
+			name:           "no selectors",
+			globalSelector: `cluster="prod"`,
+			want:           []string{`{cluster="prod"}`},
+		},
+		{
+			name:           "user and dashboard selectors",
+			selectors:      []string{`{service_name="mysql"}`, `node_load1`, `mysql_up{node_name=~"db.*"}`},
+			globalSelector: `{cluster="prod"}`,
+			want:           []string{`{service_name="mysql",cluster="prod"}`, `node_load1{cluster="prod"}`, `mysql_up{node_name=~"db.*",cluster="prod"}`},
+		},
+		{
+			name:           "instance selectors",
+			selectors:      []string{InstanceSelector("mysql"), MetricNamesSelector([]string{"node_load1"}, "db")},
+			globalSelector: `cluster="prod",env!="dev"`,
+			want: []string{
+				`{service_name="mysql",cluster="prod",env!="dev" or node_name="mysql",cluster="prod",env!="dev" or instance="mysql",cluster="prod",env!="dev"}`,
+				`{__name__=~"node_load1",service_name="db",cluster="prod",env!="dev" or __name__=~"node_load1",node_name="db",cluster="prod",env!="dev" or ` +
+					`__name__=~"node_load1",instance="db",cluster="prod",env!="dev"}`,
+			},
+		},
+		{
+			name:           "or in global selector",
+			selectors:      []string{`node_load1`},
+			globalSelector: `cluster="prod" or cluster="stage"`,
+			shouldErr:      true,
+		},
+		{
+			name:           "not a selector",
+			selectors:      []string{`rate(node_load1[5m])`},
+			globalSelector: `cluster="prod"`,
+			shouldErr:      true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GlobalSelector(tt.selectors, tt.globalSelector)
+			if err != nil {
+				if tt.shouldErr {
+					return
+				}
+				t.Fatal(err)
+			} else if tt.shouldErr {
+				t.Fatal("should be error")
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Fatalf("want %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
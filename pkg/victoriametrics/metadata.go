@@ -0,0 +1,136 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package victoriametrics
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/valyala/fasthttp"
+
+	"pmm-dump/pkg/dump"
+	"pmm-dump/pkg/grafana/client"
+)
+
+// GetMetadata collects VictoriaMetrics storage metadata. Endpoints which are unavailable are skipped.
+func GetMetadata(c *client.Client, victoriaMetricsURL string) (*dump.VMMetadata, error) {
+	metadata := new(dump.VMMetadata)
+
+	if body, err := getStatus(c, victoriaMetricsURL+"/api/v1/status/tsdb"); err != nil {
+		log.Warn().Err(err).Msg("VictoriaMetrics TSDB status is unavailable")
+	} else {
+		var resp struct {
+			Data struct {
+				TotalSeries int `json:"totalSeries"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(body, &resp); err != nil {
+			return nil, errors.Wrap(err, "failed to unmarshal TSDB status")
+		}
+		metadata.TSDBStatus = body
+		metadata.TotalSeries = resp.Data.TotalSeries
+	}
+
+	if body, err := getStatus(c, victoriaMetricsURL+"/api/v1/status/active_queries"); err != nil {
+		log.Warn().Err(err).Msg("VictoriaMetrics active queries are unavailable")
+	} else {
+		metadata.ActiveQueries = body
+	}
+
+	// VictoriaMetrics has no retention endpoint, it's available only as a command-line flag
+	if body, err := getStatus(c, victoriaMetricsURL+"/flags"); err != nil {
+		log.Warn().Err(err).Msg("VictoriaMetrics flags are unavailable")
+	} else {
+		metadata.RetentionPeriod = parseFlag(body, "retentionPeriod")
+	}
+
+	return metadata, nil
+}
+
+// GetMetricMetadata returns metadata of metrics by their names. It returns ErrNotFound if VictoriaMetrics doesn't have the endpoint.
+func GetMetricMetadata(c *client.Client, victoriaMetricsURL string) (map[string][]dump.MetricMetadata, error) {
+	status, body, err := c.GetWithTimeout(victoriaMetricsURL+"/api/v1/metadata", requestTimeout)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to send HTTP request to victoria metrics")
+	}
+	if status == fasthttp.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if status != fasthttp.StatusOK {
+		return nil, errors.Errorf("non-OK response from victoria metrics: %d: %s", status, string(body))
+	}
+
+	var resp struct {
+		Data map[string][]dump.MetricMetadata `json:"data"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal metric metadata")
+	}
+	return resp.Data, nil
+}
+
+func getStatus(c *client.Client, url string) ([]byte, error) {
+	status, body, err := c.GetWithTimeout(url, requestTimeout)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to send HTTP request to victoria metrics")
+	}
+	if status != fasthttp.StatusOK {
+		return nil, errors.Errorf("non-OK response from victoria metrics: %d: %s", status, string(body))
+	}
+	return body, nil
+}
+
+// parseFlag returns the flag value from VictoriaMetrics `/flags` response, ex. `-retentionPeriod="30d"`.
+func parseFlag(flags []byte, name string) string {
+	scanner := bufio.NewScanner(bytes.NewReader(flags))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		value, ok := strings.CutPrefix(line, "-"+name+"=")
+		if ok {
+			return strings.Trim(value, `"`)
+		}
+	}
+	return ""
+}
+
+// RetentionDays converts VictoriaMetrics retention period to days. Supported suffixes are h, d, w and y.
+// Period without suffix is in months, VictoriaMetrics counts a month as 31 days.
+func RetentionDays(period string) (int, error) {
+	const (
+		day   = 24 * time.Hour
+		month = 31 * day
+		year  = 365 * day
+	)
+
+	units := map[string]time.Duration{"h": time.Hour, "d": day, "w": 7 * day, "y": year}
+	unit, number := month, period
+	if len(period) > 0 {
+		if u, ok := units[period[len(period)-1:]]; ok {
+			unit, number = u, period[:len(period)-1]
+		}
+	}
+
+	n, err := strconv.ParseFloat(number, 64)
+	if err != nil || n <= 0 {
+		return 0, errors.Errorf("invalid retention period: %q", period)
+	}
+	return int(time.Duration(n*float64(unit)) / day), nil
+}
@@ -0,0 +1,129 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package victoriametrics
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/valyala/fasthttp"
+
+	"pmm-dump/pkg/grafana/client"
+)
+
+func TestRetentionDays(t *testing.T) {
+	tests := []struct {
+		period    string
+		want      int
+		shouldErr bool
+	}{
+		{period: "30d", want: 30},
+		{period: "720h", want: 30},
+		{period: "2w", want: 14},
+		{period: "1y", want: 365},
+		{period: "1", want: 31},
+		{period: "", shouldErr: true},
+		{period: "abc", shouldErr: true},
+		{period: "-1d", shouldErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.period, func(t *testing.T) {
+			got, err := RetentionDays(tt.period)
+			if err != nil {
+				if !tt.shouldErr {
+					t.Fatal(err)
+				}
+				return
+			}
+			if tt.shouldErr {
+				t.Fatal("should be error")
+			}
+			if got != tt.want {
+				t.Fatalf("want %d, got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestGetMetadata(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/api/v1/status/tsdb":
+			fmt.Fprint(rw, `{"status":"success","data":{"totalSeries":42}}`)
+		case "/flags":
+			fmt.Fprint(rw, "-httpListenAddr=\":8428\"\n-retentionPeriod=\"30d\"\n")
+		default:
+			rw.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	grafanaC, err := client.NewClient(&fasthttp.Client{ReadTimeout: time.Minute}, client.AuthParams{
+		User:     "admin",
+		Password: "admin",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metadata, err := GetMetadata(grafanaC, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.TotalSeries != 42 {
+		t.Fatalf("want 42 total series, got %d", metadata.TotalSeries)
+	}
+	if metadata.RetentionPeriod != "30d" {
+		t.Fatalf("want 30d retention period, got %s", metadata.RetentionPeriod)
+	}
+	if metadata.ActiveQueries != nil {
+		t.Fatalf("want no active queries, got %s", metadata.ActiveQueries)
+	}
+}
+
+func TestGetMetricMetadata(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/api/v1/metadata" {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(rw, `{"status":"success","data":{"node_load1":[{"type":"gauge","help":"1m load average.","unit":""}]}}`)
+	}))
+	defer server.Close()
+
+	grafanaC, err := client.NewClient(&fasthttp.Client{ReadTimeout: time.Minute}, client.AuthParams{
+		User:     "admin",
+		Password: "admin",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metadata, err := GetMetricMetadata(grafanaC, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metadata["node_load1"]) != 1 || metadata["node_load1"][0].Type != "gauge" {
+		t.Fatalf("unexpected metric metadata: %v", metadata)
+	}
+
+	if _, err := GetMetricMetadata(grafanaC, server.URL+"/missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("want ErrNotFound, got %v", err)
+	}
+}
@@ -15,8 +15,13 @@
 package victoriametrics
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"io"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/pkg/errors"
 )
@@ -44,6 +49,92 @@ func ParseMetrics(r io.Reader) ([]Metric, error) {
 	return result, nil
 }
 
+// MetricHash returns the hash of the series labels. Series with the same labels have the same hash.
+func (m Metric) MetricHash() string {
+	sum := sha256.Sum256([]byte(seriesKey(m.Metric)))
+	return hex.EncodeToString(sum[:])
+}
+
+// seriesKey returns the label set of the series as a string with labels sorted by name.
+func seriesKey(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	for _, name := range names {
+		sb.WriteString(name)
+		sb.WriteByte('=')
+		sb.WriteString(labels[name])
+		sb.WriteByte(0)
+	}
+	return sb.String()
+}
+
+// SampleDiff is a sample missing in one of the compared series or having different values in them.
+// X or Y is nil if the sample is missing in the series.
+type SampleDiff struct {
+	Timestamp int64
+	X, Y      *float64
+}
+
+// CompareTimestampValues returns samples of the series which differ from the samples of the series with the same labels.
+// Diffs are sorted by timestamp. If the series has duplicate timestamps, the last value is compared.
+func (m Metric) CompareTimestampValues(with Metric) []SampleDiff {
+	xSamples := m.samples()
+	ySamples := with.samples()
+
+	var diffs []SampleDiff
+	for ts, x := range xSamples {
+		x := x
+		y, ok := ySamples[ts]
+		switch {
+		case !ok:
+			diffs = append(diffs, SampleDiff{Timestamp: ts, X: &x})
+		case x != y:
+			y := y
+			diffs = append(diffs, SampleDiff{Timestamp: ts, X: &x, Y: &y})
+		}
+	}
+	for ts, y := range ySamples {
+		y := y
+		if _, ok := xSamples[ts]; !ok {
+			diffs = append(diffs, SampleDiff{Timestamp: ts, Y: &y})
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool {
+		return diffs[i].Timestamp < diffs[j].Timestamp
+	})
+	return diffs
+}
+
+func (m Metric) samples() map[int64]float64 {
+	samples := make(map[int64]float64, len(m.Timestamps))
+	for i, ts := range m.Timestamps {
+		if i < len(m.Values) {
+			samples[ts] = m.Values[i]
+		}
+	}
+	return samples
+}
+
+// FormatLabels returns labels in the time series selector format with labels sorted by name, ex. `{__name__="up", job="node"}`.
+func FormatLabels(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, name+"="+strconv.Quote(labels[name]))
+	}
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
+
 type MetricResponse struct {
 	Status string `json:"status"`
 	Data   struct {
@@ -0,0 +1,99 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package victoriametrics
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// WriteOpenMetrics writes metrics in the Prometheus text exposition format with millisecond timestamps,
+// one sample per line. It's the format accepted by VictoriaMetrics' `/api/v1/import/prometheus` endpoint.
+func WriteOpenMetrics(w io.Writer, metrics []Metric) error {
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		if len(m.Values) != len(m.Timestamps) {
+			return errors.Errorf("metric %v has %d values and %d timestamps", m.Metric, len(m.Values), len(m.Timestamps))
+		}
+		series := formatSeries(m.Metric)
+		for i := range m.Values {
+			value := strconv.FormatFloat(m.Values[i], 'g', -1, 64)
+			if _, err := fmt.Fprintf(bw, "%s %s %d\n", series, value, m.Timestamps[i]); err != nil {
+				return errors.Wrap(err, "failed to write metrics")
+			}
+		}
+	}
+	return errors.Wrap(bw.Flush(), "failed to write metrics")
+}
+
+// formatSeries formats metric name and labels sorted by name, ex. `up{instance="pmm-server",job="vm"}`.
+func formatSeries(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		if name != "__name__" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	sb.WriteString(labels["__name__"])
+	if len(names) == 0 {
+		return sb.String()
+	}
+	sb.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(name)
+		sb.WriteString(`="`)
+		sb.WriteString(labelValueReplacer.Replace(labels[name]))
+		sb.WriteByte('"')
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+// convertToOpenMetrics converts gzipped JSON chunk content to OpenMetrics text gzipped with the compression level.
+func convertToOpenMetrics(content []byte, level int) ([]byte, error) {
+	metrics, err := decompressChunk(content)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, level)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create gzip writer")
+	}
+	if err := WriteOpenMetrics(w, metrics); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, errors.Wrap(err, "failed to close gzip writer")
+	}
+	return buf.Bytes(), nil
+}
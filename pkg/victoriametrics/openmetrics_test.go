@@ -0,0 +1,94 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package victoriametrics
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestWriteOpenMetrics(t *testing.T) {
+	tests := []struct {
+		name      string
+		metrics   []Metric
+		want      string
+		shouldErr bool
+	}{
+		{
+			name: "labels",
+			metrics: []Metric{
+				{
+					Metric:     map[string]string{"__name__": "up", "job": "vm", "instance": "pmm-server"},
+					Values:     []float64{1, 0.5},
+					Timestamps: []int64{1700000000000, 1700000005000},
+				},
+			},
+			want: "up{instance=\"pmm-server\",job=\"vm\"} 1 1700000000000\n" +
+				"up{instance=\"pmm-server\",job=\"vm\"} 0.5 1700000005000\n",
+		},
+		{
+			name: "no labels and special values",
+			metrics: []Metric{
+				{
+					Metric:     map[string]string{"__name__": "up"},
+					Values:     []float64{math.NaN(), math.Inf(1)},
+					Timestamps: []int64{1, 2},
+				},
+			},
+			want: "up NaN 1\nup +Inf 2\n",
+		},
+		{
+			name: "escaped label values",
+			metrics: []Metric{
+				{
+					Metric:     map[string]string{"__name__": "up", "service_name": "a\"b\\c\nd"},
+					Values:     []float64{1},
+					Timestamps: []int64{1},
+				},
+			},
+			want: `up{service_name="a\"b\\c\nd"} 1 1` + "\n",
+		},
+		{
+			name: "values and timestamps mismatch",
+			metrics: []Metric{
+				{
+					Metric:     map[string]string{"__name__": "up"},
+					Values:     []float64{1, 2},
+					Timestamps: []int64{1},
+				},
+			},
+			shouldErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := WriteOpenMetrics(&buf, tt.metrics)
+			if err != nil {
+				if !tt.shouldErr {
+					t.Fatal(err)
+				}
+				return
+			}
+			if tt.shouldErr {
+				t.Fatal("should be error")
+			}
+			if buf.String() != tt.want {
+				t.Fatalf("want %q, got %q", tt.want, buf.String())
+			}
+		})
+	}
+}
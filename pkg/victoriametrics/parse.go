@@ -0,0 +1,110 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package victoriametrics
+
+import (
+	"runtime"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ChunkParser parses gzipped JSON chunks concurrently. Parsed chunks are handled one by one in the order
+// they are added, so the results don't depend on the number of workers.
+type ChunkParser struct {
+	jobs    chan parseJob
+	results chan chan parseResult
+	handle  func(name string, metrics []Metric) error
+
+	workers sync.WaitGroup
+	done    chan struct{}
+	stop    sync.Once
+	err     error
+}
+
+type parseJob struct {
+	name    string
+	content []byte
+	result  chan<- parseResult
+}
+
+type parseResult struct {
+	name    string
+	metrics []Metric
+	err     error
+}
+
+// NewChunkParser starts the workers parsing chunks. 0 workers means the number of CPUs.
+func NewChunkParser(workers int, handle func(name string, metrics []Metric) error) *ChunkParser {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	p := &ChunkParser{
+		jobs: make(chan parseJob),
+		// Chunks parsed ahead of the handled one are limited, so the memory usage doesn't depend on the dump size
+		results: make(chan chan parseResult, workers*2),
+		handle:  handle,
+		done:    make(chan struct{}),
+	}
+	p.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer p.workers.Done()
+			for job := range p.jobs {
+				metrics, err := decompressChunk(job.content)
+				job.result <- parseResult{name: job.name, metrics: metrics, err: err}
+			}
+		}()
+	}
+	go p.collect()
+	return p
+}
+
+// collect handles the parsed chunks in the order they are added. After the first error chunks are only drained.
+func (p *ChunkParser) collect() {
+	defer close(p.done)
+	for result := range p.results {
+		r := <-result
+		if p.err != nil {
+			continue
+		}
+		if r.err != nil {
+			p.err = errors.Wrapf(r.err, "failed to parse chunk %s", r.name)
+			continue
+		}
+		if err := p.handle(r.name, r.metrics); err != nil {
+			p.err = errors.Wrapf(err, "failed to handle chunk %s", r.name)
+		}
+	}
+}
+
+// Add queues the chunk for parsing. It blocks while too many chunks are parsed ahead of the handled one.
+func (p *ChunkParser) Add(name string, content []byte) {
+	result := make(chan parseResult, 1)
+	p.results <- result
+	p.jobs <- parseJob{name: name, content: content, result: result}
+}
+
+// Wait waits until all added chunks are handled and returns the first error of parsing or handling.
+// Chunks can't be added after it, but it can be called again, ex. deferred.
+func (p *ChunkParser) Wait() error {
+	p.stop.Do(func() {
+		close(p.jobs)
+		p.workers.Wait()
+		close(p.results)
+		<-p.done
+	})
+	return p.err
+}
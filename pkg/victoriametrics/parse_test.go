@@ -0,0 +1,81 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package victoriametrics
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestChunkParser(t *testing.T) {
+	chunks := make([][]byte, 100)
+	for i := range chunks {
+		content, err := compressData([]byte(fmt.Sprintf(`{"metric":{"__name__":"up","chunk":"%d"},"values":[1],"timestamps":[1000]}`, i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunks[i] = content
+	}
+
+	for _, workers := range []int{1, 8} {
+		t.Run(strconv.Itoa(workers), func(t *testing.T) {
+			var handled []string
+			p := NewChunkParser(workers, func(name string, metrics []Metric) error {
+				if len(metrics) != 1 || metrics[0].Metric["chunk"] != name {
+					t.Errorf("chunk %s has unexpected metrics %v", name, metrics)
+				}
+				handled = append(handled, name)
+				return nil
+			})
+			for i, content := range chunks {
+				p.Add(strconv.Itoa(i), content)
+			}
+			if err := p.Wait(); err != nil {
+				t.Fatal(err)
+			}
+			for i, name := range handled {
+				if name != strconv.Itoa(i) {
+					t.Fatalf("chunks should be handled in the order they are added, got %v", handled)
+				}
+			}
+			if len(handled) != len(chunks) {
+				t.Fatalf("want %d chunks handled, got %d", len(chunks), len(handled))
+			}
+		})
+	}
+
+	t.Run("invalid chunk", func(t *testing.T) {
+		handled := 0
+		p := NewChunkParser(4, func(string, []Metric) error {
+			handled++
+			return nil
+		})
+		p.Add("0", chunks[0])
+		p.Add("invalid", []byte("not gzipped"))
+		p.Add("2", chunks[2])
+		err := p.Wait()
+		if err == nil || !strings.Contains(err.Error(), "invalid") {
+			t.Fatalf("want error of the invalid chunk, got %v", err)
+		}
+		if handled != 1 {
+			t.Fatalf("chunks after the invalid one shouldn't be handled, got %d handled", handled)
+		}
+		if err := p.Wait(); err == nil {
+			t.Fatal("repeated wait should return the same error")
+		}
+	})
+}
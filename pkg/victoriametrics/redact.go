@@ -0,0 +1,79 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package victoriametrics
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+
+	"github.com/pkg/errors"
+)
+
+// redactedHashLen is the length of the hash prefix in redacted values.
+const redactedHashLen = 12
+
+// Redactor replaces label values of VictoriaMetrics series with their hashes.
+// Hashes keep different values different, so redacted series don't collapse.
+type Redactor struct {
+	labels     map[string]struct{}
+	valueRegex *regexp.Regexp
+}
+
+// NewRedactor returns a redactor of the values of labels and of any label values matching valueRegex.
+func NewRedactor(labels []string, valueRegex string) (*Redactor, error) {
+	r := &Redactor{
+		labels: make(map[string]struct{}, len(labels)),
+	}
+	for _, l := range labels {
+		r.labels[l] = struct{}{}
+	}
+	if valueRegex != "" {
+		var err error
+		r.valueRegex, err = regexp.Compile(valueRegex)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to compile value regex")
+		}
+	}
+	return r, nil
+}
+
+// Redact redacts labels of the metric in place.
+func (r *Redactor) Redact(m *Metric) {
+	for name, value := range m.Metric {
+		_, ok := r.labels[name]
+		if !ok && (r.valueRegex == nil || !r.valueRegex.MatchString(value)) {
+			continue
+		}
+		m.Metric[name] = redactValue(value)
+	}
+}
+
+// RedactChunk redacts the gzipped chunk in JSON format.
+func (r *Redactor) RedactChunk(content []byte) ([]byte, error) {
+	metrics, err := decompressChunk(content)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decompress chunk")
+	}
+	for i := range metrics {
+		r.Redact(&metrics[i])
+	}
+	return compressChunk(metrics)
+}
+
+func redactValue(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return "redacted-" + hex.EncodeToString(sum[:])[:redactedHashLen]
+}
@@ -0,0 +1,72 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package victoriametrics
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRedactor(t *testing.T) {
+	tests := []struct {
+		name       string
+		labels     []string
+		valueRegex string
+		metric     map[string]string
+		want       map[string]string
+		shouldErr  bool
+	}{
+		{
+			name:   "labels",
+			labels: []string{"node_name"},
+			metric: map[string]string{"__name__": "up", "node_name": "db-1", "job": "mysql"},
+			want:   map[string]string{"__name__": "up", "node_name": redactValue("db-1"), "job": "mysql"},
+		},
+		{
+			name:       "value regex",
+			valueRegex: `^10\.`,
+			metric:     map[string]string{"__name__": "up", "instance": "10.0.0.1:9100", "job": "mysql"},
+			want:       map[string]string{"__name__": "up", "instance": redactValue("10.0.0.1:9100"), "job": "mysql"},
+		},
+		{
+			name:       "invalid regex",
+			valueRegex: "(",
+			shouldErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewRedactor(tt.labels, tt.valueRegex)
+			if err != nil {
+				if !tt.shouldErr {
+					t.Fatal(err)
+				}
+				return
+			}
+			if tt.shouldErr {
+				t.Fatal("error expected")
+			}
+			m := Metric{Metric: tt.metric}
+			r.Redact(&m)
+			if !reflect.DeepEqual(m.Metric, tt.want) {
+				t.Fatalf("want %v, got %v", tt.want, m.Metric)
+			}
+		})
+	}
+
+	if redactValue("db-1") == redactValue("db-2") {
+		t.Fatal("different values are redacted to the same value")
+	}
+}
@@ -15,14 +15,19 @@
 package victoriametrics
 
 import (
+	"bufio"
 	"bytes"
 	"compress/gzip"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
+	"unicode"
 
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
@@ -52,9 +57,16 @@ func (s Source) Type() dump.SourceType {
 	return dump.VictoriaMetrics
 }
 
+// TimeSeriesSelectors returns selectors of the exported time series.
+func (s Source) TimeSeriesSelectors() []string {
+	return s.cfg.TimeSeriesSelectors
+}
+
 const requestTimeout = time.Second * 30
 
-func (s Source) ReadChunk(m dump.ChunkMeta) (*dump.Chunk, error) {
+// ReadChunk exports the chunk time range from VictoriaMetrics. The request timeout is shortened to the context deadline,
+// fasthttp requests can't be canceled otherwise.
+func (s Source) ReadChunk(ctx context.Context, m dump.ChunkMeta) (*dump.Chunk, error) {
 	q := fasthttp.AcquireArgs()
 	defer fasthttp.ReleaseArgs(q)
 
@@ -66,8 +78,17 @@ func (s Source) ReadChunk(m dump.ChunkMeta) (*dump.Chunk, error) {
 		q.Add("start", strconv.FormatInt(m.Start.Unix(), 10))
 	}
 
+	for k, v := range s.cfg.ExportParams {
+		q.Add(k, v)
+	}
+
 	if m.End != nil {
-		q.Add("end", strconv.FormatInt(m.End.Unix(), 10))
+		if s.cfg.ExclusiveEnd {
+			// VictoriaMetrics accepts fractional seconds, samples have millisecond precision
+			q.Add("end", strconv.FormatFloat(float64(m.End.UnixMilli()-1)/1000, 'f', 3, 64))
+		} else {
+			q.Add("end", strconv.FormatInt(m.End.Unix(), 10))
+		}
 	}
 
 	url := fmt.Sprintf("%s/api/v1/export?%s", s.cfg.ConnectionURL, q.String())
@@ -75,8 +96,16 @@ func (s Source) ReadChunk(m dump.ChunkMeta) (*dump.Chunk, error) {
 		url = fmt.Sprintf("%s/api/v1/export/native?%s", s.cfg.ConnectionURL, q.String())
 	}
 
+	timeout := requestTimeout
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < timeout {
+		timeout = time.Until(deadline)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	log.Debug().
-		Stringer("timeout", requestTimeout).
+		Stringer("timeout", timeout).
 		Str("url", url).
 		Msg("Sending GET chunk request to Victoria Metrics endpoint")
 
@@ -87,9 +116,12 @@ func (s Source) ReadChunk(m dump.ChunkMeta) (*dump.Chunk, error) {
 	req.SetRequestURI(url)
 	req.Header.Set(fasthttp.HeaderAcceptEncoding, "gzip")
 
-	resp, err := s.c.DoWithTimeout(req, requestTimeout)
+	resp, err := s.c.DoWithTimeout(req, timeout)
 	defer fasthttp.ReleaseResponse(resp)
 	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 		return nil, errors.Wrap(err, "failed to send HTTP request to victoria metrics")
 	}
 
@@ -101,10 +133,39 @@ func (s Source) ReadChunk(m dump.ChunkMeta) (*dump.Chunk, error) {
 
 	log.Debug().Msg("Got successful response from Victoria Metrics")
 
+	if len(body) == 0 {
+		return nil, dump.ErrEmptyChunk
+	}
+	empty, err := isEmptyChunk(body, s.cfg.NativeData)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to check chunk content")
+	}
+	if empty {
+		return nil, dump.ErrEmptyChunk
+	}
+
+	if s.cfg.Dedup {
+		var dropped int
+		body, dropped, err = dedupChunk(body, s.cfg.gzipLevel())
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to deduplicate chunk")
+		}
+		if dropped > 0 {
+			log.Debug().Msgf("Dropped %d duplicate samples of chunk %s", dropped, m.String())
+		}
+	}
+
+	if s.cfg.OpenMetrics {
+		body, err = convertToOpenMetrics(body, s.cfg.gzipLevel())
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to convert chunk to OpenMetrics")
+		}
+	}
+
 	chunk := &dump.Chunk{
 		ChunkMeta: m,
 		Content:   body,
-		Filename:  m.String() + ".bin",
+		Filename:  m.String() + s.cfg.chunkExtension(),
 	}
 
 	return chunk, nil
@@ -146,9 +207,66 @@ func decompressChunk(content []byte) ([]Metric, error) {
 	return metrics, nil
 }
 
+// isEmptyChunk checks whether gzipped chunk content has no metrics without decoding them.
+func isEmptyChunk(content []byte, nativeData bool) (bool, error) {
+	r, err := gzip.NewReader(bytes.NewReader(content))
+	if err != nil {
+		return false, errors.Wrap(err, "failed to create gzip reader")
+	}
+	defer r.Close() //nolint:errcheck
+
+	br := bufio.NewReader(r)
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return true, nil
+			}
+			return false, errors.Wrap(err, "failed to read chunk content")
+		}
+		if nativeData || !unicode.IsSpace(rune(b)) {
+			return false, nil
+		}
+	}
+}
+
+// CountMetrics counts time series in the gzipped chunk content. It's supported only for JSON data.
+func (s Source) CountMetrics(content io.Reader) (int, error) {
+	if s.cfg.NativeData || s.cfg.OpenMetrics {
+		return 0, errors.New("counting metrics is supported only for JSON data")
+	}
+	r, err := gzip.NewReader(content)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to create gzip reader")
+	}
+	defer r.Close() //nolint:errcheck
+
+	count := 0
+	decoder := json.NewDecoder(r)
+	for {
+		var metric struct{}
+		if err := decoder.Decode(&metric); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return 0, errors.Wrap(err, "failed to decode JSON stream")
+		}
+		count++
+	}
+	return count, nil
+}
+
 func compressChunk(chunk []Metric) ([]byte, error) {
+	return compressChunkLevel(chunk, gzip.DefaultCompression)
+}
+
+// compressChunkLevel encodes metrics as JSON lines gzipped with the compression level.
+func compressChunkLevel(chunk []Metric, level int) ([]byte, error) {
 	var buf bytes.Buffer
-	w := gzip.NewWriter(&buf)
+	w, err := gzip.NewWriterLevel(&buf, level)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create gzip writer")
+	}
 	for _, metric := range chunk {
 		metricData, err := json.Marshal(metric)
 		if err != nil {
@@ -164,6 +282,126 @@ func compressChunk(chunk []Metric) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// ContentType returns the content type of chunks of the data format.
+func (s Source) ContentType() string {
+	return s.cfg.contentType()
+}
+
+// CanConcatenateChunks reports if chunks can be written together: concatenated gzip streams of JSON lines or text are valid.
+// Chunks aren't concatenated with the content limit, as they would be split again on write.
+func (s Source) CanConcatenateChunks() bool {
+	return !s.cfg.NativeData && s.cfg.ContentLimit == 0
+}
+
+// SplitChunk splits the JSON chunk by metric names, if it's enabled, and into parts not exceeding the content limit.
+func (s Source) SplitChunk(c *dump.Chunk) ([]*dump.Chunk, error) {
+	if !s.cfg.SplitByName && s.cfg.ContentLimit == 0 {
+		return []*dump.Chunk{c}, nil
+	}
+	if s.cfg.NativeData || s.cfg.OpenMetrics {
+		return nil, errors.New("splitting chunks is supported only for JSON data")
+	}
+
+	chunks := []*dump.Chunk{c}
+	if s.cfg.SplitByName {
+		var err error
+		chunks, err = s.splitChunkByName(c)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to split chunk %s by metric names", c.Filename)
+		}
+	}
+
+	result := make([]*dump.Chunk, 0, len(chunks))
+	for _, c := range chunks {
+		parts, err := s.splitChunkByLimit(c)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, parts...)
+	}
+	return result, nil
+}
+
+// splitChunkByName groups metrics of the chunk by name into chunks named `<start>-<end>-<name>.bin`.
+// Names are sanitized to be safe filenames.
+func (s Source) splitChunkByName(c *dump.Chunk) ([]*dump.Chunk, error) {
+	metrics, err := decompressChunk(c.Content)
+	if err != nil {
+		return nil, err
+	}
+	if len(metrics) == 0 {
+		return []*dump.Chunk{c}, nil
+	}
+
+	groups := make(map[string][]Metric)
+	for _, m := range metrics {
+		name := m.Metric["__name__"]
+		groups[name] = append(groups[name], m)
+	}
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	filenames := make(map[string]struct{}, len(names))
+	chunks := make([]*dump.Chunk, 0, len(names))
+	for i, name := range names {
+		content, err := compressChunkLevel(groups[name], s.cfg.gzipLevel())
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to compress chunk content")
+		}
+
+		filename := fmt.Sprintf("%s-%s%s", c.String(), sanitizeMetricName(name), jsonChunkExtension)
+		for n := 1; ; n++ {
+			if _, ok := filenames[filename]; !ok {
+				break
+			}
+			filename = fmt.Sprintf("%s-%s_%d%s", c.String(), sanitizeMetricName(name), n, jsonChunkExtension)
+		}
+		filenames[filename] = struct{}{}
+
+		part := &dump.Chunk{
+			ChunkMeta: c.ChunkMeta,
+			Content:   content,
+			Filename:  filename,
+		}
+		if i == 0 {
+			part.ReadDuration = c.ReadDuration
+		}
+		chunks = append(chunks, part)
+	}
+	return chunks, nil
+}
+
+// splitChunkByLimit splits the chunk into parts not exceeding the content limit.
+// Parts are named `<chunk name>-<part>.bin`, so they keep the chunk time range.
+func (s Source) splitChunkByLimit(c *dump.Chunk) ([]*dump.Chunk, error) {
+	if s.cfg.ContentLimit == 0 || len(c.Content) <= s.cfg.ContentLimit {
+		return []*dump.Chunk{c}, nil
+	}
+
+	parts, err := s.splitChunkContent(c.Content, s.cfg.ContentLimit)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to split chunk %s", c.Filename)
+	}
+	log.Debug().Msgf("Chunk %s is split into %d parts", c.Filename, len(parts))
+
+	chunks := make([]*dump.Chunk, 0, len(parts))
+	for i, content := range parts {
+		part := &dump.Chunk{
+			ChunkMeta: c.ChunkMeta,
+			Content:   content,
+			Filename:  fmt.Sprintf("%s-%d%s", strings.TrimSuffix(c.Filename, jsonChunkExtension), i, jsonChunkExtension),
+		}
+		if i == 0 {
+			part.ReadDuration = c.ReadDuration
+		}
+		chunks = append(chunks, part)
+	}
+	return chunks, nil
+}
+
 func (s Source) splitChunkContent(chunkContent []byte, limit int) ([][]byte, error) {
 	metrics, err := decompressChunk(chunkContent)
 	if err != nil {
@@ -177,7 +415,7 @@ func (s Source) splitChunkContent(chunkContent []byte, limit int) ([][]byte, err
 
 	data := make([][]byte, 0, len(chunks))
 	for _, chunk := range chunks {
-		compressedContent, err := compressChunk(chunk)
+		compressedContent, err := compressChunkLevel(chunk, s.cfg.gzipLevel())
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to compress chunk content")
 		}
@@ -204,7 +442,7 @@ func (s Source) splitMetrics(metricChunks [][]Metric, limit int) ([][]Metric, er
 	}
 
 	for _, chunk := range newMetricChunks {
-		compressedData, err := compressChunk(chunk)
+		compressedData, err := compressChunkLevel(chunk, s.cfg.gzipLevel())
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to compress metrics")
 		}
@@ -216,14 +454,49 @@ func (s Source) splitMetrics(metricChunks [][]Metric, limit int) ([][]Metric, er
 }
 
 func (s Source) WriteChunk(filename string, r io.Reader) error {
-	if s.cfg.ContentLimit != 0 && s.cfg.NativeData {
-		return errors.New("content limit is not supported for native data")
+	if err := s.cfg.Validate(); err != nil {
+		return err
+	}
+	if s.cfg.ImportMatcher != nil && (s.cfg.NativeData || s.cfg.OpenMetrics) {
+		return errors.New("filtering series is supported only for JSON data")
+	}
+	if len(s.cfg.ImportLabels) > 0 && (s.cfg.NativeData || s.cfg.OpenMetrics) {
+		return errors.New("adding labels is supported only for JSON data")
 	}
 	chunkContent, err := io.ReadAll(r)
 	if err != nil {
 		return errors.Wrap(err, "failed to read chunk content")
 	}
 
+	empty, err := isEmptyChunk(chunkContent, s.cfg.NativeData)
+	if err != nil {
+		return errors.Wrapf(err, "failed to check chunk %s", filename)
+	}
+	if empty {
+		log.Warn().Msgf("Chunk %s has no metrics, skipping", filename)
+		return nil
+	}
+
+	if s.cfg.ImportMatcher != nil {
+		var count int
+		chunkContent, count, err = s.cfg.ImportMatcher.FilterChunk(chunkContent)
+		if err != nil {
+			return errors.Wrapf(err, "failed to filter chunk %s", filename)
+		}
+		if count == 0 {
+			log.Debug().Msgf("Chunk %s has no matching series, skipping", filename)
+			return nil
+		}
+		log.Debug().Msgf("Chunk %s has %d matching series", filename, count)
+	}
+
+	if len(s.cfg.ImportLabels) > 0 {
+		chunkContent, err = addLabels(chunkContent, s.cfg.ImportLabels, s.cfg.gzipLevel())
+		if err != nil {
+			return errors.Wrapf(err, "failed to add labels to chunk %s", filename)
+		}
+	}
+
 	if s.cfg.ContentLimit > 0 && len(chunkContent) > s.cfg.ContentLimit {
 		chunks, err := s.splitChunkContent(chunkContent, s.cfg.ContentLimit)
 		if err != nil {
@@ -250,6 +523,9 @@ func (s *Source) sendChunk(content []byte) error {
 	if s.cfg.NativeData {
 		url = s.cfg.ConnectionURL + "/api/v1/import/native"
 	}
+	if s.cfg.OpenMetrics {
+		url = s.cfg.ConnectionURL + "/api/v1/import/prometheus"
+	}
 
 	req := fasthttp.AcquireRequest()
 	defer fasthttp.ReleaseRequest(req)
@@ -269,7 +545,8 @@ func (s *Source) sendChunk(content []byte) error {
 		return errors.Wrap(err, "failed to send HTTP request to victoria metrics")
 	}
 
-	if s := resp.StatusCode(); s != fasthttp.StatusOK && s != fasthttp.StatusNoContent {
+	// Proxies may return any 2xx status, ex. 202 Accepted, instead of 204 of VictoriaMetrics
+	if s := resp.StatusCode(); s < fasthttp.StatusOK || s >= fasthttp.StatusMultipleChoices {
 		if s == http.StatusRequestEntityTooLarge {
 			return errors.New(errRequestEntityTooLarge)
 		}
@@ -280,6 +557,36 @@ func (s *Source) sendChunk(content []byte) error {
 	return nil
 }
 
+// HasMetrics checks if VictoriaMetrics has any time series matching the selectors within the time range.
+func (s Source) HasMetrics(start, end time.Time) (bool, error) {
+	q := fasthttp.AcquireArgs()
+	defer fasthttp.ReleaseArgs(q)
+
+	for _, v := range s.cfg.TimeSeriesSelectors {
+		q.Add("match[]", v)
+	}
+	q.Add("start", strconv.FormatInt(start.Unix(), 10))
+	q.Add("end", strconv.FormatInt(end.Unix(), 10))
+	q.Add("limit", "1")
+
+	url := fmt.Sprintf("%s/api/v1/series?%s", s.cfg.ConnectionURL, q.String())
+	status, body, err := s.c.GetWithTimeout(url, requestTimeout)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to send HTTP request to victoria metrics")
+	}
+	if status != fasthttp.StatusOK {
+		return false, errors.Errorf("non-OK response from victoria metrics: %d: %s", status, string(body))
+	}
+
+	var resp struct {
+		Data []json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return false, errors.Wrap(err, "failed to unmarshal series response")
+	}
+	return len(resp.Data) > 0, nil
+}
+
 func ErrIsRequestEntityTooLarge(err error) bool {
 	if err.Error() == errRequestEntityTooLarge || errors.Cause(err).Error() == errRequestEntityTooLarge {
 		return true
@@ -287,7 +594,22 @@ func ErrIsRequestEntityTooLarge(err error) bool {
 	return false
 }
 
+// FinalizeWrites resets the rollup result cache, so imported data is visible in queries.
+// It's retried while VictoriaMetrics is not ready, ex. just after a restart.
 func (s Source) FinalizeWrites() error {
+	delay := s.cfg.finalizeRetryDelay()
+	for attempt := 0; ; attempt++ {
+		err := s.resetRollupResultCache()
+		if err == nil || attempt == s.cfg.finalizeRetries() || !isTransient(err) {
+			return err
+		}
+		log.Warn().Err(err).Msgf("VictoriaMetrics is not ready, retrying in %v", delay)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+func (s Source) resetRollupResultCache() error {
 	url := s.cfg.ConnectionURL + "/internal/resetRollupResultCache"
 
 	log.Debug().
@@ -300,7 +622,7 @@ func (s Source) FinalizeWrites() error {
 	}
 
 	if status != fasthttp.StatusOK {
-		return errors.Errorf("non-OK response from victoria metrics: %d: %s", status, string(body))
+		return &statusError{status: status, body: string(body)}
 	}
 
 	log.Debug().Msg("Got successful response from Victoria Metrics")
@@ -308,7 +630,69 @@ func (s Source) FinalizeWrites() error {
 	return nil
 }
 
-func SplitTimeRangeIntoChunks(start, end time.Time, delta time.Duration) []dump.ChunkMeta {
+// statusError is the non-OK response from VictoriaMetrics.
+type statusError struct {
+	status int
+	body   string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("non-OK response from victoria metrics: %d: %s", e.status, e.body)
+}
+
+// isTransient checks if the request may succeed on retry: it failed to be sent or VictoriaMetrics or a proxy was not ready to serve it.
+func isTransient(err error) bool {
+	var se *statusError
+	if !errors.As(err, &se) {
+		return true
+	}
+	return se.status == fasthttp.StatusBadGateway || se.status == fasthttp.StatusServiceUnavailable || se.status == fasthttp.StatusGatewayTimeout
+}
+
+// maxReadyDelay is the max interval between health checks of WaitReady.
+const maxReadyDelay = 10 * time.Second
+
+// WaitReady waits until the health check of VictoriaMetrics succeeds, polling it with a growing interval up to the timeout.
+func WaitReady(c *client.Client, victoriaMetricsURL string, timeout time.Duration) error {
+	url := victoriaMetricsURL + "/health"
+	deadline := time.Now().Add(timeout)
+	delay := time.Second
+	for {
+		status, body, err := c.GetWithTimeout(url, min(requestTimeout, timeout))
+		if err == nil && status == fasthttp.StatusOK {
+			return nil
+		}
+		if err == nil {
+			err = &statusError{status: status, body: string(body)}
+		}
+		if time.Now().Add(delay).After(deadline) {
+			return errors.Wrapf(err, "VictoriaMetrics is not ready after %v", timeout)
+		}
+		log.Info().Err(err).Msgf("Waiting for VictoriaMetrics to be ready, retrying in %v", delay)
+		time.Sleep(delay)
+		delay = min(delay*2, maxReadyDelay)
+	}
+}
+
+// SplitTimeRangeIntoChunks splits the time range into chunks of delta duration.
+// If step isn't zero, chunk boundaries are aligned to multiples of step, so they don't split scrape intervals.
+// VictoriaMetrics accepts Unix timestamps in seconds, so the chunk boundaries are truncated to seconds.
+func SplitTimeRangeIntoChunks(start, end time.Time, delta, step time.Duration) ([]dump.ChunkMeta, error) {
+	if delta < time.Second {
+		return nil, errors.Errorf("invalid chunk time range %v: should be at least 1s", delta)
+	}
+	start, end = start.Truncate(time.Second), end.Truncate(time.Second)
+	delta = delta.Truncate(time.Second)
+	if step != 0 {
+		if step < time.Second || step%time.Second != 0 {
+			return nil, errors.Errorf("invalid align step %v: should be a whole number of seconds", step)
+		}
+		if delta%step != 0 {
+			return nil, errors.Errorf("chunk time range %v should be a multiple of align step %v", delta, step)
+		}
+		start, end = alignTime(start, step, false), alignTime(end, step, true)
+	}
+
 	var chunks []dump.ChunkMeta
 	chunkStart := start
 	for {
@@ -320,7 +704,7 @@ func SplitTimeRangeIntoChunks(start, end time.Time, delta time.Duration) []dump.
 		})
 
 		chunkStart = e
-		if chunkStart.After(end) {
+		if chunkStart.After(end) || (step != 0 && chunkStart.Equal(end)) {
 			break
 		}
 	}
@@ -332,5 +716,18 @@ func SplitTimeRangeIntoChunks(start, end time.Time, delta time.Duration) []dump.
 		Int("chunks", len(chunks)).
 		Msg("Split Victoria Metrics timerange into chunks")
 
-	return chunks
+	return chunks, nil
+}
+
+// alignTime rounds t down or up to the multiple of step since the Unix epoch.
+func alignTime(t time.Time, step time.Duration, up bool) time.Time {
+	sec, stepSec := t.Unix(), int64(step/time.Second)
+	r := sec % stepSec
+	if r == 0 {
+		return t
+	}
+	if up {
+		return time.Unix(sec-r+stepSec, 0).In(t.Location())
+	}
+	return time.Unix(sec-r, 0).In(t.Location())
 }
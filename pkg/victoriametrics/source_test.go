@@ -17,17 +17,23 @@ package victoriametrics
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"crypto/tls"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
 	"testing"
 	"time"
 
 	"github.com/pkg/errors"
 	"github.com/valyala/fasthttp"
 
+	"pmm-dump/pkg/dump"
 	"pmm-dump/pkg/grafana/client"
 )
 
@@ -37,6 +43,7 @@ func TestWriteChunk(t *testing.T) {
 		metricsSize  int
 		contentLimit int
 		nativeData   bool
+		noRequests   bool
 		shouldErr    bool
 	}{
 		{
@@ -56,6 +63,11 @@ func TestWriteChunk(t *testing.T) {
 			metricsSize:  20,
 			contentLimit: 130,
 		},
+		{
+			name:        "empty chunk",
+			metricsSize: 0,
+			noRequests:  true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -72,9 +84,11 @@ func TestWriteChunk(t *testing.T) {
 				},
 			}
 			var recievedMetrics []Metric
+			requestsCount := 0
 			server := httptest.NewServer(http.HandlerFunc(
 				func(rw http.ResponseWriter, req *http.Request) {
 					defer req.Body.Close() //nolint:errcheck
+					requestsCount++
 					if req.ContentLength > int64(tt.contentLimit) && tt.contentLimit != 0 {
 						rw.WriteHeader(http.StatusRequestEntityTooLarge)
 						return
@@ -120,10 +134,656 @@ func TestWriteChunk(t *testing.T) {
 			if err == nil && tt.shouldErr {
 				t.Fatal("should be error")
 			}
+			if tt.noRequests && requestsCount != 0 {
+				t.Fatalf("want no requests, got %d", requestsCount)
+			}
+		})
+	}
+}
+
+func TestWriteChunkStatus(t *testing.T) {
+	tests := []struct {
+		status    int
+		shouldErr bool
+	}{
+		{status: http.StatusOK},
+		{status: http.StatusAccepted},
+		{status: http.StatusNoContent},
+		{status: http.StatusFound, shouldErr: true},
+		{status: http.StatusInternalServerError, shouldErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.status), func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+				rw.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			c, err := client.NewClient(&fasthttp.Client{}, client.AuthParams{APIToken: "token"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := generateFakeChunk(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = NewSource(c, Config{ConnectionURL: server.URL}).WriteChunk("", bytes.NewBuffer(data))
+			if err != nil && !tt.shouldErr {
+				t.Fatal(err)
+			}
+			if err == nil && tt.shouldErr {
+				t.Fatal("should be error")
+			}
 		})
 	}
 }
 
+func TestFinalizeWrites(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		wantRequests int
+		shouldErr    bool
+	}{
+		{
+			name:         "ok",
+			statuses:     []int{http.StatusOK},
+			wantRequests: 1,
+		},
+		{
+			name:         "not ready after restart",
+			statuses:     []int{http.StatusServiceUnavailable, http.StatusBadGateway, http.StatusOK},
+			wantRequests: 3,
+		},
+		{
+			name:         "retries exceeded",
+			statuses:     []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable},
+			wantRequests: 3,
+			shouldErr:    true,
+		},
+		{
+			name:         "not found",
+			statuses:     []int{http.StatusNotFound, http.StatusOK},
+			wantRequests: 1,
+			shouldErr:    true,
+		},
+	}
+	c, err := client.NewClient(&fasthttp.Client{}, client.AuthParams{APIToken: "token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+				rw.WriteHeader(tt.statuses[requests])
+				requests++
+			}))
+			defer server.Close()
+
+			s := NewSource(c, Config{ConnectionURL: server.URL, FinalizeRetries: 2, FinalizeRetryDelay: time.Millisecond})
+			err := s.FinalizeWrites()
+			if err != nil && !tt.shouldErr {
+				t.Fatal(err)
+			}
+			if err == nil && tt.shouldErr {
+				t.Fatal("should be error")
+			}
+			if requests != tt.wantRequests {
+				t.Fatalf("want %d requests, got %d", tt.wantRequests, requests)
+			}
+		})
+	}
+}
+
+func TestWaitReady(t *testing.T) {
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/prometheus/health" {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		rw.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	c, err := client.NewClient(&fasthttp.Client{}, client.AuthParams{APIToken: "token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := WaitReady(c, server.URL+"/prometheus", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	status = http.StatusServiceUnavailable
+	if err := WaitReady(c, server.URL+"/prometheus", 500*time.Millisecond); err == nil {
+		t.Fatal("should be error if VictoriaMetrics is not ready until the timeout")
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		cfg       Config
+		shouldErr bool
+	}{
+		{
+			name: "json with content limit",
+			cfg:  Config{ContentLimit: 10},
+		},
+		{
+			name: "native without content limit",
+			cfg:  Config{NativeData: true},
+		},
+		{
+			name:      "native with content limit",
+			cfg:       Config{NativeData: true, ContentLimit: 10},
+			shouldErr: true,
+		},
+		{
+			name:      "openmetrics with content limit",
+			cfg:       Config{OpenMetrics: true, ContentLimit: 10},
+			shouldErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.Validate()
+			if err != nil && !tt.shouldErr {
+				t.Fatal(err)
+			}
+			if err == nil && tt.shouldErr {
+				t.Fatal("should be error")
+			}
+		})
+	}
+}
+
+func TestReadChunkWithGap(t *testing.T) {
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	gapStart := start.Add(20 * time.Minute)
+	gapEnd := start.Add(40 * time.Minute)
+
+	data, err := generateFakeChunk(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	empty, err := generateFakeChunk(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		var chunkStart int64
+		if _, err := fmt.Sscan(req.URL.Query().Get("start"), &chunkStart); err != nil {
+			t.Error(err)
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		content := data
+		if chunkStart >= gapStart.Unix() && chunkStart < gapEnd.Unix() {
+			content = empty
+		}
+		if _, err := rw.Write(content); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer server.Close()
+
+	grafanaC, err := client.NewClient(&fasthttp.Client{ReadTimeout: time.Minute}, client.AuthParams{
+		User:     "admin",
+		Password: "admin",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewSource(grafanaC, Config{
+		ConnectionURL: server.URL,
+	})
+
+	chunks, err := SplitTimeRangeIntoChunks(start, end, 5*time.Minute, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, skipped := 0, 0
+	for _, m := range chunks {
+		c, err := s.ReadChunk(context.Background(), m)
+		if errors.Is(err, dump.ErrEmptyChunk) {
+			if m.Start.Before(gapStart) || !m.Start.Before(gapEnd) {
+				t.Fatalf("chunk %s outside of the gap is skipped", m.String())
+			}
+			skipped++
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(c.Content) == 0 {
+			t.Fatalf("chunk %s is empty", c.Filename)
+		}
+		read++
+	}
+	if skipped != 4 || read != len(chunks)-4 {
+		t.Fatalf("want 4 skipped chunks and %d read, got %d and %d", len(chunks)-4, skipped, read)
+	}
+}
+
+func TestReadChunkExportParams(t *testing.T) {
+	data, err := generateFakeChunk(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		got = req.URL.Query()
+		if _, err := rw.Write(data); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer server.Close()
+
+	c, err := client.NewClient(&fasthttp.Client{}, client.AuthParams{APIToken: "token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := map[string]string{"reduce_mem_usage": "1", "max_rows_per_line": "100"}
+	if err := ValidateExportParams(params); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Unix(1700000000, 0)
+	end := start.Add(time.Minute)
+	s := NewSource(c, Config{ConnectionURL: server.URL, ExportParams: params})
+	if _, err := s.ReadChunk(context.Background(), dump.ChunkMeta{Start: &start, End: &end}); err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range params {
+		if got.Get(k) != v {
+			t.Fatalf("want %s=%s in export request, got %v", k, v, got)
+		}
+	}
+	if got.Get("start") != "1700000000" {
+		t.Fatalf("want start in export request, got %v", got)
+	}
+
+	for _, invalid := range []map[string]string{{"format": "csv"}, {"reduce_mem_usage": "yes"}, {"max_rows_per_line": "0"}} {
+		if err := ValidateExportParams(invalid); err == nil {
+			t.Fatalf("params %v should be invalid", invalid)
+		}
+	}
+}
+
+func TestHasMetrics(t *testing.T) {
+	tests := []struct {
+		name      string
+		selectors []string
+		response  string
+		want      bool
+		wantMatch []string
+	}{
+		{
+			name:      "default selector",
+			response:  `{"status":"success","data":[{"__name__":"up"}]}`,
+			want:      true,
+			wantMatch: []string{`{__name__=~".*"}`},
+		},
+		{
+			name:      "no matching series",
+			selectors: []string{`{service_name="mysql"}`, `up`},
+			response:  `{"status":"success","data":[]}`,
+			wantMatch: []string{`{service_name="mysql"}`, `up`},
+		},
+	}
+	c, err := client.NewClient(&fasthttp.Client{}, client.AuthParams{APIToken: "token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got url.Values
+			server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got = req.URL.Query()
+				if _, err := rw.Write([]byte(tt.response)); err != nil {
+					t.Error(err)
+				}
+			}))
+			defer server.Close()
+
+			s := NewSource(c, Config{ConnectionURL: server.URL, TimeSeriesSelectors: tt.selectors})
+			hasMetrics, err := s.HasMetrics(time.Unix(1700000000, 0), time.Unix(1700003600, 0))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hasMetrics != tt.want {
+				t.Fatalf("want %v, got %v", tt.want, hasMetrics)
+			}
+			if !reflect.DeepEqual(got["match[]"], tt.wantMatch) {
+				t.Fatalf("want match[] %q, got %q", tt.wantMatch, got["match[]"])
+			}
+		})
+	}
+}
+
+func TestReadChunkFilename(t *testing.T) {
+	data, err := generateFakeChunk(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		if _, err := rw.Write(data); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer server.Close()
+
+	c, err := client.NewClient(&fasthttp.Client{}, client.AuthParams{APIToken: "token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Unix(1700000000, 0)
+	end := start.Add(time.Minute)
+	tests := []struct {
+		cfg             Config
+		wantFilename    string
+		wantContentType string
+	}{
+		{Config{}, "1700000000-1700000060.bin", "application/x-ndjson+gzip"},
+		{Config{NativeData: true}, "1700000000-1700000060.nbin", "application/x-victoriametrics-native+gzip"},
+		{Config{OpenMetrics: true}, "1700000000-1700000060.prom.gz", "application/openmetrics-text+gzip"},
+	}
+	for _, tt := range tests {
+		tt.cfg.ConnectionURL = server.URL
+		s := NewSource(c, tt.cfg)
+		chunk, err := s.ReadChunk(context.Background(), dump.ChunkMeta{Start: &start, End: &end})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if chunk.Filename != tt.wantFilename || s.ContentType() != tt.wantContentType {
+			t.Fatalf("want %s of %s, got %s of %s", tt.wantFilename, tt.wantContentType, chunk.Filename, s.ContentType())
+		}
+	}
+}
+
+func TestCanConcatenateChunks(t *testing.T) {
+	tests := []struct {
+		cfg  Config
+		want bool
+	}{
+		{Config{}, true},
+		{Config{OpenMetrics: true}, true},
+		{Config{NativeData: true}, false},
+		{Config{ContentLimit: 1024}, false},
+	}
+	for _, tt := range tests {
+		if got := (Source{cfg: tt.cfg}).CanConcatenateChunks(); got != tt.want {
+			t.Fatalf("want %v for %+v, got %v", tt.want, tt.cfg, got)
+		}
+	}
+}
+
+func TestSplitTimeRangeIntoChunks(t *testing.T) {
+	start := time.Date(2024, 1, 1, 10, 0, 0, 300*int(time.Millisecond), time.UTC)
+	end := start.Add(4 * time.Hour)
+
+	tests := []struct {
+		name      string
+		delta     time.Duration
+		wantLen   int
+		shouldErr bool
+	}{
+		{
+			name:      "500ms",
+			delta:     500 * time.Millisecond,
+			shouldErr: true,
+		},
+		{
+			name:    "1s",
+			delta:   time.Second,
+			wantLen: 4*3600 + 1,
+		},
+		{
+			name:    "1h30m",
+			delta:   time.Hour + 30*time.Minute,
+			wantLen: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks, err := SplitTimeRangeIntoChunks(start, end, tt.delta, 0)
+			if err != nil {
+				if !tt.shouldErr {
+					t.Fatal(err)
+				}
+				return
+			}
+			if tt.shouldErr {
+				t.Fatal("should be error")
+			}
+			if len(chunks) != tt.wantLen {
+				t.Fatalf("want %d chunks, got %d", tt.wantLen, len(chunks))
+			}
+			for i, c := range chunks {
+				if c.Start.Nanosecond() != 0 || c.End.Nanosecond() != 0 {
+					t.Fatalf("chunk %d boundaries are not integer seconds: %v-%v", i, c.Start, c.End)
+				}
+				if c.End.Sub(*c.Start) != tt.delta {
+					t.Fatalf("chunk %d: want %v duration, got %v", i, tt.delta, c.End.Sub(*c.Start))
+				}
+				if i > 0 && !chunks[i-1].End.Equal(*c.Start) {
+					t.Fatalf("chunk %d doesn't start at the end of previous chunk", i)
+				}
+			}
+		})
+	}
+}
+
+func TestSplitTimeRangeIntoAlignedChunks(t *testing.T) {
+	start := time.Date(2024, 1, 1, 10, 0, 7, 0, time.UTC)
+	end := time.Date(2024, 1, 1, 10, 59, 53, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		delta     time.Duration
+		step      time.Duration
+		wantLen   int
+		shouldErr bool
+	}{
+		{
+			name:    "5m by 1m",
+			delta:   5 * time.Minute,
+			step:    time.Minute,
+			wantLen: 12,
+		},
+		{
+			name:    "15s by 15s",
+			delta:   15 * time.Second,
+			step:    15 * time.Second,
+			wantLen: 240,
+		},
+		{
+			name:      "not a multiple",
+			delta:     5 * time.Minute,
+			step:      7 * time.Second,
+			shouldErr: true,
+		},
+		{
+			name:      "fractional step",
+			delta:     5 * time.Minute,
+			step:      1500 * time.Millisecond,
+			shouldErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks, err := SplitTimeRangeIntoChunks(start, end, tt.delta, tt.step)
+			if err != nil {
+				if !tt.shouldErr {
+					t.Fatal(err)
+				}
+				return
+			}
+			if tt.shouldErr {
+				t.Fatal("should be error")
+			}
+			if len(chunks) != tt.wantLen {
+				t.Fatalf("want %d chunks, got %d", tt.wantLen, len(chunks))
+			}
+			if chunks[0].Start.After(start) || chunks[len(chunks)-1].End.Before(end) {
+				t.Fatalf("chunks %v-%v don't cover the time range", chunks[0].Start, chunks[len(chunks)-1].End)
+			}
+			for i, c := range chunks {
+				if c.Start.Unix()%int64(tt.step/time.Second) != 0 || c.End.Unix()%int64(tt.step/time.Second) != 0 {
+					t.Fatalf("chunk %d boundaries are not aligned to %v: %v-%v", i, tt.step, c.Start, c.End)
+				}
+				if i > 0 && !chunks[i-1].End.Equal(*c.Start) {
+					t.Fatalf("chunk %d doesn't start at the end of previous chunk", i)
+				}
+			}
+		})
+	}
+}
+
+func TestSplitChunk(t *testing.T) {
+	content, err := generateFakeChunk(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start, end := time.Unix(1700000000, 0), time.Unix(1700000300, 0)
+	c := &dump.Chunk{
+		ChunkMeta: dump.ChunkMeta{Source: dump.VictoriaMetrics, Start: &start, End: &end},
+		Content:   content,
+		Filename:  "1700000000-1700000300.bin",
+	}
+
+	s := Source{cfg: Config{ContentLimit: len(content)}}
+	chunks, err := s.SplitChunk(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) != 1 || chunks[0] != c {
+		t.Fatal("chunk within the limit should not be split")
+	}
+
+	s.cfg.ContentLimit = len(content) / 2
+	chunks, err = s.SplitChunk(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) < 2 {
+		t.Fatalf("want chunk to be split, got %d chunks", len(chunks))
+	}
+	total := 0
+	for i, part := range chunks {
+		if len(part.Content) > s.cfg.ContentLimit {
+			t.Fatalf("part %d exceeds the limit: %d > %d", i, len(part.Content), s.cfg.ContentLimit)
+		}
+		if want := fmt.Sprintf("1700000000-1700000300-%d.bin", i); part.Filename != want {
+			t.Fatalf("want filename %s, got %s", want, part.Filename)
+		}
+		count, err := s.CountMetrics(bytes.NewReader(part.Content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		total += count
+	}
+	if total != 1000 {
+		t.Fatalf("want 1000 metrics in parts, got %d", total)
+	}
+}
+
+func TestCompressChunkLevel(t *testing.T) {
+	content, err := generateFakeChunk(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics, err := decompressChunk(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sizes := make(map[int]int)
+	for _, level := range []int{0, 1, 9} {
+		cfg := Config{ChunkCompressionLevel: level}
+		compressed, err := compressChunkLevel(metrics, cfg.gzipLevel())
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := decompressChunk(compressed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(metrics) {
+			t.Fatalf("level %d: want %d metrics, got %d", level, len(metrics), len(got))
+		}
+		sizes[level] = len(compressed)
+	}
+	if sizes[9] > sizes[1] {
+		t.Fatalf("best compression is larger than the fastest one: %d > %d", sizes[9], sizes[1])
+	}
+}
+
+func TestSplitChunkByName(t *testing.T) {
+	metrics := []Metric{
+		{Metric: map[string]string{"__name__": "up", "job": "a"}, Values: []float64{1}, Timestamps: []int64{1}},
+		{Metric: map[string]string{"__name__": "job:up:sum"}, Values: []float64{1}, Timestamps: []int64{1}},
+		{Metric: map[string]string{"__name__": "up", "job": "b"}, Values: []float64{1}, Timestamps: []int64{1}},
+		{Metric: map[string]string{"__name__": "job_up_sum"}, Values: []float64{1}, Timestamps: []int64{1}},
+	}
+	content, err := compressChunk(metrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start, end := time.Unix(1700000000, 0), time.Unix(1700000300, 0)
+	c := &dump.Chunk{
+		ChunkMeta: dump.ChunkMeta{Source: dump.VictoriaMetrics, Start: &start, End: &end},
+		Content:   content,
+		Filename:  "1700000000-1700000300.bin",
+	}
+
+	s := Source{cfg: Config{SplitByName: true}}
+	chunks, err := s.SplitChunk(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]int{
+		"1700000000-1700000300-job_up_sum.bin":   1,
+		"1700000000-1700000300-job_up_sum_1.bin": 1,
+		"1700000000-1700000300-up.bin":           2,
+	}
+	if len(chunks) != len(want) {
+		t.Fatalf("want %d chunks, got %d", len(want), len(chunks))
+	}
+	for _, c := range chunks {
+		count, err := s.CountMetrics(bytes.NewReader(c.Content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != want[c.Filename] {
+			t.Fatalf("chunk %s: want %d metrics, got %d", c.Filename, want[c.Filename], count)
+		}
+	}
+}
+
+func TestCountMetrics(t *testing.T) {
+	for _, size := range []int{0, 1, 20} {
+		data, err := generateFakeChunk(size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		count, err := Source{}.CountMetrics(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != size {
+			t.Fatalf("want %d metrics, got %d", size, count)
+		}
+	}
+
+	if _, err := (Source{cfg: Config{NativeData: true}}).CountMetrics(bytes.NewReader(nil)); err == nil {
+		t.Fatal("should be error")
+	}
+}
+
 func generateFakeChunk(size int) ([]byte, error) {
 	metricsData, err := json.Marshal(Metric{
 		Metric: map[string]string{
@@ -15,7 +15,11 @@
 package victoriametrics
 
 import (
+	"fmt"
 	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
 
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
@@ -41,3 +45,51 @@ func ExportTestRequest(c *client.Client, victoriaMetricsURL string) error {
 	}
 	return nil
 }
+
+// InstanceSelector returns the time series selector matching service, node or instance name.
+func InstanceSelector(name string) string {
+	q := QuoteLabelValue(name)
+	return fmt.Sprintf(`{service_name=%s or node_name=%s or instance=%s}`, q, q, q)
+}
+
+var metricNameRegexp = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// ValidateMetricNames checks that names are valid Prometheus metric names.
+func ValidateMetricNames(names []string) error {
+	for _, name := range names {
+		if !metricNameRegexp.MatchString(name) {
+			return errors.Errorf("invalid metric name: %q", name)
+		}
+	}
+	return nil
+}
+
+// MetricNamesSelector returns the time series selector matching any of the metric names.
+// If instance is not empty, the selector also matches its service, node or instance name.
+// Names should be validated with ValidateMetricNames, as they are not escaped.
+func MetricNamesSelector(names []string, instance string) string {
+	nameFilter := fmt.Sprintf(`__name__=~"%s"`, strings.Join(names, "|"))
+	if instance == "" {
+		return "{" + nameFilter + "}"
+	}
+	q := QuoteLabelValue(instance)
+	return fmt.Sprintf(`{%[1]s,service_name=%[2]s or %[1]s,node_name=%[2]s or %[1]s,instance=%[2]s}`, nameFilter, q)
+}
+
+// InternalMetricsSelector matches self-monitoring metrics of VictoriaMetrics and vmagent.
+const InternalMetricsSelector = `{__name__=~"(vm|vmagent)_.+"}`
+
+// QuoteLabelValue returns the double-quoted label value with escaped quotes, backslashes and control characters.
+func QuoteLabelValue(value string) string {
+	return strconv.Quote(value)
+}
+
+var unsafeFilenameRegexp = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// sanitizeMetricName replaces characters which are unsafe in filenames, ex. colons of recording rules.
+func sanitizeMetricName(name string) string {
+	if name == "" {
+		return "unnamed"
+	}
+	return unsafeFilenameRegexp.ReplaceAllString(name, "_")
+}
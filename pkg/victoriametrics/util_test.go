@@ -0,0 +1,150 @@
+// Copyright 2023 Percona LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package victoriametrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/VictoriaMetrics/metricsql"
+)
+
+func TestInstanceSelector(t *testing.T) {
+	tests := []struct {
+		name     string
+		instance string
+	}{
+		{
+			name:     "plain",
+			instance: "mongo",
+		},
+		{
+			name:     "double quotes",
+			instance: `mongo"} or {__name__=~".*`,
+		},
+		{
+			name:     "single quotes",
+			instance: `mongo's`,
+		},
+		{
+			name:     "backslashes",
+			instance: `mongo\"\`,
+		},
+		{
+			name:     "braces",
+			instance: `{mongo}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selector := InstanceSelector(tt.instance)
+			e, err := metricsql.Parse(selector)
+			if err != nil {
+				t.Fatalf("failed to parse %s: %v", selector, err)
+			}
+			me, ok := e.(*metricsql.MetricExpr)
+			if !ok {
+				t.Fatalf("want metric expression, got %s", e.AppendString(nil))
+			}
+			if len(me.LabelFilterss) != 3 {
+				t.Fatalf("want 3 filter groups in %s, got %d", selector, len(me.LabelFilterss))
+			}
+			for _, lfs := range me.LabelFilterss {
+				if len(lfs) != 1 || lfs[0].Value != tt.instance || lfs[0].IsRegexp || lfs[0].IsNegative {
+					t.Fatalf("unexpected filters in %s: %+v", selector, lfs)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateMetricNames(t *testing.T) {
+	tests := []struct {
+		name      string
+		names     []string
+		shouldErr bool
+	}{
+		{name: "valid", names: []string{"up", "node_cpu_seconds_total", "job:rate5m", "_private"}},
+		{name: "empty", names: []string{""}, shouldErr: true},
+		{name: "leading digit", names: []string{"1up"}, shouldErr: true},
+		{name: "selector", names: []string{`up{job="vm"}`}, shouldErr: true},
+		{name: "regex", names: []string{"node_.*"}, shouldErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateMetricNames(tt.names)
+			if err != nil && !tt.shouldErr {
+				t.Fatal(err)
+			}
+			if err == nil && tt.shouldErr {
+				t.Fatal("should be error")
+			}
+		})
+	}
+}
+
+func TestMetricNamesSelector(t *testing.T) {
+	tests := []struct {
+		name       string
+		names      []string
+		instance   string
+		wantGroups int
+	}{
+		{name: "single metric", names: []string{"up"}, wantGroups: 1},
+		{name: "multiple metrics", names: []string{"up", "node_load1"}, wantGroups: 1},
+		{name: "with instance", names: []string{"up", "node_load1"}, instance: `mongo"}`, wantGroups: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selector := MetricNamesSelector(tt.names, tt.instance)
+			e, err := metricsql.Parse(selector)
+			if err != nil {
+				t.Fatalf("failed to parse %s: %v", selector, err)
+			}
+			me, ok := e.(*metricsql.MetricExpr)
+			if !ok {
+				t.Fatalf("want metric expression, got %s", e.AppendString(nil))
+			}
+			if len(me.LabelFilterss) != tt.wantGroups {
+				t.Fatalf("want %d filter groups in %s, got %d", tt.wantGroups, selector, len(me.LabelFilterss))
+			}
+			for _, lfs := range me.LabelFilterss {
+				if lfs[0].Label != "__name__" || !lfs[0].IsRegexp || lfs[0].Value != strings.Join(tt.names, "|") {
+					t.Fatalf("unexpected name filter in %s: %+v", selector, lfs[0])
+				}
+				if tt.instance != "" && (len(lfs) != 2 || lfs[1].Value != tt.instance) {
+					t.Fatalf("unexpected instance filter in %s: %+v", selector, lfs)
+				}
+			}
+		})
+	}
+}
+
+func TestInternalMetricsSelector(t *testing.T) {
+	m, err := NewSeriesMatcher(InternalMetricsSelector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]bool{
+		"vm_rows":                     true,
+		"vmagent_remotewrite_packets": true,
+		"node_load1":                  false,
+		"mysql_vm_stat":               false,
+	} {
+		if got := m.Match(map[string]string{"__name__": name}); got != want {
+			t.Fatalf("want %v for %s, got %v", want, name, got)
+		}
+	}
+}